@@ -0,0 +1,333 @@
+// Package cluster 在 labrpc 的内存网络上运行一组 KVServer，并且可以在运行时注入故障：
+// 让节点崩溃、从保留的持久化状态重启、把节点分成互相不通的分区，以及切换网络的丢包和延迟
+//
+// 每对节点之间使用单独的 ClientEnd（与 6.5840 的测试框架相同），所以可以只断开部分节点之间的连接。
+// 客户端到各节点的 ClientEnd 不受分区影响，只在节点崩溃时断开，这样网关总能连到少数派一侧的旧领导者，
+// 可以观察到写入在少数派上超时、然后转到多数派的过程
+package cluster
+
+import (
+	"course/kv"
+	"course/labrpc"
+	"course/logging"
+	"course/raft"
+	"course/transport"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var logger = logging.Logger(logging.TopicMain)
+
+// 启动集群的参数
+type Options struct {
+	MaxRaftState     int // Raft 状态超过该大小（字节）时做快照，-1 表示不做快照
+	VersionRetention int // 做快照时保留的历史版本范围，0 表示使用 KVServer 的默认值
+}
+
+type Cluster struct {
+	mu   sync.Mutex
+	net  *labrpc.Network
+	n    int
+	opts Options
+
+	servers     []*kv.KVServer    // 崩溃的节点为 nil
+	persisters  []*raft.Persister // 节点崩溃后保留的持久化状态
+	endnames    [][]string        // endnames[i][j] 为节点 i 连到节点 j 的 ClientEnd 名字
+	clientEnds  []transport.ClientEnd
+	clientNames []string
+	group       []int           // 节点所在的分区，同一分区的节点之间可以通信
+	dropRates   []float64       // 发往各节点的请求和它的回复被丢弃的比例
+	skews       []time.Duration // 各节点系统时间的偏差，重启后仍然保留
+	generation  int             // 用于为重启的节点生成新的 ClientEnd 名字
+
+	reliable       bool
+	longReordering bool
+	longDelays     bool
+}
+
+// 节点的运行状态
+type NodeState struct {
+	ID        int     `json:"id"`
+	Running   bool    `json:"running"`
+	Group     int     `json:"group"` // 所在分区的编号，没有分区时都为 0
+	DropRate  float64 `json:"drop_rate,omitempty"`
+	ClockSkew string  `json:"clock_skew,omitempty"`
+}
+
+// 网络的故障设置，含义见 labrpc.Network 的同名方法
+type NetworkState struct {
+	Reliable       bool `json:"reliable"`
+	LongReordering bool `json:"long_reordering"`
+	LongDelays     bool `json:"long_delays"`
+}
+
+// 启动 n 个节点的集群，所有节点都在同一个分区中，网络可靠
+func New(n int, opts Options) *Cluster {
+	c := &Cluster{
+		net:        labrpc.MakeNetwork(),
+		n:          n,
+		opts:       opts,
+		servers:    make([]*kv.KVServer, n),
+		persisters: make([]*raft.Persister, n),
+		endnames:   make([][]string, n),
+		group:      make([]int, n),
+		dropRates:  make([]float64, n),
+		skews:      make([]time.Duration, n),
+		reliable:   true,
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.clientEnds = make([]transport.ClientEnd, n)
+	c.clientNames = make([]string, n)
+	for j := 0; j < n; j++ {
+		c.clientNames[j] = fmt.Sprintf("client-%d", j)
+		c.clientEnds[j] = c.net.MakeEnd(c.clientNames[j])
+		c.net.Connect(c.clientNames[j], serverName(j))
+	}
+	for i := 0; i < n; i++ {
+		c.persisters[i] = raft.MakePersister()
+		c.startLocked(i)
+	}
+	c.connectLocked()
+	return c
+}
+
+func serverName(i int) string {
+	return fmt.Sprintf("server%d", i)
+}
+
+// 集群的节点数量
+func (c *Cluster) N() int {
+	return c.n
+}
+
+// 客户端使用的 ClientEnd，第 i 个连到节点 i
+func (c *Cluster) ClientEnds() []transport.ClientEnd {
+	return c.clientEnds
+}
+
+// 用节点保留的持久化状态启动它，调用前节点必须处于崩溃状态
+func (c *Cluster) startLocked(i int) {
+	// 每次启动都使用新的 ClientEnd 名字，旧实例发出的 RPC 的回复不会送到新实例
+	c.generation++
+	ends := make([]transport.ClientEnd, c.n)
+	c.endnames[i] = make([]string, c.n)
+	for j := 0; j < c.n; j++ {
+		c.endnames[i][j] = fmt.Sprintf("%d-%d-%d", i, j, c.generation)
+		ends[j] = c.net.MakeEnd(c.endnames[i][j])
+		c.net.Connect(c.endnames[i][j], serverName(j))
+	}
+
+	kvs := kv.StartKVServer(ends, i, c.persisters[i], c.opts.MaxRaftState)
+	if c.opts.VersionRetention > 0 {
+		kvs.SetVersionRetention(c.opts.VersionRetention)
+	}
+	kvs.SetClockSkew(c.skews[i])
+	server := labrpc.MakeServer()
+	server.AddService(labrpc.MakeService(kvs))
+	server.AddService(labrpc.MakeService(kvs.GetRaft()))
+	c.net.AddServer(serverName(i), server)
+	c.servers[i] = kvs
+}
+
+// 按各节点的运行状态和分区启用或禁用所有 ClientEnd
+func (c *Cluster) connectLocked() {
+	for i := 0; i < c.n; i++ {
+		for j := 0; j < c.n; j++ {
+			up := c.servers[i] != nil && c.servers[j] != nil && c.group[i] == c.group[j]
+			c.net.Enable(c.endnames[i][j], up)
+		}
+		c.net.Enable(c.clientNames[i], c.servers[i] != nil)
+	}
+}
+
+func (c *Cluster) checkID(i int) error {
+	if i < 0 || i >= c.n {
+		return fmt.Errorf("node %d does not exist (cluster has %d nodes)", i, c.n)
+	}
+	return nil
+}
+
+// 让节点 i 崩溃：断开它的所有连接并停止它，持久化状态保留下来供 Restart 使用
+// 节点已经崩溃时返回错误
+func (c *Cluster) Crash(i int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.checkID(i); err != nil {
+		return err
+	}
+	if c.servers[i] == nil {
+		return fmt.Errorf("node %d is already down", i)
+	}
+	c.crashLocked(i)
+	logger.Warn("Node crashed", "node", i)
+	return nil
+}
+
+func (c *Cluster) crashLocked(i int) {
+	kvs := c.servers[i]
+	c.servers[i] = nil
+	c.connectLocked()
+	c.net.DeleteServer(serverName(i))
+
+	// 旧实例停止前可能还会写入持久化状态，复制一份，让重启的实例只看到崩溃时的状态
+	c.persisters[i] = c.persisters[i].Copy()
+	kvs.Kill()
+}
+
+// 重启节点 i：用崩溃时保留的 Raft 状态和快照启动一个新的 KVServer，并重新注册到网络中
+// 节点仍在运行时先让它崩溃再重启
+func (c *Cluster) Restart(i int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.checkID(i); err != nil {
+		return err
+	}
+	if c.servers[i] != nil {
+		c.crashLocked(i)
+	}
+	c.startLocked(i)
+	c.connectLocked()
+	logger.Warn("Node restarted", "node", i, "raft_state_bytes", c.persisters[i].RaftStateSize())
+	return nil
+}
+
+// 把节点分成互相不通的分区，每组节点之间可以通信
+// 没有出现在任何一组中的节点单独成为一个分区；同一个节点不能出现在两组中
+func (c *Cluster) Partition(groups [][]int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	group := make([]int, c.n)
+	for i := range group {
+		group[i] = -1
+	}
+	for g, members := range groups {
+		for _, i := range members {
+			if err := c.checkID(i); err != nil {
+				return err
+			}
+			if group[i] >= 0 {
+				return fmt.Errorf("node %d appears in more than one group", i)
+			}
+			group[i] = g
+		}
+	}
+	next := len(groups)
+	for i := range group {
+		if group[i] < 0 {
+			group[i] = next
+			next++
+		}
+	}
+	c.group = group
+	c.connectLocked()
+	logger.Warn("Network partitioned", "groups", group)
+	return nil
+}
+
+// 恢复所有节点之间的连接，崩溃的节点仍然保持崩溃
+func (c *Cluster) Heal() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.group = make([]int, c.n)
+	c.connectLocked()
+	logger.Warn("Network healed")
+}
+
+// 修改网络的故障设置
+func (c *Cluster) SetNetwork(s NetworkState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reliable = s.Reliable
+	c.longReordering = s.LongReordering
+	c.longDelays = s.LongDelays
+	c.net.Reliable(s.Reliable)
+	c.net.LongReordering(s.LongReordering)
+	c.net.LongDelays(s.LongDelays)
+	logger.Warn("Network settings changed",
+		"reliable", s.Reliable, "long_reordering", s.LongReordering, "long_delays", s.LongDelays)
+}
+
+func (c *Cluster) Network() NetworkState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return NetworkState{Reliable: c.reliable, LongReordering: c.longReordering, LongDelays: c.longDelays}
+}
+
+// 丢弃发往节点 i 的请求和它的回复中 rate（0 到 1）比例的消息，0 表示不丢弃
+func (c *Cluster) SetDropRate(i int, rate float64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.checkID(i); err != nil {
+		return err
+	}
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("drop rate %v is not between 0 and 1", rate)
+	}
+	c.dropRates[i] = rate
+	c.net.SetDropRate(serverName(i), rate)
+	logger.Warn("Drop rate changed", "node", i, "rate", rate)
+	return nil
+}
+
+// 让节点 i 的系统时间偏差 skew，节点重启后仍然保留
+func (c *Cluster) SetClockSkew(i int, skew time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.checkID(i); err != nil {
+		return err
+	}
+	c.skews[i] = skew
+	if c.servers[i] != nil {
+		c.servers[i].SetClockSkew(skew)
+	}
+	logger.Warn("Clock skew changed", "node", i, "skew", skew)
+	return nil
+}
+
+// 返回当前的领导者：正在运行、认为自己是领导者并且任期最大的节点，没有时返回 false
+// 被分区的旧领导者在新领导者选出之前仍可能被返回
+func (c *Cluster) Leader() (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	leader, maxTerm := -1, -1
+	for i, kvs := range c.servers {
+		if kvs == nil {
+			continue
+		}
+		if term, isLeader := kvs.GetRaft().GetState(); isLeader && term > maxTerm {
+			leader, maxTerm = i, term
+		}
+	}
+	return leader, leader >= 0
+}
+
+// 各节点的运行状态和所在分区
+func (c *Cluster) Nodes() []NodeState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	nodes := make([]NodeState, c.n)
+	for i := range nodes {
+		nodes[i] = NodeState{ID: i, Running: c.servers[i] != nil, Group: c.group[i], DropRate: c.dropRates[i]}
+		if c.skews[i] != 0 {
+			nodes[i].ClockSkew = c.skews[i].String()
+		}
+	}
+	return nodes
+}
+
+// 停止所有节点并关闭网络
+func (c *Cluster) Shutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, kvs := range c.servers {
+		if kvs != nil {
+			kvs.Kill()
+			c.servers[i] = nil
+		}
+	}
+	c.net.Cleanup()
+}
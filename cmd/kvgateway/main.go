@@ -0,0 +1,77 @@
+// kvgateway 以独立进程运行 HTTP 网关，通过网络访问 kvnode 节点
+//
+//	kvgateway --peers 127.0.0.1:9000,127.0.0.1:9001,127.0.0.1:9002 --listen :8080
+//
+// 节点启用了双向 TLS 时，用 --tls-cert、--tls-key 和 --tls-ca 指定网关自己的证书
+//
+// 设置 --admin-token（或环境变量 KV_ADMIN_TOKEN）后启用 API 令牌认证，
+// 令牌保存在集群中，连接同一集群的所有网关共用同一批令牌
+//
+// --schema 指定记录的校验规则文件，启动时写入集群，之后所有网关都使用这份规则
+//
+// 日志格式和级别由 --log-format 和 --log-level 指定，也可以通过环境变量 LOG_FORMAT 和 LOG_LEVEL 设置，
+// 运行时可以通过 /log/levels 修改各主题的级别
+//
+// --trace-file 指定后，每个请求在网关和客户端各阶段的 span 以 OTLP/JSON 格式追加写入该文件，
+// 节点也指定 --trace-file 时，把各进程的文件合在一起就能按 traceId 还原一个请求的完整过程
+package main
+
+import (
+	"course/gateway"
+	"course/kv"
+	"course/logging"
+	"course/tracing"
+	"course/transport"
+	"flag"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	peers := flag.String("peers", "", "comma-separated addresses of the kvnode processes")
+	listen := flag.String("listen", ":8080", "HTTP listen address")
+	adminToken := flag.String("admin-token", os.Getenv("KV_ADMIN_TOKEN"), "enables API token authentication; this token has the admin role")
+	corsOrigins := flag.String("cors-origins", "", "comma-separated origins allowed for CORS, * for any (default: none)")
+	schemaFile := flag.String("schema", "", "JSON file with validation rules for student records, stored in the cluster at startup")
+	logFormat := flag.String("log-format", os.Getenv("LOG_FORMAT"), "log output format: text or json")
+	logLevel := flag.String("log-level", os.Getenv("LOG_LEVEL"), "log level for all topics (debug) or per topic (raft=debug,client=warn)")
+	traceFile := flag.String("trace-file", os.Getenv("TRACE_FILE"), "append request spans in OTLP/JSON to this file, - for stdout (default: disabled)")
+	var tlsFiles transport.TLSFiles
+	flag.StringVar(&tlsFiles.CertFile, "tls-cert", "", "client certificate for mutual TLS with the nodes")
+	flag.StringVar(&tlsFiles.KeyFile, "tls-key", "", "private key of --tls-cert")
+	flag.StringVar(&tlsFiles.CAFile, "tls-ca", "", "CA that signs the node certificates")
+	flag.Parse()
+	if err := logging.Setup(*logFormat, *logLevel); err != nil {
+		log.Fatal(err)
+	}
+	if err := tracing.Setup(*traceFile, "kvgateway"); err != nil {
+		log.Fatalf("Failed to open trace file: %v", err)
+	}
+
+	if *peers == "" {
+		log.Fatal("--peers is required")
+	}
+
+	addrs := strings.Split(*peers, ",")
+	ends := transport.NewTCPClientEnds(addrs)
+	if tlsFiles.Enabled() {
+		config, err := tlsFiles.ClientConfig()
+		if err != nil {
+			log.Fatalf("Failed to load TLS config: %v", err)
+		}
+		ends = transport.NewTLSClientEnds(addrs, config)
+	}
+
+	client := kv.MakeKVClient(ends)
+	if *schemaFile != "" {
+		if err := gateway.LoadSchemaFile(client, *schemaFile); err != nil {
+			log.Fatalf("Failed to load schema: %v", err)
+		}
+	}
+	opts := gateway.Options{AdminToken: *adminToken}
+	if *corsOrigins != "" {
+		opts.AllowedOrigins = strings.Split(*corsOrigins, ",")
+	}
+	log.Fatal(gateway.ListenAndServe(*listen, client, opts))
+}
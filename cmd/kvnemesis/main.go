@@ -0,0 +1,118 @@
+// kvnemesis 按场景文件在 labrpc 集群上注入故障，同时让多个客户端并发读写，最后检查操作历史是否可线性化
+//
+//	kvnemesis --scenario scenarios/leader-partition.json
+//	kvnemesis --scenario scenarios/flaky-network.json --seed 42 --duration 1m
+//	kvnemesis --scenario scenarios/crash-restart.json --simulate --duration 10m
+//
+// 场景的格式见 nemesis 包。--seed 覆盖场景中的随机种子，报告中会打印实际使用的种子。
+// --simulate 在虚拟时间中运行，计时器不再等待真实时间，10 分钟的场景通常几十秒内跑完。
+// 模拟运行时同一个种子复现完全相同的执行过程，报告中的 schedule 摘要可以用来确认，见 nemesis.Run
+//
+// 历史可线性化时退出码为 0，发现违反时为 1 并打印第一个违反的时间窗口，检查超时为 2。
+// --history 指定后把操作历史以 JSON Lines 写入该文件，便于用其他工具分析
+package main
+
+import (
+	"course/kv"
+	"course/linearizability"
+	"course/logging"
+	"course/nemesis"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func main() {
+	scenarioFile := flag.String("scenario", "", "JSON scenario file")
+	seed := flag.Int64("seed", 0, "overrides the seed of the scenario (default: use the scenario's, or random)")
+	duration := flag.Duration("duration", 0, "overrides the duration of the scenario")
+	simulate := flag.Bool("simulate", false, "run in virtual time instead of real time")
+	historyFile := flag.String("history", "", "write the operation history as JSON Lines to this file")
+	logFormat := flag.String("log-format", os.Getenv("LOG_FORMAT"), "log output format: text or json")
+	logLevel := flag.String("log-level", envOr("LOG_LEVEL", "warn"), "log level for all topics (debug) or per topic (raft=debug,client=warn)")
+	flag.Parse()
+	if err := logging.Setup(*logFormat, *logLevel); err != nil {
+		log.Fatal(err)
+	}
+
+	if *scenarioFile == "" {
+		log.Fatal("--scenario is required")
+	}
+	scenario, err := nemesis.Load(*scenarioFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *seed != 0 {
+		scenario.Seed = *seed
+	}
+	if *duration > 0 {
+		scenario.Duration = nemesis.Duration(*duration)
+	}
+	if *simulate {
+		scenario.Simulate = true
+	}
+
+	// 节点的数据文件放在临时目录中，不读入也不覆盖当前目录的 data_kv.json
+	dir, err := os.MkdirTemp("", "kvnemesis")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kv.DataFile = filepath.Join(dir, "data_kv.json")
+
+	report, err := nemesis.Run(scenario)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report.Write(os.Stdout)
+
+	if *historyFile != "" {
+		if err := writeHistory(*historyFile, report); err != nil {
+			log.Printf("Failed to write history: %v", err)
+		}
+	}
+
+	if !report.OK() {
+		os.RemoveAll(dir)
+		if report.Check.Violation != nil {
+			os.Exit(1)
+		}
+		os.Exit(2)
+	}
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// 每行一个操作，时间为相对开始的秒数，结果未知的写入 return 为 null
+func writeHistory(path string, report *nemesis.Report) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	for _, op := range report.History {
+		line := map[string]interface{}{
+			"client": op.ClientID,
+			"input":  op.Input,
+			"output": op.Output,
+			"call":   time.Duration(op.Call).Seconds(),
+			"return": nil,
+		}
+		if op.Return != linearizability.Pending {
+			line["return"] = time.Duration(op.Return).Seconds()
+		}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
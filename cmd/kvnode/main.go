@@ -0,0 +1,174 @@
+// kvnode 以独立进程运行集群中的一个节点
+//
+//	kvnode --id 0 --peers 127.0.0.1:9000,127.0.0.1:9001,127.0.0.1:9002 --data-dir data/node0
+//
+// --peers 按节点编号列出所有节点的地址（包括自己），所有节点必须使用相同的列表
+// Raft 状态和快照保存在 --data-dir 中，进程重启后从中恢复
+//
+// 指定 --tls-cert、--tls-key 和 --tls-ca 后节点之间使用双向 TLS（证书可以用 kvcerts 生成）：
+// 本节点的证书身份必须是 node-<id>，连接其他节点时要求对方的身份与编号一致，
+// Raft RPC 只接受来自节点证书的调用，且参数中的发送者编号必须与证书身份一致，
+// KVServer RPC 接受任何由 CA 签发的证书
+//
+// --metrics-listen 指定一个 HTTP 地址，在其 /metrics 上以 Prometheus 格式输出本节点的 Raft 和 KV 指标，
+// 在 /log/levels 上查看和修改各日志主题的级别（该地址不做认证，只应在内网开放）
+//
+// 日志格式和级别由 --log-format 和 --log-level 指定，也可以通过环境变量 LOG_FORMAT 和 LOG_LEVEL 设置
+//
+// --trace-file 指定后，本节点处理请求各阶段的 span 以 OTLP/JSON 格式追加写入该文件
+package main
+
+import (
+	"course/kv"
+	"course/logging"
+	"course/metrics"
+	"course/raft"
+	"course/tracing"
+	"course/transport"
+	"crypto/tls"
+	"flag"
+	"io/ioutil"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func main() {
+	id := flag.Int("id", -1, "index of this node in --peers")
+	peers := flag.String("peers", "", "comma-separated addresses of all nodes, ordered by id")
+	dataDir := flag.String("data-dir", "", "directory for Raft state, snapshots and the data file")
+	listen := flag.String("listen", "", "address to listen on (default: this node's entry in --peers)")
+	seed := flag.String("seed", "", "data file to load on first start when --data-dir has none")
+	maxRaftState := flag.Int("maxraftstate", 1<<20, "snapshot when the Raft state exceeds this many bytes, -1 to disable")
+	versionRetention := flag.Int("version-retention", kv.DefaultVersionRetention, "log entries of version history to keep")
+	metricsListen := flag.String("metrics-listen", "", "HTTP address serving Prometheus metrics at /metrics (default: disabled)")
+	logFormat := flag.String("log-format", os.Getenv("LOG_FORMAT"), "log output format: text or json")
+	logLevel := flag.String("log-level", os.Getenv("LOG_LEVEL"), "log level for all topics (debug) or per topic (raft=debug,client=warn)")
+	traceFile := flag.String("trace-file", os.Getenv("TRACE_FILE"), "append request spans in OTLP/JSON to this file, - for stdout (default: disabled)")
+	var tlsFiles transport.TLSFiles
+	flag.StringVar(&tlsFiles.CertFile, "tls-cert", "", "certificate of this node, enables mutual TLS")
+	flag.StringVar(&tlsFiles.KeyFile, "tls-key", "", "private key of --tls-cert")
+	flag.StringVar(&tlsFiles.CAFile, "tls-ca", "", "CA that signs all node and client certificates")
+	flag.Parse()
+	if err := logging.Setup(*logFormat, *logLevel); err != nil {
+		log.Fatal(err)
+	}
+	if err := tracing.Setup(*traceFile, "kvnode-"+strconv.Itoa(*id)); err != nil {
+		log.Fatalf("Failed to open trace file: %v", err)
+	}
+
+	addrs := strings.Split(*peers, ",")
+	if *peers == "" || *id < 0 || *id >= len(addrs) {
+		log.Fatalf("--id must be an index into --peers (got id=%d, %d peers)", *id, len(addrs))
+	}
+	if *dataDir == "" {
+		log.Fatal("--data-dir is required")
+	}
+	if *listen == "" {
+		*listen = addrs[*id]
+	}
+
+	persister, err := raft.MakeFilePersister(*dataDir)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %v", *dataDir, err)
+	}
+
+	// 每个节点使用自己目录下的数据文件，第一次启动时从 --seed 复制初始数据
+	kv.DataFile = filepath.Join(*dataDir, "data_kv.json")
+	if _, err := os.Stat(kv.DataFile); os.IsNotExist(err) && *seed != "" {
+		data, err := ioutil.ReadFile(*seed)
+		if err != nil {
+			log.Fatalf("Failed to read seed %s: %v", *seed, err)
+		}
+		if err := ioutil.WriteFile(kv.DataFile, data, 0644); err != nil {
+			log.Fatalf("Failed to write %s: %v", kv.DataFile, err)
+		}
+	}
+
+	peerEnds := transport.NewTCPClientEnds(addrs)
+	var serverConfig *tls.Config
+	if tlsFiles.Enabled() {
+		identity, err := tlsFiles.Identity()
+		if err != nil {
+			log.Fatalf("Failed to load TLS certificate: %v", err)
+		}
+		if identity != transport.NodeIdentity(*id) {
+			log.Fatalf("Certificate identity is %q, node %d needs %q", identity, *id, transport.NodeIdentity(*id))
+		}
+		if serverConfig, err = tlsFiles.ServerConfig(); err != nil {
+			log.Fatalf("Failed to load TLS server config: %v", err)
+		}
+		clientConfig, err := tlsFiles.ClientConfig()
+		if err != nil {
+			log.Fatalf("Failed to load TLS client config: %v", err)
+		}
+		peerEnds = transport.NewTLSClientEnds(addrs, clientConfig)
+	}
+
+	listener, err := transport.Listen(*listen, serverConfig)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", *listen, err)
+	}
+
+	kvs := kv.StartKVServer(peerEnds, *id, persister, *maxRaftState)
+	kvs.SetVersionRetention(*versionRetention)
+
+	server := transport.NewServer()
+	server.AddService(kvs)
+	server.AddService(kvs.GetRaft())
+	if serverConfig != nil {
+		server.SetAuthorizer(func(peer, svcMeth string, args interface{}) bool {
+			if strings.HasPrefix(svcMeth, "Raft.") {
+				return isSender(peer, args, len(addrs))
+			}
+			return true
+		})
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			log.Fatalf("Failed to serve on %s: %v", *listen, err)
+		}
+	}()
+	slog.Info("Node is listening", "node", *id, "addr", *listen)
+
+	if *metricsListen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler(metrics.Default))
+		mux.Handle("/log/levels", logging.LevelsHandler())
+		go func() {
+			log.Fatal(http.ListenAndServe(*metricsListen, mux))
+		}()
+		slog.Info("Serving metrics", "node", *id, "addr", *metricsListen)
+	}
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+	<-signalChan
+
+	slog.Info("Node shutting down", "node", *id)
+	server.Close()
+	kvs.Kill()
+	tracing.Shutdown()
+}
+
+// 判断证书身份是否是 Raft 请求参数中声明的发送者，防止一个节点冒充其他节点投票或发送日志
+func isSender(identity string, args interface{}, n int) bool {
+	var from int
+	switch args := args.(type) {
+	case *raft.RequestVoteArgs:
+		from = args.CandidateId
+	case *raft.AppendEntriesArgs:
+		from = args.LeaderId
+	case *raft.InstallSnapshotArgs:
+		from = args.LeaderId
+	default:
+		return false
+	}
+	return from >= 0 && from < n && identity == transport.NodeIdentity(from)
+}
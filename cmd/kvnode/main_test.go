@@ -0,0 +1,33 @@
+package main
+
+import (
+	"course/raft"
+	"course/transport"
+	"testing"
+)
+
+// Raft 请求只有在参数中声明的发送者与证书身份相同时才被接受
+func TestIsSender(t *testing.T) {
+	node1 := transport.NodeIdentity(1)
+	tests := []struct {
+		args interface{}
+		want bool
+	}{
+		{&raft.RequestVoteArgs{CandidateId: 1}, true},
+		{&raft.RequestVoteArgs{CandidateId: 2}, false},
+		{&raft.AppendEntriesArgs{LeaderId: 1}, true},
+		{&raft.AppendEntriesArgs{LeaderId: 0}, false},
+		{&raft.InstallSnapshotArgs{LeaderId: 1}, true},
+		{&raft.InstallSnapshotArgs{LeaderId: 3}, false},
+		{&raft.RequestVoteArgs{CandidateId: -1}, false},
+		{"not a Raft request", false},
+	}
+	for _, tt := range tests {
+		if got := isSender(node1, tt.args, 3); got != tt.want {
+			t.Errorf("isSender(%q, %+v) = %v, want %v", node1, tt.args, got, tt.want)
+		}
+	}
+	if isSender(transport.NodeIdentity(5), &raft.RequestVoteArgs{CandidateId: 5}, 3) {
+		t.Errorf("isSender accepted a node outside the cluster")
+	}
+}
@@ -0,0 +1,171 @@
+package gateway
+
+import (
+	"course/cluster"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 注入故障的接口只在网关和节点运行在同一进程中（labrpc 网络）时可用，由 Options.Cluster 启用
+var faults *cluster.Cluster
+
+// /admin/nodes 等接口返回的集群状态
+type faultsStateJSON struct {
+	Nodes   []cluster.NodeState  `json:"nodes"`
+	Network cluster.NetworkState `json:"network"`
+}
+
+// 只在显式传入集群并启用认证时注册，未启用认证时 requireRole 会放行所有请求
+func registerAdmin(mux *http.ServeMux, c *cluster.Cluster) {
+	if c != nil && auth == nil {
+		logger.Error("Fault injection endpoints are not served without authentication")
+		c = nil
+	}
+	faults = c
+	if faults == nil {
+		return
+	}
+	mux.Handle("GET /admin/nodes", requireRole(RoleAdmin, handleAdminNodes))
+	mux.Handle("POST /admin/nodes/{id}/crash", requireRole(RoleAdmin, handleAdminCrash))
+	mux.Handle("POST /admin/nodes/{id}/restart", requireRole(RoleAdmin, handleAdminRestart))
+	mux.Handle("PUT /admin/nodes/{id}", requireRole(RoleAdmin, handleAdminNode))
+	mux.Handle("POST /admin/partition", requireRole(RoleAdmin, handleAdminPartition))
+	mux.Handle("POST /admin/heal", requireRole(RoleAdmin, handleAdminHeal))
+	mux.Handle("PUT /admin/network", requireRole(RoleAdmin, handleAdminNetwork))
+}
+
+func writeFaultsState(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(faultsStateJSON{Nodes: faults.Nodes(), Network: faults.Network()})
+}
+
+// 处理 GET /admin/nodes 请求，返回各节点是否在运行、所在分区和网络设置
+func handleAdminNodes(w http.ResponseWriter, r *http.Request) {
+	writeFaultsState(w)
+}
+
+// 解析路径中的节点编号，不存在时写出 404 并返回 false
+func adminNodeID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 0 || id >= faults.N() {
+		writeJSONError(w, fmt.Sprintf("Node %q does not exist", r.PathValue("id")), http.StatusNotFound)
+		return 0, false
+	}
+	return id, true
+}
+
+// 处理 POST /admin/nodes/{id}/crash 请求，节点已经崩溃时返回 409
+func handleAdminCrash(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminNodeID(w, r)
+	if !ok {
+		return
+	}
+	if err := faults.Crash(id); err != nil {
+		writeJSONError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeFaultsState(w)
+}
+
+// 处理 POST /admin/nodes/{id}/restart 请求，节点从崩溃时的 Raft 状态和快照恢复
+// 节点仍在运行时会先崩溃再重启
+func handleAdminRestart(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminNodeID(w, r)
+	if !ok {
+		return
+	}
+	if err := faults.Restart(id); err != nil {
+		writeJSONError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeFaultsState(w)
+}
+
+// 处理 PUT /admin/nodes/{id} 请求，修改单个节点的故障设置，没有给出的设置保持不变
+//
+//	{"drop_rate": 0.3, "clock_skew": "-1m"}
+func handleAdminNode(w http.ResponseWriter, r *http.Request) {
+	id, ok := adminNodeID(w, r)
+	if !ok {
+		return
+	}
+	var request struct {
+		DropRate  *float64 `json:"drop_rate"`
+		ClockSkew *string  `json:"clock_skew"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSONError(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+	var skew time.Duration
+	if request.ClockSkew != nil {
+		var err error
+		if skew, err = time.ParseDuration(*request.ClockSkew); err != nil {
+			writeJSONError(w, "clock_skew must be a duration like \"-1m\"", http.StatusBadRequest)
+			return
+		}
+	}
+	if request.DropRate != nil {
+		if err := faults.SetDropRate(id, *request.DropRate); err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if request.ClockSkew != nil {
+		faults.SetClockSkew(id, skew)
+	}
+	writeFaultsState(w)
+}
+
+// 处理 POST /admin/partition 请求，请求体为 {"groups": [[0], [1, 2]]}
+// 没有列出的节点各自单独成为一个分区
+func handleAdminPartition(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Groups [][]int `json:"groups"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Groups) == 0 {
+		writeJSONError(w, "Request body must be {\"groups\": [[node, ...], ...]}", http.StatusBadRequest)
+		return
+	}
+	if err := faults.Partition(request.Groups); err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeFaultsState(w)
+}
+
+// 处理 POST /admin/heal 请求，恢复所有节点之间的连接
+func handleAdminHeal(w http.ResponseWriter, r *http.Request) {
+	faults.Heal()
+	writeFaultsState(w)
+}
+
+// 处理 PUT /admin/network 请求，请求体中没有给出的设置保持不变
+//
+//	{"reliable": false, "long_reordering": true, "long_delays": true}
+func handleAdminNetwork(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Reliable       *bool `json:"reliable"`
+		LongReordering *bool `json:"long_reordering"`
+		LongDelays     *bool `json:"long_delays"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSONError(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+	s := faults.Network()
+	if request.Reliable != nil {
+		s.Reliable = *request.Reliable
+	}
+	if request.LongReordering != nil {
+		s.LongReordering = *request.LongReordering
+	}
+	if request.LongDelays != nil {
+		s.LongDelays = *request.LongDelays
+	}
+	faults.SetNetwork(s)
+	writeFaultsState(w)
+}
@@ -0,0 +1,322 @@
+package gateway
+
+import (
+	"context"
+	"course/kv"
+	"course/logging"
+	"course/tracing"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 角色，高级别的角色包含低级别角色的全部权限
+const (
+	RoleReader = "reader" // 只读：get、search、list_all 等
+	RoleWriter = "writer" // 读写：put、patch、batch_put
+	RoleAdmin  = "admin"  // 管理：创建和吊销令牌、查看审计日志
+)
+
+var roleLevel = map[string]int{
+	RoleReader: 1,
+	RoleWriter: 2,
+	RoleAdmin:  3,
+}
+
+const (
+	// 令牌保存在系统键空间中，键为该前缀加令牌的 SHA-256，集群中不保存令牌明文
+	tokenKeyPrefix = "auth/token/"
+
+	// 网关缓存已验证令牌的时间，吊销的令牌在其他网关上最多还能使用这么久
+	credentialCacheTTL = 5 * time.Second
+)
+
+// 一个 API 令牌对应的用户和角色
+type Credential struct {
+	ID      string    `json:"id"` // 令牌的 SHA-256，用于列出和吊销令牌
+	User    string    `json:"user"`
+	Role    string    `json:"role"`
+	Created time.Time `json:"created"`
+}
+
+type cachedCredential struct {
+	cred    Credential
+	expires time.Time
+}
+
+type authenticator struct {
+	adminToken string // 启动网关时配置的管理员令牌，用于创建第一批令牌
+
+	mu    sync.Mutex
+	cache map[string]cachedCredential // 令牌 ID -> 凭据
+}
+
+// 为 nil 时不做认证，所有请求都可以访问全部接口
+var auth *authenticator
+
+type credentialKey struct{}
+
+func tokenID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "kv_" + hex.EncodeToString(b), nil
+}
+
+// 令牌缺失、格式错误或不存在
+var errInvalidToken = errors.New("missing or invalid API token")
+
+// 从 Authorization: Bearer <token> 中取出令牌并查找对应的凭据
+// 令牌无效时返回 errInvalidToken；集群暂时无法完成读取时返回其他错误，此时无法判断令牌是否有效
+func (a *authenticator) authenticate(r *http.Request) (Credential, error) {
+	header := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || token == "" {
+		return Credential{}, errInvalidToken
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) == 1 {
+		return Credential{User: "admin", Role: RoleAdmin}, nil
+	}
+
+	id := tokenID(token)
+	a.mu.Lock()
+	cached, ok := a.cache[id]
+	a.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.cred, nil
+	}
+
+	// 通过日志读取，刚创建的令牌立即可用，已吊销的令牌不会从落后的节点上读到
+	raw, err := client.SysGet(tokenKeyPrefix + id)
+	if err != nil {
+		if err.Error() == kv.ErrNoKey {
+			return Credential{}, errInvalidToken
+		}
+		return Credential{}, err
+	}
+	var cred Credential
+	if err := json.Unmarshal([]byte(raw), &cred); err != nil {
+		logger.Error("Invalid credential stored for token", "token", id[:12], "err", err)
+		return Credential{}, errInvalidToken
+	}
+
+	a.mu.Lock()
+	a.cache[id] = cachedCredential{cred: cred, expires: time.Now().Add(credentialCacheTTL)}
+	a.mu.Unlock()
+	return cred, nil
+}
+
+func (a *authenticator) forget(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.cache, id)
+}
+
+// 要求请求至少具有 role 角色，未启用认证时直接放行
+func requireRole(role string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth == nil {
+			next(w, r)
+			return
+		}
+
+		cred, err := auth.authenticate(r)
+		if err == errInvalidToken {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kv"`)
+			writeError(w, r, http.StatusUnauthorized, CodeUnauthenticated, err.Error())
+			return
+		} else if err != nil {
+			logger.Warn("Failed to look up API token", "err", err)
+			writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "cannot verify API token, please retry")
+			return
+		}
+		if roleLevel[cred.Role] < roleLevel[role] {
+			writeError(w, r, http.StatusForbidden, CodePermissionDenied, "role "+cred.Role+" cannot access "+r.URL.Path)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), credentialKey{}, cred)))
+	})
+}
+
+// 返回以当前用户身份发起写操作的客户端，写操作会以该用户记录在审计日志中
+func clientFor(r *http.Request) *kv.KVClient {
+	c := client.WithRequestID(logging.RequestID(r.Context())).WithTrace(tracing.FromContext(r.Context()))
+	if cred, ok := r.Context().Value(credentialKey{}).(Credential); ok {
+		return c.WithActor(cred.User)
+	}
+	return c
+}
+
+// 处理 /auth/whoami 请求，返回当前令牌对应的用户和角色
+func handleWhoAmI(w http.ResponseWriter, r *http.Request) {
+	cred, _ := r.Context().Value(credentialKey{}).(Credential)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"user": cred.User, "role": cred.Role})
+}
+
+// 处理 /auth/tokens 请求：GET 列出令牌，POST 创建令牌，DELETE 按 id 或 user 吊销令牌
+func handleTokens(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		creds, err := listCredentials()
+		if err != nil {
+			writeJSONError(w, "Failed to list tokens", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(creds)
+
+	case http.MethodPost:
+		var request struct {
+			User string `json:"user"`
+			Role string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeJSONError(w, "Failed to parse request body", http.StatusBadRequest)
+			return
+		}
+		if request.User == "" {
+			writeJSONError(w, "user is required", http.StatusBadRequest)
+			return
+		}
+		if _, ok := roleLevel[request.Role]; !ok {
+			writeJSONError(w, "role must be one of reader, writer, admin", http.StatusBadRequest)
+			return
+		}
+
+		token, err := newToken()
+		if err != nil {
+			writeJSONError(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		cred := Credential{ID: tokenID(token), User: request.User, Role: request.Role, Created: time.Now().UTC()}
+		data, _ := json.Marshal(cred)
+		if err := clientFor(r).SysPut(tokenKeyPrefix+cred.ID, string(data)); err != nil {
+			writeJSONError(w, "Failed to store token", http.StatusServiceUnavailable)
+			return
+		}
+
+		// 令牌明文只在创建时返回一次
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":    cred.ID,
+			"token": token,
+			"user":  cred.User,
+			"role":  cred.Role,
+		})
+
+	case http.MethodDelete:
+		id, user := r.URL.Query().Get("id"), r.URL.Query().Get("user")
+		if id == "" && user == "" {
+			writeJSONError(w, "id or user is required", http.StatusBadRequest)
+			return
+		}
+		creds, err := listCredentials()
+		if err != nil {
+			writeJSONError(w, "Failed to list tokens", http.StatusServiceUnavailable)
+			return
+		}
+
+		revoked := 0
+		for _, cred := range creds {
+			if (id != "" && cred.ID != id) || (user != "" && cred.User != user) {
+				continue
+			}
+			if err := clientFor(r).SysDelete(tokenKeyPrefix + cred.ID); err != nil {
+				writeJSONError(w, "Failed to revoke token", http.StatusServiceUnavailable)
+				return
+			}
+			auth.forget(cred.ID)
+			revoked++
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"revoked": revoked})
+
+	default:
+		writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// 读取集群中保存的全部令牌，按用户排序
+func listCredentials() ([]Credential, error) {
+	values, err := client.SysList(tokenKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	creds := make([]Credential, 0, len(values))
+	for _, raw := range values {
+		var cred Credential
+		if json.Unmarshal([]byte(raw), &cred) == nil {
+			creds = append(creds, cred)
+		}
+	}
+	sort.Slice(creds, func(i, j int) bool {
+		if creds[i].User != creds[j].User {
+			return creds[i].User < creds[j].User
+		}
+		return creds[i].ID < creds[j].ID
+	})
+	return creds, nil
+}
+
+// 处理 /audit 请求，返回最近的写操作，可以按 key、actor 过滤
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	args := kv.AuditArgs{Key: query.Get("key"), Actor: query.Get("actor"), Limit: 100}
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 0 {
+			writeJSONError(w, "limit must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		args.Limit = limit
+	}
+
+	records, err := clientFor(r).Audit(args)
+	if err != nil {
+		writeJSONError(w, "Failed to read audit log", http.StatusServiceUnavailable)
+		return
+	}
+
+	type auditRecord struct {
+		Index int       `json:"index"`
+		Time  time.Time `json:"time"`
+		Actor string    `json:"actor"`
+		Op    string    `json:"op"`
+		Key   string    `json:"key"`
+	}
+	result := make([]auditRecord, 0, len(records))
+	for _, record := range records {
+		result = append(result, auditRecord{
+			Index: record.Index,
+			Time:  time.Unix(0, record.Time).UTC(),
+			Actor: record.Actor,
+			Op:    record.Op,
+			Key:   record.Key,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,84 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// 创建令牌，按角色限制访问，审计日志记录写操作的用户，吊销后令牌立即失效
+func TestTokenRolesAndAudit(t *testing.T) {
+	const admin = "admin-secret"
+	srv := startGateway(t, Options{AdminToken: admin})
+
+	if status, _ := do(t, srv, "GET", "/get?key=21030101", "", nil); status != http.StatusUnauthorized {
+		t.Fatalf("request without a token = %d, want 401", status)
+	}
+	if status, _ := do(t, srv, "GET", "/get?key=21030101", "kv_wrong", nil); status != http.StatusUnauthorized {
+		t.Fatalf("request with an unknown token = %d, want 401", status)
+	}
+	if status, _ := do(t, srv, "GET", "/healthz", "", nil); status != http.StatusOK {
+		t.Fatalf("/healthz without a token = %d, want 200", status)
+	}
+
+	type created struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	var writer, reader created
+	doJSON(t, srv, "POST", "/auth/tokens", admin, map[string]string{"user": "alice", "role": RoleWriter}, http.StatusCreated, &writer)
+	doJSON(t, srv, "POST", "/auth/tokens", admin, map[string]string{"user": "bob", "role": RoleReader}, http.StatusCreated, &reader)
+	if status, _ := do(t, srv, "POST", "/auth/tokens", admin, map[string]string{"user": "eve", "role": "root"}); status != http.StatusBadRequest {
+		t.Fatalf("token with an unknown role = %d, want 400", status)
+	}
+	if status, _ := do(t, srv, "POST", "/auth/tokens", writer.Token, map[string]string{"user": "eve", "role": RoleAdmin}); status != http.StatusForbidden {
+		t.Fatalf("writer creating a token = %d, want 403", status)
+	}
+
+	var who map[string]string
+	doJSON(t, srv, "GET", "/auth/whoami", reader.Token, nil, http.StatusOK, &who)
+	if who["user"] != "bob" || who["role"] != RoleReader {
+		t.Fatalf("whoami = %v, want bob as reader", who)
+	}
+
+	put := map[string]interface{}{"key": "21030101", "value": student("甲", 2021)}
+	if status, _ := do(t, srv, "POST", "/put", reader.Token, put); status != http.StatusForbidden {
+		t.Fatalf("reader writing = %d, want 403", status)
+	}
+	doJSON(t, srv, "POST", "/put", writer.Token, put, http.StatusOK, nil)
+	doJSON(t, srv, "GET", "/get?key=21030101", reader.Token, nil, http.StatusOK, nil)
+	if status, _ := do(t, srv, "GET", "/audit", writer.Token, nil); status != http.StatusForbidden {
+		t.Fatalf("writer reading the audit log = %d, want 403", status)
+	}
+
+	var records []struct {
+		Actor string `json:"actor"`
+		Op    string `json:"op"`
+		Key   string `json:"key"`
+	}
+	doJSON(t, srv, "GET", "/audit?key=21030101", admin, nil, http.StatusOK, &records)
+	if len(records) != 1 || records[0].Actor != "alice" || records[0].Op != "Put" {
+		t.Fatalf("audit for 21030101 = %+v, want one Put by alice", records)
+	}
+
+	var creds []Credential
+	doJSON(t, srv, "GET", "/auth/tokens", admin, nil, http.StatusOK, &creds)
+	if len(creds) != 2 || creds[0].User != "alice" || creds[1].User != "bob" {
+		t.Fatalf("tokens = %+v, want alice and bob", creds)
+	}
+
+	var revoked map[string]int
+	doJSON(t, srv, "DELETE", "/auth/tokens?user=bob", admin, nil, http.StatusOK, &revoked)
+	if revoked["revoked"] != 1 {
+		t.Fatalf("revoked %v, want 1", revoked)
+	}
+	if status, _ := do(t, srv, "GET", "/get?key=21030101", reader.Token, nil); status != http.StatusUnauthorized {
+		t.Fatalf("revoked token = %d, want 401", status)
+	}
+
+	// 令牌的创建和吊销本身也记入审计日志，令牌明文不会出现
+	status, body := do(t, srv, "GET", "/audit?actor=admin", admin, nil)
+	if status != http.StatusOK || !strings.Contains(string(body), "SysDelete") || strings.Contains(string(body), reader.Token) {
+		t.Fatalf("audit for admin = %d %s", status, body)
+	}
+}
@@ -0,0 +1,538 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"course/kv"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// /import 和 /export 用于批量导入导出学生记录，支持 CSV 和 JSON Lines 两种格式
+// CSV 的第一行为列名，学号列为 id（也可以写作 key），其余列为记录的字段名
+
+const (
+	// 导入文件的大小和行数上限
+	maxImportBytes = 64 << 20
+	maxImportRows  = 200000
+
+	// 每一批通过一次 MultiPut 提交
+	importBatchSize = 500
+
+	// 响应中最多列出多少行的错误
+	maxReportedRows = 1000
+
+	// 导出期间续期固定位置的间隔，需要明显短于 kv 中固定位置的有效期
+	exportRenewInterval = time.Minute
+)
+
+// Excel 打开没有 BOM 的 UTF-8 CSV 时会按本地编码解析，中文会变成乱码
+const utf8BOM = "\xef\xbb\xbf"
+
+// 一行没有通过检查的原因，Row 为该行在文件中的行号（从 1 开始，CSV 的列名为第 1 行）
+type RowError struct {
+	Row    int             `json:"row"`
+	ID     string          `json:"id,omitempty"`
+	Errors []kv.FieldError `json:"errors"`
+}
+
+type importRow struct {
+	line  int
+	key   string
+	value kv.KVEntry
+	errs  []kv.FieldError
+
+	malformed bool // 无法解析的行，不再按规则检查
+}
+
+// 处理 /import 请求，例如 POST /import?format=csv&dry_run=true
+// 请求体为文件内容，也可以是 multipart/form-data 中名为 file 的文件
+// 任何一行没有通过检查时不导入任何记录；dry_run 只检查不写入
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	body, format, err := importSource(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var rows []importRow
+	switch format {
+	case "csv":
+		rows, err = parseCSVRows(body)
+	case "jsonl":
+		rows, err = parseJSONLRows(body)
+	default:
+		err = fmt.Errorf("format must be csv or jsonl")
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONError(w, fmt.Sprintf("file is larger than %d bytes", maxImportBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 检查每一行，同一个学号出现多次也视为错误
+	schema := schemas.current()
+	seen := make(map[string]int, len(rows))
+	var rowErrors []RowError
+	invalid := 0
+	for _, row := range rows {
+		errs := row.errs
+		if !row.malformed {
+			errs = append(errs, schema.Validate(row.key, row.value)...)
+		}
+		if first, ok := seen[row.key]; ok && row.key != "" {
+			errs = append(errs, kv.FieldError{Field: "id", Message: fmt.Sprintf("duplicate of row %d", first)})
+		} else {
+			seen[row.key] = row.line
+		}
+		if len(errs) == 0 {
+			continue
+		}
+		invalid++
+		if len(rowErrors) < maxReportedRows {
+			rowErrors = append(rowErrors, RowError{Row: row.line, ID: row.key, Errors: errs})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if invalid > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   fmt.Sprintf("%d of %d rows failed validation, nothing was imported", invalid, len(rows)),
+			"rows":    len(rows),
+			"invalid": invalid,
+			"errors":  rowErrors,
+		})
+		return
+	}
+	if dryRun {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"dry_run":  true,
+			"rows":     len(rows),
+			"imported": 0,
+		})
+		return
+	}
+
+	// 分批提交，某一批失败时之前的批次已经写入，重新导入同一个文件是安全的
+	kvClient := clientFor(r)
+	imported := 0
+	for start := 0; start < len(rows); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		batch := make([]kv.KeyValue, 0, end-start)
+		for _, row := range rows[start:end] {
+			batch = append(batch, kv.KeyValue{Key: row.key, Value: row.value})
+		}
+		if err := kvClient.MultiPut(batch); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":    fmt.Sprintf("import stopped at row %d, please retry", rows[start].line),
+				"rows":     len(rows),
+				"imported": imported,
+			})
+			return
+		}
+		imported += len(batch)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rows":     len(rows),
+		"imported": imported,
+	})
+}
+
+// 返回要导入的内容和格式，format 参数优先，其次是 Content-Type 或上传文件的扩展名
+func importSource(r *http.Request) (io.Reader, string, error) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if mediaType == "multipart/form-data" {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			return nil, "", fmt.Errorf("multipart upload requires a file field: %v", err)
+		}
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(path.Ext(header.Filename)), ".")
+		}
+		return file, normalizeFormat(format), nil
+	}
+
+	if format == "" {
+		switch mediaType {
+		case "text/csv":
+			format = "csv"
+		case "application/x-ndjson", "application/jsonl", "application/json-lines":
+			format = "jsonl"
+		}
+	}
+	return r.Body, normalizeFormat(format), nil
+}
+
+func normalizeFormat(format string) string {
+	switch format {
+	case "ndjson", "json-lines":
+		return "jsonl"
+	}
+	return format
+}
+
+// 解析 CSV，第一行为列名；兼容 Excel 保存的带 BOM 和 CRLF 换行的文件
+func parseCSVRows(body io.Reader) ([]importRow, error) {
+	reader := bufio.NewReader(body)
+	if bom, err := reader.Peek(len(utf8BOM)); err == nil && string(bom) == utf8BOM {
+		reader.Discard(len(utf8BOM))
+	}
+	cr := csv.NewReader(reader)
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("file is empty")
+	} else if err != nil {
+		return nil, err
+	}
+	columns, err := importColumns(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []importRow
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("file has more than %d rows", maxImportRows)
+		}
+
+		line, _ := cr.FieldPos(0)
+		row := importRow{line: line}
+		for i, column := range columns {
+			cell := strings.TrimSpace(record[i])
+			if column == "id" {
+				row.key = unescapeFormula(cell)
+				continue
+			}
+			if typ, _ := kv.FieldType(column); typ == kv.FieldString {
+				cell = unescapeFormula(cell)
+			}
+			v, fe := parseCell(column, cell)
+			if fe != nil {
+				row.errs = append(row.errs, *fe)
+				continue
+			}
+			if fe := setImportField(&row.value, column, v); fe != nil {
+				row.errs = append(row.errs, *fe)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// 检查 CSV 的列名，返回每一列对应的字段名，学号列记为 id
+func importColumns(header []string) ([]string, error) {
+	columns := make([]string, len(header))
+	seen := make(map[string]bool)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "key" {
+			name = "id"
+		}
+		if _, ok := kv.FieldType(name); !ok && name != "id" {
+			return nil, fmt.Errorf("unknown column %q, expected id and %s", header[i], strings.Join(kv.EntryFields, ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("column %q appears more than once", name)
+		}
+		seen[name] = true
+		columns[i] = name
+	}
+	if !seen["id"] {
+		return nil, fmt.Errorf("an id column is required")
+	}
+	return columns, nil
+}
+
+// 将 CSV 单元格转换为与 JSON 解码结果相同的类型，空单元格为零值
+func parseCell(field, cell string) (interface{}, *kv.FieldError) {
+	typ, _ := kv.FieldType(field)
+	if typ == kv.FieldString {
+		return cell, nil
+	}
+	if cell == "" {
+		return 0.0, nil
+	}
+	f, err := strconv.ParseFloat(cell, 64)
+	if err != nil {
+		return nil, &kv.FieldError{Field: field, Message: fmt.Sprintf("%q is not a number", cell)}
+	}
+	return f, nil
+}
+
+// 解析 JSON Lines，每行一个与 /get 返回格式相同的对象，空行会被忽略
+func parseJSONLRows(body io.Reader) ([]importRow, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+
+	var rows []importRow
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if line == 1 {
+			text = bytes.TrimPrefix(text, []byte(utf8BOM))
+		}
+		if len(text) == 0 {
+			continue
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("file has more than %d rows", maxImportRows)
+		}
+
+		row := importRow{line: line}
+		var object map[string]interface{}
+		if err := json.Unmarshal(text, &object); err != nil {
+			row.errs = append(row.errs, kv.FieldError{Message: "not a JSON object: " + err.Error()})
+			row.malformed = true
+			rows = append(rows, row)
+			continue
+		}
+		for field, v := range object {
+			if field == "id" || field == "key" {
+				key, ok := v.(string)
+				if !ok {
+					row.errs = append(row.errs, kv.FieldError{Field: "id", Message: "must be a string"})
+				}
+				row.key = key
+				continue
+			}
+			if fe := setImportField(&row.value, field, v); fe != nil {
+				row.errs = append(row.errs, *fe)
+			}
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("line %d: %v", line+1, err)
+	}
+	return rows, nil
+}
+
+// 检查值的类型并设置字段
+func setImportField(e *kv.KVEntry, field string, v interface{}) *kv.FieldError {
+	typ, ok := kv.FieldType(field)
+	if !ok {
+		return &kv.FieldError{Field: field, Message: "unknown field"}
+	}
+	f, isNumber := v.(float64)
+	switch {
+	case typ == kv.FieldString:
+		if _, ok := v.(string); !ok {
+			return &kv.FieldError{Field: field, Message: "must be a string"}
+		}
+	case typ == kv.FieldInt && (!isNumber || f != float64(int(f))):
+		return &kv.FieldError{Field: field, Message: "must be an integer"}
+	case typ == kv.FieldFloat && !isNumber:
+		return &kv.FieldError{Field: field, Message: "must be a number"}
+	}
+	kv.SetEntryField(e, field, v)
+	return nil
+}
+
+// 处理 /export 请求，例如 /export?format=csv&columns=id,name,major
+// 所有页都读取同一个日志位置时的数据，导出过程中的写入不会出现在结果中
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	format := normalizeFormat(strings.ToLower(query.Get("format")))
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "jsonl" {
+		writeJSONError(w, "format must be csv or jsonl", http.StatusBadRequest)
+		return
+	}
+	columns := append([]string{"id"}, kv.EntryFields...)
+	if v := query.Get("columns"); v != "" {
+		columns = strings.Split(v, ",")
+		for i, column := range columns {
+			column = strings.TrimSpace(column)
+			if _, ok := kv.FieldType(column); !ok && column != "id" {
+				writeJSONError(w, fmt.Sprintf("unknown column %q, expected id and %s", column, strings.Join(kv.EntryFields, ", ")), http.StatusBadRequest)
+				return
+			}
+			columns[i] = column
+		}
+	}
+
+	// 先在领导者上通过日志固定一个位置，所有页都读取该位置时的数据；
+	// 导出结束前各节点都保留该位置之后的历史，后面的页不会因为历史被丢弃而失败
+	c := clientFor(r)
+	pinID, err := newPinID()
+	if err != nil {
+		writeJSONError(w, "Export failed, please retry", http.StatusInternalServerError)
+		return
+	}
+	index, err := c.PinVersions(pinID)
+	if err != nil {
+		writeJSONError(w, "Export failed, please retry", http.StatusServiceUnavailable)
+		return
+	}
+	defer func() {
+		if err := c.UnpinVersions(pinID); err != nil {
+			logger.WarnContext(r.Context(), "Failed to release export index, it expires on its own", "index", index, "err", err)
+		}
+	}()
+	entries, next, _, err := c.ScanAt("", "", kv.MaxScanLimit, "", index)
+	if err != nil {
+		writeJSONError(w, "Export failed, please retry", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("X-Export-Index", strconv.Itoa(index))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="students-%d.%s"`, index, format))
+	var writePage func([]kv.KeyValue) error
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		io.WriteString(w, utf8BOM)
+		cw := csv.NewWriter(w)
+		cw.UseCRLF = true
+		cw.Write(columns)
+		writePage = func(page []kv.KeyValue) error {
+			for _, entry := range page {
+				record := make([]string, len(columns))
+				for i, column := range columns {
+					record[i] = exportCell(entry, column)
+				}
+				cw.Write(record)
+			}
+			cw.Flush()
+			return cw.Error()
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		writePage = func(page []kv.KeyValue) error {
+			for _, entry := range page {
+				object := make(map[string]interface{}, len(columns))
+				for _, column := range columns {
+					if column == "id" {
+						object["id"] = entry.Key
+					} else {
+						object[column], _ = kv.EntryField(entry.Value, column)
+					}
+				}
+				if err := enc.Encode(object); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	flusher, _ := w.(http.Flusher)
+	renewed := time.Now()
+	for {
+		if err := writePage(entries); err != nil {
+			return // 客户端断开
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if next == "" {
+			return
+		}
+		// 导出时间较长时续期，避免固定的位置过期
+		if time.Since(renewed) > exportRenewInterval {
+			if _, err := c.PinVersions(pinID); err != nil {
+				logger.WarnContext(r.Context(), "Failed to renew export index", "index", index, "err", err)
+			} else {
+				renewed = time.Now()
+			}
+		}
+		entries, next, _, err = c.ScanAt("", "", kv.MaxScanLimit, next, index)
+		if err != nil {
+			// 响应已经开始发送，只能中断连接，让客户端知道导出不完整
+			logger.WarnContext(r.Context(), "Export aborted", "index", index, "err", err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// 导出固定位置的 ID，只用于区分同时进行的导出
+func newPinID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "export/" + hex.EncodeToString(b), nil
+}
+
+// 以这些字符开头的单元格会被 Excel 等表格软件当作公式执行，导出时在前面加上单引号
+const formulaPrefixes = "=+-@\t\r"
+
+// 导出时转义可能被当作公式的文本，导入时去掉这个单引号，导出的文件可以原样导入
+// 本身以单引号开头的文本也要转义，否则导入时会被当作转义去掉一个单引号
+func escapeFormula(cell string) string {
+	if cell != "" && (cell[0] == '\'' || strings.ContainsRune(formulaPrefixes, rune(cell[0]))) {
+		return "'" + cell
+	}
+	return cell
+}
+
+func unescapeFormula(cell string) string {
+	if cell != "" && cell[0] == '\'' {
+		return cell[1:]
+	}
+	return cell
+}
+
+// 数字列按数字输出，负数不会被当作公式，不需要转义
+func exportCell(entry kv.KeyValue, column string) string {
+	if column == "id" {
+		return escapeFormula(entry.Key)
+	}
+	v, _ := kv.EntryField(entry.Value, column)
+	switch x := v.(type) {
+	case string:
+		return escapeFormula(x)
+	case int:
+		return strconv.Itoa(x)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	return ""
+}
@@ -0,0 +1,153 @@
+package gateway
+
+import (
+	"bytes"
+	"course/kv"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// 导出的 CSV 原样导入后得到相同的文本，包括本身以单引号开头、看起来已经转义过的值
+func TestCSVFormulaRoundTrip(t *testing.T) {
+	names := []string{"plain", "=x", "+1", "-5", "@a", "'=x", "'+1", "'-5", "'@a", "'abc", "''", "'"}
+
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Write([]string{"id", "name"})
+	for _, name := range names {
+		entry := kv.KeyValue{Key: name, Value: kv.KVEntry{Name: name}}
+		cw.Write([]string{exportCell(entry, "id"), exportCell(entry, "name")})
+	}
+	cw.Flush()
+
+	rows, err := parseCSVRows(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(names) {
+		t.Fatalf("%d rows imported, want %d", len(rows), len(names))
+	}
+	for i, row := range rows {
+		if row.key != names[i] || row.value.Name != names[i] || len(row.errs) > 0 {
+			t.Fatalf("row %d imported as id %q, name %q, errors %v; want %q", i, row.key, row.value.Name, row.errs, names[i])
+		}
+	}
+}
+
+// 可能被当作公式的单元格导出时以单引号开头
+func TestExportEscapesFormulas(t *testing.T) {
+	for _, name := range []string{"=SUM(A1)", "+1", "-5", "@a", "'x"} {
+		cell := exportCell(kv.KeyValue{Key: "1", Value: kv.KVEntry{Name: name}}, "name")
+		if cell != "'"+name {
+			t.Fatalf("exportCell(%q) = %q, want %q", name, cell, "'"+name)
+		}
+	}
+	if cell := exportCell(kv.KeyValue{Key: "1", Value: kv.KVEntry{Grand: -1}}, "grand"); cell != "-1" {
+		t.Fatalf("exportCell(grand -1) = %q, want -1", cell)
+	}
+}
+
+const importCSV = "\xef\xbb\xbfid,name,grand,class,total_credits\r\n" +
+	"21030101,甲,2021,21计一,20\r\n" +
+	"21030102,乙,2021,21计一,18.5\r\n"
+
+// dry_run 只检查不写入；有任何一行不合法时整个文件都不导入，响应列出每一行的错误
+func TestImport(t *testing.T) {
+	srv := startGateway(t, Options{})
+	type result struct {
+		Rows     int        `json:"rows"`
+		Imported int        `json:"imported"`
+		Invalid  int        `json:"invalid"`
+		Errors   []RowError `json:"errors"`
+	}
+	missing := func(keys ...string) int {
+		var got struct {
+			Missing []string `json:"missing"`
+		}
+		doJSON(t, srv, "POST", "/batch_get", "", map[string]interface{}{"keys": keys}, http.StatusOK, &got)
+		return len(got.Missing)
+	}
+
+	var res result
+	doJSON(t, srv, "POST", "/import?format=csv&dry_run=true", "", importCSV, http.StatusOK, &res)
+	if res.Rows != 2 || res.Imported != 0 || missing("21030101", "21030102") != 2 {
+		t.Fatalf("dry run = %+v, or it wrote records", res)
+	}
+
+	bad := importCSV + "21030103,,2021,21计一,x\r\n21030101,丁,2021,21计一,1\r\n"
+	res = result{}
+	doJSON(t, srv, "POST", "/import?format=csv", "", bad, http.StatusUnprocessableEntity, &res)
+	if res.Rows != 4 || res.Invalid != 2 || len(res.Errors) != 2 || missing("21030101", "21030102") != 2 {
+		t.Fatalf("import with bad rows = %+v, or it wrote records", res)
+	}
+	if e := res.Errors[0]; e.Row != 4 || e.ID != "21030103" || len(e.Errors) != 2 {
+		t.Errorf("errors for row 4 = %+v, want total_credits and name", e)
+	}
+	if e := res.Errors[1]; e.Row != 5 || len(e.Errors) != 1 || e.Errors[0].Message != "duplicate of row 2" {
+		t.Errorf("errors for row 5 = %+v, want a duplicate of row 2", e)
+	}
+
+	res = result{}
+	doJSON(t, srv, "POST", "/import?format=csv", "", importCSV, http.StatusOK, &res)
+	if res.Imported != 2 || missing("21030101", "21030102") != 0 {
+		t.Fatalf("import = %+v", res)
+	}
+
+	jsonl := `{"id": "21030201", "name": "丙", "grand": 2022}` + "\n\n" + `{"id": "21030202", "name": "丁", "grand": "2022"}` + "\nnot json\n"
+	res = result{}
+	doJSON(t, srv, "POST", "/import?format=jsonl", "", jsonl, http.StatusUnprocessableEntity, &res)
+	if res.Invalid != 2 || res.Errors[0].Row != 3 || res.Errors[1].Row != 4 {
+		t.Fatalf("jsonl import with bad lines = %+v", res)
+	}
+
+	if status, body := do(t, srv, "POST", "/import?format=xml", "", "x"); status != http.StatusBadRequest {
+		t.Errorf("import of an unknown format = %d %s, want 400", status, body)
+	}
+	if status, body := do(t, srv, "POST", "/import?format=csv", "", "name\r\n甲\r\n"); status != http.StatusBadRequest {
+		t.Errorf("import without an id column = %d %s, want 400", status, body)
+	}
+}
+
+// 导出读取 X-Export-Index 所在位置的数据，导出的文件可以原样导入
+func TestExportRoundTrip(t *testing.T) {
+	srv := startGateway(t, Options{})
+	doJSON(t, srv, "POST", "/import?format=csv", "", importCSV, http.StatusOK, nil)
+
+	resp, err := srv.Client().Get(srv.URL + "/export?format=csv&columns=id,name,grand,class,total_credits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("export = %d, %v", resp.StatusCode, err)
+	}
+	if string(exported) != importCSV {
+		t.Fatalf("exported %q, want %q", exported, importCSV)
+	}
+	index := resp.Header.Get("X-Export-Index")
+	if index == "" || !strings.Contains(resp.Header.Get("Content-Disposition"), "students-"+index+".csv") {
+		t.Fatalf("X-Export-Index = %q, Content-Disposition = %q", index, resp.Header.Get("Content-Disposition"))
+	}
+
+	// 导出之后的写入不影响导出位置上的数据
+	changed := student("改", 2021)
+	doJSON(t, srv, "PUT", "/api/v1/students/21030101", "", changed, http.StatusOK, nil)
+	var record map[string]interface{}
+	doJSON(t, srv, "GET", "/api/v1/students/21030101?as_of="+index, "", nil, http.StatusOK, &record)
+	if record["name"] != "甲" {
+		t.Fatalf("record at the export index = %v, want name 甲", record)
+	}
+
+	status, body := do(t, srv, "GET", "/export?format=jsonl&columns=id,name", "", nil)
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	if status != http.StatusOK || len(lines) != 2 || lines[0] != `{"id":"21030101","name":"改"}` {
+		t.Fatalf("jsonl export = %d %q", status, body)
+	}
+	if status, _ := do(t, srv, "GET", "/export?columns=id,nickname", "", nil); status != http.StatusBadRequest {
+		t.Fatalf("export with an unknown column = %d, want 400", status)
+	}
+}
@@ -0,0 +1,836 @@
+package gateway
+
+import (
+	"course/cluster"
+	"course/kv"
+	"course/logging"
+	"course/metrics"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var logger = logging.Logger(logging.TopicGateway)
+
+// HTTP 网关通过 KVClient 访问集群，既可以和节点运行在同一进程中，也可以作为独立进程通过网络访问节点
+
+var client *kv.KVClient
+
+// 网关配置
+type Options struct {
+	// 不为空时启用认证：所有接口都需要 Authorization: Bearer <token>
+	// 持有该令牌的请求具有 admin 角色，可以通过 /auth/tokens 创建保存在集群中的令牌
+	AdminToken string
+
+	// 允许跨域访问的来源，"*" 表示允许所有来源，为空时不允许跨域访问
+	AllowedOrigins []string
+
+	// 与网关运行在同一进程中的集群，不为 nil 时启用 /admin/ 下注入故障的接口（admin 角色）
+	// 这些接口可以让整个集群停止服务，必须同时设置 AdminToken
+	Cluster *cluster.Cluster
+}
+
+func cors(allowed []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 设置允许的来源，未配置时不返回 Access-Control-Allow-Origin，浏览器会拒绝跨域请求
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		for _, o := range allowed {
+			if o == "*" {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+				break
+			}
+			if o == origin {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				break
+			}
+		}
+		// 设置允许的请求方法
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		// 设置允许的请求头
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+		// 处理预检请求（OPTIONS）
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// 返回 HTTP API 的处理器，所有请求都通过 c 访问集群
+func Handler(c *kv.KVClient, opts Options) http.Handler {
+	client = c
+	schemas = &schemaCache{}
+	auth = nil
+	if opts.AdminToken != "" {
+		auth = &authenticator{adminToken: opts.AdminToken, cache: make(map[string]cachedCredential)}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/put", requireRole(RoleWriter, handlePut))
+	mux.Handle("/get", requireRole(RoleReader, handleGet))
+	mux.Handle("/get_field", requireRole(RoleReader, handleGetField))
+	mux.Handle("/history", requireRole(RoleReader, handleHistory))
+	mux.Handle("/patch", requireRole(RoleWriter, handlePatch))
+	mux.Handle("/search", requireRole(RoleReader, handleSearch))
+	mux.Handle("/list_all", requireRole(RoleReader, handleListAll))
+	mux.Handle("/batch_get", requireRole(RoleReader, handleBatchGet))
+	mux.Handle("/batch_put", requireRole(RoleWriter, handleBatchPut))
+	mux.Handle("/scan", requireRole(RoleReader, handleScan))
+	mux.Handle("/stats", requireRole(RoleReader, handleStats))
+	mux.Handle("/watch", requireRole(RoleReader, handleWatch))
+	mux.Handle("/import", requireRole(RoleWriter, handleImport))
+	mux.Handle("/metrics", requireRole(RoleReader, metrics.Handler(metrics.Default).ServeHTTP))
+	mux.Handle("/export", requireRole(RoleReader, handleExport))
+	mux.Handle("/audit", requireRole(RoleAdmin, handleAudit))
+	mux.Handle("/log/levels", requireRole(RoleAdmin, logging.LevelsHandler().ServeHTTP))
+	mux.Handle("/cluster/status", requireRole(RoleReader, handleClusterStatus))
+	// 探针不需要认证
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", handleReadyz)
+	mux.Handle("GET /schema", requireRole(RoleReader, handleSchema))
+	mux.Handle("PUT /schema", requireRole(RoleAdmin, handleSchema))
+	if auth != nil {
+		mux.Handle("/auth/whoami", requireRole(RoleReader, handleWhoAmI))
+		mux.Handle("/auth/tokens", requireRole(RoleAdmin, handleTokens))
+	}
+	registerAPI(mux)
+	registerAdmin(mux, opts.Cluster)
+
+	// 使用跨域中间件，并为每个请求分配请求 ID
+	return withRequestLog(cors(opts.AllowedOrigins, mux))
+}
+
+// 在 addr 上启动 HTTP 服务
+func ListenAndServe(addr string, c *kv.KVClient, opts Options) error {
+	if opts.Cluster != nil && opts.AdminToken == "" {
+		return errors.New("fault injection endpoints require an admin token")
+	}
+	if opts.AdminToken == "" {
+		logger.Warn("HTTP authentication is disabled, every client can read and write")
+	}
+	logger.Info("HTTP server is running", "addr", addr)
+	return http.ListenAndServe(addr, Handler(c, opts))
+}
+
+// 处理 /put 请求
+func handlePut(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Key   string     `json:"key"`
+		Value kv.KVEntry `json:"value"`
+		TTL   float64    `json:"ttl"` // 可选，单位为秒，到期后自动删除
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeJSONError(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+	if request.TTL < 0 {
+		writeJSONError(w, "ttl must not be negative", http.StatusBadRequest)
+		return
+	}
+	if errs := schemas.current().Validate(request.Key, request.Value); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	if err := clientFor(r).PutWithTTL(request.Key, request.Value, time.Duration(request.TTL*float64(time.Second))); err != nil {
+		writeJSONError(w, "Put failed, please retry", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": fmt.Sprintf("Put operation successful for key: %s", request.Key),
+	})
+}
+
+// 处理 /get 请求
+func handleGet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeJSONError(w, "Key is required", http.StatusBadRequest)
+		return
+	}
+
+	// 带 as_of 时读取该日志位置时的历史值
+	if asOf := r.URL.Query().Get("as_of"); asOf != "" {
+		index, err := strconv.Atoi(asOf)
+		if err != nil || index <= 0 {
+			writeJSONError(w, "as_of must be a positive log index", http.StatusBadRequest)
+			return
+		}
+		value, err := clientFor(r).GetAt(key, index)
+		if err != nil {
+			switch err.Error() {
+			case kv.ErrNoKey:
+				writeJSONError(w, "Key not found", http.StatusNotFound)
+			case kv.ErrCompacted:
+				writeJSONError(w, "History at this index has been compacted", http.StatusGone)
+			default:
+				writeJSONError(w, "Get failed, please retry", http.StatusServiceUnavailable)
+			}
+			return
+		}
+		record, _ := entryToRecord(value)
+		record["id"] = key
+		record["as_of"] = index
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(record)
+		return
+	}
+
+	value, err := clientFor(r).GetEntry(key)
+	if err != nil {
+		writeGetError(w, err)
+		return
+	}
+
+	// 将记录转换为结构化对象
+	record, err := entryToRecord(value)
+	if err != nil {
+		writeJSONError(w, "Failed to parse record", http.StatusInternalServerError)
+		return
+	}
+
+	// 添加 key 字段到记录中
+	record["id"] = key
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(record)
+}
+
+// 返回读取失败的错误：键不存在时为 404，其他错误（没有领导者、超时）为 503，客户端可以重试
+func writeGetError(w http.ResponseWriter, err error) {
+	if err.Error() == kv.ErrNoKey {
+		writeJSONError(w, "Key not found", http.StatusNotFound)
+		return
+	}
+	writeJSONError(w, "Get failed, please retry", http.StatusServiceUnavailable)
+}
+
+// 处理 /history 请求，列出一个键的历史版本
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeJSONError(w, "Key is required", http.StatusBadRequest)
+		return
+	}
+
+	versions, horizon, err := clientFor(r).History(key)
+	if err != nil {
+		writeJSONError(w, "History failed, please retry", http.StatusServiceUnavailable)
+		return
+	}
+
+	changes := []map[string]interface{}{}
+	for _, version := range versions {
+		change := map[string]interface{}{
+			"index": version.Index,
+			"type":  kv.EventPut,
+		}
+		if version.Time != 0 {
+			change["time"] = time.Unix(0, version.Time).Format(time.RFC3339Nano)
+		}
+		if version.Deleted {
+			change["type"] = kv.EventDelete
+		} else if record, err := entryToRecord(version.Value); err == nil {
+			change["value"] = record
+		}
+		changes = append(changes, change)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      key,
+		"horizon": horizon,
+		"changes": changes,
+	})
+}
+
+// 处理 /get_field 请求，只返回记录中的一个字段
+func handleGetField(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	field := r.URL.Query().Get("field")
+	if key == "" || field == "" {
+		writeJSONError(w, "Key and field are required", http.StatusBadRequest)
+		return
+	}
+	if _, ok := kv.FieldType(field); !ok {
+		writeJSONError(w, fmt.Sprintf("Invalid field: %s, expected one of %s", field, strings.Join(kv.EntryFields, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	value, err := clientFor(r).GetEntry(key)
+	if err != nil {
+		writeGetError(w, err)
+		return
+	}
+
+	record, err := entryToRecord(value)
+	if err != nil {
+		writeJSONError(w, "Failed to parse record", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":    key,
+		"field": field,
+		"value": record[field],
+	})
+}
+
+// 处理 /patch 请求，只修改请求中给出的字段
+// 合并操作通过 Raft 提交，并发修改不同字段不会互相覆盖
+func handlePatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Key    string                 `json:"key"`
+		Fields map[string]interface{} `json:"fields"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeJSONError(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+	if request.Key == "" || len(request.Fields) == 0 {
+		writeJSONError(w, "Key and fields are required", http.StatusBadRequest)
+		return
+	}
+
+	fields, value, err := parsePatchFields(request.Fields)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errs := schemas.current().ValidateFields(request.Key, fields, value); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	err = clientFor(r).Patch(request.Key, fields, value)
+	if err != nil {
+		if err.Error() == kv.ErrNoKey {
+			writeJSONError(w, "Key not found", http.StatusNotFound)
+		} else {
+			writeJSONError(w, "Patch failed, please retry", http.StatusServiceUnavailable)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": fmt.Sprintf("Patch operation successful for key: %s", request.Key),
+		"fields":  fields,
+	})
+}
+
+// 处理 /search 请求
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 解析查询条件，语法见 ParseSearchQuery
+	args, err := ParseSearchQuery(r.URL.RawQuery)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(args.Conditions) == 0 {
+		writeJSONError(w, "At least one condition is required", http.StatusBadRequest)
+		return
+	}
+
+	// 查询、排序和分页都在 KVServer 上执行
+	entries, total, err := clientFor(r).Query(args)
+	if err != nil {
+		var queryErr *kv.QueryError
+		if errors.As(err, &queryErr) {
+			writeJSONError(w, queryErr.Detail, http.StatusBadRequest)
+		} else {
+			writeJSONError(w, "Query failed, please retry", http.StatusServiceUnavailable)
+		}
+		return
+	}
+
+	// 分页信息放在响应头中，响应体保持为记录数组
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if next := args.Offset + len(entries); args.Limit > 0 && next < total {
+		w.Header().Set("X-Next-Offset", strconv.Itoa(next))
+	}
+
+	// 结果列表
+	var results []map[string]interface{}
+	for _, entry := range entries {
+		record, err := entryToRecord(entry.Value)
+		if err != nil {
+			continue
+		}
+		record["id"] = entry.Key // 添加键作为 `id` 字段
+		results = append(results, record)
+	}
+
+	// 设置响应头为 JSON 格式
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// 处理 /list_all 请求
+func handleListAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 从客户端获取所有键，并一次性批量读取
+	keys, err := clientFor(r).GetAllKeys()
+	if err != nil {
+		writeJSONError(w, "List failed, please retry", http.StatusServiceUnavailable)
+		return
+	}
+	values, err := clientFor(r).MultiGet(keys)
+	if err != nil {
+		writeJSONError(w, "List failed, please retry", http.StatusServiceUnavailable)
+		return
+	}
+
+	// 存储所有学生信息的列表
+	var results []map[string]interface{}
+
+	// 遍历每个键
+	for _, key := range keys {
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+
+		// 将数据转换为结构化对象
+		record, err := entryToRecord(value)
+		if err != nil {
+			continue
+		}
+
+		// 添加键到结果中
+		record["id"] = key
+		results = append(results, record)
+	}
+
+	// 设置响应头为 JSON 格式
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// 处理 /batch_get 请求
+func handleBatchGet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Keys []string `json:"keys"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeJSONError(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+	if len(request.Keys) == 0 {
+		writeJSONError(w, "Keys are required", http.StatusBadRequest)
+		return
+	}
+
+	values, err := clientFor(r).MultiGet(request.Keys)
+	if err != nil {
+		writeJSONError(w, "Batch get failed, please retry", http.StatusServiceUnavailable)
+		return
+	}
+
+	// 按请求顺序返回找到的记录，不存在的键单独列出
+	results := []map[string]interface{}{}
+	missing := []string{}
+	for _, key := range request.Keys {
+		value, ok := values[key]
+		if !ok {
+			missing = append(missing, key)
+			continue
+		}
+
+		record, err := entryToRecord(value)
+		if err != nil {
+			continue
+		}
+		record["id"] = key
+		results = append(results, record)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"records": results,
+		"missing": missing,
+	})
+}
+
+// 处理 /batch_put 请求
+func handleBatchPut(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Entries []kv.KeyValue `json:"entries"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeJSONError(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+	if len(request.Entries) == 0 {
+		writeJSONError(w, "Entries are required", http.StatusBadRequest)
+		return
+	}
+	// 任何一条记录没有通过校验时整批都不写入，错误的字段名带上记录的位置
+	schema := schemas.current()
+	var errs []kv.FieldError
+	for i, entry := range request.Entries {
+		for _, fe := range schema.Validate(entry.Key, entry.Value) {
+			fe.Field = fmt.Sprintf("entries[%d].%s", i, fe.Field)
+			errs = append(errs, fe)
+		}
+	}
+	if len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	if err := clientFor(r).MultiPut(request.Entries); err != nil {
+		writeJSONError(w, "Batch put failed, please retry", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": fmt.Sprintf("Batch put operation successful for %d keys", len(request.Entries)),
+	})
+}
+
+// 处理 /scan 请求，支持 start/end 范围扫描或 prefix 前缀扫描
+func handleScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	start := query.Get("start")
+	end := query.Get("end")
+	token := query.Get("token")
+
+	if prefix != "" && (start != "" || end != "") {
+		writeJSONError(w, "prefix cannot be combined with start or end", http.StatusBadRequest)
+		return
+	}
+
+	limit := 0
+	if v := query.Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			writeJSONError(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var entries []kv.KeyValue
+	var nextToken string
+	var err error
+	if prefix != "" {
+		entries, nextToken, err = clientFor(r).PrefixScan(prefix, limit, token)
+	} else {
+		entries, nextToken, err = clientFor(r).Scan(start, end, limit, token)
+	}
+	if err != nil {
+		writeJSONError(w, "Scan failed, please retry", http.StatusServiceUnavailable)
+		return
+	}
+
+	results := []map[string]interface{}{}
+	for _, entry := range entries {
+		record, err := entryToRecord(entry.Value)
+		if err != nil {
+			continue
+		}
+		record["id"] = entry.Key
+		results = append(results, record)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"records":    results,
+		"next_token": nextToken,
+	})
+}
+
+// 处理 /stats 请求，例如 /stats?group_by=class&metrics=count,avg(total_credits)
+// 其余参数按 /search 的语法作为筛选条件
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	args := kv.StatsArgs{GroupBy: []string{}}
+	if v := query.Get("group_by"); v != "" {
+		args.GroupBy = strings.Split(v, ",")
+	}
+	metrics := query.Get("metrics")
+	if metrics == "" {
+		metrics = kv.MetricCount
+	}
+	for _, part := range strings.Split(metrics, ",") {
+		m, err := kv.ParseMetric(part)
+		if err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		args.Metrics = append(args.Metrics, m)
+	}
+
+	// 去掉 group_by 和 metrics 后，剩下的部分是筛选条件
+	var filters []string
+	for _, term := range strings.Split(r.URL.RawQuery, "&") {
+		if !strings.HasPrefix(term, "group_by=") && !strings.HasPrefix(term, "metrics=") {
+			filters = append(filters, term)
+		}
+	}
+	filter, err := ParseSearchQuery(strings.Join(filters, "&"))
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(filter.Sort) > 0 || filter.Limit > 0 || filter.Offset > 0 {
+		writeJSONError(w, "sort, limit and offset are not supported by /stats", http.StatusBadRequest)
+		return
+	}
+	args.Conditions = filter.Conditions
+
+	groups, err := clientFor(r).Stats(args)
+	if err != nil {
+		var queryErr *kv.QueryError
+		if errors.As(err, &queryErr) {
+			writeJSONError(w, queryErr.Detail, http.StatusBadRequest)
+		} else {
+			writeJSONError(w, "Stats failed, please retry", http.StatusServiceUnavailable)
+		}
+		return
+	}
+
+	// 每个分组输出为一个对象：分组字段的取值加上各项指标
+	results := []map[string]interface{}{}
+	for _, group := range groups {
+		result := map[string]interface{}{}
+		for i, field := range args.GroupBy {
+			result[field] = group.Key[i]
+		}
+		for _, m := range args.Metrics {
+			switch m.Func {
+			case kv.MetricCount:
+				result[m.String()] = group.Count
+			case kv.MetricDist:
+				result[m.String()] = group.Distributions[m.String()]
+			default:
+				result[m.String()] = group.Values[m.String()]
+			}
+		}
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"group_by": args.GroupBy,
+		"groups":   results,
+	})
+}
+
+// 处理 /watch 请求，以 Server-Sent Events 推送 key 或 prefix 上的变更
+// 每个事件的 id 为其 Raft 日志位置，断线重连时可以通过 from 参数或
+// Last-Event-ID 请求头从上次的位置继续
+func handleWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	key := query.Get("key")
+	prefix := query.Get("prefix")
+	if key != "" && prefix != "" {
+		writeJSONError(w, "key and prefix cannot be used together", http.StatusBadRequest)
+		return
+	}
+
+	from := 0
+	if v := query.Get("from"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeJSONError(w, "from must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		from = n
+	} else if v := r.Header.Get("Last-Event-ID"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeJSONError(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		from = n + 1
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		default:
+		}
+
+		events, next, err := clientFor(r).Watch(key, prefix, from, 10*time.Second)
+		if err != nil {
+			data := map[string]interface{}{"error": err.Error()}
+			if err.Error() == kv.ErrCompacted {
+				// 请求的历史已经不在节点上，客户端需要重新读取全量数据后从 next 开始监听
+				data = map[string]interface{}{"error": "compacted", "next": next}
+			}
+			// 错误信息可能包含引号和换行，编码成 JSON 后才能放进一行 data
+			body, _ := json.Marshal(data)
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", body)
+			flusher.Flush()
+			return
+		}
+
+		for _, event := range events {
+			data := map[string]interface{}{
+				"index": event.Index,
+				"key":   event.Key,
+			}
+			if event.Type == kv.EventPut {
+				record, err := entryToRecord(event.Value)
+				if err == nil {
+					record["id"] = event.Key
+					data["value"] = record
+				}
+			}
+			body, _ := json.Marshal(data)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Index, event.Type, body)
+		}
+		if len(events) == 0 {
+			// 心跳，避免代理因长时间无数据断开连接
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		flusher.Flush()
+		from = next
+	}
+}
+
+// 以 {"error": message} 的格式返回错误，message 会被正确转义
+func writeJSONError(w http.ResponseWriter, message string, code int) {
+	var body strings.Builder
+	enc := json.NewEncoder(&body)
+	enc.SetEscapeHTML(false) // 保留错误信息中的 < > 等运算符
+	enc.Encode(map[string]string{"error": message})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	fmt.Fprint(w, body.String())
+}
+
+// 按记录的字段定义检查要修改的字段名和类型，返回字段列表和只包含这些字段的记录
+func parsePatchFields(request map[string]interface{}) ([]string, kv.KVEntry, error) {
+	var value kv.KVEntry
+	var fields []string
+	for _, field := range kv.EntryFields {
+		v, ok := request[field]
+		if !ok {
+			continue
+		}
+		if err := kv.SetEntryField(&value, field, v); err != nil {
+			return nil, value, err
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) != len(request) {
+		for field := range request {
+			if _, ok := kv.FieldType(field); !ok {
+				return nil, value, fmt.Errorf("Invalid field: %s, expected one of %s", field, strings.Join(kv.EntryFields, ", "))
+			}
+		}
+	}
+	return fields, value, nil
+}
+
+// 将 KVEntry 转换为可以附加额外字段的 JSON 对象
+func entryToRecord(value kv.KVEntry) (map[string]interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var record map[string]interface{}
+	err = json.Unmarshal(data, &record)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
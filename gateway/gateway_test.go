@@ -0,0 +1,181 @@
+package gateway
+
+// 网关的测试：在 labrpc 上启动三个节点的集群，通过 httptest 发送 HTTP 请求
+
+import (
+	"bytes"
+	"course/cluster"
+	"course/kv"
+	"course/logging"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// 集群从空的状态开始，不读写 data_kv.json
+	kv.DataFile = ""
+	if err := logging.Setup("", "error"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// 启动三个节点的集群和连接它的网关，测试结束时停止
+func startGateway(t *testing.T, opts Options) *httptest.Server {
+	t.Helper()
+	c := cluster.New(3, cluster.Options{MaxRaftState: -1})
+	t.Cleanup(c.Shutdown)
+	srv := httptest.NewServer(Handler(kv.MakeKVClient(c.ClientEnds()), opts))
+	t.Cleanup(srv.Close)
+
+	// 等待选出领导者，之后的请求不会因为集群刚启动而失败
+	for start := time.Now(); ; time.Sleep(50 * time.Millisecond) {
+		if _, ok := c.Leader(); ok {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("no leader after 5s")
+		}
+	}
+	return srv
+}
+
+// 发送请求，body 不是 nil 时编码为 JSON，返回状态码和响应体
+func do(t *testing.T, srv *httptest.Server, method, path, token string, body interface{}) (int, []byte) {
+	t.Helper()
+	var reader io.Reader
+	if raw, ok := body.(string); ok {
+		reader = bytes.NewBufferString(raw)
+	} else if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, srv.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
+
+// 发送请求并把 JSON 响应解码到 out，状态码不是 want 时失败
+func doJSON(t *testing.T, srv *httptest.Server, method, path, token string, body interface{}, want int, out interface{}) {
+	t.Helper()
+	status, data := do(t, srv, method, path, token, body)
+	if status != want {
+		t.Fatalf("%s %s = %d %s, want %d", method, path, status, data, want)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			t.Fatalf("%s %s: %v in %s", method, path, err, data)
+		}
+	}
+}
+
+// 符合默认校验规则的一条记录
+func student(name string, grand int) kv.KVEntry {
+	return kv.KVEntry{Name: name, Grand: grand, Class: "21计一", Major: "软件工程", CourseCount: 10, TotalCredits: 20}
+}
+
+// 批量写入后批量读取，按请求的顺序返回记录，不存在的键单独列出；任何一条不合法时整批都不写入
+func TestBatchPutGet(t *testing.T) {
+	srv := startGateway(t, Options{})
+
+	entries := []kv.KeyValue{
+		{Key: "21030101", Value: student("甲", 2021)},
+		{Key: "21030102", Value: student("乙", 2021)},
+	}
+	doJSON(t, srv, "POST", "/batch_put", "", map[string]interface{}{"entries": entries}, http.StatusOK, nil)
+
+	var got struct {
+		Records []map[string]interface{} `json:"records"`
+		Missing []string                 `json:"missing"`
+	}
+	doJSON(t, srv, "POST", "/batch_get", "", map[string]interface{}{"keys": []string{"21030102", "21039999", "21030101"}}, http.StatusOK, &got)
+	if len(got.Records) != 2 || got.Records[0]["id"] != "21030102" || got.Records[0]["name"] != "乙" || got.Records[1]["id"] != "21030101" {
+		t.Fatalf("batch_get records = %v", got.Records)
+	}
+	if len(got.Missing) != 1 || got.Missing[0] != "21039999" {
+		t.Fatalf("batch_get missing = %v, want [21039999]", got.Missing)
+	}
+
+	bad := []kv.KeyValue{
+		{Key: "21030103", Value: student("丙", 2021)},
+		{Key: "21030104", Value: kv.KVEntry{Grand: 2021}},
+	}
+	status, body := do(t, srv, "POST", "/batch_put", "", map[string]interface{}{"entries": bad})
+	if status != http.StatusUnprocessableEntity || !bytes.Contains(body, []byte("entries[1].name")) {
+		t.Fatalf("batch_put with an invalid record = %d %s, want 422 naming entries[1].name", status, body)
+	}
+	doJSON(t, srv, "POST", "/batch_get", "", map[string]interface{}{"keys": []string{"21030103"}}, http.StatusOK, &got)
+	if len(got.Records) != 0 {
+		t.Fatalf("part of a rejected batch was written: %v", got.Records)
+	}
+
+	status, _ = do(t, srv, "POST", "/batch_get", "", map[string]interface{}{"keys": []string{}})
+	if status != http.StatusBadRequest {
+		t.Fatalf("batch_get without keys = %d, want 400", status)
+	}
+}
+
+// /scan 按 next_token 翻页，prefix 与 start、end 不能同时使用
+func TestScanPages(t *testing.T) {
+	srv := startGateway(t, Options{})
+	var entries []kv.KeyValue
+	for i := 0; i < 5; i++ {
+		entries = append(entries, kv.KeyValue{Key: fmt.Sprintf("2103010%d", i), Value: student("甲", 2021)})
+	}
+	entries = append(entries, kv.KeyValue{Key: "21040101", Value: student("乙", 2021)})
+	doJSON(t, srv, "POST", "/batch_put", "", map[string]interface{}{"entries": entries}, http.StatusOK, nil)
+
+	type page struct {
+		Records   []map[string]interface{} `json:"records"`
+		NextToken string                   `json:"next_token"`
+	}
+	var ids []interface{}
+	path := "/scan?prefix=2103&limit=2"
+	for i := 0; i < 5; i++ {
+		var p page
+		doJSON(t, srv, "GET", path, "", nil, http.StatusOK, &p)
+		for _, record := range p.Records {
+			ids = append(ids, record["id"])
+		}
+		if p.NextToken == "" {
+			break
+		}
+		path = "/scan?prefix=2103&limit=2&token=" + p.NextToken
+	}
+	if fmt.Sprint(ids) != "[21030100 21030101 21030102 21030103 21030104]" {
+		t.Fatalf("scanned %v", ids)
+	}
+
+	var p page
+	doJSON(t, srv, "GET", "/scan?start=21030103&end=21040101", "", nil, http.StatusOK, &p)
+	if len(p.Records) != 2 || p.Records[1]["id"] != "21030104" {
+		t.Fatalf("range scan = %v", p.Records)
+	}
+	if status, _ := do(t, srv, "GET", "/scan?prefix=2103&start=1", "", nil); status != http.StatusBadRequest {
+		t.Fatalf("prefix with start = %d, want 400", status)
+	}
+}
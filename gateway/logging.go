@@ -0,0 +1,109 @@
+package gateway
+
+import (
+	"course/logging"
+	"course/tracing"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// 请求 ID 的请求头和响应头
+const requestIDHeader = "X-Request-ID"
+
+// 为每个请求分配请求 ID，记录访问日志和该请求的 span
+// 请求中带有合法的 X-Request-ID 时沿用它，方便和上游的日志对应起来；
+// 带有 traceparent 请求头时，span 挂在上游的 trace 下面
+func withRequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		parent, _ := tracing.ParseTraceParent(r.Header.Get("traceparent"))
+		span := tracing.Start(parent, "HTTP "+r.Method+" "+r.URL.Path, tracing.KindServer,
+			"http.method", r.Method, "http.target", r.URL.Path, "request.id", id)
+		ctx := logging.WithRequestID(r.Context(), id)
+		if span != nil {
+			ctx = tracing.ContextWith(ctx, span.Context())
+			w.Header().Set("X-Trace-ID", span.Context().TraceID.String())
+		}
+		r = r.WithContext(ctx)
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		span.SetAttrs("http.status_code", rec.status)
+		if rec.status >= 500 {
+			span.SetError(http.StatusText(rec.status))
+		}
+		span.End()
+
+		level := slog.LevelInfo
+		if rec.status >= 500 {
+			level = slog.LevelWarn
+		}
+		logger.Log(r.Context(), level, "HTTP request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration", time.Since(start).String())
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 只接受较短的可打印 ASCII 字符串，避免把任意内容写进日志
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// 记录响应状态码和长度，同时保留 Flush，/watch 和 /export 需要流式输出
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
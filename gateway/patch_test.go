@@ -0,0 +1,61 @@
+package gateway
+
+import (
+	"course/kv"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// 请求中的字段按 KVEntry 的顺序解析，类型不符或字段不存在时报错
+func TestParsePatchFields(t *testing.T) {
+	fields, value, err := parsePatchFields(map[string]interface{}{"total_credits": 21.5, "name": "乙"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fields, []string{"name", "total_credits"}) || value != (kv.KVEntry{Name: "乙", TotalCredits: 21.5}) {
+		t.Fatalf("parsePatchFields = %v, %+v", fields, value)
+	}
+	for _, bad := range []map[string]interface{}{{"grand": "2021"}, {"grand": 2021.5}, {"nope": 1}} {
+		if _, _, err := parsePatchFields(bad); err == nil {
+			t.Errorf("parsePatchFields(%v) succeeded, want an error", bad)
+		}
+	}
+}
+
+// /patch 只修改给出的字段，/get_field 读取单个字段
+func TestPatchAndGetField(t *testing.T) {
+	srv := startGateway(t, Options{})
+	doJSON(t, srv, "POST", "/put", "", map[string]interface{}{"key": "21030101", "value": student("甲", 2021)}, http.StatusOK, nil)
+
+	doJSON(t, srv, "PATCH", "/patch", "", map[string]interface{}{"key": "21030101", "fields": map[string]interface{}{"name": "乙"}}, http.StatusOK, nil)
+	doJSON(t, srv, "PATCH", "/patch", "", map[string]interface{}{"key": "21030101", "fields": map[string]interface{}{"course_count": 12}}, http.StatusOK, nil)
+
+	var field struct {
+		Field string      `json:"field"`
+		Value interface{} `json:"value"`
+	}
+	for name, want := range map[string]interface{}{"name": "乙", "course_count": 12.0, "class": "21计一"} {
+		doJSON(t, srv, "GET", "/get_field?key=21030101&field="+name, "", nil, http.StatusOK, &field)
+		if field.Field != name || field.Value != want {
+			t.Errorf("get_field %s = %v, want %v", name, field.Value, want)
+		}
+	}
+
+	cases := []struct {
+		method, path string
+		body         interface{}
+		want         int
+	}{
+		{"PATCH", "/patch", map[string]interface{}{"key": "21039999", "fields": map[string]interface{}{"name": "丙"}}, http.StatusNotFound},
+		{"PATCH", "/patch", map[string]interface{}{"key": "21030101", "fields": map[string]interface{}{"nope": 1}}, http.StatusBadRequest},
+		{"PATCH", "/patch", map[string]interface{}{"key": "21030101", "fields": map[string]interface{}{"name": ""}}, http.StatusUnprocessableEntity},
+		{"GET", "/get_field?key=21030101&field=nope", nil, http.StatusBadRequest},
+		{"GET", "/get_field?key=21039999&field=name", nil, http.StatusNotFound},
+	}
+	for _, c := range cases {
+		if status, body := do(t, srv, c.method, c.path, "", c.body); status != c.want {
+			t.Errorf("%s %s %v = %d %s, want %d", c.method, c.path, c.body, status, body, c.want)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package gateway
+
+import (
+	"bytes"
+	"course/kv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// /api/v1 以资源的形式访问学生记录：
+//
+//	GET    /api/v1/students              分页列出记录
+//	GET    /api/v1/students/{id}         读取一条记录
+//	PUT    /api/v1/students/{id}         写入整条记录
+//	PATCH  /api/v1/students/{id}         只修改给出的字段
+//	DELETE /api/v1/students/{id}         删除记录
+//
+// 所有错误都使用同样的格式：{"error": {"code": "not_found", "message": "...", "status": 404}}
+// 记录没有通过校验时返回 422，details 中为每个字段的错误
+// 旧的 /get、/put 等接口作为别名保留，错误格式保持不变
+
+const apiPrefix = "/api/v1/"
+
+// 错误码，客户端应当按 code 而不是 message 处理错误
+const (
+	CodeInvalidArgument  = "invalid_argument"
+	CodeUnauthenticated  = "unauthenticated"
+	CodePermissionDenied = "permission_denied"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeGone             = "gone"
+	CodeFailedValidation = "failed_validation"
+	CodeInternal         = "internal"
+	CodeUnavailable      = "unavailable"
+)
+
+// 列表接口默认和最大的每页条数
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+)
+
+// 错误响应中的 error 对象
+type APIError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Status  int         `json:"status"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// 写入统一格式的错误响应
+func writeAPIError(w http.ResponseWriter, status int, code, message string, details interface{}) {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	enc.SetEscapeHTML(false)
+	enc.Encode(map[string]APIError{"error": {Code: code, Message: message, Status: status, Details: details}})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(body.Bytes())
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// 认证失败等由中间件产生的错误，/api/ 下使用统一格式，旧接口保持原来的格式
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if strings.HasPrefix(r.URL.Path, apiPrefix) {
+		writeAPIError(w, status, code, message, nil)
+		return
+	}
+	writeJSONError(w, message, status)
+}
+
+// 注册 /api/v1 的路由
+func registerAPI(mux *http.ServeMux) {
+	mux.Handle("GET /api/v1/students", requireRole(RoleReader, apiListStudents))
+	mux.Handle("GET /api/v1/students/{id}", requireRole(RoleReader, apiGetStudent))
+	mux.Handle("PUT /api/v1/students/{id}", requireRole(RoleWriter, apiPutStudent))
+	mux.Handle("PATCH /api/v1/students/{id}", requireRole(RoleWriter, apiPatchStudent))
+	mux.Handle("DELETE /api/v1/students/{id}", requireRole(RoleWriter, apiDeleteStudent))
+
+	// 路径存在但方法不支持时返回 405，其余 /api/ 路径返回 404，二者都使用统一的错误格式
+	mux.HandleFunc("/api/v1/students", methodNotAllowed("GET"))
+	mux.HandleFunc("/api/v1/students/{id}", methodNotAllowed("GET, PUT, PATCH, DELETE"))
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, CodeNotFound, "no such resource: "+r.URL.Path, nil)
+	})
+}
+
+func methodNotAllowed(allow string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		writeAPIError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed,
+			fmt.Sprintf("method %s is not allowed, use %s", r.Method, allow), nil)
+	}
+}
+
+// GET /api/v1/students?page_size=20&page_token=...&prefix=2103
+// 按键的顺序分页，next_page_token 为空表示已经是最后一页
+func apiListStudents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	pageSize := defaultPageSize
+	if v := query.Get("page_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxPageSize {
+			writeAPIError(w, http.StatusBadRequest, CodeInvalidArgument,
+				fmt.Sprintf("page_size must be an integer between 1 and %d", maxPageSize), nil)
+			return
+		}
+		pageSize = n
+	}
+
+	var entries []kv.KeyValue
+	var next string
+	var err error
+	if prefix := query.Get("prefix"); prefix != "" {
+		entries, next, err = clientFor(r).PrefixScan(prefix, pageSize, query.Get("page_token"))
+	} else {
+		entries, next, err = clientFor(r).Scan("", "", pageSize, query.Get("page_token"))
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusServiceUnavailable, CodeUnavailable, "list failed, please retry", nil)
+		return
+	}
+
+	items := []map[string]interface{}{}
+	for _, entry := range entries {
+		record, err := entryToRecord(entry.Value)
+		if err != nil {
+			continue
+		}
+		record["id"] = entry.Key
+		items = append(items, record)
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]interface{}{
+		"items":           items,
+		"next_page_token": next,
+	})
+}
+
+// GET /api/v1/students/{id}，带 as_of 时读取该日志位置时的历史值
+func apiGetStudent(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var value kv.KVEntry
+	if asOf := r.URL.Query().Get("as_of"); asOf != "" {
+		index, err := strconv.Atoi(asOf)
+		if err != nil || index <= 0 {
+			writeAPIError(w, http.StatusBadRequest, CodeInvalidArgument, "as_of must be a positive log index", nil)
+			return
+		}
+		value, err = clientFor(r).GetAt(id, index)
+		if err != nil {
+			switch err.Error() {
+			case kv.ErrNoKey:
+				writeAPIError(w, http.StatusNotFound, CodeNotFound, "student "+id+" not found", nil)
+			case kv.ErrCompacted:
+				writeAPIError(w, http.StatusGone, CodeGone, "history at this index has been compacted", nil)
+			default:
+				writeAPIError(w, http.StatusServiceUnavailable, CodeUnavailable, "read failed, please retry", nil)
+			}
+			return
+		}
+	} else {
+		var err error
+		value, err = clientFor(r).GetEntry(id)
+		if err != nil {
+			// 只有确认键不存在时才返回 404，集群不可用时让客户端重试
+			if err.Error() == kv.ErrNoKey {
+				writeAPIError(w, http.StatusNotFound, CodeNotFound, "student "+id+" not found", nil)
+			} else {
+				writeAPIError(w, http.StatusServiceUnavailable, CodeUnavailable, "read failed, please retry", nil)
+			}
+			return
+		}
+	}
+
+	record, err := entryToRecord(value)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, CodeInternal, "stored record is not valid", nil)
+		return
+	}
+	record["id"] = id
+	writeAPIJSON(w, http.StatusOK, record)
+}
+
+// PUT /api/v1/students/{id}，请求体为整条记录，可选的 ttl 参数单位为秒
+func apiPutStudent(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var value kv.KVEntry
+	if err := decodeStrict(r.Body, &value); err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidArgument, "invalid request body: "+err.Error(), nil)
+		return
+	}
+	if errs := schemas.current().Validate(id, value); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+	var ttl time.Duration
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		seconds, err := strconv.ParseFloat(v, 64)
+		if err != nil || seconds < 0 {
+			writeAPIError(w, http.StatusBadRequest, CodeInvalidArgument, "ttl must be a non-negative number of seconds", nil)
+			return
+		}
+		ttl = time.Duration(seconds * float64(time.Second))
+	}
+
+	if err := clientFor(r).PutWithTTL(id, value, ttl); err != nil {
+		writeAPIError(w, http.StatusServiceUnavailable, CodeUnavailable, "write failed, please retry", nil)
+		return
+	}
+
+	record, _ := entryToRecord(value)
+	record["id"] = id
+	writeAPIJSON(w, http.StatusOK, record)
+}
+
+// PATCH /api/v1/students/{id}，请求体只包含要修改的字段，例如 {"major": "通信工程"}
+func apiPatchStudent(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var request map[string]interface{}
+	if err := decodeStrict(r.Body, &request); err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidArgument, "invalid request body: "+err.Error(), nil)
+		return
+	}
+	if len(request) == 0 {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidArgument, "at least one field is required", nil)
+		return
+	}
+	fields, value, err := parsePatchFields(request)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidArgument, err.Error(), nil)
+		return
+	}
+	if errs := schemas.current().ValidateFields(id, fields, value); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	if err := clientFor(r).Patch(id, fields, value); err != nil {
+		if err.Error() == kv.ErrNoKey {
+			writeAPIError(w, http.StatusNotFound, CodeNotFound, "student "+id+" not found", nil)
+		} else {
+			writeAPIError(w, http.StatusServiceUnavailable, CodeUnavailable, "write failed, please retry", nil)
+		}
+		return
+	}
+
+	writeAPIJSON(w, http.StatusOK, map[string]interface{}{
+		"id":     id,
+		"fields": fields,
+	})
+}
+
+// DELETE /api/v1/students/{id}，成功时返回 204
+func apiDeleteStudent(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	if err := clientFor(r).Delete(id); err != nil {
+		if err.Error() == kv.ErrNoKey {
+			writeAPIError(w, http.StatusNotFound, CodeNotFound, "student "+id+" not found", nil)
+		} else {
+			writeAPIError(w, http.StatusServiceUnavailable, CodeUnavailable, "delete failed, please retry", nil)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 解析 JSON 请求体，拒绝未知字段和多余的内容
+func decodeStrict(body io.Reader, v interface{}) error {
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return fmt.Errorf("unexpected data after the JSON object")
+	}
+	return nil
+}
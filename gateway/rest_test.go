@@ -0,0 +1,131 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// 检查 /api/v1 的错误响应：状态码和 error.code 都符合预期
+func expectAPIError(t *testing.T, status int, body []byte, wantStatus int, wantCode string) APIError {
+	t.Helper()
+	var envelope struct {
+		Error APIError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		t.Fatalf("error body %s: %v", body, err)
+	}
+	if status != wantStatus || envelope.Error.Code != wantCode || envelope.Error.Status != wantStatus || envelope.Error.Message == "" {
+		t.Fatalf("got %d %s, want %d with code %s", status, body, wantStatus, wantCode)
+	}
+	return envelope.Error
+}
+
+// 通过 /api/v1 写入、读取、修改和删除一条记录
+func TestAPIStudentLifecycle(t *testing.T) {
+	srv := startGateway(t, Options{})
+	const path = "/api/v1/students/21030101"
+
+	status, body := do(t, srv, "GET", path, "", nil)
+	expectAPIError(t, status, body, http.StatusNotFound, CodeNotFound)
+
+	var record map[string]interface{}
+	doJSON(t, srv, "PUT", path, "", student("甲", 2021), http.StatusOK, &record)
+	if record["id"] != "21030101" || record["name"] != "甲" {
+		t.Fatalf("PUT returned %v", record)
+	}
+	doJSON(t, srv, "PATCH", path, "", map[string]interface{}{"major": "网络工程"}, http.StatusOK, nil)
+	doJSON(t, srv, "GET", path, "", nil, http.StatusOK, &record)
+	if record["major"] != "网络工程" || record["name"] != "甲" {
+		t.Fatalf("GET after PATCH = %v", record)
+	}
+
+	status, body = do(t, srv, "DELETE", path, "", nil)
+	if status != http.StatusNoContent || len(body) != 0 {
+		t.Fatalf("DELETE = %d %s, want 204 with an empty body", status, body)
+	}
+	status, body = do(t, srv, "DELETE", path, "", nil)
+	expectAPIError(t, status, body, http.StatusNotFound, CodeNotFound)
+	status, body = do(t, srv, "PATCH", path, "", map[string]interface{}{"name": "乙"})
+	expectAPIError(t, status, body, http.StatusNotFound, CodeNotFound)
+}
+
+// 请求不合法时按统一的格式返回错误码
+func TestAPIErrors(t *testing.T) {
+	srv := startGateway(t, Options{})
+	const path = "/api/v1/students/21030101"
+
+	status, body := do(t, srv, "PUT", path, "", map[string]interface{}{"name": "甲", "nickname": "x"})
+	expectAPIError(t, status, body, http.StatusBadRequest, CodeInvalidArgument)
+	status, body = do(t, srv, "PUT", path, "", `{"name": "甲"} {}`)
+	expectAPIError(t, status, body, http.StatusBadRequest, CodeInvalidArgument)
+	status, body = do(t, srv, "PUT", path+"?ttl=-1", "", student("甲", 2021))
+	expectAPIError(t, status, body, http.StatusBadRequest, CodeInvalidArgument)
+	status, body = do(t, srv, "PATCH", path, "", map[string]interface{}{})
+	expectAPIError(t, status, body, http.StatusBadRequest, CodeInvalidArgument)
+
+	status, body = do(t, srv, "PUT", path, "", map[string]interface{}{"grand": 2021})
+	apiErr := expectAPIError(t, status, body, http.StatusUnprocessableEntity, CodeFailedValidation)
+	if details, ok := apiErr.Details.([]interface{}); !ok || len(details) == 0 {
+		t.Fatalf("validation error without field details: %s", body)
+	}
+
+	status, body = do(t, srv, "POST", path, "", nil)
+	expectAPIError(t, status, body, http.StatusMethodNotAllowed, CodeMethodNotAllowed)
+	status, body = do(t, srv, "GET", "/api/v1/teachers", "", nil)
+	expectAPIError(t, status, body, http.StatusNotFound, CodeNotFound)
+	status, body = do(t, srv, "GET", "/api/v1/students?page_size=0", "", nil)
+	expectAPIError(t, status, body, http.StatusBadRequest, CodeInvalidArgument)
+
+	// 认证失败同样使用统一格式，旧接口保持原来的格式
+	authSrv := startGateway(t, Options{AdminToken: "admin-secret"})
+	status, body = do(t, authSrv, "GET", path, "", nil)
+	expectAPIError(t, status, body, http.StatusUnauthorized, CodeUnauthenticated)
+	status, body = do(t, authSrv, "GET", "/get?key=21030101", "", nil)
+	var legacy map[string]interface{}
+	json.Unmarshal(body, &legacy)
+	if status != http.StatusUnauthorized || legacy["error"] != errInvalidToken.Error() {
+		t.Fatalf("legacy endpoint without a token = %d %s", status, body)
+	}
+}
+
+// 列表按键分页，next_page_token 为空表示最后一页
+func TestAPIListPages(t *testing.T) {
+	srv := startGateway(t, Options{})
+	for i := 0; i < 5; i++ {
+		doJSON(t, srv, "PUT", fmt.Sprintf("/api/v1/students/2103010%d", i), "", student("甲", 2021), http.StatusOK, nil)
+	}
+	doJSON(t, srv, "PUT", "/api/v1/students/21040101", "", student("乙", 2021), http.StatusOK, nil)
+
+	var ids []interface{}
+	path := "/api/v1/students?prefix=2103&page_size=2"
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("too many pages")
+		}
+		var page struct {
+			Items         []map[string]interface{} `json:"items"`
+			NextPageToken string                   `json:"next_page_token"`
+		}
+		doJSON(t, srv, "GET", path, "", nil, http.StatusOK, &page)
+		for _, item := range page.Items {
+			ids = append(ids, item["id"])
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		path = "/api/v1/students?prefix=2103&page_size=2&page_token=" + page.NextPageToken
+	}
+	if fmt.Sprint(ids) != "[21030100 21030101 21030102 21030103 21030104]" {
+		t.Fatalf("listed %v", ids)
+	}
+
+	var all struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	doJSON(t, srv, "GET", "/api/v1/students", "", nil, http.StatusOK, &all)
+	if len(all.Items) != 6 {
+		t.Fatalf("unfiltered list has %d items, want 6", len(all.Items))
+	}
+}
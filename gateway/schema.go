@@ -0,0 +1,179 @@
+package gateway
+
+import (
+	"course/kv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 校验规则保存在系统键空间中，连接同一集群的所有网关使用同一份规则
+	schemaKey = "schema/students"
+
+	// 网关缓存规则的时间，修改后其他网关最多在这么久之后生效
+	schemaCacheTTL = 5 * time.Second
+)
+
+type schemaCache struct {
+	mu      sync.Mutex
+	schema  *kv.Schema
+	expires time.Time
+	loading chan struct{} // 正在从集群读取时不为 nil，读取结束时关闭
+	version int           // 每次 set 加一，读取期间规则被 set 替换时丢弃读到的旧规则
+
+	load func() (string, error) // 从集群读取保存的规则，默认为 client.SysGet(schemaKey)
+}
+
+var schemas = &schemaCache{}
+
+// 返回当前的校验规则，集群中没有保存规则时使用 kv.DefaultSchema
+// 缓存过期后在后台重新读取，读取完成前继续使用上一次的规则，请求不会等待集群；
+// 只有还没有读到过规则时才等待第一次读取完成。读取失败时继续使用上一次读到的规则
+func (c *schemaCache) current() *kv.Schema {
+	c.mu.Lock()
+	if c.schema != nil && time.Now().Before(c.expires) {
+		defer c.mu.Unlock()
+		return c.schema
+	}
+	if c.loading == nil {
+		c.loading = make(chan struct{})
+		go c.refresh(c.loading, c.version)
+	}
+	if c.schema != nil {
+		defer c.mu.Unlock()
+		return c.schema
+	}
+	loading := c.loading
+	c.mu.Unlock()
+
+	<-loading
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.schema
+}
+
+// 从集群读取规则，读取时不持有锁，读完后替换缓存并关闭 done
+func (c *schemaCache) refresh(done chan struct{}, version int) {
+	load := c.load
+	if load == nil {
+		load = func() (string, error) { return client.SysGet(schemaKey) }
+	}
+	raw, err := load()
+
+	var schema *kv.Schema
+	switch {
+	case err == nil:
+		parsed, perr := kv.ParseSchema([]byte(raw))
+		if perr != nil {
+			logger.Error("Invalid schema stored in the cluster, keeping the previous one", "err", perr)
+			break
+		}
+		schema = parsed
+	case err.Error() == kv.ErrNoKey:
+		schema = kv.DefaultSchema()
+	default:
+		logger.Warn("Failed to read schema from the cluster", "err", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer close(done)
+	c.loading = nil
+	if c.version != version {
+		// 读取期间规则已经被 set 替换
+		return
+	}
+	if schema != nil {
+		c.schema = schema
+	}
+	if c.schema == nil {
+		c.schema = kv.DefaultSchema()
+	}
+	c.expires = time.Now().Add(schemaCacheTTL)
+}
+
+func (c *schemaCache) set(schema *kv.Schema) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.schema = schema
+	c.expires = time.Now().Add(schemaCacheTTL)
+	c.version++
+}
+
+// 从配置文件读取校验规则并保存到集群中，集群刚启动还没有领导者时会等待最多 10 秒
+func LoadSchemaFile(c *kv.KVClient, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	schema, err := kv.ParseSchema(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	raw, _ := json.Marshal(schema)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		err = c.SysPut(schemaKey, string(raw))
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store schema in the cluster: %v", err)
+	}
+	logger.Info("Loaded schema", "file", path)
+	return nil
+}
+
+// 处理 /schema 请求：GET 返回当前规则，PUT 替换集群中的规则
+func handleSchema(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(schemas.current())
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeJSONError(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		schema, err := kv.ParseSchema(data)
+		if err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		raw, _ := json.Marshal(schema)
+		if err := clientFor(r).SysPut(schemaKey, string(raw)); err != nil {
+			writeJSONError(w, "Failed to store schema, please retry", http.StatusServiceUnavailable)
+			return
+		}
+		schemas.set(schema)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(schema)
+	default:
+		writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// 返回 422 和每个字段的错误，/api/ 下使用统一的错误格式
+func writeValidationError(w http.ResponseWriter, r *http.Request, errs []kv.FieldError) {
+	const message = "record failed validation"
+	if strings.HasPrefix(r.URL.Path, apiPrefix) {
+		writeAPIError(w, http.StatusUnprocessableEntity, CodeFailedValidation, message, errs)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  message,
+		"fields": errs,
+	})
+}
@@ -0,0 +1,131 @@
+package gateway
+
+import (
+	"course/kv"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 缓存过期后请求继续使用上一次的规则，不等待集群；同一时刻只有一次读取
+func TestSchemaCacheRefreshesInBackground(t *testing.T) {
+	release := make(chan struct{})
+	var loads atomic.Int32
+	c := &schemaCache{load: func() (string, error) {
+		if loads.Add(1) > 1 {
+			<-release
+		}
+		return `{"fields": {"name": {"required": true}}}`, nil
+	}}
+
+	first := c.current()
+	if _, ok := first.Fields["name"]; !ok || loads.Load() != 1 {
+		t.Fatalf("first current() = %+v after %d loads", first, loads.Load())
+	}
+
+	c.mu.Lock()
+	c.expires = time.Now()
+	c.mu.Unlock()
+	for i := 0; i < 10; i++ {
+		done := make(chan *kv.Schema)
+		go func() { done <- c.current() }()
+		select {
+		case got := <-done:
+			if got != first {
+				t.Fatalf("current() during a refresh returned a different schema")
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("current() waited for the cluster while a schema was cached")
+		}
+	}
+	for start := time.Now(); loads.Load() < 2; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("expired cache was not refreshed")
+		}
+	}
+	c.current()
+	if n := loads.Load(); n != 2 {
+		t.Fatalf("%d loads while a refresh was in flight, want 2", n)
+	}
+
+	close(release)
+	for start := time.Now(); c.current() == first; {
+		if time.Since(start) > time.Second {
+			t.Fatalf("refreshed schema was not swapped in")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// 读取期间通过 /schema 替换的规则不会被读取到的旧规则覆盖
+func TestSchemaCacheSetDuringRefresh(t *testing.T) {
+	loading := make(chan struct{})
+	release := make(chan struct{})
+	c := &schemaCache{load: func() (string, error) {
+		close(loading)
+		<-release
+		return `{"fields": {"name": {"required": true}}}`, nil
+	}}
+	c.set(kv.DefaultSchema())
+	c.mu.Lock()
+	c.expires = time.Now()
+	c.mu.Unlock()
+
+	c.current()
+	<-loading
+	replaced, err := kv.ParseSchema([]byte(`{"fields": {"class": {"required": true}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.set(replaced)
+	close(release)
+
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		done := c.loading == nil
+		c.mu.Unlock()
+		if done {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatalf("refresh did not finish")
+		}
+	}
+	if got := c.current(); got != replaced {
+		t.Fatalf("current() = %+v after set during a refresh, want the replaced schema", got)
+	}
+}
+
+// 通过 PUT /schema 替换的规则保存在集群中并立即用于校验写入
+func TestSchemaEndpoint(t *testing.T) {
+	srv := startGateway(t, Options{})
+
+	var current kv.Schema
+	doJSON(t, srv, "GET", "/schema", "", nil, http.StatusOK, &current)
+	if current.Key.Pattern != kv.DefaultSchema().Key.Pattern || !current.Fields["name"].Required {
+		t.Fatalf("default schema = %+v", current)
+	}
+
+	if status, body := do(t, srv, "PUT", "/schema", "", `{"fields": {"nickname": {}}}`); status != http.StatusBadRequest {
+		t.Fatalf("PUT of an invalid schema = %d %s, want 400", status, body)
+	}
+	doJSON(t, srv, "PUT", "/schema", "", `{"fields": {"major": {"required": true, "enum": ["软件工程"]}}}`, http.StatusOK, nil)
+
+	other := student("甲", 2021)
+	other.Major = "网络工程"
+	status, body := do(t, srv, "POST", "/put", "", map[string]interface{}{"key": "anything", "value": other})
+	if status != http.StatusUnprocessableEntity || !strings.Contains(string(body), `"field":"major"`) {
+		t.Fatalf("put violating the new schema = %d %s, want 422 on major", status, body)
+	}
+	doJSON(t, srv, "POST", "/put", "", map[string]interface{}{"key": "anything", "value": student("甲", 2021)}, http.StatusOK, nil)
+
+	// 新的网关从集群读取规则
+	schemas = &schemaCache{}
+	var stored kv.Schema
+	doJSON(t, srv, "GET", "/schema", "", nil, http.StatusOK, &stored)
+	if _, ok := stored.Fields["name"]; ok || len(stored.Fields["major"].Enum) != 1 {
+		t.Fatalf("schema read back from the cluster = %+v", stored)
+	}
+}
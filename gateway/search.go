@@ -0,0 +1,114 @@
+package gateway
+
+import (
+	"course/kv"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// /search 支持的比较运算符，按长度从长到短排列，保证 ">=" 优先于 ">" 匹配
+var searchOperators = []string{
+	kv.CondGe, kv.CondLe, kv.CondNe, kv.CondContains, kv.CondPrefix,
+	kv.CondGt, kv.CondLt, kv.CondEq,
+}
+
+// 解析 /search 的查询字符串
+//
+// 每个 & 分隔的部分是一个条件，所有条件同时满足：
+//
+//	class=21计一               等于
+//	class=21计一,21计二         等于其中任意一个（IN）
+//	total_credits>=20          范围比较：> >= < <= !=
+//	name~=伟                   名字包含“伟”
+//	name^=张                   名字以“张”开头
+//
+// 另外支持 sort=field[:asc|:desc][,field...]、limit=N 和 offset=N
+//
+// 由于 ">=" 这类条件不是标准的 key=value 形式，这里直接解析原始查询字符串
+func ParseSearchQuery(rawQuery string) (kv.QueryArgs, error) {
+	var args kv.QueryArgs
+
+	for _, term := range strings.Split(rawQuery, "&") {
+		if term == "" {
+			continue
+		}
+		term, err := url.QueryUnescape(term)
+		if err != nil {
+			return args, fmt.Errorf("malformed query term: %v", err)
+		}
+
+		// 字段名由小写字母和下划线组成，其后紧跟运算符
+		i := 0
+		for i < len(term) && (term[i] >= 'a' && term[i] <= 'z' || term[i] == '_') {
+			i++
+		}
+		field := term[:i]
+		if field == "" {
+			return args, fmt.Errorf("expected a field name at the start of %q", term)
+		}
+
+		op := ""
+		for _, candidate := range searchOperators {
+			if strings.HasPrefix(term[i:], candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return args, fmt.Errorf("expected an operator after field %q in %q", field, term)
+		}
+		value := term[i+len(op):]
+		if value == "" {
+			return args, fmt.Errorf("missing value in %q", term)
+		}
+
+		switch field {
+		case "sort", "limit", "offset":
+			if op != kv.CondEq {
+				return args, fmt.Errorf("%s only supports \"=\"", field)
+			}
+		}
+
+		switch {
+		case field == "sort":
+			for _, part := range strings.Split(value, ",") {
+				name, order, _ := strings.Cut(part, ":")
+				sf := kv.SortField{Field: name}
+				switch order {
+				case "", "asc":
+				case "desc":
+					sf.Desc = true
+				default:
+					return args, fmt.Errorf("sort order must be asc or desc, got %q", order)
+				}
+				args.Sort = append(args.Sort, sf)
+			}
+		case field == "limit" || field == "offset":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return args, fmt.Errorf("%s must be a non-negative integer, got %q", field, value)
+			}
+			if field == "limit" {
+				args.Limit = n
+			} else {
+				args.Offset = n
+			}
+		case op == kv.CondEq && strings.Contains(value, ","):
+			args.Conditions = append(args.Conditions, kv.Condition{
+				Field:  field,
+				Op:     kv.CondIn,
+				Values: strings.Split(value, ","),
+			})
+		default:
+			args.Conditions = append(args.Conditions, kv.Condition{
+				Field: field,
+				Op:    op,
+				Value: value,
+			})
+		}
+	}
+
+	return args, nil
+}
@@ -0,0 +1,118 @@
+package gateway
+
+import (
+	"course/kv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// /search 在集群上执行条件查询，返回带 id 的记录
+func TestSearchByField(t *testing.T) {
+	srv := startGateway(t, Options{})
+	other := student("丙", 2022)
+	other.Class = "21计二"
+	entries := []kv.KeyValue{
+		{Key: "21030101", Value: student("甲", 2021)},
+		{Key: "21030102", Value: student("乙", 2021)},
+		{Key: "21030103", Value: other},
+	}
+	doJSON(t, srv, "POST", "/batch_put", "", map[string]interface{}{"entries": entries}, http.StatusOK, nil)
+
+	var records []map[string]interface{}
+	doJSON(t, srv, "GET", "/search?class=21计二", "", nil, http.StatusOK, &records)
+	if len(records) != 1 || records[0]["id"] != "21030103" || records[0]["name"] != "丙" {
+		t.Fatalf("class=21计二: %v", records)
+	}
+
+	records = nil
+	doJSON(t, srv, "GET", "/search?class=21计一&grand=2021", "", nil, http.StatusOK, &records)
+	var ids []interface{}
+	for _, record := range records {
+		ids = append(ids, record["id"])
+	}
+	if fmt.Sprint(ids) != "[21030101 21030102]" {
+		t.Fatalf("class=21计一&grand=2021: %v", ids)
+	}
+
+	for _, path := range []string{"/search", "/search?nope=1", "/search?grand=abc"} {
+		if status, body := do(t, srv, "GET", path, "", nil); status != http.StatusBadRequest {
+			t.Errorf("GET %s = %d %s, want 400", path, status, body)
+		}
+	}
+}
+
+// 查询字符串中的运算符、IN 列表、排序和分页参数
+func TestParseSearchQuery(t *testing.T) {
+	args, err := ParseSearchQuery("total_credits%3E%3D20&class=21计一,21计二&name~=伟&sort=grand:desc,name&limit=5&offset=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []kv.Condition{
+		{Field: "total_credits", Op: kv.CondGe, Value: "20"},
+		{Field: "class", Op: kv.CondIn, Values: []string{"21计一", "21计二"}},
+		{Field: "name", Op: kv.CondContains, Value: "伟"},
+	}
+	if fmt.Sprint(args.Conditions) != fmt.Sprint(want) {
+		t.Errorf("conditions = %+v, want %+v", args.Conditions, want)
+	}
+	if fmt.Sprint(args.Sort) != "[{grand true} {name false}]" || args.Limit != 5 || args.Offset != 10 {
+		t.Errorf("sort = %v, limit = %d, offset = %d", args.Sort, args.Limit, args.Offset)
+	}
+
+	for _, raw := range []string{"=1", "grand", "grand=", "sort>=x", "sort=grand:up", "limit=-1", "offset=x", "name%ZZ"} {
+		if _, err := ParseSearchQuery(raw); err == nil {
+			t.Errorf("ParseSearchQuery(%q) succeeded, want an error", raw)
+		}
+	}
+}
+
+// 范围查询按指定字段排序，分页信息放在 X-Total-Count 和 X-Next-Offset 响应头中
+func TestSearchSortAndPaging(t *testing.T) {
+	srv := startGateway(t, Options{})
+	var entries []kv.KeyValue
+	for i := 0; i < 5; i++ {
+		e := student(fmt.Sprintf("学生%d", i), 2021)
+		e.TotalCredits = float64(10 + i*5)
+		entries = append(entries, kv.KeyValue{Key: fmt.Sprintf("2103010%d", i), Value: e})
+	}
+	doJSON(t, srv, "POST", "/batch_put", "", map[string]interface{}{"entries": entries}, http.StatusOK, nil)
+
+	get := func(path string) ([]interface{}, http.Header) {
+		t.Helper()
+		resp, err := srv.Client().Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s = %d", path, resp.StatusCode)
+		}
+		var records []map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+			t.Fatal(err)
+		}
+		var ids []interface{}
+		for _, record := range records {
+			ids = append(ids, record["id"])
+		}
+		return ids, resp.Header
+	}
+
+	ids, header := get("/search?total_credits%3E=15&sort=total_credits:desc&limit=2")
+	if fmt.Sprint(ids) != "[21030104 21030103]" {
+		t.Fatalf("first page = %v", ids)
+	}
+	if header.Get("X-Total-Count") != "4" || header.Get("X-Next-Offset") != "2" {
+		t.Fatalf("X-Total-Count = %q, X-Next-Offset = %q, want 4 and 2", header.Get("X-Total-Count"), header.Get("X-Next-Offset"))
+	}
+	ids, header = get("/search?total_credits%3E=15&sort=total_credits:desc&limit=2&offset=2")
+	if fmt.Sprint(ids) != "[21030102 21030101]" || header.Get("X-Next-Offset") != "" {
+		t.Fatalf("last page = %v, X-Next-Offset = %q", ids, header.Get("X-Next-Offset"))
+	}
+
+	if status, body := do(t, srv, "GET", "/search?name%3E=a", "", nil); status != http.StatusBadRequest {
+		t.Fatalf("range on a string field = %d %s, want 400", status, body)
+	}
+}
@@ -0,0 +1,42 @@
+package gateway
+
+import (
+	"course/kv"
+	"net/http"
+	"testing"
+)
+
+// /stats 先按查询条件筛选，再按 group_by 分组计算指标
+func TestStats(t *testing.T) {
+	srv := startGateway(t, Options{})
+	a, b, c := student("甲", 2021), student("乙", 2021), student("丙", 2022)
+	a.TotalCredits, b.TotalCredits, c.TotalCredits = 10, 20, 30
+	c.Class = "21计二"
+	entries := []kv.KeyValue{{Key: "21030101", Value: a}, {Key: "21030102", Value: b}, {Key: "21030103", Value: c}}
+	doJSON(t, srv, "POST", "/batch_put", "", map[string]interface{}{"entries": entries}, http.StatusOK, nil)
+
+	var got struct {
+		GroupBy []string                 `json:"group_by"`
+		Groups  []map[string]interface{} `json:"groups"`
+	}
+	doJSON(t, srv, "GET", "/stats?group_by=class&metrics=count,avg(total_credits)", "", nil, http.StatusOK, &got)
+	if len(got.Groups) != 2 {
+		t.Fatalf("groups = %v", got.Groups)
+	}
+	first := got.Groups[0]
+	if first["class"] != "21计一" || first["count"] != 2.0 || first["avg(total_credits)"] != 15.0 {
+		t.Fatalf("first group = %v, want 21计一 with count 2 and avg 15", first)
+	}
+
+	got.Groups = nil
+	doJSON(t, srv, "GET", "/stats?grand=2021&metrics=max(total_credits)", "", nil, http.StatusOK, &got)
+	if len(got.Groups) != 1 || got.Groups[0]["max(total_credits)"] != 20.0 {
+		t.Fatalf("filtered stats = %v, want max 20", got.Groups)
+	}
+
+	for _, path := range []string{"/stats?metrics=avg(name)", "/stats?metrics=avg", "/stats?group_by=nope", "/stats?limit=1"} {
+		if status, body := do(t, srv, "GET", path, "", nil); status != http.StatusBadRequest {
+			t.Errorf("GET %s = %d %s, want 400", path, status, body)
+		}
+	}
+}
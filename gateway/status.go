@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"course/kv"
+	"course/raft"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// 查询各节点状态的最长等待时间，超时的节点视为不可达
+const statusTimeout = time.Second
+
+// 节点状态的 JSON 格式
+type nodeStatusJSON struct {
+	ID        int    `json:"id"`
+	Reachable bool   `json:"reachable"`
+	Role      string `json:"role,omitempty"`
+	Term      int    `json:"term,omitempty"`
+	VotedFor  *int   `json:"voted_for,omitempty"`
+
+	CommitIndex         int `json:"commit_index"`
+	LastApplied         int `json:"last_applied"`          // Raft 交给状态机的最后位置
+	StateMachineApplied int `json:"state_machine_applied"` // 状态机已应用的最后位置
+	SnapshotIndex       int `json:"snapshot_index"`
+	SnapshotTerm        int `json:"snapshot_term"`
+	LastLogIndex        int `json:"last_log_index"`
+	LogLength           int `json:"log_length"`
+	Keys                int `json:"keys"`
+
+	Peers []peerStatusJSON `json:"peers,omitempty"` // 只有领导者有
+}
+
+type peerStatusJSON struct {
+	ID         int `json:"id"`
+	MatchIndex int `json:"match_index"`
+	NextIndex  int `json:"next_index"`
+	Lag        int `json:"lag"` // 领导者的最后位置与该节点 MatchIndex 的差
+}
+
+// 网关看到的集群概况
+type clusterView struct {
+	Leader    *int `json:"leader"` // 没有可用的领导者时为 null
+	Term      int  `json:"term"`   // 可达节点中最大的任期
+	Reachable int  `json:"reachable"`
+	Quorum    int  `json:"quorum"`
+	Ready     bool `json:"ready"`
+}
+
+// 根据各节点的状态判断集群是否可用：能连到多数派，并且其中有当前任期的领导者
+func viewOf(statuses []kv.NodeStatus) clusterView {
+	view := clusterView{Quorum: len(statuses)/2 + 1}
+	for _, s := range statuses {
+		if !s.Reachable {
+			continue
+		}
+		view.Reachable++
+		if s.Raft.Term > view.Term {
+			view.Term = s.Raft.Term
+		}
+	}
+	for _, s := range statuses {
+		// 被分区的旧领导者可能还认为自己是领导者，只认最大任期的那个
+		if s.Reachable && s.Raft.Role == raft.Leader && s.Raft.Term == view.Term {
+			id := s.Server
+			view.Leader = &id
+		}
+	}
+	view.Ready = view.Reachable >= view.Quorum && view.Leader != nil
+	return view
+}
+
+func toNodeJSON(s kv.NodeStatus) nodeStatusJSON {
+	n := nodeStatusJSON{ID: s.Server, Reachable: s.Reachable}
+	if !s.Reachable {
+		return n
+	}
+	r := s.Raft
+	n.Role = string(r.Role)
+	n.Term = r.Term
+	if r.VotedFor >= 0 {
+		votedFor := r.VotedFor
+		n.VotedFor = &votedFor
+	}
+	n.CommitIndex = r.CommitIndex
+	n.LastApplied = r.LastApplied
+	n.StateMachineApplied = s.LastApplied
+	n.SnapshotIndex = r.SnapshotIndex
+	n.SnapshotTerm = r.SnapshotTerm
+	n.LastLogIndex = r.LastLogIndex
+	n.LogLength = r.LogLength
+	n.Keys = s.Keys
+	for _, p := range r.Peers {
+		n.Peers = append(n.Peers, peerStatusJSON{
+			ID:         p.ID,
+			MatchIndex: p.MatchIndex,
+			NextIndex:  p.NextIndex,
+			Lag:        r.LastLogIndex - p.MatchIndex,
+		})
+	}
+	return n
+}
+
+// 处理 /healthz 请求，只要网关进程在运行就返回 200
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// 处理 /readyz 请求，集群有领导者并且网关能连到多数派时返回 200，否则返回 503
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	view := viewOf(clientFor(r).ClusterStatus(statusTimeout))
+	w.Header().Set("Content-Type", "application/json")
+	if !view.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(view)
+}
+
+// 处理 /cluster/status 请求，返回每个节点的 Raft 状态，领导者还会返回各节点的复制进度
+func handleClusterStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	statuses := clientFor(r).ClusterStatus(statusTimeout)
+	nodes := make([]nodeStatusJSON, len(statuses))
+	for i, s := range statuses {
+		nodes[i] = toNodeJSON(s)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		clusterView
+		Nodes []nodeStatusJSON `json:"nodes"`
+	}{viewOf(statuses), nodes})
+}
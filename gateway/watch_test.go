@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"course/kv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sseEvent struct {
+	id, typ string
+	data    map[string]interface{}
+}
+
+// 打开 /watch 连接读取 n 个事件，读完后断开
+func readEvents(t *testing.T, srv *httptest.Server, path, lastEventID string, n int) []sseEvent {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET %s = %d %s", path, resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	var events []sseEvent
+	var event sseEvent
+	scanner := bufio.NewScanner(resp.Body)
+	for len(events) < n && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event.typ != "" {
+				events = append(events, event)
+			}
+			event = sseEvent{}
+		case strings.HasPrefix(line, "id: "):
+			event.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event.typ = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.data); err != nil {
+				t.Fatalf("bad data line %q: %v", line, err)
+			}
+		}
+	}
+	if len(events) < n {
+		t.Fatalf("GET %s: got %d events before the stream ended (%v), want %d", path, len(events), scanner.Err(), n)
+	}
+	return events
+}
+
+// /watch 推送前缀下的变更，断线后通过 Last-Event-ID 或 from 从下一个事件继续
+func TestWatchStreamResume(t *testing.T) {
+	srv := startGateway(t, Options{})
+	doJSON(t, srv, "POST", "/put", "", map[string]interface{}{"key": "21030101", "value": student("甲", 2021)}, http.StatusOK, nil)
+	doJSON(t, srv, "POST", "/put", "", map[string]interface{}{"key": "21040101", "value": student("乙", 2021)}, http.StatusOK, nil)
+	doJSON(t, srv, "POST", "/put", "", map[string]interface{}{"key": "21030102", "value": student("丙", 2021)}, http.StatusOK, nil)
+	doJSON(t, srv, "POST", "/put", "", map[string]interface{}{"key": "21030101", "value": student("丁", 2021)}, http.StatusOK, nil)
+
+	events := readEvents(t, srv, "/watch?prefix=2103&from=1", "", 3)
+	want := []struct{ typ, key string }{{kv.EventPut, "21030101"}, {kv.EventPut, "21030102"}, {kv.EventPut, "21030101"}}
+	for i, event := range events {
+		if event.typ != want[i].typ || event.data["key"] != want[i].key {
+			t.Fatalf("event %d = %s %v, want %s %s", i, event.typ, event.data, want[i].typ, want[i].key)
+		}
+	}
+	if value, _ := events[1].data["value"].(map[string]interface{}); value["name"] != "丙" {
+		t.Fatalf("put event value = %v", events[1].data["value"])
+	}
+
+	resumed := readEvents(t, srv, "/watch?prefix=2103", events[0].id, 1)
+	if resumed[0].id != events[1].id {
+		t.Fatalf("resumed after Last-Event-ID %s at %s, want %s", events[0].id, resumed[0].id, events[1].id)
+	}
+	resumed = readEvents(t, srv, "/watch?key=21030101&from="+events[1].id, "", 1)
+	if value, _ := resumed[0].data["value"].(map[string]interface{}); resumed[0].id != events[2].id || value["name"] != "丁" {
+		t.Fatalf("from=%s on key 21030101 = %+v, want the second put at %s", events[1].id, resumed[0], events[2].id)
+	}
+
+	for _, path := range []string{"/watch?key=a&prefix=b", "/watch?from=-1"} {
+		if status, body := do(t, srv, "GET", path, "", nil); status != http.StatusBadRequest {
+			t.Errorf("GET %s = %d %s, want 400", path, status, body)
+		}
+	}
+}
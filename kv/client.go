@@ -1,120 +1,860 @@
 package kv
 
 import (
-	"course/labrpc"
-	"encoding/json"
-	"log"
-	"math/rand"
+	"course/logging"
+	"course/sim"
+	"course/tracing"
+	"course/transport"
+	"errors"
+	"log/slog"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 type KVClient struct {
-	servers  []*labrpc.ClientEnd
+	*clientCore
+	actor     string              // 写操作记录在审计日志中的用户
+	requestID string              // 发起请求的 HTTP 请求 ID，记录在日志中
+	trace     tracing.SpanContext // 网关处理该请求的 span，客户端的 span 以它为父节点
+	logger    *slog.Logger
+}
+
+// WithActor 返回的客户端共用的状态
+type clientCore struct {
+	servers  []transport.ClientEnd
 	clientID int64
-	seqNum   int64
-	leaderID int
+	readSeq  int64 // 读操作的序号，只用来在服务端区分同一位置上提交的是不是自己的请求
+	leaderID int64 // 最近一次知道的领导者，多个请求会同时读写，只用 atomic 访问
+
+	mu       sync.Mutex
+	sessions []*session // 空闲的写操作会话
+}
+
+// 写操作会话：服务端按会话记录已执行的最大序号来识别重复的请求（见 dedup.go），
+// 因此同一个会话同一时刻只能有一个写操作。网关的多个 HTTP 请求共用一个客户端，
+// 并发的写操作各自取一个会话，会话的数量等于同时进行的写操作的最大数量
+type session struct {
+	id  int64
+	seq int
+}
+
+// 取一个空闲的会话并分配下一个序号，写操作结束（成功或重试用尽）后用 releaseSession 归还
+// 重试用尽的写操作之后仍可能被提交，此时同一会话上更新的写操作已经执行，它会被当作重复的请求跳过
+func (c *clientCore) acquireSession() *session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var s *session
+	if n := len(c.sessions); n > 0 {
+		s = c.sessions[n-1]
+		c.sessions = c.sessions[:n-1]
+	} else {
+		s = &session{id: sim.Int63()}
+	}
+	s.seq++
+	return s
+}
+
+func (c *clientCore) releaseSession(s *session) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessions = append(c.sessions, s)
+}
+
+// 读操作的序号，与写操作的会话序号无关
+func (c *clientCore) nextReadSeq() int {
+	return int(atomic.AddInt64(&c.readSeq, 1))
 }
 
-func MakeKVClient(servers []*labrpc.ClientEnd) *KVClient {
+func MakeKVClient(servers []transport.ClientEnd) *KVClient {
 	ck := &KVClient{
-		servers:  servers,
-		clientID: rand.Int63(),
-		leaderID: 0,
+		clientCore: &clientCore{
+			servers:  servers,
+			clientID: sim.Int63(),
+		},
 	}
+	ck.logger = logging.Logger(logging.TopicClient).With("client", ck.clientID)
 	return ck
 }
 
-func (ck *KVClient) Get(key string) string {
+// 最近一次知道的领导者
+func (c *clientCore) leader() int {
+	return int(atomic.LoadInt64(&c.leaderID))
+}
+
+// 请求 leader 失败，换到下一个节点；其他请求已经换过时不再换，避免同时失败的请求把领导者跳过去
+func (c *clientCore) nextLeader(leader int) {
+	atomic.CompareAndSwapInt64(&c.leaderID, int64(leader), int64((leader+1)%len(c.servers)))
+}
+
+// 返回以 actor 身份发起写操作的客户端，与 ck 共用连接、领导者信息和请求序号
+// 网关为每个已认证的请求创建一个，写操作会以该用户记录在审计日志中
+func (ck *KVClient) WithActor(actor string) *KVClient {
+	view := *ck
+	view.actor = actor
+	return &view
+}
+
+// 返回日志中带有请求 ID 的客户端，与 ck 共用连接、领导者信息和请求序号
+func (ck *KVClient) WithRequestID(id string) *KVClient {
+	if id == "" {
+		return ck
+	}
+	view := *ck
+	view.requestID = id
+	view.logger = logging.Logger(logging.TopicClient).With("client", ck.clientID, "request_id", id)
+	return &view
+}
+
+// 返回 span 挂在 sc 下面的客户端，与 ck 共用连接、领导者信息和请求序号
+func (ck *KVClient) WithTrace(sc tracing.SpanContext) *KVClient {
+	view := *ck
+	view.trace = sc
+	return &view
+}
+
+// 开始一次请求的 span，每次 RPC 尝试是它的子 span，从中可以看出找领导者花了多少时间
+func (ck *KVClient) startSpan(method string, attrs ...interface{}) *tracing.Span {
+	attrs = append([]interface{}{"client.id", ck.clientID, "request.id", ck.requestID}, attrs...)
+	return tracing.Start(ck.trace, "KVClient."+method, tracing.KindClient, attrs...)
+}
+
+// 带有请求 ID 和 trace 的请求参数
+type tracedArgs interface {
+	setTrace(requestID string, sc tracing.SpanContext)
+}
+
+func (a *GetArgs) setTrace(id string, sc tracing.SpanContext)      { a.RequestID, a.Trace = id, sc }
+func (a *PutArgs) setTrace(id string, sc tracing.SpanContext)      { a.RequestID, a.Trace = id, sc }
+func (a *PatchArgs) setTrace(id string, sc tracing.SpanContext)    { a.RequestID, a.Trace = id, sc }
+func (a *DeleteArgs) setTrace(id string, sc tracing.SpanContext)   { a.RequestID, a.Trace = id, sc }
+func (a *MultiPutArgs) setTrace(id string, sc tracing.SpanContext) { a.RequestID, a.Trace = id, sc }
+
+// 开始一次 RPC 尝试的 span，并把请求 ID 和该 span 填入参数，服务端的 span 以它为父节点
+func (ck *KVClient) startAttempt(parent *tracing.Span, method string, server, attempt int, args tracedArgs) *tracing.Span {
+	span := tracing.Start(parent.Context(), "call "+method, tracing.KindClient, "server", server, "attempt", attempt)
+	args.setTrace(ck.requestID, span.Context())
+	return span
+}
+
+// 结束一次 RPC 尝试，ok 为 false 表示节点不可达，ErrNoKey 是正常的结果
+func endAttempt(span *tracing.Span, ok bool, err string) {
+	if !ok {
+		span.SetError("unreachable")
+	} else if err != ErrNoKey {
+		span.SetError(err)
+	}
+	span.End()
+}
+
+// 读取键的最新值，键不存在返回 ErrNoKey，重试用尽返回 ErrTimeout
+func (ck *KVClient) GetEntry(key string) (KVEntry, error) {
 	args := &GetArgs{
-		Key: key,
+		Key:      key,
+		ClientID: ck.clientID,
+		SeqNum:   ck.nextReadSeq(),
 	}
 
-	log.Printf("Client %d: Starting Get request for key=%s", ck.clientID, key)
+	ck.logger.Debug("Starting Get request", "key", key)
+	span := ck.startSpan("Get", "key", key)
+	defer span.End()
 
 	for retries := 0; retries < 5; retries++ {
-		server := ck.servers[ck.leaderID]
-		log.Printf("Client %d: Sending Get request for key=%s to server %d (attempt %d)", ck.clientID, key, ck.leaderID, retries+1)
+		leader := ck.leader()
+		ck.logger.Debug("Sending Get request", "key", key, "server", leader, "attempt", retries+1)
 
 		var reply GetReply
-		ok := server.Call("KVServer.Get", args, &reply)
+		attempt := ck.startAttempt(span, "KVServer.Get", leader, retries+1, args)
+		ok := ck.servers[leader].Call("KVServer.Get", args, &reply)
+		endAttempt(attempt, ok, reply.Err)
 
 		if ok {
-			if reply.Err == "Key not found" {
-				log.Printf("Client %d: Get key=%s not found on server %d", ck.clientID, key, ck.leaderID)
-				return "" // 返回空值表示 key 不存在
+			if reply.Err == ErrNoKey {
+				ck.logger.Debug("Get found no key", "key", key, "server", leader)
+				return KVEntry{}, errors.New(ErrNoKey)
 			} else if reply.Err == "" {
-				// 将 KVEntry 转换为 JSON 字符串返回
-				value, _ := json.Marshal(reply.Value)
-				log.Printf("Client %d: Get key=%s succeeded on server %d", ck.clientID, key, ck.leaderID)
-				return string(value)
-			} else if reply.Err == ErrWrongLeader {
-				log.Printf("Client %d: Wrong leader on server %d, switching to server %d", ck.clientID, ck.leaderID, (ck.leaderID+1)%len(ck.servers))
-				ck.leaderID = (ck.leaderID + 1) % len(ck.servers)
+				ck.logger.Debug("Get succeeded", "key", key, "server", leader)
+				return reply.Value, nil
+			} else if reply.Err == ErrWrongLeader || reply.Err == ErrTimeout {
+				ck.logger.Debug("Wrong leader, switching server", "server", leader, "next", (leader+1)%len(ck.servers))
+				ck.nextLeader(leader)
 			}
 		} else {
-			log.Printf("Client %d: Get key=%s failed on server %d, retrying...", ck.clientID, key, ck.leaderID)
+			ck.logger.Debug("Get failed, retrying", "key", key, "server", leader)
+			ck.nextLeader(leader)
 		}
 
-		time.Sleep(100 * time.Millisecond)
+		sim.Sleep(100 * time.Millisecond)
 	}
 
-	log.Printf("Client %d: Get key=%s failed after retries", ck.clientID, key)
-	return ""
+	ck.logger.Warn("Get failed after retries", "key", key)
+	span.SetError(ErrTimeout)
+	return KVEntry{}, errors.New(ErrTimeout)
+}
+
+// 写入一个不会过期的键，重试用尽后返回 ErrTimeout
+func (ck *KVClient) Put(key string, value KVEntry) error {
+	return ck.PutWithTTL(key, value, 0)
 }
 
-func (ck *KVClient) Put(key string, value KVEntry) {
+// 写入一个键，ttl 大于 0 时该键在 ttl 之后自动删除，重试用尽后返回 ErrTimeout
+func (ck *KVClient) PutWithTTL(key string, value KVEntry, ttl time.Duration) error {
+	sess := ck.acquireSession()
+	defer ck.releaseSession(sess)
 	args := &PutArgs{
 		Key:      key,
 		Value:    value,
-		ClientID: ck.clientID,
-		SeqNum:   int(atomic.AddInt64(&ck.seqNum, 1)),
+		TTL:      ttl,
+		Actor:    ck.actor,
+		ClientID: sess.id,
+		SeqNum:   sess.seq,
 	}
+	span := ck.startSpan("Put", "key", key)
+	defer span.End()
 
 	for retries := 0; retries < 5; retries++ {
-		server := ck.servers[ck.leaderID]
+		leader := ck.leader()
 		var reply PutReply
-		ok := server.Call("KVServer.Put", args, &reply)
+		attempt := ck.startAttempt(span, "KVServer.Put", leader, retries+1, args)
+		ok := ck.servers[leader].Call("KVServer.Put", args, &reply)
+		endAttempt(attempt, ok, reply.Err)
 		if ok && reply.Err == "" {
-			log.Printf("Client %d: Put key=%s value=%+v succeeded on leader %d", ck.clientID, key, value, ck.leaderID)
-			return
+			ck.logger.Debug("Put succeeded", "key", key, "server", leader)
+			return nil
 		} else if ok && reply.Err == ErrWrongLeader {
-			log.Printf("Client %d: Wrong leader, switching from %d to %d", ck.clientID, ck.leaderID, (ck.leaderID+1)%len(ck.servers))
-			ck.leaderID = (ck.leaderID + 1) % len(ck.servers)
+			ck.logger.Debug("Wrong leader, switching server", "server", leader, "next", (leader+1)%len(ck.servers))
+			ck.nextLeader(leader)
+		} else if !ok || reply.Err == ErrTimeout {
+			// 节点不可达（进程退出或网络断开），或者没能在超时内提交（可能是被分区的旧领导者），换一个节点重试
+			ck.nextLeader(leader)
 		}
 
-		time.Sleep(100 * time.Millisecond)
+		sim.Sleep(100 * time.Millisecond)
 	}
 
-	log.Printf("Client %d: Put key=%s value=%+v failed after retries", ck.clientID, key, value)
+	ck.logger.Warn("Put failed after retries", "key", key)
+	span.SetError(ErrTimeout)
+	return errors.New(ErrTimeout)
 }
 
-// 新增 GetAllKeys 方法
-func (ck *KVClient) GetAllKeys() []string {
-	args := &GetAllKeysArgs{}
+// 删除一个键，键不存在时返回 ErrNoKey
+func (ck *KVClient) Delete(key string) error {
+	sess := ck.acquireSession()
+	defer ck.releaseSession(sess)
+	args := &DeleteArgs{
+		Key:      key,
+		Actor:    ck.actor,
+		ClientID: sess.id,
+		SeqNum:   sess.seq,
+	}
+	span := ck.startSpan("Delete", "key", key)
+	defer span.End()
+
+	for retries := 0; retries < 5; retries++ {
+		leader := ck.leader()
+		var reply DeleteReply
+		attempt := ck.startAttempt(span, "KVServer.Delete", leader, retries+1, args)
+		ok := ck.servers[leader].Call("KVServer.Delete", args, &reply)
+		endAttempt(attempt, ok, reply.Err)
+		if ok && reply.Err == "" {
+			ck.logger.Debug("Delete succeeded", "key", key, "server", leader)
+			return nil
+		} else if ok && reply.Err == ErrNoKey {
+			return errors.New(ErrNoKey)
+		} else if ok && reply.Err == ErrWrongLeader {
+			ck.logger.Debug("Wrong leader, switching server", "server", leader, "next", (leader+1)%len(ck.servers))
+			ck.nextLeader(leader)
+		} else if !ok || reply.Err == ErrTimeout {
+			// 节点不可达（进程退出或网络断开），或者没能在超时内提交（可能是被分区的旧领导者），换一个节点重试
+			ck.nextLeader(leader)
+		}
 
-	log.Printf("Client %d: Starting GetAllKeys request", ck.clientID)
+		sim.Sleep(100 * time.Millisecond)
+	}
+
+	ck.logger.Warn("Delete failed after retries", "key", key)
+	span.SetError(ErrTimeout)
+	return errors.New(ErrTimeout)
+}
+
+// 按键的字典序返回所有键，重试用尽后返回 ErrTimeout
+func (ck *KVClient) GetAllKeys() ([]string, error) {
+	args := &GetAllKeysArgs{
+		ClientID: ck.clientID,
+		SeqNum:   ck.nextReadSeq(),
+	}
+
+	ck.logger.Debug("Starting GetAllKeys request")
 
 	for retries := 0; retries < 5; retries++ {
-		server := ck.servers[ck.leaderID]
+		leader := ck.leader()
+		server := ck.servers[leader]
 		var reply GetAllKeysReply
 		ok := server.Call("KVServer.GetAllKeys", args, &reply)
 
 		if ok {
 			if reply.Err == "" {
-				log.Printf("Client %d: GetAllKeys succeeded on server %d", ck.clientID, ck.leaderID)
-				return reply.Keys // 返回 string 类型的键列表
+				ck.logger.Debug("GetAllKeys succeeded", "server", leader)
+				return reply.Keys, nil
+			} else if reply.Err == ErrWrongLeader || reply.Err == ErrTimeout {
+				ck.logger.Debug("Wrong leader, switching server", "server", leader, "next", (leader+1)%len(ck.servers))
+				ck.nextLeader(leader)
+			}
+		} else {
+			ck.logger.Debug("GetAllKeys failed, retrying", "server", leader)
+			ck.nextLeader(leader)
+		}
+
+		sim.Sleep(100 * time.Millisecond)
+	}
+
+	ck.logger.Warn("GetAllKeys failed after retries")
+	return nil, errors.New(ErrTimeout)
+}
+
+// 批量读取多个键，返回存在的键及其值，重试用尽后返回 ErrTimeout
+func (ck *KVClient) MultiGet(keys []string) (map[string]KVEntry, error) {
+	args := &MultiGetArgs{
+		Keys:     keys,
+		ClientID: ck.clientID,
+		SeqNum:   ck.nextReadSeq(),
+	}
+
+	ck.logger.Debug("Starting MultiGet request", "keys", len(keys))
+
+	for retries := 0; retries < 5; retries++ {
+		leader := ck.leader()
+		server := ck.servers[leader]
+		var reply MultiGetReply
+		ok := server.Call("KVServer.MultiGet", args, &reply)
+
+		if ok {
+			if reply.Err == "" {
+				ck.logger.Debug("MultiGet succeeded", "server", leader)
+				return reply.Values, nil
+			} else if reply.Err == ErrWrongLeader || reply.Err == ErrTimeout {
+				ck.logger.Debug("Wrong leader, switching server", "server", leader, "next", (leader+1)%len(ck.servers))
+				ck.nextLeader(leader)
+			}
+		} else {
+			ck.logger.Debug("MultiGet failed, retrying", "server", leader)
+			ck.nextLeader(leader)
+		}
+
+		sim.Sleep(100 * time.Millisecond)
+	}
+
+	ck.logger.Warn("MultiGet failed after retries")
+	return nil, errors.New(ErrTimeout)
+}
+
+// 批量写入，所有键值在一条 Raft 日志中提交，重试用尽后返回 ErrTimeout
+func (ck *KVClient) MultiPut(entries []KeyValue) error {
+	sess := ck.acquireSession()
+	defer ck.releaseSession(sess)
+	args := &MultiPutArgs{
+		Entries:  entries,
+		Actor:    ck.actor,
+		ClientID: sess.id,
+		SeqNum:   sess.seq,
+	}
+	span := ck.startSpan("MultiPut", "entries", len(entries))
+	defer span.End()
+
+	for retries := 0; retries < 5; retries++ {
+		leader := ck.leader()
+		var reply MultiPutReply
+		attempt := ck.startAttempt(span, "KVServer.MultiPut", leader, retries+1, args)
+		ok := ck.servers[leader].Call("KVServer.MultiPut", args, &reply)
+		endAttempt(attempt, ok, reply.Err)
+		if ok && reply.Err == "" {
+			ck.logger.Debug("MultiPut succeeded", "entries", len(entries), "server", leader)
+			return nil
+		} else if ok && reply.Err == ErrWrongLeader {
+			ck.logger.Debug("Wrong leader, switching server", "server", leader, "next", (leader+1)%len(ck.servers))
+			ck.nextLeader(leader)
+		} else if !ok || reply.Err == ErrTimeout {
+			// 节点不可达（进程退出或网络断开），或者没能在超时内提交（可能是被分区的旧领导者），换一个节点重试
+			ck.nextLeader(leader)
+		}
+
+		sim.Sleep(100 * time.Millisecond)
+	}
+
+	ck.logger.Warn("MultiPut failed after retries", "entries", len(entries))
+	span.SetError(ErrTimeout)
+	return errors.New(ErrTimeout)
+}
+
+// 扫描 [start, end) 范围内的键值，token 为上一页返回的续传标记，重试用尽后返回 ErrTimeout
+func (ck *KVClient) Scan(start, end string, limit int, token string) ([]KeyValue, string, error) {
+	args := &ScanArgs{
+		Start: start,
+		End:   end,
+		Limit: limit,
+		Token: token,
+
+		ClientID: ck.clientID,
+		SeqNum:   ck.nextReadSeq(),
+	}
+	return ck.scan("KVServer.Scan", args)
+}
+
+// 扫描 [start, end) 范围内 asOf 日志位置时的键值，asOf 为 0 时读取当前值
+// 第三个返回值为本页读取的位置，之后的页传入同一位置即可看到同一个一致的视图
+// 该位置之前的历史已经被丢弃时返回 ErrCompacted
+func (ck *KVClient) ScanAt(start, end string, limit int, token string, asOf int) ([]KeyValue, string, int, error) {
+	args := &ScanArgs{
+		Start: start,
+		End:   end,
+		Limit: limit,
+		Token: token,
+		AsOf:  asOf,
+
+		ClientID: ck.clientID,
+		SeqNum:   ck.nextReadSeq(),
+	}
+
+	for retries := 0; retries < 5; retries++ {
+		leader := ck.leader()
+		server := ck.servers[leader]
+		var reply ScanReply
+		ok := server.Call("KVServer.Scan", args, &reply)
+
+		if ok {
+			if reply.Err == "" {
+				return reply.Entries, reply.NextToken, reply.Index, nil
+			} else if reply.Err == ErrCompacted {
+				return nil, "", 0, errors.New(reply.Err)
+			} else if reply.Err == ErrWrongLeader || reply.Err == ErrTimeout {
+				ck.nextLeader(leader)
+			}
+		} else {
+			ck.logger.Debug("Scan failed, retrying", "as_of", asOf, "server", leader)
+			ck.nextLeader(leader)
+		}
+
+		sim.Sleep(100 * time.Millisecond)
+	}
+
+	return nil, "", 0, errors.New(ErrTimeout)
+}
+
+// 在集群中固定一个日志位置，之后用 ScanAt 读取该位置不会因为历史被丢弃而失败
+// 同一个 id 再次调用为续期，返回原来的位置；超过 pinTTL 没有续期的位置会被自动释放
+func (ck *KVClient) PinVersions(id string) (int, error) {
+	reply, err := ck.pinVersions(&PinVersionsArgs{ID: id})
+	return reply.Index, err
+}
+
+// 释放 PinVersions 固定的位置
+func (ck *KVClient) UnpinVersions(id string) error {
+	_, err := ck.pinVersions(&PinVersionsArgs{ID: id, Release: true})
+	return err
+}
+
+func (ck *KVClient) pinVersions(args *PinVersionsArgs) (PinVersionsReply, error) {
+	sess := ck.acquireSession()
+	defer ck.releaseSession(sess)
+	args.ClientID = sess.id
+	args.SeqNum = sess.seq
+
+	for retries := 0; retries < 5; retries++ {
+		leader := ck.leader()
+		var reply PinVersionsReply
+		ok := ck.servers[leader].Call("KVServer.PinVersions", args, &reply)
+		if ok && reply.Err == "" {
+			return reply, nil
+		} else if ok && reply.Err == ErrCompacted {
+			return reply, errors.New(reply.Err)
+		} else {
+			ck.nextLeader(leader)
+		}
+
+		sim.Sleep(100 * time.Millisecond)
+	}
+
+	return PinVersionsReply{}, errors.New(ErrTimeout)
+}
+
+// 扫描以 prefix 开头的键值，token 为上一页返回的续传标记，重试用尽后返回 ErrTimeout
+func (ck *KVClient) PrefixScan(prefix string, limit int, token string) ([]KeyValue, string, error) {
+	args := &PrefixScanArgs{
+		Prefix:   prefix,
+		Limit:    limit,
+		Token:    token,
+		ClientID: ck.clientID,
+		SeqNum:   ck.nextReadSeq(),
+	}
+	return ck.scan("KVServer.PrefixScan", args)
+}
+
+func (ck *KVClient) scan(method string, args interface{}) ([]KeyValue, string, error) {
+	ck.logger.Debug("Starting scan request", "method", method)
+
+	for retries := 0; retries < 5; retries++ {
+		leader := ck.leader()
+		server := ck.servers[leader]
+		var reply ScanReply
+		ok := server.Call(method, args, &reply)
+
+		if ok {
+			if reply.Err == "" {
+				ck.logger.Debug("Scan request succeeded", "method", method, "entries", len(reply.Entries), "server", leader)
+				return reply.Entries, reply.NextToken, nil
+			} else if reply.Err == ErrWrongLeader || reply.Err == ErrTimeout {
+				ck.logger.Debug("Wrong leader, switching server", "server", leader, "next", (leader+1)%len(ck.servers))
+				ck.nextLeader(leader)
+			}
+		} else {
+			ck.logger.Debug("Scan request failed, retrying", "method", method, "server", leader)
+			ck.nextLeader(leader)
+		}
+
+		sim.Sleep(100 * time.Millisecond)
+	}
+
+	ck.logger.Warn("Scan request failed after retries", "method", method)
+	return nil, "", errors.New(ErrTimeout)
+}
+
+// 在服务端按条件查询、排序和分页，返回本页记录和满足条件的总数
+// 条件不合法时返回 *QueryError
+func (ck *KVClient) Query(args QueryArgs) ([]KeyValue, int, error) {
+	ck.logger.Debug("Starting Query request", "conditions", len(args.Conditions))
+	args.ClientID = ck.clientID
+	args.SeqNum = ck.nextReadSeq()
+
+	for retries := 0; retries < 5; retries++ {
+		leader := ck.leader()
+		server := ck.servers[leader]
+		var reply QueryReply
+		ok := server.Call("KVServer.Query", &args, &reply)
+
+		if ok {
+			if reply.Err == "" {
+				ck.logger.Debug("Query succeeded", "entries", len(reply.Entries), "total", reply.Total, "server", leader)
+				return reply.Entries, reply.Total, nil
+			} else if reply.Err == ErrInvalidQuery {
+				return nil, 0, &QueryError{Detail: reply.Detail}
+			} else if reply.Err == ErrWrongLeader || reply.Err == ErrTimeout {
+				ck.logger.Debug("Wrong leader, switching server", "server", leader, "next", (leader+1)%len(ck.servers))
+				ck.nextLeader(leader)
+			}
+		} else {
+			ck.logger.Debug("Query failed, retrying", "server", leader)
+			ck.nextLeader(leader)
+		}
+
+		sim.Sleep(100 * time.Millisecond)
+	}
+
+	ck.logger.Warn("Query failed after retries")
+	return nil, 0, errors.New(ErrTimeout)
+}
+
+// 在服务端计算分组统计，参数不合法时返回 *QueryError
+func (ck *KVClient) Stats(args StatsArgs) ([]StatsGroup, error) {
+	ck.logger.Debug("Starting Stats request")
+	args.ClientID = ck.clientID
+	args.SeqNum = ck.nextReadSeq()
+
+	for retries := 0; retries < 5; retries++ {
+		leader := ck.leader()
+		server := ck.servers[leader]
+		var reply StatsReply
+		ok := server.Call("KVServer.Stats", &args, &reply)
+
+		if ok {
+			if reply.Err == "" {
+				ck.logger.Debug("Stats succeeded", "groups", len(reply.Groups), "server", leader)
+				return reply.Groups, nil
+			} else if reply.Err == ErrInvalidQuery {
+				return nil, &QueryError{Detail: reply.Detail}
+			} else if reply.Err == ErrWrongLeader || reply.Err == ErrTimeout {
+				ck.logger.Debug("Wrong leader, switching server", "server", leader, "next", (leader+1)%len(ck.servers))
+				ck.nextLeader(leader)
+			}
+		} else {
+			ck.logger.Debug("Stats failed, retrying", "server", leader)
+			ck.nextLeader(leader)
+		}
+
+		sim.Sleep(100 * time.Millisecond)
+	}
+
+	ck.logger.Warn("Stats failed after retries")
+	return nil, errors.New(ErrTimeout)
+}
+
+// 只修改记录中的部分字段，记录不存在时返回 ErrNoKey
+func (ck *KVClient) Patch(key string, fields []string, value KVEntry) error {
+	sess := ck.acquireSession()
+	defer ck.releaseSession(sess)
+	args := &PatchArgs{
+		Key:      key,
+		Fields:   fields,
+		Value:    value,
+		Actor:    ck.actor,
+		ClientID: sess.id,
+		SeqNum:   sess.seq,
+	}
+	span := ck.startSpan("Patch", "key", key)
+	defer span.End()
+
+	for retries := 0; retries < 5; retries++ {
+		leader := ck.leader()
+		var reply PatchReply
+		attempt := ck.startAttempt(span, "KVServer.Patch", leader, retries+1, args)
+		ok := ck.servers[leader].Call("KVServer.Patch", args, &reply)
+		endAttempt(attempt, ok, reply.Err)
+		if ok && reply.Err == "" {
+			ck.logger.Debug("Patch succeeded", "key", key, "fields", fields, "server", leader)
+			return nil
+		} else if ok && (reply.Err == ErrNoKey || reply.Err == ErrInvalidField) {
+			return errors.New(reply.Err)
+		} else if ok && reply.Err == ErrWrongLeader {
+			ck.logger.Debug("Wrong leader, switching server", "server", leader, "next", (leader+1)%len(ck.servers))
+			ck.nextLeader(leader)
+		} else if !ok || reply.Err == ErrTimeout {
+			// 节点不可达（进程退出或网络断开），或者没能在超时内提交（可能是被分区的旧领导者），换一个节点重试
+			ck.nextLeader(leader)
+		}
+
+		sim.Sleep(100 * time.Millisecond)
+	}
+
+	ck.logger.Warn("Patch failed after retries", "key", key)
+	span.SetError(ErrTimeout)
+	return errors.New(ErrTimeout)
+}
+
+// 等待 key（或 prefix 开头的键）上从 fromIndex 开始的变更，最多等待 timeout
+// 返回事件和下一次调用应使用的 fromIndex；历史已被丢弃时返回 ErrCompacted
+func (ck *KVClient) Watch(key, prefix string, fromIndex int, timeout time.Duration) ([]WatchEvent, int, error) {
+	args := &WatchArgs{
+		Key:       key,
+		Prefix:    prefix,
+		FromIndex: fromIndex,
+		Timeout:   timeout,
+	}
+
+	for retries := 0; retries < 5; retries++ {
+		leader := ck.leader()
+		server := ck.servers[leader]
+		var reply WatchReply
+		ok := server.Call("KVServer.Watch", args, &reply)
+
+		if ok {
+			if reply.Err == "" {
+				return reply.Events, reply.NextIndex, nil
+			} else if reply.Err == ErrCompacted {
+				return nil, reply.NextIndex, errors.New(ErrCompacted)
 			} else if reply.Err == ErrWrongLeader {
-				log.Printf("Client %d: Wrong leader on server %d, switching to server %d", ck.clientID, ck.leaderID, (ck.leaderID+1)%len(ck.servers))
-				ck.leaderID = (ck.leaderID + 1) % len(ck.servers)
+				// 节点已经停止，换一个节点继续
+				ck.nextLeader(leader)
+			}
+		} else {
+			// 任意副本都可以提供 Watch，换一个节点继续
+			ck.logger.Debug("Watch failed, retrying", "server", leader)
+			ck.nextLeader(leader)
+		}
+
+		sim.Sleep(100 * time.Millisecond)
+	}
+
+	ck.logger.Warn("Watch failed after retries")
+	return nil, fromIndex, errors.New(ErrTimeout)
+}
+
+// 读取键在日志位置 asOfIndex 时的值
+// 键当时不存在返回 ErrNoKey，该位置的历史已被丢弃返回 ErrCompacted
+func (ck *KVClient) GetAt(key string, asOfIndex int) (KVEntry, error) {
+	args := &GetArgs{
+		Key:       key,
+		AsOfIndex: asOfIndex,
+	}
+
+	for retries := 0; retries < 5; retries++ {
+		leader := ck.leader()
+		server := ck.servers[leader]
+		var reply GetReply
+		ok := server.Call("KVServer.Get", args, &reply)
+
+		if ok {
+			if reply.Err == "" {
+				return reply.Value, nil
+			} else if reply.Err == ErrNoKey || reply.Err == ErrCompacted {
+				return KVEntry{}, errors.New(reply.Err)
+			} else if reply.Err == ErrWrongLeader {
+				ck.nextLeader(leader)
+			}
+		} else {
+			ck.logger.Debug("GetAt failed, retrying", "key", key, "as_of", asOfIndex, "server", leader)
+			ck.nextLeader(leader)
+		}
+
+		sim.Sleep(100 * time.Millisecond)
+	}
+
+	return KVEntry{}, errors.New(ErrTimeout)
+}
+
+// 读取键的历史版本，返回版本列表和最早可以查询的日志位置
+func (ck *KVClient) History(key string) ([]Version, int, error) {
+	args := &HistoryArgs{
+		Key: key,
+	}
+
+	for retries := 0; retries < 5; retries++ {
+		leader := ck.leader()
+		server := ck.servers[leader]
+		var reply HistoryReply
+		ok := server.Call("KVServer.History", args, &reply)
+
+		if ok {
+			if reply.Err == "" {
+				return reply.Versions, reply.Horizon, nil
+			} else if reply.Err == ErrWrongLeader {
+				ck.nextLeader(leader)
+			}
+		} else {
+			ck.logger.Debug("History failed, retrying", "key", key, "server", leader)
+			ck.nextLeader(leader)
+		}
+
+		sim.Sleep(100 * time.Millisecond)
+	}
+
+	return nil, 0, errors.New(ErrTimeout)
+}
+
+// 读取系统键，键不存在时返回 ErrNoKey
+func (ck *KVClient) SysGet(key string) (string, error) {
+	args := &SysGetArgs{
+		Key:      key,
+		ClientID: ck.clientID,
+		SeqNum:   ck.nextReadSeq(),
+	}
+
+	for retries := 0; retries < 5; retries++ {
+		leader := ck.leader()
+		server := ck.servers[leader]
+		var reply SysGetReply
+		ok := server.Call("KVServer.SysGet", args, &reply)
+
+		if ok {
+			if reply.Err == "" {
+				return reply.Value, nil
+			} else if reply.Err == ErrNoKey {
+				return "", errors.New(ErrNoKey)
+			} else if reply.Err == ErrWrongLeader || reply.Err == ErrTimeout {
+				ck.nextLeader(leader)
+			}
+		} else {
+			ck.logger.Debug("SysGet failed, retrying", "key", key, "server", leader)
+			ck.nextLeader(leader)
+		}
+
+		sim.Sleep(100 * time.Millisecond)
+	}
+
+	return "", errors.New(ErrTimeout)
+}
+
+// 写入系统键
+func (ck *KVClient) SysPut(key, value string) error {
+	return ck.sysPut(&SysPutArgs{Key: key, Value: value})
+}
+
+// 删除系统键
+func (ck *KVClient) SysDelete(key string) error {
+	return ck.sysPut(&SysPutArgs{Key: key, Delete: true})
+}
+
+func (ck *KVClient) sysPut(args *SysPutArgs) error {
+	args.Actor = ck.actor
+	sess := ck.acquireSession()
+	defer ck.releaseSession(sess)
+	args.ClientID = sess.id
+	args.SeqNum = sess.seq
+
+	for retries := 0; retries < 5; retries++ {
+		leader := ck.leader()
+		server := ck.servers[leader]
+		var reply SysPutReply
+		ok := server.Call("KVServer.SysPut", args, &reply)
+		if ok && reply.Err == "" {
+			return nil
+		} else if ok && reply.Err == ErrWrongLeader {
+			ck.nextLeader(leader)
+		} else if !ok || reply.Err == ErrTimeout {
+			// 节点不可达（进程退出或网络断开），或者没能在超时内提交（可能是被分区的旧领导者），换一个节点重试
+			ck.nextLeader(leader)
+		}
+
+		sim.Sleep(100 * time.Millisecond)
+	}
+
+	ck.logger.Warn("SysPut failed after retries", "key", args.Key)
+	return errors.New(ErrTimeout)
+}
+
+// 返回 prefix 开头的全部系统键
+func (ck *KVClient) SysList(prefix string) (map[string]string, error) {
+	args := &SysListArgs{
+		Prefix:   prefix,
+		ClientID: ck.clientID,
+		SeqNum:   ck.nextReadSeq(),
+	}
+
+	for retries := 0; retries < 5; retries++ {
+		leader := ck.leader()
+		server := ck.servers[leader]
+		var reply SysListReply
+		ok := server.Call("KVServer.SysList", args, &reply)
+
+		if ok {
+			if reply.Err == "" {
+				return reply.Values, nil
+			} else if reply.Err == ErrWrongLeader || reply.Err == ErrTimeout {
+				ck.nextLeader(leader)
+			}
+		} else {
+			ck.logger.Debug("SysList failed, retrying", "prefix", prefix, "server", leader)
+			ck.nextLeader(leader)
+		}
+
+		sim.Sleep(100 * time.Millisecond)
+	}
+
+	return nil, errors.New(ErrTimeout)
+}
+
+// 读取审计日志
+func (ck *KVClient) Audit(args AuditArgs) ([]AuditRecord, error) {
+	args.ClientID = ck.clientID
+	args.SeqNum = ck.nextReadSeq()
+
+	for retries := 0; retries < 5; retries++ {
+		leader := ck.leader()
+		server := ck.servers[leader]
+		var reply AuditReply
+		ok := server.Call("KVServer.Audit", &args, &reply)
+
+		if ok {
+			if reply.Err == "" {
+				return reply.Records, nil
+			} else if reply.Err == ErrWrongLeader || reply.Err == ErrTimeout {
+				ck.nextLeader(leader)
 			}
 		} else {
-			log.Printf("Client %d: GetAllKeys failed on server %d, retrying...", ck.clientID, ck.leaderID)
+			ck.logger.Debug("Audit failed, retrying", "server", leader)
+			ck.nextLeader(leader)
 		}
 
-		time.Sleep(100 * time.Millisecond)
+		sim.Sleep(100 * time.Millisecond)
 	}
 
-	log.Printf("Client %d: GetAllKeys failed after retries", ck.clientID)
-	return []string{}
+	return nil, errors.New(ErrTimeout)
 }
@@ -1,9 +1,27 @@
 package kv
 
+import (
+	"course/raft"
+	"course/tracing"
+	"time"
+)
+
 // 操作类型常量
 const (
-	OpGet = "Get"
-	OpPut = "Put"
+	OpGet      = "Get"
+	OpPut      = "Put"
+	OpMultiPut = "MultiPut"
+	OpPatch    = "Patch"
+	OpDelete   = "Delete"
+	OpTick     = "Tick" // 只推进状态机时钟，用于让到期的键被删除
+	OpRead     = "Read" // 读屏障，不改变状态，见 readBarrier
+
+	OpPinVersions   = "PinVersions"   // 固定当前位置的历史版本，见 versions.go
+	OpUnpinVersions = "UnpinVersions" // 释放固定的位置
+
+	OpSysGet    = "SysGet" // 读取系统键，和 Get 一样作为日志提交，不改变状态
+	OpSysPut    = "SysPut" // 写入系统键空间
+	OpSysDelete = "SysDelete"
 )
 
 // 操作结构体，用于封装客户端请求
@@ -11,13 +29,42 @@ type Op struct {
 	Type     string
 	Key      string
 	Value    KVEntry
+	Entries  []KeyValue // MultiPut 的全部键值，作为一条日志提交
+	Fields   []string   // Patch 要从 Value 合并到原记录的字段
+	TTL      time.Duration
+	Time     int64  // 领导者提交该操作时的时间戳（UnixNano）
+	Actor    string // 发起写操作的用户，记录在审计日志中
+	Raw      string // 系统键空间的值
 	ClientID int64
 	SeqNum   int
+
+	RequestID string
+	Trace     tracing.SpanContext // 领导者上 startOp 的 span，各副本应用该操作时的 span 以它为父节点
+}
+
+// 操作在状态机上的执行结果，通过 notifyCh 交给等待的 RPC
+type opResult struct {
+	ClientID  int64
+	SeqNum    int
+	Err       string
+	AppliedAt time.Time // applyLoop 开始应用该操作的时间，用于计算复制阶段的耗时
+}
+
+// 批量操作中的一个键值对
+type KeyValue struct {
+	Key   string  `json:"key"`
+	Value KVEntry `json:"value"`
 }
 
 // Get 请求参数
 type GetArgs struct {
-	Key string
+	Key       string
+	AsOfIndex int // 大于 0 时读取该日志位置时的值
+	ClientID  int64
+	SeqNum    int // 读取最新值时作为一条日志提交，用来确认应用的是自己的那条日志
+
+	RequestID string              // 发起请求的 HTTP 请求 ID，用于日志
+	Trace     tracing.SpanContext // 客户端这次尝试的 span，服务端的 span 以它为父节点
 }
 
 // Get 回复参数
@@ -30,8 +77,13 @@ type GetReply struct {
 type PutArgs struct {
 	Key      string
 	Value    KVEntry
+	TTL      time.Duration // 大于 0 时，键在写入 TTL 之后自动删除
+	Actor    string
 	ClientID int64
 	SeqNum   int
+
+	RequestID string              // 发起请求的 HTTP 请求 ID，用于日志
+	Trace     tracing.SpanContext // 客户端这次尝试的 span，服务端的 span 以它为父节点
 }
 
 // Put 回复参数
@@ -39,11 +91,259 @@ type PutReply struct {
 	Err string
 }
 
+// Patch 请求参数，只有 Fields 中列出的字段会从 Value 写入原记录
+type PatchArgs struct {
+	Key      string
+	Fields   []string
+	Value    KVEntry
+	Actor    string
+	ClientID int64
+	SeqNum   int
+
+	RequestID string              // 发起请求的 HTTP 请求 ID，用于日志
+	Trace     tracing.SpanContext // 客户端这次尝试的 span，服务端的 span 以它为父节点
+}
+
+// Patch 回复参数
+type PatchReply struct {
+	Err string
+}
+
+// Delete 请求参数
+type DeleteArgs struct {
+	Key      string
+	Actor    string
+	ClientID int64
+	SeqNum   int
+
+	RequestID string              // 发起请求的 HTTP 请求 ID，用于日志
+	Trace     tracing.SpanContext // 客户端这次尝试的 span，服务端的 span 以它为父节点
+}
+
+// Delete 回复参数，键不存在时 Err 为 ErrNoKey
+type DeleteReply struct {
+	Err string
+}
+
+// MultiGet 请求参数
+type MultiGetArgs struct {
+	Keys     []string
+	ClientID int64
+	SeqNum   int
+}
+
+// MultiGet 回复参数，不存在的键不会出现在 Values 中
+type MultiGetReply struct {
+	Values map[string]KVEntry
+	Err    string
+}
+
+// MultiPut 请求参数
+type MultiPutArgs struct {
+	Entries  []KeyValue
+	Actor    string
+	ClientID int64
+	SeqNum   int
+
+	RequestID string              // 发起请求的 HTTP 请求 ID，用于日志
+	Trace     tracing.SpanContext // 客户端这次尝试的 span，服务端的 span 以它为父节点
+}
+
+// MultiPut 回复参数
+type MultiPutReply struct {
+	Err string
+}
+
+// 单次扫描返回条数的默认值和上限
+const (
+	DefaultScanLimit = 100
+	MaxScanLimit     = 1000
+)
+
+// Scan 请求参数，扫描 [Start, End) 范围内的键，End 为空表示不设上界
+type ScanArgs struct {
+	Start string
+	End   string
+	Limit int
+	Token string // 上一页返回的 NextToken，为空表示从 Start 开始
+	AsOf  int    // 大于 0 时读取该日志位置时的值，分页扫描时用来让每一页看到同一个一致的视图
+
+	ClientID int64
+	SeqNum   int
+}
+
+// PrefixScan 请求参数
+type PrefixScanArgs struct {
+	Prefix   string
+	Limit    int
+	Token    string
+	ClientID int64
+	SeqNum   int
+}
+
+// Scan 和 PrefixScan 的回复参数
+type ScanReply struct {
+	Entries   []KeyValue
+	NextToken string // 下一页的起点，为空表示已经扫描完毕
+	Index     int    // 本页读取的日志位置，AsOf 为 0 时为本节点已应用的最后位置
+	Err       string
+}
+
+// Query 请求参数，所有条件同时满足的记录才会返回
+type QueryArgs struct {
+	Conditions []Condition
+	Sort       []SortField // 为空时按键排序
+	Offset     int         // 跳过排序后的前 Offset 条
+	Limit      int         // 最多返回的条数，0 表示不限制
+
+	ClientID int64
+	SeqNum   int
+}
+
+// Query 回复参数
+type QueryReply struct {
+	Entries []KeyValue
+	Total   int // 满足条件的记录总数，不受 Offset 和 Limit 影响
+	Err     string
+	Detail  string // Err 为 ErrInvalidQuery 时说明具体原因
+}
+
+// Stats 请求参数，对满足 Conditions 的记录按 GroupBy 分组后计算 Metrics
+// GroupBy 为空时所有记录为一组
+type StatsArgs struct {
+	GroupBy    []string
+	Metrics    []Metric
+	Conditions []Condition
+
+	ClientID int64
+	SeqNum   int
+}
+
+// Stats 回复参数
+type StatsReply struct {
+	Groups []StatsGroup
+	Err    string
+	Detail string // Err 为 ErrInvalidQuery 时说明具体原因
+}
+
+// Watch 请求参数，Key 不为空时监听单个键，否则监听 Prefix 开头的所有键
+type WatchArgs struct {
+	Key       string
+	Prefix    string
+	FromIndex int           // 从该日志位置开始返回事件，0 表示从当前位置开始
+	Timeout   time.Duration // 没有事件时最长等待时间
+}
+
+// Watch 回复参数
+type WatchReply struct {
+	Events    []WatchEvent
+	NextIndex int // 下一次调用应使用的 FromIndex
+	Err       string
+}
+
+// Status 请求参数
+type StatusArgs struct{}
+
+// Status 回复参数，已停止的节点返回 ErrWrongLeader
+type StatusReply struct {
+	Raft        raft.Status
+	LastApplied int // 状态机已应用的最后位置
+	Keys        int
+	Err         string
+}
+
+// History 请求参数
+type HistoryArgs struct {
+	Key string
+}
+
+// History 回复参数
+type HistoryReply struct {
+	Versions    []Version
+	Horizon     int // 早于该位置的版本已被丢弃
+	LastApplied int
+	Err         string
+}
+
+// PinVersions 请求参数：登记或续期 ID 对应的固定位置，Release 为 true 时释放
+type PinVersionsArgs struct {
+	ID       string
+	Release  bool
+	ClientID int64
+	SeqNum   int
+}
+
+// PinVersions 回复参数，Index 为固定的日志位置
+type PinVersionsReply struct {
+	Index int
+	Err   string
+}
+
+// SysGet 请求参数
+type SysGetArgs struct {
+	Key      string
+	ClientID int64
+	SeqNum   int
+}
+
+// SysGet 回复参数
+type SysGetReply struct {
+	Value string
+	Err   string
+}
+
+// SysPut 请求参数，Delete 为 true 时删除该键
+type SysPutArgs struct {
+	Key      string
+	Value    string
+	Delete   bool
+	Actor    string
+	ClientID int64
+	SeqNum   int
+}
+
+// SysPut 回复参数
+type SysPutReply struct {
+	Err string
+}
+
+// SysList 请求参数
+type SysListArgs struct {
+	Prefix   string
+	ClientID int64
+	SeqNum   int
+}
+
+// SysList 回复参数
+type SysListReply struct {
+	Values map[string]string
+	Err    string
+}
+
+// Audit 请求参数，Key 和 Actor 为空表示不过滤
+type AuditArgs struct {
+	Key   string
+	Actor string
+	Limit int // 最多返回最近的多少条，0 表示全部
+
+	ClientID int64
+	SeqNum   int
+}
+
+// Audit 回复参数
+type AuditReply struct {
+	Records []AuditRecord
+	Err     string
+}
+
 // 错误信息常量
 const (
-	ErrNoKey       = "ErrNoKey"
-	ErrWrongLeader = "ErrWrongLeader"
-	ErrTimeout     = "ErrTimeout"
+	ErrNoKey        = "ErrNoKey"
+	ErrWrongLeader  = "ErrWrongLeader"
+	ErrTimeout      = "ErrTimeout"
+	ErrInvalidQuery = "ErrInvalidQuery"
+	ErrInvalidField = "ErrInvalidField"
+	ErrCompacted    = "ErrCompacted"
 )
 
 // KVEntry 定义存储的数据结构
@@ -57,7 +357,10 @@ type KVEntry struct {
 }
 
 // GetAllKeys 请求参数
-type GetAllKeysArgs struct{}
+type GetAllKeysArgs struct {
+	ClientID int64
+	SeqNum   int
+}
 
 // GetAllKeys 回复参数
 type GetAllKeysReply struct {
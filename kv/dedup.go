@@ -0,0 +1,30 @@
+package kv
+
+// 每个写操作带有客户端的会话 ID 和该会话内递增的序号。KVClient 保证同一个会话同一时刻只有一个写操作在进行，
+// 上一个写操作结束（成功或重试用尽）后才用下一个序号，因此同一会话的写操作按序号顺序提交，
+// 只需记录每个会话已执行的最大序号：不大于它的就是重复的请求。
+// 读操作不改变状态，不使用会话序号，也不参与去重
+
+// 判断写操作是否已经执行过，ClientID 为 0 的内部操作（如 Tick）不做判断，调用方需持有 kv.mu
+func (kv *KVServer) isDuplicateLocked(clientID int64, seq int) bool {
+	if clientID == 0 {
+		return false
+	}
+	max, ok := kv.clientSeq[clientID]
+	return ok && seq <= max
+}
+
+// 记录已经执行的写操作，调用方需持有 kv.mu
+func (kv *KVServer) recordSeqLocked(clientID int64, seq int) {
+	if clientID == 0 {
+		return
+	}
+	if seq > kv.clientSeq[clientID] {
+		kv.clientSeq[clientID] = seq
+	}
+}
+
+// 读操作作为日志提交只是为了确认领导者身份和读到最新的状态
+func isReadOp(typ string) bool {
+	return typ == OpGet || typ == OpRead || typ == OpSysGet
+}
@@ -0,0 +1,101 @@
+package kv
+
+import (
+	"strconv"
+	"testing"
+)
+
+func init() {
+	// 应用写操作后不导出数据文件
+	DataFile = ""
+}
+
+// 不启动 Raft 的 KVServer，只用来直接应用操作
+func newTestServer() *KVServer {
+	return &KVServer{
+		data:             newSkipList(),
+		index:            newSecondaryIndex(),
+		notifyCh:         make(map[int]chan opResult),
+		clientSeq:        make(map[int64]int),
+		expireAt:         make(map[string]int64),
+		watchCh:          make(chan struct{}),
+		versions:         make(map[string][]Version),
+		pins:             make(map[string]versionPin),
+		system:           make(map[string]string),
+		versionRetention: DefaultVersionRetention,
+		maxraftstate:     -1,
+	}
+}
+
+func applyPut(kv *KVServer, seq int, key, name string) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.applyingIndex++
+	kv.applyOpLocked(Op{Type: OpPut, Key: key, Value: KVEntry{Name: name}, ClientID: 1, SeqNum: seq})
+}
+
+func value(kv *KVServer, key string) string {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	entry, _ := kv.data.get(key)
+	return entry.Name
+}
+
+// 同一会话的重试被跳过，其他会话的写操作不受影响
+func TestDedupRetry(t *testing.T) {
+	kv := newTestServer()
+
+	applyPut(kv, 1, "a", "1")
+	applyPut(kv, 2, "a", "2")
+	applyPut(kv, 1, "a", "1")
+	if got := value(kv, "a"); got != "2" {
+		t.Fatalf("a = %q after retrying seq 1, want %q", got, "2")
+	}
+
+	kv.mu.Lock()
+	kv.applyingIndex++
+	kv.applyOpLocked(Op{Type: OpPut, Key: "a", Value: KVEntry{Name: "other"}, ClientID: 2, SeqNum: 1})
+	kv.mu.Unlock()
+	if got := value(kv, "a"); got != "other" {
+		t.Fatalf("a = %q after a write from another session, want %q", got, "other")
+	}
+}
+
+// 一个写操作迟迟没有提交时，共用客户端的其他请求使用别的会话，之后提交的这个写操作照常执行
+func TestStalledWriteIsApplied(t *testing.T) {
+	kv := newTestServer()
+	ck := &KVClient{clientCore: &clientCore{}}
+
+	stalled := ck.acquireSession()
+	for i := 0; i < 2000; i++ {
+		other := ck.acquireSession()
+		if other.id == stalled.id {
+			t.Fatalf("session %d handed out while still in use", stalled.id)
+		}
+		kv.mu.Lock()
+		kv.applyingIndex++
+		kv.applyOpLocked(Op{Type: OpPut, Key: "b", Value: KVEntry{Name: strconv.Itoa(i)}, ClientID: other.id, SeqNum: other.seq})
+		kv.mu.Unlock()
+		ck.releaseSession(other)
+	}
+
+	// 读操作不使用会话序号，也不参与去重
+	for i := 0; i < 2000; i++ {
+		applyOp(kv, Op{Type: OpRead, ClientID: stalled.id, SeqNum: ck.nextReadSeq()})
+	}
+
+	kv.mu.Lock()
+	kv.applyingIndex++
+	kv.applyOpLocked(Op{Type: OpPut, Key: "a", Value: KVEntry{Name: "late"}, ClientID: stalled.id, SeqNum: stalled.seq})
+	kv.mu.Unlock()
+	if got := value(kv, "a"); got != "late" {
+		t.Fatalf("a = %q after a stalled write was committed, want %q", got, "late")
+	}
+	ck.releaseSession(stalled)
+
+	// 归还的会话继续使用，序号递增
+	again := ck.acquireSession()
+	if again.seq <= 1 {
+		t.Fatalf("reused session has seq %d, want more than 1", again.seq)
+	}
+}
@@ -0,0 +1,101 @@
+package kv
+
+import (
+	"course/sim"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// 领导者检查是否有键到期的间隔
+const expireInterval = 500 * time.Millisecond
+
+// 让本节点的系统时间偏差 skew，用于模拟时钟不准的节点
+// 时间只用于领导者给出的时间戳，因此偏差只影响键的过期时间，不影响读写的正确性
+func (kv *KVServer) SetClockSkew(skew time.Duration) {
+	atomic.StoreInt64(&kv.clockSkew, int64(skew))
+}
+
+// 本节点的系统时间，包括 SetClockSkew 设置的偏差
+func (kv *KVServer) now() time.Time {
+	return sim.Now().Add(time.Duration(atomic.LoadInt64(&kv.clockSkew)))
+}
+
+// 用日志中领导者提出的时间戳推进状态机时钟，并删除已经到期的键
+// 时钟只会前进，所有副本按同样的日志得到同样的时钟，因此到期结果在各副本上一致
+// 调用方需持有 kv.mu
+func (kv *KVServer) advanceClockLocked(t int64) {
+	if t <= kv.clock {
+		return
+	}
+	kv.clock = t
+
+	// 按键的顺序删除，各副本上删除事件和历史版本的顺序相同
+	var expired []string
+	for key, expireAt := range kv.expireAt {
+		if expireAt <= kv.clock {
+			expired = append(expired, key)
+		}
+	}
+	sort.Strings(expired)
+	for _, key := range expired {
+		kv.deleteLocked(key)
+	}
+}
+
+// 读取本地状态时用来判断键是否到期的时间，调用方需持有 kv.mu
+// 状态机时钟只在应用日志时前进，到期时间不晚于它的键已经被删除；两次 OpTick 之间
+// 已经到期的键还在 data 中，读取时再按本节点的时间过滤，删除仍然只由日志驱动
+func (kv *KVServer) readClockLocked() int64 {
+	if now := kv.now().UnixNano(); now > kv.clock {
+		return now
+	}
+	return kv.clock
+}
+
+// 键在 now 时是否已经到期，调用方需持有 kv.mu
+func (kv *KVServer) expiredLocked(key string, now int64) bool {
+	expireAt, ok := kv.expireAt[key]
+	return ok && expireAt <= now
+}
+
+// 设置或清除键的过期时间，ttl 为 0 表示永不过期，调用方需持有 kv.mu
+func (kv *KVServer) setTTLLocked(key string, ttl time.Duration) {
+	if ttl > 0 {
+		kv.expireAt[key] = kv.clock + int64(ttl)
+	} else {
+		delete(kv.expireAt, key)
+	}
+}
+
+// 返回最早的过期时间，没有设置过期时间的键时返回 0
+func (kv *KVServer) nextExpiryLocked() int64 {
+	var next int64
+	for _, expireAt := range kv.expireAt {
+		if next == 0 || expireAt < next {
+			next = expireAt
+		}
+	}
+	return next
+}
+
+// 领导者定期检查是否有键到期，有则提交一条 OpTick 日志推进各副本的时钟
+// 到期删除只在应用日志时发生，不依赖各副本自己的系统时间
+func (kv *KVServer) expireTicker() {
+	for !kv.killed() {
+		sim.Sleep(expireInterval)
+
+		if _, isLeader := kv.rf.GetState(); !isLeader {
+			continue
+		}
+
+		now := kv.now().UnixNano()
+		kv.mu.Lock()
+		next := kv.nextExpiryLocked()
+		kv.mu.Unlock()
+
+		if next != 0 && next <= now {
+			kv.rf.Start(Op{Type: OpTick, Time: now})
+		}
+	}
+}
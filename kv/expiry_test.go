@@ -0,0 +1,80 @@
+package kv
+
+import (
+	"course/sim"
+	"testing"
+	"time"
+)
+
+func applyOp(kv *KVServer, op Op) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.applyingIndex++
+	kv.applyOpLocked(op)
+}
+
+// 同时到期的键按键的顺序删除，各副本的删除事件相同
+func TestExpiryDeletesInKeyOrder(t *testing.T) {
+	kv := newTestServer()
+	start := sim.Now().UnixNano()
+
+	keys := []string{"c", "a", "e", "b", "d"}
+	for i, key := range keys {
+		applyOp(kv, Op{Type: OpPut, Key: key, Value: KVEntry{Name: key}, TTL: time.Second, Time: start, ClientID: 1, SeqNum: i + 1})
+	}
+	applyOp(kv, Op{Type: OpTick, Time: start + int64(time.Second)})
+
+	var deleted []string
+	for _, event := range kv.events {
+		if event.Type == EventDelete {
+			deleted = append(deleted, event.Key)
+		}
+	}
+	want := []string{"a", "b", "c", "d", "e"}
+	if len(deleted) != len(want) {
+		t.Fatalf("deleted %v, want %v", deleted, want)
+	}
+	for i := range want {
+		if deleted[i] != want[i] {
+			t.Fatalf("deleted %v, want %v", deleted, want)
+		}
+	}
+}
+
+// 已经到期、但还没有 OpTick 删除的键不出现在读取结果中
+func TestExpiredKeysHiddenBeforeTick(t *testing.T) {
+	kv := newTestServer()
+	start := sim.Now().Add(-time.Minute).UnixNano()
+
+	applyOp(kv, Op{Type: OpPut, Key: "temp", Value: KVEntry{Name: "temp", Class: "c1"}, TTL: time.Second, Time: start, ClientID: 1, SeqNum: 1})
+	applyOp(kv, Op{Type: OpPut, Key: "keep", Value: KVEntry{Name: "keep", Class: "c1"}, Time: start, ClientID: 1, SeqNum: 2})
+	if _, exists := kv.data.get("temp"); !exists {
+		t.Fatalf("temp was deleted without a tick")
+	}
+
+	// 读请求的 RPC 先经过读屏障，这里没有 Raft，直接检查读屏障之后读取本地状态的部分
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if keys := kv.allKeysLocked(); len(keys) != 1 || keys[0] != "keep" {
+		t.Fatalf("GetAllKeys = %v, want [keep]", keys)
+	}
+
+	multi := kv.multiGetLocked([]string{"temp", "keep"})
+	if _, ok := multi["temp"]; ok || len(multi) != 1 {
+		t.Fatalf("MultiGet = %v, want only keep", multi)
+	}
+
+	scan, _ := kv.scanLocked("", 0, func(string) bool { return true })
+	if len(scan) != 1 || scan[0].Key != "keep" {
+		t.Fatalf("Scan = %v, want only keep", scan)
+	}
+
+	conds, err := compileConditions([]Condition{{Field: "class", Op: CondEq, Value: "c1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query := kv.queryLocked(conds); len(query) != 1 || query[0].Key != "keep" {
+		t.Fatalf("Query = %v, want only keep", query)
+	}
+}
@@ -0,0 +1,80 @@
+package kv
+
+import "sort"
+
+// 建立二级索引的字段
+var indexedFields = []string{"class", "major", "grand"}
+
+// 二级索引：字段 -> 字段值 -> 键集合
+// 与 kv.data 一起在 kv.mu 的保护下修改
+type secondaryIndex struct {
+	fields map[string]map[string]map[string]struct{}
+}
+
+func newSecondaryIndex() *secondaryIndex {
+	ix := &secondaryIndex{
+		fields: make(map[string]map[string]map[string]struct{}),
+	}
+	for _, field := range indexedFields {
+		ix.fields[field] = make(map[string]map[string]struct{})
+	}
+	return ix
+}
+
+func (ix *secondaryIndex) add(key string, e KVEntry) {
+	for field, values := range ix.fields {
+		v, _ := EntryField(e, field)
+		s := fieldString(v)
+		keys, ok := values[s]
+		if !ok {
+			keys = make(map[string]struct{})
+			values[s] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+func (ix *secondaryIndex) remove(key string, e KVEntry) {
+	for field, values := range ix.fields {
+		v, _ := EntryField(e, field)
+		s := fieldString(v)
+		if keys, ok := values[s]; ok {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(values, s)
+			}
+		}
+	}
+}
+
+func (ix *secondaryIndex) has(field string) bool {
+	_, ok := ix.fields[field]
+	return ok
+}
+
+// 返回字段等于 value 的所有键，按键排序
+func (ix *secondaryIndex) lookup(field, value string) []string {
+	keys := ix.fields[field][value]
+	result := make([]string, 0, len(keys))
+	for key := range keys {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// 估算 lookup 返回的键数量，用于选择最有区分度的索引
+func (ix *secondaryIndex) count(field, value string) int {
+	return len(ix.fields[field][value])
+}
+
+// 根据全部数据重建索引，在加载数据或安装快照之后调用
+func (ix *secondaryIndex) rebuild(data *skipList) {
+	for _, field := range indexedFields {
+		ix.fields[field] = make(map[string]map[string]struct{})
+	}
+	data.ascend("", func(key string, value KVEntry) bool {
+		ix.add(key, value)
+		return true
+	})
+}
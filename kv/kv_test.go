@@ -0,0 +1,503 @@
+package kv_test
+
+// KV 层的测试：用 cluster 包在 labrpc 上启动集群，通过 KVClient 读写，
+// 同时崩溃、重启节点和划分网络
+
+import (
+	"course/cluster"
+	"course/kv"
+	"course/linearizability"
+	"course/logging"
+	"course/transport"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// 每个测试的集群都从空的状态开始，不读写 data_kv.json
+	kv.DataFile = ""
+	if err := logging.Setup("", "error"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// 启动 n 个节点的集群，测试结束时停止
+func makeCluster(t *testing.T, n int, maxRaftState int) *cluster.Cluster {
+	t.Helper()
+	c := cluster.New(n, cluster.Options{MaxRaftState: maxRaftState})
+	t.Cleanup(c.Shutdown)
+	return c
+}
+
+// 等待选出领导者
+func waitLeader(t *testing.T, c *cluster.Cluster) int {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; {
+		if leader, ok := c.Leader(); ok {
+			return leader
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("no leader after 5s")
+	return -1
+}
+
+// 写入直到成功，故障期间 KVClient 的重试可能用尽
+func tryPut(ck *kv.KVClient, key, value string) error {
+	var err error
+	for start := time.Now(); time.Since(start) < 10*time.Second; {
+		if err = ck.PutWithTTL(key, kv.KVEntry{Name: value}, 0); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("put %s = %s did not succeed in 10s: %v", key, value, err)
+}
+
+func put(t *testing.T, ck *kv.KVClient, key, value string) {
+	t.Helper()
+	if err := tryPut(ck, key, value); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 读取直到得到结果，键不存在时返回空字符串
+func get(t *testing.T, ck *kv.KVClient, key string) string {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 10*time.Second; {
+		value, err := ck.GetEntry(key)
+		if err == nil {
+			return value.Name
+		}
+		if err.Error() == kv.ErrNoKey {
+			return ""
+		}
+	}
+	t.Fatalf("get %s did not succeed in 10s", key)
+	return ""
+}
+
+func check(t *testing.T, ck *kv.KVClient, key, want string) {
+	t.Helper()
+	if got := get(t, ck, key); got != want {
+		t.Fatalf("get %s = %q, want %q", key, got, want)
+	}
+}
+
+// 等待所有运行中的节点应用到同样的位置，并且键的数量相同
+func waitConverged(t *testing.T, c *cluster.Cluster, ck *kv.KVClient) {
+	t.Helper()
+	var statuses []kv.NodeStatus
+	for start := time.Now(); time.Since(start) < 10*time.Second; {
+		statuses = ck.ClusterStatus(time.Second)
+		converged := true
+		for _, s := range statuses {
+			if !s.Reachable {
+				continue
+			}
+			if s.LastApplied != statuses[0].LastApplied || s.Keys != statuses[0].Keys {
+				converged = false
+			}
+		}
+		if converged && statuses[0].Reachable {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("nodes did not converge: %+v", statuses)
+}
+
+func TestBasic(t *testing.T) {
+	c := makeCluster(t, 3, -1)
+	ck := kv.MakeKVClient(c.ClientEnds())
+
+	check(t, ck, "a", "")
+	put(t, ck, "a", "1")
+	check(t, ck, "a", "1")
+	put(t, ck, "a", "2")
+	check(t, ck, "a", "2")
+
+	if err := ck.Delete("a"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	check(t, ck, "a", "")
+	if err := ck.Delete("a"); err == nil || err.Error() != kv.ErrNoKey {
+		t.Fatalf("delete of a missing key returned %v, want %s", err, kv.ErrNoKey)
+	}
+
+	entries := []kv.KeyValue{
+		{Key: "b", Value: kv.KVEntry{Name: "x"}},
+		{Key: "c", Value: kv.KVEntry{Name: "y"}},
+	}
+	if err := ck.MultiPut(entries); err != nil {
+		t.Fatalf("multi put: %v", err)
+	}
+	got, err := ck.MultiGet([]string{"b", "c", "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["b"].Name != "x" || got["c"].Name != "y" {
+		t.Fatalf("multi get = %+v", got)
+	}
+	if _, ok := got["d"]; ok {
+		t.Fatalf("multi get returned missing key d")
+	}
+
+	waitConverged(t, c, ck)
+}
+
+// 多个客户端共用一个 KVClient，领导者变更时同时切换节点
+func TestSharedClient(t *testing.T) {
+	c := makeCluster(t, 3, -1)
+	ck := kv.MakeKVClient(c.ClientEnds())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			view := ck.WithActor(fmt.Sprintf("user%d", i))
+			for j := 0; j < 10; j++ {
+				if err := tryPut(view, fmt.Sprintf("k%d", i), fmt.Sprintf("%d", j)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	time.Sleep(500 * time.Millisecond)
+	leader := waitLeader(t, c)
+	if err := c.Crash(leader); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	for i := 0; i < 5; i++ {
+		check(t, ck, fmt.Sprintf("k%d", i), "9")
+	}
+}
+
+// 领导者被划到少数派时，多数派选出新的领导者继续服务；恢复后旧领导者跟上
+func TestPartition(t *testing.T) {
+	c := makeCluster(t, 5, -1)
+	ck := kv.MakeKVClient(c.ClientEnds())
+	put(t, ck, "a", "1")
+
+	leader := waitLeader(t, c)
+	minority := []int{leader, (leader + 1) % 5}
+	majority := []int{(leader + 2) % 5, (leader + 3) % 5, (leader + 4) % 5}
+	if err := c.Partition([][]int{minority, majority}); err != nil {
+		t.Fatal(err)
+	}
+
+	put(t, ck, "a", "2")
+	check(t, ck, "a", "2")
+	if newLeader := waitLeader(t, c); newLeader == leader {
+		t.Fatalf("the leader of the minority is still the leader")
+	}
+
+	c.Heal()
+	put(t, ck, "a", "3")
+	check(t, ck, "a", "3")
+	waitConverged(t, c, ck)
+}
+
+// 少数派不能提交写入：只连接少数派的客户端在分区期间一直失败
+func TestMinorityCannotCommit(t *testing.T) {
+	c := makeCluster(t, 3, -1)
+	ends := c.ClientEnds()
+	ck := kv.MakeKVClient(ends)
+	put(t, ck, "a", "1")
+
+	leader := waitLeader(t, c)
+	others := []int{(leader + 1) % 3, (leader + 2) % 3}
+	if err := c.Partition([][]int{{leader}, others}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 只能访问旧领导者的客户端
+	alone := kv.MakeKVClient(ends[leader : leader+1])
+	if err := alone.PutWithTTL("a", kv.KVEntry{Name: "lost"}, 0); err == nil {
+		t.Fatalf("a put through the isolated leader succeeded")
+	}
+	if _, err := alone.GetEntry("a"); err == nil {
+		t.Fatalf("a get through the isolated leader succeeded")
+	}
+
+	// 多数派在新任期提交一条日志，恢复后旧领导者的日志不再是最新的，不能重新当选并提交它未提交的写入
+	majority := kv.MakeKVClient([]transport.ClientEnd{ends[others[0]], ends[others[1]]})
+	put(t, majority, "b", "1")
+
+	c.Heal()
+	check(t, ck, "a", "1")
+}
+
+// 系统键和审计日志也通过日志读取：在多数派上删除后，被隔离的旧领导者不能再返回旧值，
+// 否则网关会继续接受已经吊销的令牌
+func TestSysGetIsolatedLeader(t *testing.T) {
+	c := makeCluster(t, 3, -1)
+	ends := c.ClientEnds()
+	ck := kv.MakeKVClient(ends)
+	if err := ck.SysPut("auth/token/x", "reader"); err != nil {
+		t.Fatal(err)
+	}
+
+	leader := waitLeader(t, c)
+	others := []int{(leader + 1) % 3, (leader + 2) % 3}
+	if err := c.Partition([][]int{{leader}, others}); err != nil {
+		t.Fatal(err)
+	}
+	majority := kv.MakeKVClient([]transport.ClientEnd{ends[others[0]], ends[others[1]]})
+	for start := time.Now(); majority.SysDelete("auth/token/x") != nil; {
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("delete on the majority did not succeed in 10s")
+		}
+	}
+
+	alone := kv.MakeKVClient(ends[leader : leader+1])
+	if value, err := alone.SysGet("auth/token/x"); err == nil || err.Error() == kv.ErrNoKey {
+		t.Fatalf("SysGet through the isolated leader = %q, %v; want a timeout", value, err)
+	}
+	// 网关吊销令牌时通过 SysList 找到要删除的令牌，列表同样不能来自旧领导者
+	if values, err := alone.SysList("auth/token/"); err == nil {
+		t.Fatalf("SysList through the isolated leader = %v; want a timeout", values)
+	}
+	if records, err := alone.Audit(kv.AuditArgs{}); err == nil {
+		t.Fatalf("Audit through the isolated leader = %v; want a timeout", records)
+	}
+
+	c.Heal()
+	if _, err := ck.SysGet("auth/token/x"); err == nil || err.Error() != kv.ErrNoKey {
+		t.Fatalf("SysGet after heal = %v, want %s", err, kv.ErrNoKey)
+	}
+	if values, err := ck.SysList("auth/token/"); err != nil || len(values) != 0 {
+		t.Fatalf("SysList after heal = %v, %v; want no tokens", values, err)
+	}
+}
+
+// 范围读取同样经过日志，被分区的旧领导者不会返回多数派已经覆盖的旧值
+func TestRangeReadsIsolatedLeader(t *testing.T) {
+	c := makeCluster(t, 3, -1)
+	ends := c.ClientEnds()
+	ck := kv.MakeKVClient(ends)
+	put(t, ck, "a", "old")
+
+	leader := waitLeader(t, c)
+	others := []int{(leader + 1) % 3, (leader + 2) % 3}
+	if err := c.Partition([][]int{{leader}, others}); err != nil {
+		t.Fatal(err)
+	}
+	majority := kv.MakeKVClient([]transport.ClientEnd{ends[others[0]], ends[others[1]]})
+	put(t, majority, "a", "new")
+
+	alone := kv.MakeKVClient(ends[leader : leader+1])
+	if got, err := alone.MultiGet([]string{"a"}); err == nil {
+		t.Fatalf("MultiGet through the isolated leader = %v, want an error", got)
+	}
+	if entries, _, err := alone.Scan("", "", 0, ""); err == nil {
+		t.Fatalf("Scan through the isolated leader = %v, want an error", entries)
+	}
+	if entries, _, err := alone.Query(kv.QueryArgs{}); err == nil {
+		t.Fatalf("Query through the isolated leader = %v, want an error", entries)
+	}
+
+	c.Heal()
+	if got, err := ck.MultiGet([]string{"a"}); err != nil || got["a"].Name != "new" {
+		t.Fatalf("MultiGet after heal = %v, %v; want new", got, err)
+	}
+}
+
+// 所有节点崩溃后从持久化的 Raft 状态恢复
+func TestCrashRestart(t *testing.T) {
+	c := makeCluster(t, 3, -1)
+	ck := kv.MakeKVClient(c.ClientEnds())
+	for i := 0; i < 10; i++ {
+		put(t, ck, fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i))
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := c.Crash(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := c.Restart(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		check(t, ck, fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i))
+	}
+	waitConverged(t, c, ck)
+}
+
+// Raft 状态超过 MaxRaftState 时做快照；落后的节点通过安装快照跟上，重启后从快照恢复
+func TestSnapshot(t *testing.T) {
+	const maxRaftState = 4096
+	c := makeCluster(t, 3, maxRaftState)
+	ck := kv.MakeKVClient(c.ClientEnds())
+	put(t, ck, "a", "0")
+
+	lagging := (waitLeader(t, c) + 1) % 3
+	if err := c.Crash(lagging); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		put(t, ck, fmt.Sprintf("k%d", i%20), fmt.Sprintf("v%d", i))
+	}
+	if err := c.Restart(lagging); err != nil {
+		t.Fatal(err)
+	}
+	put(t, ck, "a", "1")
+	waitConverged(t, c, ck)
+
+	for _, s := range ck.ClusterStatus(time.Second) {
+		if s.Raft.SnapshotIndex == 0 {
+			t.Fatalf("node %d has not taken or installed a snapshot", s.Server)
+		}
+		if s.Raft.LogLength > 100 {
+			t.Fatalf("node %d keeps %d log entries after snapshots", s.Server, s.Raft.LogLength)
+		}
+	}
+
+	// 重启全部节点，状态只能从快照和日志的尾部恢复
+	for i := 0; i < 3; i++ {
+		if err := c.Restart(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(t, ck, "a", "1")
+	for i := 180; i < 200; i++ {
+		check(t, ck, fmt.Sprintf("k%d", i%20), fmt.Sprintf("v%d", i))
+	}
+}
+
+// 每个键是一个寄存器
+type registerInput struct {
+	put   bool
+	key   string
+	value string
+}
+
+var registerModel = linearizability.Model{
+	Partition: func(history []linearizability.Operation) [][]linearizability.Operation {
+		byKey := make(map[string][]linearizability.Operation)
+		for _, op := range history {
+			key := op.Input.(registerInput).key
+			byKey[key] = append(byKey[key], op)
+		}
+		var partitions [][]linearizability.Operation
+		for _, ops := range byKey {
+			partitions = append(partitions, ops)
+		}
+		return partitions
+	},
+	Init: func() interface{} { return "" },
+	Step: func(state, input, output interface{}) (bool, interface{}) {
+		in := input.(registerInput)
+		if in.put {
+			return true, in.value
+		}
+		return output.(string) == state.(string), state
+	},
+	Describe: func(input, output interface{}) string {
+		in := input.(registerInput)
+		if in.put {
+			return fmt.Sprintf("put %s = %s", in.key, in.value)
+		}
+		return fmt.Sprintf("get %s -> %q", in.key, output)
+	},
+}
+
+// 不可靠的网络上并发读写，同时反复划分网络、崩溃和重启节点，检查历史可线性化。
+// 丢失的回复会让客户端重发请求，重复的写入只能被应用一次
+func TestLinearizableUnderFaults(t *testing.T) {
+	const (
+		servers  = 5
+		clients  = 4
+		keys     = 2
+		duration = 8 * time.Second
+	)
+	c := makeCluster(t, servers, 8192)
+	net := c.Network()
+	net.Reliable = false
+	c.SetNetwork(net)
+
+	start := time.Now()
+	now := func() int64 { return int64(time.Since(start)) }
+
+	var mu sync.Mutex
+	var history []linearizability.Operation
+	var wg sync.WaitGroup
+	for id := 0; id < clients; id++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			ck := kv.MakeKVClient(c.ClientEnds())
+			rng := rand.New(rand.NewSource(int64(id)))
+			for n := 0; time.Since(start) < duration; n++ {
+				key := fmt.Sprintf("k%d", rng.Intn(keys))
+				op := linearizability.Operation{ClientID: id, Call: now()}
+				if rng.Intn(2) == 0 {
+					value := fmt.Sprintf("%d-%d", id, n)
+					op.Input = registerInput{put: true, key: key, value: value}
+					err := ck.PutWithTTL(key, kv.KVEntry{Name: value}, 0)
+					op.Return = now()
+					if err != nil {
+						op.Return = linearizability.Pending
+					}
+				} else {
+					op.Input = registerInput{key: key}
+					value, err := ck.GetEntry(key)
+					op.Return = now()
+					if err != nil && err.Error() != kv.ErrNoKey {
+						continue
+					}
+					op.Output = value.Name
+				}
+				mu.Lock()
+				history = append(history, op)
+				mu.Unlock()
+			}
+		}(id)
+	}
+
+	rng := rand.New(rand.NewSource(0))
+	for time.Since(start) < duration {
+		time.Sleep(time.Second)
+		switch rng.Intn(3) {
+		case 0:
+			perm := rng.Perm(servers)
+			c.Partition([][]int{perm[:2], perm[2:]})
+		case 1:
+			c.Heal()
+			c.Restart(rng.Intn(servers))
+		case 2:
+			c.Heal()
+		}
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(history) < 20 {
+		t.Fatalf("only %d operations completed", len(history))
+	}
+	report := linearizability.Check(registerModel, history, time.Minute)
+	switch report.Result {
+	case linearizability.Illegal:
+		v := report.Violation
+		t.Errorf("history of %d operations is not linearizable; stuck on:", len(history))
+		for _, op := range v.Stuck {
+			t.Errorf("  client %d: %s", op.ClientID, registerModel.Describe(op.Input, op.Output))
+		}
+	case linearizability.Unknown:
+		t.Logf("linearizability check timed out on %d operations", len(history))
+	}
+}
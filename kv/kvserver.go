@@ -1,43 +1,93 @@
 package kv
 
 import (
-	"course/labrpc"
+	"course/logging"
 	"course/raft"
+	"course/sim"
+	"course/tracing"
+	"course/transport"
 	"encoding/gob"
-	"log"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
 type KVServer struct {
-	mu        sync.Mutex
-	me        int
-	rf        *raft.Raft
-	applyCh   chan raft.ApplyMsg
-	data      map[string]KVEntry
-	notifyCh  map[int]chan Op
-	clientSeq map[int64]int
-	dead      int32
+	mu          sync.Mutex
+	me          int
+	rf          *raft.Raft
+	applyCh     chan raft.ApplyMsg
+	data        *skipList       // 按键有序存储
+	index       *secondaryIndex // class、major、grand 上的二级索引
+	notifyCh    map[int]chan opResult
+	clientSeq   map[int64]int // 每个客户端会话已执行的最大写操作序号，见 dedup.go
+	dead        int32
+	lastApplied int
 
-	peers []*labrpc.ClientEnd
+	clock     int64            // 最近应用的日志中领导者给出的时间戳（UnixNano）
+	expireAt  map[string]int64 // 设置了 TTL 的键及其过期时间
+	clockSkew int64            // 本节点系统时间的偏差，用于模拟时钟不准，见 SetClockSkew
+
+	applyingIndex int           // 正在应用的日志位置，用于给变更事件编号
+	events        []WatchEvent  // 最近的变更事件，按日志位置排序
+	historyStart  int           // events 覆盖从该位置开始的全部变更
+	watchCh       chan struct{} // 有新事件时关闭，用于唤醒等待中的 Watch
+
+	versions         map[string][]Version  // 每个键的历史版本，按日志位置排序
+	versionHorizon   int                   // 早于该位置的历史版本已被丢弃
+	versionRetention int                   // 保留最近多少条日志范围内的版本
+	pins             map[string]versionPin // 分页读取固定的位置，见 versions.go
+
+	system map[string]string // 系统键空间，见 system.go
+	audit  []AuditRecord     // 最近的写操作，按日志位置排序
+
+	maxraftstate int // Raft 状态超过该字节数时做快照，-1 表示不做快照
+	persister    *raft.Persister
+
+	peers []transport.ClientEnd
+
+	logger *slog.Logger
 }
 
-func StartKVServer(peers []*labrpc.ClientEnd, me int, persister *raft.Persister) *KVServer {
+func StartKVServer(peers []transport.ClientEnd, me int, persister *raft.Persister, maxraftstate int) *KVServer {
 	gob.Register(Op{})
 	gob.Register(KVEntry{})
 
 	kv := &KVServer{
 		me:        me,
-		applyCh:   make(chan raft.ApplyMsg),
-		data:      make(map[string]KVEntry),
-		notifyCh:  make(map[int]chan Op),
+		applyCh:   make(chan raft.ApplyMsg, 1), // 带缓冲，Virtual 时钟下收发双方都是轮询
+		data:      newSkipList(),
+		index:     newSecondaryIndex(),
+		notifyCh:  make(map[int]chan opResult),
 		clientSeq: make(map[int64]int),
-		peers:     peers,
+		expireAt:  make(map[string]int64),
+		watchCh:   make(chan struct{}),
+		versions:  make(map[string][]Version),
+		pins:      make(map[string]versionPin),
+		system:    make(map[string]string),
+
+		versionRetention: DefaultVersionRetention,
+		maxraftstate:     maxraftstate,
+		persister:        persister,
+		peers:            peers,
+		logger:           logging.Logger(logging.TopicKV).With("node", me),
 	}
+
+	// 优先从 Raft 快照恢复，没有快照时再读取 data_kv.json
+	if snapshot := persister.ReadSnapshot(); len(snapshot) > 0 {
+		kv.readSnapshotLocked(snapshot)
+	} else {
+		kv.loadData()
+	}
+	kv.historyStart = kv.lastApplied + 1
+
 	kv.rf = raft.Make(peers, me, persister, kv.applyCh)
-	kv.loadData()
-	go kv.applyLoop()
+	sim.Go(kv.applyLoop)
+	sim.Go(kv.expireTicker)
 	return kv
 }
 
@@ -48,7 +98,7 @@ func (kv *KVServer) GetRaft() *raft.Raft {
 func (kv *KVServer) Kill() {
 	atomic.StoreInt32(&kv.dead, 1)
 	kv.rf.Kill()
-	log.Printf("Server %d: Killed", kv.me)
+	kv.logger.Info("Killed")
 }
 
 func (kv *KVServer) killed() bool {
@@ -56,40 +106,254 @@ func (kv *KVServer) killed() bool {
 }
 
 func (kv *KVServer) applyLoop() {
-	for msg := range kv.applyCh {
+	for {
+		msg, ok := sim.Recv(kv.applyCh)
+		if !ok {
+			return
+		}
 		if msg.CommandValid {
-			command := msg.Command.(Op)
 			kv.mu.Lock()
+			if msg.CommandIndex <= kv.lastApplied {
+				// 已经包含在安装过的快照中
+				kv.mu.Unlock()
+				continue
+			}
+			kv.lastApplied = msg.CommandIndex
+
+			command, ok := msg.Command.(Op)
+			if ok {
+				appliedAt := time.Now()
+				kv.applyingIndex = msg.CommandIndex
+				err := kv.applyOpLocked(command)
+				kv.traceApplyLocked(command, msg.CommandIndex, appliedAt, err)
 
-			if command.Type == OpPut {
-				kv.data[command.Key] = command.Value // 修正为 KVEntry 类型
-				kv.clientSeq[command.ClientID] = command.SeqNum
-				kv.SaveData()
+				if ch, ok := kv.notifyCh[msg.CommandIndex]; ok {
+					ch <- opResult{ClientID: command.ClientID, SeqNum: command.SeqNum, Err: err, AppliedAt: appliedAt}
+					delete(kv.notifyCh, msg.CommandIndex)
+				}
 			}
 
-			if ch, ok := kv.notifyCh[msg.CommandIndex]; ok {
-				ch <- command
-				delete(kv.notifyCh, msg.CommandIndex)
+			keysGauge.With(strconv.Itoa(kv.me)).Set(float64(kv.data.len()))
+
+			if kv.maxraftstate != -1 && kv.persister.RaftStateSize() >= kv.maxraftstate {
+				kv.compactVersionsLocked()
+				kv.rf.Snapshot(msg.CommandIndex, kv.encodeSnapshotLocked())
 			}
 
+			kv.mu.Unlock()
+		} else if msg.SnapshotValid {
+			kv.mu.Lock()
+			if msg.SnapshotIndex > kv.lastApplied {
+				kv.readSnapshotLocked(msg.Snapshot)
+				kv.lastApplied = msg.SnapshotIndex
+				kv.resetHistoryLocked(msg.SnapshotIndex + 1)
+			}
 			kv.mu.Unlock()
 		}
 	}
 }
 
+// 将已提交的操作应用到状态机，返回该操作的执行结果，调用方需持有 kv.mu
+func (kv *KVServer) applyOpLocked(command Op) string {
+	kv.advanceClockLocked(command.Time)
+	if isReadOp(command.Type) {
+		return ""
+	}
+
+	if kv.isDuplicateLocked(command.ClientID, command.SeqNum) {
+		dedupHits.With(strconv.Itoa(kv.me)).Inc()
+		return ""
+	}
+
+	switch command.Type {
+	case OpPut:
+		kv.setLocked(command.Key, command.Value)
+		kv.setTTLLocked(command.Key, command.TTL)
+		kv.recordAuditLocked(command.Actor, command.Type, command.Key)
+	case OpMultiPut:
+		for _, entry := range command.Entries {
+			kv.setLocked(entry.Key, entry.Value)
+			kv.setTTLLocked(entry.Key, 0)
+			kv.recordAuditLocked(command.Actor, command.Type, entry.Key)
+		}
+	case OpPatch:
+		// 合并发生在应用日志时，基于当时的最新值，并发修改不同字段不会互相覆盖
+		value, exists := kv.data.get(command.Key)
+		if !exists {
+			return ErrNoKey
+		}
+		for _, field := range command.Fields {
+			CopyEntryField(&value, command.Value, field)
+		}
+		kv.setLocked(command.Key, value)
+		kv.recordAuditLocked(command.Actor, command.Type, command.Key)
+	case OpDelete:
+		if _, exists := kv.data.get(command.Key); !exists {
+			return ErrNoKey
+		}
+		kv.deleteLocked(command.Key)
+		kv.recordAuditLocked(command.Actor, command.Type, command.Key)
+	case OpPinVersions:
+		kv.pinVersionsLocked(command.Key)
+	case OpUnpinVersions:
+		delete(kv.pins, command.Key)
+	case OpSysPut:
+		kv.system[command.Key] = command.Raw
+		kv.recordAuditLocked(command.Actor, command.Type, command.Key)
+	case OpSysDelete:
+		delete(kv.system, command.Key)
+		kv.recordAuditLocked(command.Actor, command.Type, command.Key)
+	default:
+		return ""
+	}
+	kv.recordSeqLocked(command.ClientID, command.SeqNum)
+	kv.SaveData()
+	return ""
+}
+
+// 写入一个键，并同步维护二级索引
+func (kv *KVServer) setLocked(key string, value KVEntry) {
+	old, exists := kv.data.get(key)
+	if exists {
+		kv.index.remove(key, old)
+	}
+	kv.recordVersionLocked(key, old, exists, value, false)
+	kv.data.put(key, value)
+	kv.index.add(key, value)
+	kv.recordEventLocked(EventPut, key, value)
+}
+
+// 删除一个键及其索引和过期时间
+func (kv *KVServer) deleteLocked(key string) {
+	if old, exists := kv.data.get(key); exists {
+		kv.index.remove(key, old)
+		kv.recordVersionLocked(key, old, exists, KVEntry{}, true)
+		kv.data.delete(key)
+		kv.recordEventLocked(EventDelete, key, KVEntry{})
+	}
+	delete(kv.expireAt, key)
+}
+
+// 将操作提交给 Raft，并等待它在本节点被应用
+func (kv *KVServer) startOp(op Op) (err string) {
+	start := time.Now()
+	defer kv.observeOp(op.Type, start)
+
+	span := tracing.Start(op.Trace, "KVServer."+op.Type, tracing.KindServer,
+		"node", kv.me, "request.id", op.RequestID, "client.id", op.ClientID, "seq", op.SeqNum)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	if span != nil {
+		// 各副本应用该操作时的 span 以这里的 span 为父节点
+		op.Trace = span.Context()
+	}
+
+	// 由领导者给出时间戳，所有副本用它来判断键是否过期
+	op.Time = kv.now().UnixNano()
+
+	// 在 Start 之前登记通知通道，避免日志在登记前就被应用而错过通知
+	kv.mu.Lock()
+	startSpan := tracing.Start(span.Context(), "raft.Start", tracing.KindInternal)
+	index, term, isLeader := kv.rf.Start(op)
+	startSpan.SetAttrs("log.index", index, "term", term, "leader", isLeader)
+	startSpan.End()
+	if !isLeader {
+		kv.mu.Unlock()
+		return ErrWrongLeader
+	}
+	ch := make(chan opResult, 1)
+	kv.notifyCh[index] = ch
+	kv.mu.Unlock()
+
+	// 从 Start 返回到本节点开始应用该日志，包括复制到多数派和提交
+	replicate := tracing.Start(span.Context(), "raft.replicate", tracing.KindInternal, "log.index", index, "term", term)
+	if applied, _, ok := sim.RecvUntil(ch, sim.After(1*time.Second)); ok {
+		replicate.EndAt(applied.AppliedAt)
+		// 该位置上应用的是别的操作，说明领导者已经变更
+		if applied.ClientID != op.ClientID || applied.SeqNum != op.SeqNum {
+			err = ErrWrongLeader
+		} else {
+			err = applied.Err
+		}
+	} else {
+		err = ErrTimeout
+		replicate.SetError(err)
+		replicate.End()
+		opTimeouts.With(strconv.Itoa(kv.me), op.Type).Inc()
+	}
+
+	kv.mu.Lock()
+	delete(kv.notifyCh, index)
+	kv.mu.Unlock()
+	return err
+}
+
+// 读屏障：提交一条不改变状态的日志，本节点应用到它之后，本地状态已经包含了在它之前完成的所有写入
+// 被分区的旧领导者和落后的跟随者无法提交，返回 ErrWrongLeader 或 ErrTimeout，不会返回过期的数据
+func (kv *KVServer) readBarrier(clientID int64, seqNum int) string {
+	if kv.killed() {
+		return ErrWrongLeader
+	}
+	return kv.startOp(Op{Type: OpRead, ClientID: clientID, SeqNum: seqNum})
+}
+
 func (kv *KVServer) Get(args *GetArgs, reply *GetReply) {
+	if args.AsOfIndex > 0 {
+		kv.getAt(args, reply)
+		return
+	}
+	if kv.killed() {
+		reply.Err = ErrWrongLeader
+		return
+	}
+
+	// 读操作也作为一条日志提交，本节点应用到这条日志时已经包含了在它之前完成的所有写入，
+	// 这样被分区的旧领导者和落后的跟随者不会返回过期的值
+	op := Op{
+		Type:     OpGet,
+		Key:      args.Key,
+		ClientID: args.ClientID,
+		SeqNum:   args.SeqNum,
+
+		RequestID: args.RequestID,
+		Trace:     args.Trace,
+	}
+	if reply.Err = kv.startOp(op); reply.Err != "" {
+		return
+	}
+
 	kv.mu.Lock()
 	defer kv.mu.Unlock()
-
-	value, exists := kv.data[args.Key]
+	value, exists := kv.data.get(args.Key)
 	if exists {
 		reply.Value = value
-		reply.Err = ""
 	} else {
 		reply.Err = ErrNoKey
 	}
 }
 
+// 读取键在历史日志位置上的值，历史在各副本上相同，直接读取本地状态
+func (kv *KVServer) getAt(args *GetArgs, reply *GetReply) {
+	defer kv.observeOp(OpGet, time.Now())
+	span := tracing.Start(args.Trace, "KVServer.Get", tracing.KindServer, "node", kv.me, "request.id", args.RequestID)
+	defer func() {
+		if reply.Err != ErrNoKey {
+			span.SetError(reply.Err)
+		}
+		span.End()
+	}()
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	// 本节点还没有应用到 AsOfIndex，让客户端换一个节点
+	if args.AsOfIndex > kv.lastApplied {
+		reply.Err = ErrWrongLeader
+		return
+	}
+	reply.Value, reply.Err = kv.getAsOfLocked(args.Key, args.AsOfIndex)
+}
+
 func (kv *KVServer) Put(args *PutArgs, reply *PutReply) {
 	if kv.killed() {
 		reply.Err = ErrWrongLeader
@@ -100,47 +364,302 @@ func (kv *KVServer) Put(args *PutArgs, reply *PutReply) {
 		Type:     OpPut,
 		Key:      args.Key,
 		Value:    args.Value,
+		TTL:      args.TTL,
+		Actor:    args.Actor,
 		ClientID: args.ClientID,
 		SeqNum:   args.SeqNum,
+
+		RequestID: args.RequestID,
+		Trace:     args.Trace,
 	}
-	index, _, isLeader := kv.rf.Start(op)
-	if !isLeader {
+	reply.Err = kv.startOp(op)
+}
+
+// 批量读取，一次 RPC 返回多个键的值
+func (kv *KVServer) MultiGet(args *MultiGetArgs, reply *MultiGetReply) {
+	defer kv.observeOp("MultiGet", time.Now())
+	if reply.Err = kv.readBarrier(args.ClientID, args.SeqNum); reply.Err != "" {
+		return
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	reply.Values = kv.multiGetLocked(args.Keys)
+}
+
+// 返回存在且没有过期的键的值，调用方需持有 kv.mu
+func (kv *KVServer) multiGetLocked(keys []string) map[string]KVEntry {
+	now := kv.readClockLocked()
+	values := make(map[string]KVEntry, len(keys))
+	for _, key := range keys {
+		if value, exists := kv.data.get(key); exists && !kv.expiredLocked(key, now) {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// 批量写入，所有键值作为一条日志提交
+func (kv *KVServer) MultiPut(args *MultiPutArgs, reply *MultiPutReply) {
+	if kv.killed() {
 		reply.Err = ErrWrongLeader
 		return
 	}
 
+	op := Op{
+		Type:     OpMultiPut,
+		Entries:  args.Entries,
+		Actor:    args.Actor,
+		ClientID: args.ClientID,
+		SeqNum:   args.SeqNum,
+
+		RequestID: args.RequestID,
+		Trace:     args.Trace,
+	}
+	reply.Err = kv.startOp(op)
+}
+
+// 修改记录中的部分字段，只有 Fields 中列出的字段会被覆盖
+func (kv *KVServer) Patch(args *PatchArgs, reply *PatchReply) {
+	if kv.killed() {
+		reply.Err = ErrWrongLeader
+		return
+	}
+
+	for _, field := range args.Fields {
+		if _, ok := FieldType(field); !ok {
+			reply.Err = ErrInvalidField
+			return
+		}
+	}
+
+	op := Op{
+		Type:     OpPatch,
+		Key:      args.Key,
+		Value:    args.Value,
+		Fields:   args.Fields,
+		Actor:    args.Actor,
+		ClientID: args.ClientID,
+		SeqNum:   args.SeqNum,
+
+		RequestID: args.RequestID,
+		Trace:     args.Trace,
+	}
+	reply.Err = kv.startOp(op)
+}
+
+// 删除一个键，键不存在时返回 ErrNoKey
+func (kv *KVServer) Delete(args *DeleteArgs, reply *DeleteReply) {
+	if kv.killed() {
+		reply.Err = ErrWrongLeader
+		return
+	}
+
+	op := Op{
+		Type:     OpDelete,
+		Key:      args.Key,
+		Actor:    args.Actor,
+		ClientID: args.ClientID,
+		SeqNum:   args.SeqNum,
+
+		RequestID: args.RequestID,
+		Trace:     args.Trace,
+	}
+	reply.Err = kv.startOp(op)
+}
+
+func (kv *KVServer) GetAllKeys(args *GetAllKeysArgs, reply *GetAllKeysReply) {
+	if reply.Err = kv.readBarrier(args.ClientID, args.SeqNum); reply.Err != "" {
+		return
+	}
+
 	kv.mu.Lock()
-	ch := make(chan Op, 1)
-	kv.notifyCh[index] = ch
-	kv.mu.Unlock()
+	defer kv.mu.Unlock()
+	reply.Keys = kv.allKeysLocked()
+}
 
-	select {
-	case <-ch:
-		reply.Err = ""
-	case <-time.After(1 * time.Second):
-		reply.Err = ErrTimeout
+// 按键的字典序返回没有过期的键，调用方需持有 kv.mu
+func (kv *KVServer) allKeysLocked() []string {
+	now := kv.readClockLocked()
+	keys := []string{}
+	for _, key := range kv.data.keys() {
+		if !kv.expiredLocked(key, now) {
+			keys = append(keys, key)
+		}
 	}
+	return keys
+}
 
+// 按键的顺序扫描 [Start, End) 范围内的键值
+// AsOf 为 0 时先经过读屏障；AsOf 大于 0 时读取的历史在各副本上相同，直接读取本地状态
+func (kv *KVServer) Scan(args *ScanArgs, reply *ScanReply) {
+	defer kv.observeOp("Scan", time.Now())
+	if args.AsOf == 0 {
+		if reply.Err = kv.readBarrier(args.ClientID, args.SeqNum); reply.Err != "" {
+			return
+		}
+	}
 	kv.mu.Lock()
-	delete(kv.notifyCh, index)
-	kv.mu.Unlock()
+	defer kv.mu.Unlock()
+
+	start := args.Start
+	if args.Token > start {
+		start = args.Token
+	}
+	inRange := func(key string) bool {
+		return args.End == "" || key < args.End
+	}
+	if args.AsOf > 0 {
+		// 本节点还没有应用到 AsOf，让客户端换一个节点
+		if args.AsOf > kv.lastApplied {
+			reply.Err = ErrWrongLeader
+			return
+		}
+		if args.AsOf < kv.versionHorizon {
+			reply.Err = ErrCompacted
+			return
+		}
+		reply.Entries, reply.NextToken = kv.scanAsOfLocked(start, args.Limit, args.AsOf, inRange)
+		reply.Index = args.AsOf
+		reply.Err = ""
+		return
+	}
+	reply.Entries, reply.NextToken = kv.scanLocked(start, args.Limit, inRange)
+	reply.Index = kv.lastApplied
+	reply.Err = ""
 }
-func (kv *KVServer) GetAllKeys(args *GetAllKeysArgs, reply *GetAllKeysReply) {
+
+// 扫描以 Prefix 开头的所有键值
+func (kv *KVServer) PrefixScan(args *PrefixScanArgs, reply *ScanReply) {
+	defer kv.observeOp("PrefixScan", time.Now())
+	if reply.Err = kv.readBarrier(args.ClientID, args.SeqNum); reply.Err != "" {
+		return
+	}
 	kv.mu.Lock()
 	defer kv.mu.Unlock()
 
-	var keys []string
-	for key := range kv.data { // 确保 key 是 string 类型
-		keys = append(keys, key)
+	start := args.Prefix
+	if args.Token > start {
+		start = args.Token
+	}
+	reply.Entries, reply.NextToken = kv.scanLocked(start, args.Limit, func(key string) bool {
+		return strings.HasPrefix(key, args.Prefix)
+	})
+	reply.Err = ""
+}
+
+// 从 start 开始按序收集最多 limit 个键值，遇到 inRange 返回 false 的键时停止
+// 第二个返回值是下一页的起始键
+func (kv *KVServer) scanLocked(start string, limit int, inRange func(key string) bool) ([]KeyValue, string) {
+	if limit <= 0 {
+		limit = DefaultScanLimit
+	} else if limit > MaxScanLimit {
+		limit = MaxScanLimit
+	}
+
+	now := kv.readClockLocked()
+	entries := []KeyValue{}
+	next := ""
+	kv.data.ascend(start, func(key string, value KVEntry) bool {
+		if !inRange(key) {
+			return false
+		}
+		if kv.expiredLocked(key, now) {
+			return true
+		}
+		if len(entries) == limit {
+			next = key
+			return false
+		}
+		entries = append(entries, KeyValue{Key: key, Value: value})
+		return true
+	})
+	return entries, next
+}
+
+// 在服务端按条件查询记录，等值条件优先走二级索引
+func (kv *KVServer) Query(args *QueryArgs, reply *QueryReply) {
+	defer kv.observeOp("Query", time.Now())
+	conds, err := compileConditions(args.Conditions)
+	if err == nil {
+		err = checkSortFields(args.Sort)
+	}
+	if err == nil && (args.Offset < 0 || args.Limit < 0) {
+		err = fmt.Errorf("offset and limit must not be negative")
+	}
+	if err != nil {
+		reply.Err = ErrInvalidQuery
+		reply.Detail = err.Error()
+		return
+	}
+	if reply.Err = kv.readBarrier(args.ClientID, args.SeqNum); reply.Err != "" {
+		return
+	}
+
+	kv.mu.Lock()
+	entries := kv.queryLocked(conds)
+	kv.mu.Unlock()
+
+	sortEntries(entries, args.Sort)
+	reply.Total = len(entries)
+	if args.Offset >= len(entries) {
+		entries = entries[:0]
+	} else {
+		entries = entries[args.Offset:]
+	}
+	if args.Limit > 0 && args.Limit < len(entries) {
+		entries = entries[:args.Limit]
+	}
+
+	reply.Entries = entries
+	reply.Err = ""
+}
+
+// 在服务端计算分组统计
+// 筛选和聚合都在持有 kv.mu 时完成，结果对应同一时刻的数据，不会混入并发写入
+func (kv *KVServer) Stats(args *StatsArgs, reply *StatsReply) {
+	defer kv.observeOp("Stats", time.Now())
+	conds, err := compileConditions(args.Conditions)
+	if err == nil {
+		err = checkMetrics(args.GroupBy, args.Metrics)
+	}
+	if err != nil {
+		reply.Err = ErrInvalidQuery
+		reply.Detail = err.Error()
+		return
+	}
+	if reply.Err = kv.readBarrier(args.ClientID, args.SeqNum); reply.Err != "" {
+		return
 	}
 
-	reply.Keys = keys
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	reply.Groups = aggregate(kv.queryLocked(conds), args.GroupBy, args.Metrics)
 	reply.Err = ""
 }
-func (kv *KVServer) GetPeers() []*labrpc.ClientEnd {
+
+func (kv *KVServer) GetPeers() []transport.ClientEnd {
 	return kv.peers
 }
 
 func (kv *KVServer) GetMe() int {
 	return kv.me
 }
+
+// 记录本节点应用一条带有请求信息的日志，调用方需持有 kv.mu
+// 每个副本都会记录，父节点为领导者上 startOp 的 span，可以看出各副本应用该操作的时间
+func (kv *KVServer) traceApplyLocked(op Op, index int, start time.Time, err string) {
+	if op.RequestID != "" {
+		kv.logger.Debug("Applied op", "index", index, "op", op.Type, "request_id", op.RequestID, "err", err)
+	}
+	if !op.Trace.IsValid() {
+		return
+	}
+	span := tracing.StartAt(op.Trace, "kv.apply", tracing.KindInternal, start,
+		"node", kv.me, "log.index", index, "op", op.Type, "request.id", op.RequestID)
+	if err != "" {
+		span.SetAttrs("result", err)
+	}
+	span.End()
+}
@@ -0,0 +1,95 @@
+package kv
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// MultiPut 的所有键值在同一条日志中写入，变更事件的位置相同；重试不会再次写入
+func TestMultiPutAppliesAtOneIndex(t *testing.T) {
+	kv := newTestServer()
+	entries := []KeyValue{
+		{Key: "b", Value: KVEntry{Name: "2"}},
+		{Key: "a", Value: KVEntry{Name: "1"}},
+	}
+	applyOp(kv, Op{Type: OpMultiPut, Entries: entries, ClientID: 1, SeqNum: 1})
+
+	kv.mu.Lock()
+	got := kv.multiGetLocked([]string{"a", "b", "c"})
+	kv.mu.Unlock()
+	if len(got) != 2 || got["a"].Name != "1" || got["b"].Name != "2" {
+		t.Fatalf("MultiGet = %v, want a = 1 and b = 2", got)
+	}
+	if len(kv.events) != 2 || kv.events[0].Index != kv.events[1].Index {
+		t.Fatalf("events = %+v, want two at the same index", kv.events)
+	}
+
+	applyPut(kv, 2, "a", "changed")
+	applyOp(kv, Op{Type: OpMultiPut, Entries: entries, ClientID: 1, SeqNum: 1})
+	if got := value(kv, "a"); got != "changed" {
+		t.Fatalf("a = %q after a retried MultiPut, want %q", got, "changed")
+	}
+}
+
+// 范围扫描按键分页，下一页从上一页返回的键开始，不重复也不遗漏
+func TestScanPaging(t *testing.T) {
+	kv := newTestServer()
+	for i := 0; i < 25; i++ {
+		applyPut(kv, i+1, fmt.Sprintf("s%02d", i), strconv.Itoa(i))
+	}
+	applyPut(kv, 26, "t00", "other")
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	inRange := func(key string) bool { return key < "t" }
+
+	var keys []string
+	start := "s"
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("too many pages")
+		}
+		entries, next := kv.scanLocked(start, 10, inRange)
+		for _, entry := range entries {
+			keys = append(keys, entry.Key)
+		}
+		if next == "" {
+			break
+		}
+		start = next
+	}
+	if len(keys) != 25 || keys[0] != "s00" || keys[24] != "s24" || !sort.StringsAreSorted(keys) {
+		t.Fatalf("scanned %v", keys)
+	}
+
+	// 超过上限的 limit 按 MaxScanLimit 处理，没有给出时使用 DefaultScanLimit
+	if entries, _ := kv.scanLocked("", 0, func(string) bool { return true }); len(entries) != 26 {
+		t.Fatalf("scan with the default limit returned %d entries, want 26", len(entries))
+	}
+	if entries, next := kv.scanLocked("s2", 100, func(key string) bool { return strings.HasPrefix(key, "s2") }); len(entries) != 5 || next != "" {
+		t.Fatalf("prefix scan of s2 = %v, %q", entries, next)
+	}
+}
+
+// 基于同一份旧值的两个 Patch 修改不同字段，应用后两处修改都保留；键不存在时返回 ErrNoKey
+func TestPatchMergesFields(t *testing.T) {
+	kv := newTestServer()
+	applyOp(kv, Op{Type: OpPut, Key: "a", Value: KVEntry{Name: "甲", Class: "21计一", Grand: 2021}, ClientID: 1, SeqNum: 1})
+	applyOp(kv, Op{Type: OpPatch, Key: "a", Fields: []string{"name"}, Value: KVEntry{Name: "乙"}, ClientID: 2, SeqNum: 1})
+	applyOp(kv, Op{Type: OpPatch, Key: "a", Fields: []string{"class", "grand"}, Value: KVEntry{Class: "21计二"}, ClientID: 3, SeqNum: 1})
+
+	kv.mu.Lock()
+	got, _ := kv.data.get("a")
+	if got != (KVEntry{Name: "乙", Class: "21计二"}) {
+		t.Errorf("a = %+v after two patches, want name 乙, class 21计二 and grand 0", got)
+	}
+	kv.applyingIndex++
+	err := kv.applyOpLocked(Op{Type: OpPatch, Key: "missing", Fields: []string{"name"}, Value: KVEntry{Name: "丙"}, ClientID: 2, SeqNum: 2})
+	if _, ok := kv.data.get("missing"); ok || err != ErrNoKey {
+		t.Errorf("patching a missing key returned %q and created it: %v", err, ok)
+	}
+	kv.mu.Unlock()
+}
@@ -0,0 +1,22 @@
+package kv
+
+import (
+	"course/metrics"
+	"strconv"
+	"time"
+)
+
+// KV 服务端的指标，与 Raft 的指标一样按 node 标签区分同一进程中的多个节点
+var (
+	opDuration = metrics.Default.NewHistogramVec("kv_op_duration_seconds",
+		"Time to serve a KV request on the node, by operation. Writes include replication through Raft.", nil, "node", "op")
+	opTimeouts = metrics.Default.NewCounterVec("kv_op_timeouts_total",
+		"Writes that were not applied within the timeout after being started on the leader.", "node", "op")
+	dedupHits = metrics.Default.NewCounterVec("kv_dedup_hits_total",
+		"Committed writes skipped because the same client request was already applied.", "node")
+	keysGauge = metrics.Default.NewGaugeVec("kv_keys", "Number of keys in the state machine.", "node")
+)
+
+func (kv *KVServer) observeOp(op string, start time.Time) {
+	opDuration.With(strconv.Itoa(kv.me), op).ObserveSince(start)
+}
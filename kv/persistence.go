@@ -1,58 +1,74 @@
 package kv
 
 import (
+	"course/sim"
 	"encoding/json"
 	"io/ioutil"
-	"log"
 	"sync"
 )
 
 var fileMutex sync.Mutex
 
+// 数据文件路径，没有快照时从这里加载初始数据，每次写入后导出全部数据
+// 同一目录下运行多个节点进程时，每个节点需要使用各自的文件；为空时不加载也不导出，例如测试中
+var DataFile = "data_kv.json"
+
 func (kv *KVServer) persistData() {
 	fileMutex.Lock()
 	defer fileMutex.Unlock()
+	if DataFile == "" {
+		return
+	}
 
 	kv.mu.Lock()
-	data, err := json.MarshalIndent(kv.data, "", "    ")
+	records := kv.data.toMap()
+	// 设置了 TTL 的键是临时数据，data_kv.json 中没有过期时间，不写入文件
+	for key := range kv.expireAt {
+		delete(records, key)
+	}
+	data, err := json.MarshalIndent(records, "", "    ")
 	kv.mu.Unlock()
 
 	if err != nil {
-		log.Printf("Server %d: Failed to marshal data: %v", kv.me, err)
+		kv.logger.Error("Failed to marshal data", "err", err)
 		return
 	}
 
-	err = ioutil.WriteFile("data_kv.json", data, 0644)
+	err = ioutil.WriteFile(DataFile, data, 0644)
 	if err != nil {
-		log.Printf("Server %d: Failed to write data to file: %v", kv.me, err)
+		kv.logger.Error("Failed to write data file", "file", DataFile, "err", err)
 	} else {
-		log.Printf("Server %d: Data persisted to data_kv.json", kv.me)
+		kv.logger.Debug("Data persisted", "file", DataFile)
 	}
 }
 
 func (kv *KVServer) SaveData() {
-	go kv.persistData() // 调用现有的 persistData 方法
+	sim.Go(kv.persistData) // 调用现有的 persistData 方法
 }
 
 func (kv *KVServer) loadData() {
 	fileMutex.Lock()
 	defer fileMutex.Unlock()
+	if DataFile == "" {
+		return
+	}
 
-	data, err := ioutil.ReadFile("data_kv.json")
+	data, err := ioutil.ReadFile(DataFile)
 	if err != nil {
-		log.Printf("Server %d: Failed to read data_kv.json: %v", kv.me, err)
+		kv.logger.Warn("Failed to read data file", "file", DataFile, "err", err)
 		return // 文件可能首次不存在，直接返回
 	}
 
 	var loadedData map[string]KVEntry
 	err = json.Unmarshal(data, &loadedData)
 	if err != nil {
-		log.Printf("Server %d: Failed to parse data_kv.json: %v", kv.me, err)
+		kv.logger.Error("Failed to parse data file", "file", DataFile, "err", err)
 		return
 	}
 
 	kv.mu.Lock()
-	kv.data = loadedData // 更新内存中的数据
+	kv.data = skipListFromMap(loadedData) // 更新内存中的数据
+	kv.index.rebuild(kv.data)
 	kv.mu.Unlock()
-	log.Printf("Server %d: Data loaded from data_kv.json", kv.me)
+	kv.logger.Info("Data loaded", "file", DataFile, "keys", len(loadedData))
 }
@@ -0,0 +1,284 @@
+package kv
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 查询条件的比较方式
+const (
+	CondEq       = "="
+	CondNe       = "!="
+	CondGt       = ">"
+	CondGe       = ">="
+	CondLt       = "<"
+	CondLe       = "<="
+	CondContains = "~=" // 子串匹配，仅用于字符串字段
+	CondPrefix   = "^=" // 前缀匹配，仅用于字符串字段
+	CondIn       = "in" // 等于 Values 中的任意一个
+)
+
+// 单个查询条件，例如 {Field: "class", Op: "=", Value: "21计一"}
+// Op 为 CondIn 时使用 Values
+type Condition struct {
+	Field  string
+	Op     string
+	Value  string
+	Values []string
+}
+
+// 排序字段，多个排序字段依次比较，全部相同时按键排序
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// 查询条件不合法时由客户端返回的错误
+type QueryError struct {
+	Detail string
+}
+
+func (e *QueryError) Error() string {
+	return e.Detail
+}
+
+// 解析后的查询条件，Value 已经转换为字段对应的类型
+type compiledCondition struct {
+	Condition
+	typ    string
+	value  interface{}
+	values []interface{}
+}
+
+// 检查条件中的字段和取值，并转换为字段类型
+func compileConditions(conds []Condition) ([]compiledCondition, error) {
+	compiled := make([]compiledCondition, 0, len(conds))
+	for _, cond := range conds {
+		typ, ok := FieldType(cond.Field)
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", cond.Field)
+		}
+		c := compiledCondition{Condition: cond, typ: typ}
+
+		switch cond.Op {
+		case CondEq, CondNe:
+		case CondGt, CondGe, CondLt, CondLe:
+			if typ == FieldString {
+				return nil, fmt.Errorf("operator %q needs a numeric field, %q is a string", cond.Op, cond.Field)
+			}
+		case CondContains, CondPrefix:
+			if typ != FieldString {
+				return nil, fmt.Errorf("operator %q needs a string field, %q is numeric", cond.Op, cond.Field)
+			}
+		case CondIn:
+			if len(cond.Values) == 0 {
+				return nil, fmt.Errorf("operator %q on field %q needs at least one value", cond.Op, cond.Field)
+			}
+			for _, s := range cond.Values {
+				value, err := parseFieldValue(typ, s)
+				if err != nil {
+					return nil, fmt.Errorf("invalid value %q for field %q: %v", s, cond.Field, err)
+				}
+				c.values = append(c.values, value)
+			}
+			compiled = append(compiled, c)
+			continue
+		default:
+			return nil, fmt.Errorf("unsupported operator %q on field %q", cond.Op, cond.Field)
+		}
+
+		value, err := parseFieldValue(typ, cond.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for field %q: %v", cond.Value, cond.Field, err)
+		}
+		c.value = value
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// 检查排序字段是否存在
+func checkSortFields(fields []SortField) error {
+	for _, sf := range fields {
+		if _, ok := FieldType(sf.Field); !ok {
+			return fmt.Errorf("unknown sort field %q", sf.Field)
+		}
+	}
+	return nil
+}
+
+func parseFieldValue(typ, s string) (interface{}, error) {
+	switch typ {
+	case FieldInt:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("expected an integer")
+		}
+		return n, nil
+	case FieldFloat:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected a number")
+		}
+		return f, nil
+	}
+	return s, nil
+}
+
+// 将数值字段转换为 float64 以便比较大小
+func toFloat(v interface{}) float64 {
+	switch x := v.(type) {
+	case int:
+		return float64(x)
+	case float64:
+		return x
+	}
+	return 0
+}
+
+// 比较两个同类型的字段值，返回 -1、0 或 1
+func compareFieldValues(a, b interface{}) int {
+	if sa, ok := a.(string); ok {
+		return strings.Compare(sa, b.(string))
+	}
+	fa, fb := toFloat(a), toFloat(b)
+	if fa < fb {
+		return -1
+	} else if fa > fb {
+		return 1
+	}
+	return 0
+}
+
+func (c *compiledCondition) match(e KVEntry) bool {
+	v, _ := EntryField(e, c.Field)
+	switch c.Op {
+	case CondEq:
+		return v == c.value
+	case CondNe:
+		return v != c.value
+	case CondGt:
+		return toFloat(v) > toFloat(c.value)
+	case CondGe:
+		return toFloat(v) >= toFloat(c.value)
+	case CondLt:
+		return toFloat(v) < toFloat(c.value)
+	case CondLe:
+		return toFloat(v) <= toFloat(c.value)
+	case CondContains:
+		return strings.Contains(v.(string), c.value.(string))
+	case CondPrefix:
+		return strings.HasPrefix(v.(string), c.value.(string))
+	case CondIn:
+		for _, value := range c.values {
+			if v == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 返回该条件可以用二级索引查找的取值，不能用索引时返回 false
+func (c *compiledCondition) indexValues() ([]string, bool) {
+	switch c.Op {
+	case CondEq:
+		return []string{fieldString(c.value)}, true
+	case CondIn:
+		values := make([]string, 0, len(c.values))
+		for _, value := range c.values {
+			values = append(values, fieldString(value))
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+// 在服务端执行查询，调用方需持有 kv.mu
+// 如果有等值或 IN 条件命中二级索引，只检查索引给出的候选键，否则扫描全部数据
+func (kv *KVServer) queryLocked(conds []compiledCondition) []KeyValue {
+	now := kv.readClockLocked()
+	matchAll := func(key string, e KVEntry) bool {
+		if kv.expiredLocked(key, now) {
+			return false
+		}
+		for i := range conds {
+			if !conds[i].match(e) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// 选择候选集合最小的索引条件
+	var bestValues []string
+	bestField := ""
+	bestCount := 0
+	for i := range conds {
+		if !kv.index.has(conds[i].Field) {
+			continue
+		}
+		values, ok := conds[i].indexValues()
+		if !ok {
+			continue
+		}
+		count := 0
+		for _, value := range values {
+			count += kv.index.count(conds[i].Field, value)
+		}
+		if bestField == "" || count < bestCount {
+			bestField, bestValues, bestCount = conds[i].Field, values, count
+		}
+	}
+
+	results := []KeyValue{}
+	if bestField != "" {
+		var keys []string
+		for _, value := range bestValues {
+			keys = append(keys, kv.index.lookup(bestField, value)...)
+		}
+		sort.Strings(keys)
+		for i, key := range keys {
+			if i > 0 && keys[i-1] == key {
+				continue // IN 列表中有重复取值
+			}
+			value, ok := kv.data.get(key)
+			if ok && matchAll(key, value) {
+				results = append(results, KeyValue{Key: key, Value: value})
+			}
+		}
+		return results
+	}
+
+	kv.data.ascend("", func(key string, value KVEntry) bool {
+		if matchAll(key, value) {
+			results = append(results, KeyValue{Key: key, Value: value})
+		}
+		return true
+	})
+	return results
+}
+
+// 按排序字段对结果排序，结果原本按键有序，稳定排序保证相同取值时仍按键排列
+func sortEntries(entries []KeyValue, fields []SortField) {
+	if len(fields) == 0 {
+		return
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		for _, sf := range fields {
+			a, _ := EntryField(entries[i].Value, sf.Field)
+			b, _ := EntryField(entries[j].Value, sf.Field)
+			cmp := compareFieldValues(a, b)
+			if cmp == 0 {
+				continue
+			}
+			if sf.Desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		return false
+	})
+}
@@ -0,0 +1,166 @@
+package kv
+
+import (
+	"fmt"
+	"testing"
+)
+
+func applyEntry(kv *KVServer, seq int, key string, e KVEntry) {
+	applyOp(kv, Op{Type: OpPut, Key: key, Value: e, ClientID: 1, SeqNum: seq})
+}
+
+func query(t *testing.T, kv *KVServer, conds ...Condition) []string {
+	t.Helper()
+	compiled, err := compileConditions(conds)
+	if err != nil {
+		t.Fatalf("compileConditions(%+v): %v", conds, err)
+	}
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	var keys []string
+	for _, entry := range kv.queryLocked(compiled) {
+		keys = append(keys, entry.Key)
+	}
+	return keys
+}
+
+// 修改和删除记录后二级索引与数据保持一致，等值查询只返回当前满足条件的键
+func TestIndexFollowsWrites(t *testing.T) {
+	kv := newTestServer()
+	applyEntry(kv, 1, "a", KVEntry{Name: "甲", Class: "21计一", Grand: 2021})
+	applyEntry(kv, 2, "b", KVEntry{Name: "乙", Class: "21计一", Grand: 2021})
+	applyEntry(kv, 3, "c", KVEntry{Name: "丙", Class: "21计二", Grand: 2022})
+
+	class := func(value string) Condition { return Condition{Field: "class", Op: CondEq, Value: value} }
+	if got := fmt.Sprint(query(t, kv, class("21计一"))); got != "[a b]" {
+		t.Fatalf("class = 21计一: %s, want [a b]", got)
+	}
+
+	applyEntry(kv, 4, "a", KVEntry{Name: "甲", Class: "21计二", Grand: 2021})
+	applyOp(kv, Op{Type: OpDelete, Key: "c", ClientID: 1, SeqNum: 5})
+	if got := fmt.Sprint(query(t, kv, class("21计一"))); got != "[b]" {
+		t.Fatalf("class = 21计一 after moving a: %s, want [b]", got)
+	}
+	if got := fmt.Sprint(query(t, kv, class("21计二"))); got != "[a]" {
+		t.Fatalf("class = 21计二 after deleting c: %s, want [a]", got)
+	}
+
+	kv.mu.Lock()
+	if n := kv.index.count("class", "21计二"); n != 1 {
+		t.Errorf("index holds %d keys for class 21计二, want 1", n)
+	}
+	if _, ok := kv.index.fields["grand"]["2022"]; ok {
+		t.Errorf("index still holds grand 2022 after its only key was deleted")
+	}
+	kv.mu.Unlock()
+}
+
+// 索引条件和其他条件同时满足才返回，结果按键排序；不能用索引的条件扫描全部数据
+func TestQueryCombinesConditions(t *testing.T) {
+	kv := newTestServer()
+	for i := 0; i < 10; i++ {
+		class := "21计一"
+		if i%2 == 1 {
+			class = "21计二"
+		}
+		applyEntry(kv, i+1, fmt.Sprintf("k%d", 9-i), KVEntry{Name: fmt.Sprint(i), Class: class, Grand: 2020 + i%3})
+	}
+
+	got := query(t, kv,
+		Condition{Field: "class", Op: CondEq, Value: "21计一"},
+		Condition{Field: "grand", Op: CondEq, Value: "2020"})
+	if fmt.Sprint(got) != "[k3 k9]" {
+		t.Fatalf("class = 21计一 and grand = 2020: %v, want [k3 k9]", got)
+	}
+
+	got = query(t, kv, Condition{Field: "name", Op: CondEq, Value: "4"})
+	if fmt.Sprint(got) != "[k5]" {
+		t.Fatalf("name = 4: %v, want [k5]", got)
+	}
+}
+
+// 从快照恢复后重建索引，查询结果与恢复前相同
+func TestIndexRebuiltFromSnapshot(t *testing.T) {
+	kv := newTestServer()
+	applyEntry(kv, 1, "a", KVEntry{Name: "甲", Major: "软件工程"})
+	applyEntry(kv, 2, "b", KVEntry{Name: "乙", Major: "网络工程"})
+	kv.mu.Lock()
+	kv.lastApplied = kv.applyingIndex
+	snapshot := kv.encodeSnapshotLocked()
+	kv.mu.Unlock()
+
+	restored := newTestServer()
+	restored.mu.Lock()
+	restored.readSnapshotLocked(snapshot)
+	restored.mu.Unlock()
+	got := query(t, restored, Condition{Field: "major", Op: CondEq, Value: "网络工程"})
+	if fmt.Sprint(got) != "[b]" {
+		t.Fatalf("major = 网络工程 after restoring a snapshot: %v, want [b]", got)
+	}
+}
+
+// 范围、子串、前缀和 IN 条件按字段类型比较
+func TestQueryOperators(t *testing.T) {
+	kv := newTestServer()
+	applyEntry(kv, 1, "a", KVEntry{Name: "张伟", Grand: 2020, TotalCredits: 18.5})
+	applyEntry(kv, 2, "b", KVEntry{Name: "李伟", Grand: 2021, TotalCredits: 20})
+	applyEntry(kv, 3, "c", KVEntry{Name: "张三", Grand: 2022, TotalCredits: 9})
+
+	tests := []struct {
+		cond Condition
+		want string
+	}{
+		{Condition{Field: "total_credits", Op: CondGe, Value: "18.5"}, "[a b]"},
+		{Condition{Field: "total_credits", Op: CondGt, Value: "18.5"}, "[b]"},
+		{Condition{Field: "grand", Op: CondLt, Value: "2021"}, "[a]"},
+		{Condition{Field: "grand", Op: CondLe, Value: "2021"}, "[a b]"},
+		{Condition{Field: "grand", Op: CondNe, Value: "2021"}, "[a c]"},
+		{Condition{Field: "name", Op: CondContains, Value: "伟"}, "[a b]"},
+		{Condition{Field: "name", Op: CondPrefix, Value: "张"}, "[a c]"},
+		{Condition{Field: "grand", Op: CondIn, Values: []string{"2022", "2020", "2022"}}, "[a c]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(query(t, kv, tt.cond)); got != tt.want {
+			t.Errorf("%s %s %s%v: %s, want %s", tt.cond.Field, tt.cond.Op, tt.cond.Value, tt.cond.Values, got, tt.want)
+		}
+	}
+}
+
+// 不存在的字段、类型不匹配的运算符和取值在执行前被拒绝
+func TestCompileConditionsRejects(t *testing.T) {
+	bad := []Condition{
+		{Field: "nope", Op: CondEq, Value: "1"},
+		{Field: "name", Op: CondGt, Value: "a"},
+		{Field: "grand", Op: CondContains, Value: "1"},
+		{Field: "grand", Op: CondEq, Value: "abc"},
+		{Field: "grand", Op: CondIn},
+		{Field: "grand", Op: CondIn, Values: []string{"2021", "x"}},
+		{Field: "grand", Op: "<>", Value: "1"},
+	}
+	for _, cond := range bad {
+		if _, err := compileConditions([]Condition{cond}); err == nil {
+			t.Errorf("compileConditions(%+v) succeeded, want an error", cond)
+		}
+	}
+	if err := checkSortFields([]SortField{{Field: "nope"}}); err == nil {
+		t.Errorf("checkSortFields accepted an unknown field")
+	}
+}
+
+// 多个排序字段依次比较，取值全部相同时保持按键的顺序
+func TestSortEntries(t *testing.T) {
+	entries := []KeyValue{
+		{Key: "a", Value: KVEntry{Grand: 2021, TotalCredits: 10}},
+		{Key: "b", Value: KVEntry{Grand: 2020, TotalCredits: 10}},
+		{Key: "c", Value: KVEntry{Grand: 2021, TotalCredits: 30}},
+		{Key: "d", Value: KVEntry{Grand: 2021, TotalCredits: 10}},
+	}
+	sortEntries(entries, []SortField{{Field: "grand", Desc: true}, {Field: "total_credits"}})
+	var keys []string
+	for _, entry := range entries {
+		keys = append(keys, entry.Key)
+	}
+	if fmt.Sprint(keys) != "[a d c b]" {
+		t.Fatalf("sorted by grand desc, total_credits: %v, want [a d c b]", keys)
+	}
+}
@@ -0,0 +1,127 @@
+package kv
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// 字段的值类型
+const (
+	FieldString = "string"
+	FieldInt    = "int"
+	FieldFloat  = "float"
+)
+
+// 记录中的字段名（与 KVEntry 的 json tag 一致）到类型的映射
+var entryFieldTypes = map[string]string{
+	"grand":         FieldInt,
+	"class":         FieldString,
+	"major":         FieldString,
+	"name":          FieldString,
+	"course_count":  FieldInt,
+	"total_credits": FieldFloat,
+}
+
+// 按 KVEntry 中的定义顺序排列的字段名
+var EntryFields = []string{"grand", "class", "major", "name", "course_count", "total_credits"}
+
+// 返回字段的类型，字段不存在时返回 false
+func FieldType(field string) (string, bool) {
+	t, ok := entryFieldTypes[field]
+	return t, ok
+}
+
+// 读取记录中的某个字段，返回 string、int 或 float64
+func EntryField(e KVEntry, field string) (interface{}, bool) {
+	switch field {
+	case "grand":
+		return e.Grand, true
+	case "class":
+		return e.Class, true
+	case "major":
+		return e.Major, true
+	case "name":
+		return e.Name, true
+	case "course_count":
+		return e.CourseCount, true
+	case "total_credits":
+		return e.TotalCredits, true
+	}
+	return nil, false
+}
+
+// 将 src 中的某个字段复制到 dst，字段不存在时返回 false
+func CopyEntryField(dst *KVEntry, src KVEntry, field string) bool {
+	switch field {
+	case "grand":
+		dst.Grand = src.Grand
+	case "class":
+		dst.Class = src.Class
+	case "major":
+		dst.Major = src.Major
+	case "name":
+		dst.Name = src.Name
+	case "course_count":
+		dst.CourseCount = src.CourseCount
+	case "total_credits":
+		dst.TotalCredits = src.TotalCredits
+	default:
+		return false
+	}
+	return true
+}
+
+// 用 JSON 解码出的值设置某个字段，并检查值的类型是否与字段匹配
+func SetEntryField(e *KVEntry, field string, v interface{}) error {
+	typ, ok := FieldType(field)
+	if !ok {
+		return fmt.Errorf("invalid field: %s", field)
+	}
+
+	switch typ {
+	case FieldString:
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("field %s must be a string", field)
+		}
+		switch field {
+		case "class":
+			e.Class = s
+		case "major":
+			e.Major = s
+		case "name":
+			e.Name = s
+		}
+	case FieldInt:
+		f, ok := v.(float64)
+		if !ok || f != float64(int(f)) {
+			return fmt.Errorf("field %s must be an integer", field)
+		}
+		switch field {
+		case "grand":
+			e.Grand = int(f)
+		case "course_count":
+			e.CourseCount = int(f)
+		}
+	case FieldFloat:
+		f, ok := v.(float64)
+		if !ok {
+			return fmt.Errorf("field %s must be a number", field)
+		}
+		e.TotalCredits = f
+	}
+	return nil
+}
+
+// 将字段值格式化为字符串，用作索引的键
+func fieldString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case int:
+		return strconv.Itoa(x)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,210 @@
+package kv
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// 学生记录的校验规则，以 JSON 保存在配置文件和集群的系统键空间中，例如：
+//
+//	{
+//	  "key": {"pattern": "^[0-9]{8}$"},
+//	  "fields": {
+//	    "name":          {"required": true, "max_length": 32},
+//	    "grand":         {"required": true, "min": 2000, "max": 2100},
+//	    "total_credits": {"min": 0},
+//	    "major":         {"enum": ["通信工程", "人工智能"]}
+//	  }
+//	}
+//
+// KVEntry 无法区分缺失的字段和零值，因此 required 表示字段不能为零值（空字符串或 0）
+type Schema struct {
+	Key    KeyRule              `json:"key"`
+	Fields map[string]FieldRule `json:"fields"`
+}
+
+// 键的规则
+type KeyRule struct {
+	Pattern string `json:"pattern,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// 字段的规则，min/max 只能用于数值字段，长度、pattern 和 enum 只能用于字符串字段
+type FieldRule struct {
+	Required  bool     `json:"required,omitempty"`
+	Min       *float64 `json:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+	MinLength int      `json:"min_length,omitempty"`
+	MaxLength int      `json:"max_length,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+	Enum      []string `json:"enum,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// 一个字段没有通过校验的原因，键的错误使用字段名 id
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// 没有配置规则时使用的默认规则
+func DefaultSchema() *Schema {
+	zero, minGrand, maxGrand := 0.0, 1900.0, 2100.0
+	s := &Schema{
+		Key: KeyRule{Pattern: `^[0-9]{8}$`},
+		Fields: map[string]FieldRule{
+			"name":          {Required: true},
+			"grand":         {Required: true, Min: &minGrand, Max: &maxGrand},
+			"course_count":  {Min: &zero},
+			"total_credits": {Min: &zero},
+		},
+	}
+	if err := s.Compile(); err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// 解析 JSON 格式的规则并检查规则本身是否合法
+func ParseSchema(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid schema: %v", err)
+	}
+	if err := s.Compile(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// 检查字段名和规则是否与字段类型匹配，并编译正则表达式
+func (s *Schema) Compile() error {
+	if s.Key.Pattern != "" {
+		re, err := regexp.Compile(s.Key.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid key pattern: %v", err)
+		}
+		s.Key.re = re
+	}
+
+	for field, rule := range s.Fields {
+		typ, ok := FieldType(field)
+		if !ok {
+			return fmt.Errorf("unknown field %q in schema, expected one of %s", field, strings.Join(EntryFields, ", "))
+		}
+		if typ == FieldString {
+			if rule.Min != nil || rule.Max != nil {
+				return fmt.Errorf("field %s is a string, use min_length and max_length instead of min and max", field)
+			}
+			if rule.Pattern != "" {
+				re, err := regexp.Compile(rule.Pattern)
+				if err != nil {
+					return fmt.Errorf("invalid pattern for field %s: %v", field, err)
+				}
+				rule.re = re
+			}
+		} else if rule.MinLength != 0 || rule.MaxLength != 0 || rule.Pattern != "" || len(rule.Enum) > 0 {
+			return fmt.Errorf("field %s is numeric, only required, min and max apply", field)
+		}
+		if rule.Min != nil && rule.Max != nil && *rule.Min > *rule.Max {
+			return fmt.Errorf("field %s has min greater than max", field)
+		}
+		s.Fields[field] = rule
+	}
+	return nil
+}
+
+// 校验整条记录，返回全部错误，没有错误时返回 nil
+func (s *Schema) Validate(key string, value KVEntry) []FieldError {
+	errs := s.validateKey(key)
+	for _, field := range EntryFields {
+		errs = append(errs, s.validateField(field, value)...)
+	}
+	return errs
+}
+
+// 只校验 fields 中的字段，用于部分更新
+func (s *Schema) ValidateFields(key string, fields []string, value KVEntry) []FieldError {
+	errs := s.validateKey(key)
+	sorted := append([]string(nil), fields...)
+	sort.Strings(sorted)
+	for _, field := range sorted {
+		errs = append(errs, s.validateField(field, value)...)
+	}
+	return errs
+}
+
+func (s *Schema) validateKey(key string) []FieldError {
+	if key == "" {
+		return []FieldError{{Field: "id", Message: "is required"}}
+	}
+	if s.Key.re != nil && !s.Key.re.MatchString(key) {
+		return []FieldError{{Field: "id", Message: fmt.Sprintf("must match %s", s.Key.Pattern)}}
+	}
+	return nil
+}
+
+func (s *Schema) validateField(field string, value KVEntry) []FieldError {
+	rule, ok := s.Fields[field]
+	if !ok {
+		return nil
+	}
+	v, _ := EntryField(value, field)
+	fail := func(format string, args ...interface{}) []FieldError {
+		return []FieldError{{Field: field, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	switch x := v.(type) {
+	case string:
+		if x == "" {
+			if rule.Required {
+				return fail("is required")
+			}
+			return nil
+		}
+		n := utf8.RuneCountInString(x)
+		if rule.MinLength > 0 && n < rule.MinLength {
+			return fail("must be at least %d characters", rule.MinLength)
+		}
+		if rule.MaxLength > 0 && n > rule.MaxLength {
+			return fail("must be at most %d characters", rule.MaxLength)
+		}
+		if rule.re != nil && !rule.re.MatchString(x) {
+			return fail("must match %s", rule.Pattern)
+		}
+		if len(rule.Enum) > 0 && !containsString(rule.Enum, x) {
+			return fail("must be one of %s", strings.Join(rule.Enum, ", "))
+		}
+	case int, float64:
+		f := toFloat(x)
+		if f == 0 && rule.Required {
+			return fail("is required")
+		}
+		if rule.Min != nil && f < *rule.Min {
+			return fail("must be at least %s", fieldString(*rule.Min))
+		}
+		if rule.Max != nil && f > *rule.Max {
+			return fail("must be at most %s", fieldString(*rule.Max))
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package kv
+
+import (
+	"fmt"
+	"testing"
+)
+
+// 规则本身不合法时 ParseSchema 返回错误
+func TestParseSchemaRejects(t *testing.T) {
+	bad := []string{
+		`{"fields": {"name": {"required": true}`,
+		`{"fields": {"nickname": {"required": true}}}`,
+		`{"fields": {"name": {"min": 1}}}`,
+		`{"fields": {"grand": {"max_length": 4}}}`,
+		`{"fields": {"grand": {"enum": ["2021"]}}}`,
+		`{"fields": {"grand": {"min": 2100, "max": 2000}}}`,
+		`{"fields": {"name": {"pattern": "("}}}`,
+		`{"key": {"pattern": "["}}`,
+	}
+	for _, raw := range bad {
+		if _, err := ParseSchema([]byte(raw)); err == nil {
+			t.Errorf("ParseSchema(%s) succeeded, want an error", raw)
+		}
+	}
+}
+
+// 每条规则都能拒绝不符合的取值，全部错误一起返回
+func TestSchemaValidate(t *testing.T) {
+	s, err := ParseSchema([]byte(`{
+		"key": {"pattern": "^[0-9]{8}$"},
+		"fields": {
+			"name":          {"required": true, "min_length": 2, "max_length": 4},
+			"class":         {"pattern": "^[0-9]{2}"},
+			"major":         {"enum": ["软件工程", "网络工程"]},
+			"grand":         {"required": true, "min": 2000, "max": 2100},
+			"total_credits": {"min": 0}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := KVEntry{Name: "张三", Class: "21计一", Major: "软件工程", Grand: 2021, TotalCredits: 20}
+	if errs := s.Validate("21030101", valid); errs != nil {
+		t.Fatalf("valid record rejected: %v", errs)
+	}
+
+	tests := []struct {
+		key    string
+		change func(e *KVEntry)
+		field  string
+	}{
+		{"2103010", func(e *KVEntry) {}, "id"},
+		{"", func(e *KVEntry) {}, "id"},
+		{"21030101", func(e *KVEntry) { e.Name = "" }, "name"},
+		{"21030101", func(e *KVEntry) { e.Name = "张" }, "name"},
+		{"21030101", func(e *KVEntry) { e.Name = "欧阳小明同学" }, "name"},
+		{"21030101", func(e *KVEntry) { e.Class = "计一" }, "class"},
+		{"21030101", func(e *KVEntry) { e.Major = "通信工程" }, "major"},
+		{"21030101", func(e *KVEntry) { e.Grand = 0 }, "grand"},
+		{"21030101", func(e *KVEntry) { e.Grand = 1999 }, "grand"},
+		{"21030101", func(e *KVEntry) { e.Grand = 2101 }, "grand"},
+		{"21030101", func(e *KVEntry) { e.TotalCredits = -0.5 }, "total_credits"},
+	}
+	for _, tt := range tests {
+		e := valid
+		tt.change(&e)
+		errs := s.Validate(tt.key, e)
+		if len(errs) != 1 || errs[0].Field != tt.field {
+			t.Errorf("Validate(%q, %+v) = %v, want one error on %s", tt.key, e, errs, tt.field)
+		}
+	}
+
+	// 未设置 required 的字段为空时不检查其他规则
+	e := valid
+	e.Major, e.Class = "", ""
+	if errs := s.Validate("21030101", e); errs != nil {
+		t.Errorf("empty optional fields rejected: %v", errs)
+	}
+
+	errs := s.Validate("x", KVEntry{Grand: 1})
+	if fmt.Sprint(errs) != "[id: must match ^[0-9]{8}$ grand: must be at least 2000 name: is required]" {
+		t.Errorf("Validate of a bad record = %v", errs)
+	}
+}
+
+// 部分更新只校验给出的字段
+func TestSchemaValidateFields(t *testing.T) {
+	s := DefaultSchema()
+	if errs := s.ValidateFields("21030101", []string{"major"}, KVEntry{Major: "软件工程"}); errs != nil {
+		t.Errorf("patch of major checked other fields: %v", errs)
+	}
+	errs := s.ValidateFields("21030101", []string{"name", "grand"}, KVEntry{Grand: 1800})
+	if len(errs) != 2 || errs[0].Field != "grand" || errs[1].Field != "name" {
+		t.Errorf("ValidateFields = %v, want errors on grand and name", errs)
+	}
+}
@@ -0,0 +1,152 @@
+package kv
+
+import "math/rand"
+
+const (
+	skipListMaxLevel = 24
+	skipListP        = 4 // 每层晋升的概率为 1/skipListP
+)
+
+type skipNode struct {
+	key   string
+	value KVEntry
+	next  []*skipNode
+}
+
+// 按键有序存储的跳表，替代 map 以支持有序遍历和范围扫描
+// 所有方法都需要在 kv.mu 的保护下调用
+type skipList struct {
+	head   *skipNode
+	level  int
+	length int
+	rng    *rand.Rand
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		head:  &skipNode{next: make([]*skipNode, skipListMaxLevel)},
+		level: 1,
+		// 固定种子，使相同的写入序列得到相同的结构
+		rng: rand.New(rand.NewSource(1)),
+	}
+}
+
+func (sl *skipList) randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && sl.rng.Intn(skipListP) == 0 {
+		level++
+	}
+	return level
+}
+
+// 找到每一层中最后一个小于 key 的节点
+func (sl *skipList) findPrev(key string) []*skipNode {
+	prev := make([]*skipNode, skipListMaxLevel)
+	node := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && node.next[i].key < key {
+			node = node.next[i]
+		}
+		prev[i] = node
+	}
+	return prev
+}
+
+// 返回第一个大于等于 key 的节点
+func (sl *skipList) seek(key string) *skipNode {
+	node := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && node.next[i].key < key {
+			node = node.next[i]
+		}
+	}
+	return node.next[0]
+}
+
+func (sl *skipList) get(key string) (KVEntry, bool) {
+	node := sl.seek(key)
+	if node != nil && node.key == key {
+		return node.value, true
+	}
+	return KVEntry{}, false
+}
+
+func (sl *skipList) put(key string, value KVEntry) {
+	prev := sl.findPrev(key)
+	if node := prev[0].next[0]; node != nil && node.key == key {
+		node.value = value
+		return
+	}
+
+	level := sl.randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			prev[i] = sl.head
+		}
+		sl.level = level
+	}
+
+	node := &skipNode{key: key, value: value, next: make([]*skipNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = prev[i].next[i]
+		prev[i].next[i] = node
+	}
+	sl.length++
+}
+
+func (sl *skipList) delete(key string) bool {
+	prev := sl.findPrev(key)
+	node := prev[0].next[0]
+	if node == nil || node.key != key {
+		return false
+	}
+
+	for i := 0; i < len(node.next); i++ {
+		prev[i].next[i] = node.next[i]
+	}
+	for sl.level > 1 && sl.head.next[sl.level-1] == nil {
+		sl.level--
+	}
+	sl.length--
+	return true
+}
+
+func (sl *skipList) len() int {
+	return sl.length
+}
+
+// 从第一个大于等于 start 的键开始按序遍历，fn 返回 false 时停止
+func (sl *skipList) ascend(start string, fn func(key string, value KVEntry) bool) {
+	for node := sl.seek(start); node != nil; node = node.next[0] {
+		if !fn(node.key, node.value) {
+			return
+		}
+	}
+}
+
+func (sl *skipList) keys() []string {
+	keys := make([]string, 0, sl.length)
+	sl.ascend("", func(key string, _ KVEntry) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// 转换为 map，用于写入 data_kv.json
+func (sl *skipList) toMap() map[string]KVEntry {
+	m := make(map[string]KVEntry, sl.length)
+	sl.ascend("", func(key string, value KVEntry) bool {
+		m[key] = value
+		return true
+	})
+	return m
+}
+
+func skipListFromMap(m map[string]KVEntry) *skipList {
+	sl := newSkipList()
+	for key, value := range m {
+		sl.put(key, value)
+	}
+	return sl
+}
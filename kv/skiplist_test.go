@@ -0,0 +1,77 @@
+package kv
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// 随机的写入和删除之后，跳表与 map 的内容相同，并且按键有序
+func TestSkipListMatchesMap(t *testing.T) {
+	sl := newSkipList()
+	ref := make(map[string]KVEntry)
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("k%03d", rng.Intn(500))
+		if rng.Intn(3) == 0 {
+			_, exists := ref[key]
+			if deleted := sl.delete(key); deleted != exists {
+				t.Fatalf("delete(%s) = %v, want %v", key, deleted, exists)
+			}
+			delete(ref, key)
+		} else {
+			value := KVEntry{Name: key, Grand: i}
+			sl.put(key, value)
+			ref[key] = value
+		}
+	}
+
+	if sl.len() != len(ref) {
+		t.Fatalf("len = %d, want %d", sl.len(), len(ref))
+	}
+	want := make([]string, 0, len(ref))
+	for key := range ref {
+		want = append(want, key)
+	}
+	sort.Strings(want)
+	got := sl.keys()
+	if len(got) != len(want) {
+		t.Fatalf("keys = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("keys[%d] = %s, want %s", i, got[i], want[i])
+		}
+		if value, ok := sl.get(want[i]); !ok || value != ref[want[i]] {
+			t.Fatalf("get(%s) = %+v, %v; want %+v", want[i], value, ok, ref[want[i]])
+		}
+	}
+	if _, ok := sl.get("missing"); ok {
+		t.Fatalf("get of a missing key succeeded")
+	}
+}
+
+// ascend 从第一个不小于 start 的键开始，回调返回 false 时停止
+func TestSkipListAscend(t *testing.T) {
+	sl := skipListFromMap(map[string]KVEntry{"a": {}, "c": {}, "e": {}, "g": {}})
+
+	var got []string
+	sl.ascend("b", func(key string, _ KVEntry) bool {
+		got = append(got, key)
+		return key < "e"
+	})
+	if fmt.Sprint(got) != "[c e]" {
+		t.Fatalf("ascend from b = %v, want [c e]", got)
+	}
+
+	got = nil
+	sl.ascend("h", func(key string, _ KVEntry) bool {
+		got = append(got, key)
+		return true
+	})
+	if len(got) != 0 {
+		t.Fatalf("ascend past the last key = %v", got)
+	}
+}
@@ -0,0 +1,115 @@
+package kv
+
+import (
+	"bytes"
+	"course/labgob"
+	"io"
+)
+
+// 将状态机编码为 Raft 快照，调用方需持有 kv.mu
+func (kv *KVServer) encodeSnapshotLocked() []byte {
+	w := new(bytes.Buffer)
+	e := labgob.NewEncoder(w)
+	e.Encode(kv.data.toMap())
+	e.Encode(kv.clientSeq)
+	e.Encode(kv.expireAt)
+	e.Encode(kv.clock)
+	e.Encode(kv.lastApplied)
+	e.Encode(kv.versions)
+	e.Encode(kv.versionHorizon)
+	e.Encode(kv.system)
+	e.Encode(kv.audit)
+	e.Encode(map[int64][]int{}) // 曾经保存去重窗口的位置，保留以便读取已有的快照
+	e.Encode(kv.pins)
+	return w.Bytes()
+}
+
+// 从 Raft 快照恢复状态机，并重建二级索引，调用方需持有 kv.mu
+func (kv *KVServer) readSnapshotLocked(snapshot []byte) {
+	if len(snapshot) == 0 {
+		return
+	}
+
+	r := bytes.NewBuffer(snapshot)
+	d := labgob.NewDecoder(r)
+
+	var data map[string]KVEntry
+	var clientSeq map[int64]int
+	var expireAt map[string]int64
+	var clock int64
+	var lastApplied int
+	var versions map[string][]Version
+	var versionHorizon int
+	var system map[string]string
+	var audit []AuditRecord
+	var unused map[int64][]int
+	var pins map[string]versionPin
+	if err := d.Decode(&data); err != nil {
+		kv.logger.Error("Failed to decode snapshot data", "err", err)
+		return
+	}
+	if err := d.Decode(&clientSeq); err != nil {
+		kv.logger.Error("Failed to decode snapshot client sequence", "err", err)
+		return
+	}
+	if err := d.Decode(&expireAt); err != nil {
+		kv.logger.Error("Failed to decode snapshot expiry", "err", err)
+		return
+	}
+	if err := d.Decode(&clock); err != nil {
+		kv.logger.Error("Failed to decode snapshot clock", "err", err)
+		return
+	}
+	if err := d.Decode(&lastApplied); err != nil {
+		kv.logger.Error("Failed to decode snapshot index", "err", err)
+		return
+	}
+	if err := d.Decode(&versions); err != nil {
+		kv.logger.Error("Failed to decode snapshot versions", "err", err)
+		return
+	}
+	if err := d.Decode(&versionHorizon); err != nil {
+		kv.logger.Error("Failed to decode snapshot version horizon", "err", err)
+		return
+	}
+	if err := d.Decode(&system); err != nil {
+		kv.logger.Error("Failed to decode snapshot system keyspace", "err", err)
+		return
+	}
+	if err := d.Decode(&audit); err != nil {
+		kv.logger.Error("Failed to decode snapshot audit log", "err", err)
+		return
+	}
+	if err := d.Decode(&unused); err != nil && err != io.EOF {
+		kv.logger.Error("Failed to decode snapshot", "err", err)
+		return
+	}
+	if err := d.Decode(&pins); err != nil && err != io.EOF {
+		kv.logger.Error("Failed to decode snapshot version pins", "err", err)
+		return
+	}
+
+	kv.data = skipListFromMap(data)
+	kv.clientSeq = clientSeq
+	kv.expireAt = expireAt
+	if kv.expireAt == nil {
+		kv.expireAt = make(map[string]int64)
+	}
+	kv.clock = clock
+	kv.lastApplied = lastApplied
+	kv.versions = versions
+	if kv.versions == nil {
+		kv.versions = make(map[string][]Version)
+	}
+	kv.versionHorizon = versionHorizon
+	kv.system = system
+	if kv.system == nil {
+		kv.system = make(map[string]string)
+	}
+	kv.audit = audit
+	kv.pins = pins
+	if kv.pins == nil {
+		kv.pins = make(map[string]versionPin)
+	}
+	kv.index.rebuild(kv.data)
+}
@@ -0,0 +1,163 @@
+package kv
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// 聚合函数
+const (
+	MetricCount = "count"
+	MetricSum   = "sum"
+	MetricAvg   = "avg"
+	MetricMin   = "min"
+	MetricMax   = "max"
+	MetricDist  = "dist" // 字段取值的分布，即每个取值出现的次数
+)
+
+// 一个聚合指标，例如 {Func: "avg", Field: "total_credits"}
+// count 不需要 Field
+type Metric struct {
+	Func  string
+	Field string
+}
+
+func (m Metric) String() string {
+	if m.Func == MetricCount {
+		return MetricCount
+	}
+	return fmt.Sprintf("%s(%s)", m.Func, m.Field)
+}
+
+// 解析 "count" 或 "avg(total_credits)" 这样的指标表达式
+func ParseMetric(s string) (Metric, error) {
+	s = strings.TrimSpace(s)
+	if s == MetricCount {
+		return Metric{Func: MetricCount}, nil
+	}
+	fn, rest, ok := strings.Cut(s, "(")
+	if !ok || !strings.HasSuffix(rest, ")") {
+		return Metric{}, fmt.Errorf("metric %q should look like count or func(field)", s)
+	}
+	return Metric{Func: fn, Field: strings.TrimSuffix(rest, ")")}, nil
+}
+
+// 一个分组的聚合结果
+type StatsGroup struct {
+	Key           []string                  // 分组字段的取值，顺序与 GroupBy 一致
+	Count         int                       // 组内记录数
+	Values        map[string]float64        // sum/avg/min/max 的结果，键为 Metric.String()
+	Distributions map[string]map[string]int // dist 的结果，键为 Metric.String()
+}
+
+func checkMetrics(groupBy []string, metrics []Metric) error {
+	for _, field := range groupBy {
+		if _, ok := FieldType(field); !ok {
+			return fmt.Errorf("unknown group_by field %q", field)
+		}
+	}
+	if len(metrics) == 0 {
+		return fmt.Errorf("at least one metric is required")
+	}
+	for _, m := range metrics {
+		switch m.Func {
+		case MetricCount:
+		case MetricSum, MetricAvg, MetricMin, MetricMax:
+			typ, ok := FieldType(m.Field)
+			if !ok {
+				return fmt.Errorf("unknown field %q in metric %s", m.Field, m)
+			}
+			if typ == FieldString {
+				return fmt.Errorf("metric %s needs a numeric field", m)
+			}
+		case MetricDist:
+			if _, ok := FieldType(m.Field); !ok {
+				return fmt.Errorf("unknown field %q in metric %s", m.Field, m)
+			}
+		default:
+			return fmt.Errorf("unknown metric function %q", m.Func)
+		}
+	}
+	return nil
+}
+
+// 对记录分组并计算聚合指标，分组按 Key 排序
+func aggregate(entries []KeyValue, groupBy []string, metrics []Metric) []StatsGroup {
+	type accumulator struct {
+		group StatsGroup
+		sums  map[string]float64
+	}
+
+	groups := make(map[string]*accumulator)
+	for _, entry := range entries {
+		key := make([]string, len(groupBy))
+		for i, field := range groupBy {
+			v, _ := EntryField(entry.Value, field)
+			key[i] = fieldString(v)
+		}
+		id := strings.Join(key, "\x00")
+
+		acc, ok := groups[id]
+		if !ok {
+			acc = &accumulator{
+				group: StatsGroup{
+					Key:           key,
+					Values:        make(map[string]float64),
+					Distributions: make(map[string]map[string]int),
+				},
+				sums: make(map[string]float64),
+			}
+			groups[id] = acc
+		}
+		acc.group.Count++
+
+		for _, m := range metrics {
+			name := m.String()
+			if m.Func == MetricCount {
+				continue
+			}
+			v, _ := EntryField(entry.Value, m.Field)
+			if m.Func == MetricDist {
+				dist, ok := acc.group.Distributions[name]
+				if !ok {
+					dist = make(map[string]int)
+					acc.group.Distributions[name] = dist
+				}
+				dist[fieldString(v)]++
+				continue
+			}
+
+			f := toFloat(v)
+			acc.sums[name] += f
+			switch m.Func {
+			case MetricSum:
+				acc.group.Values[name] = acc.sums[name]
+			case MetricAvg:
+				acc.group.Values[name] = acc.sums[name] / float64(acc.group.Count)
+			case MetricMin:
+				if old, ok := acc.group.Values[name]; !ok || f < old {
+					acc.group.Values[name] = f
+				}
+			case MetricMax:
+				if old, ok := acc.group.Values[name]; !ok || f > old {
+					acc.group.Values[name] = f
+				}
+			}
+		}
+	}
+
+	result := make([]StatsGroup, 0, len(groups))
+	for _, acc := range groups {
+		for name, v := range acc.group.Values {
+			// 平均值保留两位小数，避免 28.499999 这样的输出
+			acc.group.Values[name] = math.Round(v*100) / 100
+		}
+		result = append(result, acc.group)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return strings.Join(result[i].Key, "\x00") < strings.Join(result[j].Key, "\x00")
+	})
+	return result
+}
@@ -0,0 +1,81 @@
+package kv
+
+import (
+	"fmt"
+	"testing"
+)
+
+// 按字段分组计算各项指标，分组按取值排序，平均值保留两位小数
+func TestAggregate(t *testing.T) {
+	entries := []KeyValue{
+		{Key: "a", Value: KVEntry{Class: "21计一", Major: "软件工程", TotalCredits: 10}},
+		{Key: "b", Value: KVEntry{Class: "21计二", Major: "软件工程", TotalCredits: 20}},
+		{Key: "c", Value: KVEntry{Class: "21计一", Major: "网络工程", TotalCredits: 15}},
+		{Key: "d", Value: KVEntry{Class: "21计一", Major: "软件工程", TotalCredits: 12.333}},
+	}
+	metrics := []Metric{
+		{Func: MetricCount},
+		{Func: MetricAvg, Field: "total_credits"},
+		{Func: MetricMin, Field: "total_credits"},
+		{Func: MetricMax, Field: "total_credits"},
+		{Func: MetricSum, Field: "total_credits"},
+		{Func: MetricDist, Field: "major"},
+	}
+	groups := aggregate(entries, []string{"class"}, metrics)
+	if len(groups) != 2 || fmt.Sprint(groups[0].Key, groups[1].Key) != "[21计一] [21计二]" {
+		t.Fatalf("groups = %+v", groups)
+	}
+	g := groups[0]
+	if g.Count != 3 {
+		t.Errorf("count = %d, want 3", g.Count)
+	}
+	want := map[string]float64{
+		"avg(total_credits)": 12.44,
+		"min(total_credits)": 10,
+		"max(total_credits)": 15,
+		"sum(total_credits)": 37.33,
+	}
+	for name, v := range want {
+		if g.Values[name] != v {
+			t.Errorf("%s = %v, want %v", name, g.Values[name], v)
+		}
+	}
+	if dist := g.Distributions["dist(major)"]; dist["软件工程"] != 2 || dist["网络工程"] != 1 {
+		t.Errorf("dist(major) = %v", dist)
+	}
+
+	all := aggregate(entries, nil, []Metric{{Func: MetricCount}})
+	if len(all) != 1 || all[0].Count != 4 {
+		t.Errorf("without group_by = %+v, want one group of 4", all)
+	}
+	if groups := aggregate(nil, []string{"class"}, metrics); len(groups) != 0 {
+		t.Errorf("no entries gave %d groups", len(groups))
+	}
+}
+
+// 指标表达式的解析和检查
+func TestParseAndCheckMetrics(t *testing.T) {
+	m, err := ParseMetric(" avg(total_credits) ")
+	if err != nil || m != (Metric{Func: MetricAvg, Field: "total_credits"}) || m.String() != "avg(total_credits)" {
+		t.Fatalf("ParseMetric = %+v, %v", m, err)
+	}
+	if _, err := ParseMetric("avg total_credits"); err == nil {
+		t.Errorf("ParseMetric accepted a metric without parentheses")
+	}
+
+	bad := []struct {
+		groupBy []string
+		metrics []Metric
+	}{
+		{nil, nil},
+		{[]string{"nope"}, []Metric{{Func: MetricCount}}},
+		{nil, []Metric{{Func: MetricAvg, Field: "name"}}},
+		{nil, []Metric{{Func: MetricMax, Field: "nope"}}},
+		{nil, []Metric{{Func: "median", Field: "grand"}}},
+	}
+	for _, tt := range bad {
+		if err := checkMetrics(tt.groupBy, tt.metrics); err == nil {
+			t.Errorf("checkMetrics(%v, %v) succeeded, want an error", tt.groupBy, tt.metrics)
+		}
+	}
+}
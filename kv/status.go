@@ -0,0 +1,54 @@
+package kv
+
+import (
+	"course/sim"
+	"time"
+)
+
+// 返回本节点的 Raft 状态和状态机的概况，用于监控，任何节点都可以回答
+func (kv *KVServer) Status(args *StatusArgs, reply *StatusReply) {
+	if kv.killed() {
+		reply.Err = ErrWrongLeader
+		return
+	}
+	reply.Raft = kv.rf.Status()
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	reply.LastApplied = kv.lastApplied
+	reply.Keys = kv.data.len()
+	reply.Err = ""
+}
+
+// 一个节点的状态，Reachable 为 false 时其他字段没有意义
+type NodeStatus struct {
+	Server    int
+	Reachable bool
+	StatusReply
+}
+
+// 同时向所有节点查询状态，timeout 内没有回复的节点视为不可达，结果按节点编号排列
+func (ck *KVClient) ClusterStatus(timeout time.Duration) []NodeStatus {
+	results := make(chan NodeStatus, len(ck.servers))
+	for i, server := range ck.servers {
+		sim.Go(func() {
+			var reply StatusReply
+			ok := server.Call("KVServer.Status", &StatusArgs{}, &reply)
+			sim.Send(results, NodeStatus{Server: i, Reachable: ok && reply.Err == "", StatusReply: reply})
+		})
+	}
+
+	statuses := make([]NodeStatus, len(ck.servers))
+	for i := range statuses {
+		statuses[i] = NodeStatus{Server: i}
+	}
+	deadline := sim.After(timeout)
+	for range ck.servers {
+		s, _, ok := sim.RecvUntil(results, deadline)
+		if !ok {
+			return statuses
+		}
+		statuses[s.Server] = s
+	}
+	return statuses
+}
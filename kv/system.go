@@ -0,0 +1,121 @@
+package kv
+
+import "strings"
+
+// 系统键空间：与学生记录分开存放的字符串键值，例如网关的 API 令牌
+// 和普通数据一样通过 Raft 复制并包含在快照中，所有网关看到的内容一致；
+// 但不写入 data_kv.json，也不会出现在扫描、查询、统计和 Watch 的结果中
+
+// 审计日志最多保留的条数，超出后丢弃最旧的记录
+const maxAuditLog = 10000
+
+// 一次写操作的审计记录
+type AuditRecord struct {
+	Index int    // 产生该记录的日志位置
+	Time  int64  // 领导者时间戳（UnixNano）
+	Actor string // 发起写操作的用户，为空表示未认证的调用方
+	Op    string // 操作类型，例如 Put、Patch、SysPut
+	Key   string
+}
+
+// 追加一条审计记录，调用方需持有 kv.mu
+func (kv *KVServer) recordAuditLocked(actor, op, key string) {
+	kv.audit = append(kv.audit, AuditRecord{
+		Index: kv.applyingIndex,
+		Time:  kv.clock,
+		Actor: actor,
+		Op:    op,
+		Key:   key,
+	})
+	if len(kv.audit) > maxAuditLog {
+		kv.audit = append([]AuditRecord(nil), kv.audit[len(kv.audit)-maxAuditLog:]...)
+	}
+}
+
+// 读取系统键，与 Get 一样先提交一条日志，应用到它之后再读取，保证读到已提交的最新值
+func (kv *KVServer) SysGet(args *SysGetArgs, reply *SysGetReply) {
+	if kv.killed() {
+		reply.Err = ErrWrongLeader
+		return
+	}
+
+	op := Op{
+		Type:     OpSysGet,
+		Key:      args.Key,
+		ClientID: args.ClientID,
+		SeqNum:   args.SeqNum,
+	}
+	if reply.Err = kv.startOp(op); reply.Err != "" {
+		return
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	value, exists := kv.system[args.Key]
+	if !exists {
+		reply.Err = ErrNoKey
+		return
+	}
+	reply.Value = value
+	reply.Err = ""
+}
+
+// 写入或删除系统键
+func (kv *KVServer) SysPut(args *SysPutArgs, reply *SysPutReply) {
+	if kv.killed() {
+		reply.Err = ErrWrongLeader
+		return
+	}
+
+	op := Op{
+		Type:     OpSysPut,
+		Key:      args.Key,
+		Raw:      args.Value,
+		Actor:    args.Actor,
+		ClientID: args.ClientID,
+		SeqNum:   args.SeqNum,
+	}
+	if args.Delete {
+		op.Type = OpSysDelete
+	}
+	reply.Err = kv.startOp(op)
+}
+
+// 返回 Prefix 开头的全部系统键，先经过读屏障，吊销令牌时不会漏掉刚创建的令牌
+func (kv *KVServer) SysList(args *SysListArgs, reply *SysListReply) {
+	if reply.Err = kv.readBarrier(args.ClientID, args.SeqNum); reply.Err != "" {
+		return
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	reply.Values = make(map[string]string)
+	for key, value := range kv.system {
+		if strings.HasPrefix(key, args.Prefix) {
+			reply.Values[key] = value
+		}
+	}
+	reply.Err = ""
+}
+
+// 返回满足条件的最近的审计记录，按日志位置排序，先经过读屏障
+func (kv *KVServer) Audit(args *AuditArgs, reply *AuditReply) {
+	if reply.Err = kv.readBarrier(args.ClientID, args.SeqNum); reply.Err != "" {
+		return
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	reply.Records = nil
+	for _, record := range kv.audit {
+		if (args.Key == "" || record.Key == args.Key) && (args.Actor == "" || record.Actor == args.Actor) {
+			reply.Records = append(reply.Records, record)
+		}
+	}
+	if args.Limit > 0 && len(reply.Records) > args.Limit {
+		reply.Records = reply.Records[len(reply.Records)-args.Limit:]
+	}
+	reply.Err = ""
+}
@@ -0,0 +1,39 @@
+package kv
+
+import "testing"
+
+// 写操作按应用顺序记入审计日志，系统键不会出现在普通数据中，审计日志随快照保存
+func TestAuditLog(t *testing.T) {
+	kv := newTestServer()
+	applyOp(kv, Op{Type: OpPut, Key: "a", Value: KVEntry{Name: "甲"}, Actor: "alice", ClientID: 1, SeqNum: 1})
+	applyOp(kv, Op{Type: OpSysPut, Key: "auth/token/x", Raw: "{}", Actor: "admin", ClientID: 1, SeqNum: 2})
+	applyOp(kv, Op{Type: OpPut, Key: "a", Value: KVEntry{Name: "甲"}, Actor: "alice", ClientID: 1, SeqNum: 1})
+	applyOp(kv, Op{Type: OpRead, ClientID: 1, SeqNum: 3})
+	applyOp(kv, Op{Type: OpDelete, Key: "a", Actor: "bob", ClientID: 1, SeqNum: 4})
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	want := []AuditRecord{
+		{Index: 1, Actor: "alice", Op: OpPut, Key: "a"},
+		{Index: 2, Actor: "admin", Op: OpSysPut, Key: "auth/token/x"},
+		{Index: 5, Actor: "bob", Op: OpDelete, Key: "a"},
+	}
+	if len(kv.audit) != len(want) {
+		t.Fatalf("audit = %+v, want %+v", kv.audit, want)
+	}
+	for i := range want {
+		if kv.audit[i] != want[i] {
+			t.Errorf("audit[%d] = %+v, want %+v", i, kv.audit[i], want[i])
+		}
+	}
+	if _, ok := kv.data.get("auth/token/x"); ok || kv.system["auth/token/x"] != "{}" {
+		t.Errorf("system key leaked into the data or was not stored")
+	}
+
+	kv.lastApplied = kv.applyingIndex
+	restored := newTestServer()
+	restored.readSnapshotLocked(kv.encodeSnapshotLocked())
+	if len(restored.audit) != 3 || restored.audit[2] != want[2] || restored.system["auth/token/x"] != "{}" {
+		t.Errorf("after a snapshot: audit = %+v, system = %v", restored.audit, restored.system)
+	}
+}
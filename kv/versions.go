@@ -0,0 +1,243 @@
+package kv
+
+import (
+	"sort"
+	"time"
+)
+
+// 默认保留最近多少条日志范围内的历史版本
+const DefaultVersionRetention = 10000
+
+// 固定的位置在这么久没有续期后自动释放，避免发起方退出后一直保留历史
+const pinTTL = 5 * time.Minute
+
+// 分页读取期间固定的历史位置：导出等操作的每一页都读取同一位置，
+// 该位置之后的历史版本在释放或过期之前不会被丢弃
+// 通过日志登记和释放，各副本一致，并保存在快照中
+type versionPin struct {
+	Index   int
+	Expires int64 // 领导者时间戳（UnixNano）
+}
+
+// 键的一个历史版本，Index 和 Time 为产生该版本的日志位置和领导者时间戳
+// Index 为 0 的版本表示开始记录历史之前已经存在的值
+type Version struct {
+	Index   int
+	Time    int64
+	Value   KVEntry
+	Deleted bool
+}
+
+// 记录键的一个新版本，调用方需持有 kv.mu
+// 第一次修改一个已有的键时，先把修改前的值作为 Index 为 0 的版本保存下来
+func (kv *KVServer) recordVersionLocked(key string, old KVEntry, existed bool, value KVEntry, deleted bool) {
+	if len(kv.versions[key]) == 0 && existed {
+		kv.versions[key] = append(kv.versions[key], Version{Value: old})
+	}
+	kv.versions[key] = append(kv.versions[key], Version{
+		Index:   kv.applyingIndex,
+		Time:    kv.clock,
+		Value:   value,
+		Deleted: deleted,
+	})
+
+	// 不做快照（maxraftstate 为 -1）时 compactVersionsLocked 不会被调用，写入时就丢弃这个键超出保留范围的版本，
+	// 其他键的旧版本在它们下一次被写入或做快照时丢弃
+	kv.advanceVersionHorizonLocked(kv.applyingIndex)
+	kv.trimVersionsLocked(key)
+}
+
+// 读取键在 asOf 位置时的值，调用方需持有 kv.mu
+func (kv *KVServer) getAsOfLocked(key string, asOf int) (KVEntry, string) {
+	if asOf < kv.versionHorizon {
+		return KVEntry{}, ErrCompacted
+	}
+
+	versions := kv.versions[key]
+	if len(versions) == 0 {
+		// 没有历史版本说明从 versionHorizon 起该键没有变化
+		if value, exists := kv.data.get(key); exists {
+			return value, ""
+		}
+		return KVEntry{}, ErrNoKey
+	}
+
+	// 找到最后一个不晚于 asOf 的版本
+	i := sort.Search(len(versions), func(i int) bool {
+		return versions[i].Index > asOf
+	}) - 1
+	if i < 0 || versions[i].Deleted {
+		return KVEntry{}, ErrNoKey
+	}
+	return versions[i].Value, ""
+}
+
+// 按键的顺序扫描 asOf 位置时存在的键值，参数和返回值与 scanLocked 相同，调用方需持有 kv.mu
+// 之后被删除的键只保存在 kv.versions 中，因此要把当前的键和有历史版本的键合并起来
+func (kv *KVServer) scanAsOfLocked(start string, limit int, asOf int, inRange func(key string) bool) ([]KeyValue, string) {
+	if limit <= 0 {
+		limit = DefaultScanLimit
+	} else if limit > MaxScanLimit {
+		limit = MaxScanLimit
+	}
+
+	var deleted []string
+	for key := range kv.versions {
+		if key >= start && inRange(key) {
+			if _, exists := kv.data.get(key); !exists {
+				deleted = append(deleted, key)
+			}
+		}
+	}
+	sort.Strings(deleted)
+
+	entries := []KeyValue{}
+	next := ""
+	// 返回 false 表示本页已满或超出范围
+	visit := func(key string) bool {
+		if !inRange(key) {
+			return false
+		}
+		value, err := kv.getAsOfLocked(key, asOf)
+		if err != "" {
+			return true
+		}
+		if len(entries) == limit {
+			next = key
+			return false
+		}
+		entries = append(entries, KeyValue{Key: key, Value: value})
+		return true
+	}
+
+	done := false
+	kv.data.ascend(start, func(key string, _ KVEntry) bool {
+		for len(deleted) > 0 && deleted[0] < key {
+			if !visit(deleted[0]) {
+				done = true
+				return false
+			}
+			deleted = deleted[1:]
+		}
+		if !visit(key) {
+			done = true
+			return false
+		}
+		return true
+	})
+	for !done && len(deleted) > 0 && visit(deleted[0]) {
+		deleted = deleted[1:]
+	}
+	return entries, next
+}
+
+// 丢弃所有键超出保留范围的历史版本，在做快照前调用，调用方需持有 kv.mu
+// 每个键保留 versionHorizon 时刻的版本，保证 asOf 不早于 versionHorizon 的读取仍然正确
+func (kv *KVServer) compactVersionsLocked() {
+	kv.advanceVersionHorizonLocked(kv.lastApplied)
+	for key := range kv.versions {
+		kv.trimVersionsLocked(key)
+	}
+}
+
+// 按保留范围和固定的位置把 versionHorizon 推进到 index 对应的位置，调用方需持有 kv.mu
+func (kv *KVServer) advanceVersionHorizonLocked(index int) {
+	horizon := index - kv.versionRetention
+	for _, pin := range kv.pins {
+		if pin.Expires > kv.clock && pin.Index < horizon {
+			horizon = pin.Index
+		}
+	}
+	if horizon > kv.versionHorizon {
+		kv.versionHorizon = horizon
+	}
+}
+
+// 丢弃一个键早于 versionHorizon 的历史版本，调用方需持有 kv.mu
+func (kv *KVServer) trimVersionsLocked(key string) {
+	versions := kv.versions[key]
+	floor := sort.Search(len(versions), func(i int) bool {
+		return versions[i].Index > kv.versionHorizon
+	}) - 1
+	if floor < 0 {
+		return
+	}
+	if floor == len(versions)-1 {
+		// 只剩下 versionHorizon 时刻的版本，它就是当前值（或已删除），不再需要单独保存
+		delete(kv.versions, key)
+		return
+	}
+	if floor > 0 {
+		kv.versions[key] = append([]Version(nil), versions[floor:]...)
+	}
+}
+
+// 登记或续期固定的位置，新登记的位置为本条日志的位置，调用方需持有 kv.mu
+func (kv *KVServer) pinVersionsLocked(id string) {
+	for other, pin := range kv.pins {
+		if pin.Expires <= kv.clock {
+			delete(kv.pins, other)
+		}
+	}
+	pin, exists := kv.pins[id]
+	if !exists {
+		pin.Index = kv.applyingIndex
+	}
+	pin.Expires = kv.clock + int64(pinTTL)
+	kv.pins[id] = pin
+}
+
+// 登记、续期或释放一个固定的位置
+// 登记也作为读屏障：返回的位置是本条日志的位置，之后用 AsOf 读取该位置能看到在它之前完成的所有写入
+func (kv *KVServer) PinVersions(args *PinVersionsArgs, reply *PinVersionsReply) {
+	if kv.killed() {
+		reply.Err = ErrWrongLeader
+		return
+	}
+
+	op := Op{
+		Type:     OpPinVersions,
+		Key:      args.ID,
+		ClientID: args.ClientID,
+		SeqNum:   args.SeqNum,
+	}
+	if args.Release {
+		op.Type = OpUnpinVersions
+	}
+	if reply.Err = kv.startOp(op); reply.Err != "" || args.Release {
+		return
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	pin, exists := kv.pins[args.ID]
+	if !exists {
+		// 应用之后又被释放
+		reply.Err = ErrCompacted
+		return
+	}
+	reply.Index = pin.Index
+}
+
+// 设置历史版本的保留范围（日志条数），下一次写入或快照时生效
+func (kv *KVServer) SetVersionRetention(n int) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.versionRetention = n
+}
+
+// 返回键的全部历史版本，以及最早可以查询的日志位置
+func (kv *KVServer) History(args *HistoryArgs, reply *HistoryReply) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	reply.Versions = append([]Version(nil), kv.versions[args.Key]...)
+	if len(reply.Versions) == 0 {
+		if value, exists := kv.data.get(args.Key); exists {
+			reply.Versions = []Version{{Value: value}}
+		}
+	}
+	reply.Horizon = kv.versionHorizon
+	reply.LastApplied = kv.lastApplied
+	reply.Err = ""
+}
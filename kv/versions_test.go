@@ -0,0 +1,91 @@
+package kv
+
+import (
+	"strconv"
+	"testing"
+)
+
+// 还没有应用到的位置不能在本节点读取，否则会把更早的值当作该位置的值返回
+func TestGetAsOfNotYetApplied(t *testing.T) {
+	kv := newTestServer()
+	kv.lastApplied = 1
+	applyOp(kv, Op{Type: OpPut, Key: "a", Value: KVEntry{Name: "1"}, ClientID: 1, SeqNum: 1})
+
+	var reply GetReply
+	kv.Get(&GetArgs{Key: "a", AsOfIndex: 1}, &reply)
+	if reply.Err != "" || reply.Value.Name != "1" {
+		t.Fatalf("Get as of 1 = %+v, want a = 1", reply)
+	}
+
+	reply = GetReply{}
+	kv.Get(&GetArgs{Key: "a", AsOfIndex: 2}, &reply)
+	if reply.Err != ErrWrongLeader {
+		t.Fatalf("Get as of an index the node has not applied returned %q, want %s", reply.Err, ErrWrongLeader)
+	}
+}
+
+// 固定的位置在释放之前不会被丢弃，释放或过期之后照常丢弃
+func TestPinnedVersionsSurviveCompaction(t *testing.T) {
+	kv := newTestServer()
+	kv.versionRetention = 0
+	start := int64(1)
+
+	apply := func(op Op) {
+		op.Time = start
+		applyOp(kv, op)
+		kv.lastApplied = kv.applyingIndex
+	}
+	apply(Op{Type: OpPut, Key: "a", Value: KVEntry{Name: "1"}, ClientID: 1, SeqNum: 1})
+	apply(Op{Type: OpPinVersions, Key: "export", ClientID: 1, SeqNum: 2})
+	pinned := kv.pins["export"].Index
+	for i := 3; i < 10; i++ {
+		apply(Op{Type: OpPut, Key: "a", Value: KVEntry{Name: "later"}, ClientID: 1, SeqNum: i})
+	}
+
+	kv.compactVersionsLocked()
+	if value, err := kv.getAsOfLocked("a", pinned); err != "" || value.Name != "1" {
+		t.Fatalf("a as of pinned index %d = %q, %q; want 1", pinned, value.Name, err)
+	}
+
+	apply(Op{Type: OpUnpinVersions, Key: "export", ClientID: 1, SeqNum: 10})
+	kv.compactVersionsLocked()
+	if _, err := kv.getAsOfLocked("a", pinned); err != ErrCompacted {
+		t.Fatalf("a as of released index %d returned %q, want %s", pinned, err, ErrCompacted)
+	}
+
+	// 没有续期的位置过期后不再阻止丢弃
+	apply(Op{Type: OpPinVersions, Key: "stale", ClientID: 1, SeqNum: 11})
+	stale := kv.pins["stale"].Index
+	start += int64(pinTTL)
+	apply(Op{Type: OpPut, Key: "a", Value: KVEntry{Name: "expired"}, ClientID: 1, SeqNum: 12})
+	kv.compactVersionsLocked()
+	if _, err := kv.getAsOfLocked("a", stale); err != ErrCompacted {
+		t.Fatalf("a as of expired pin %d returned %q, want %s", stale, err, ErrCompacted)
+	}
+}
+
+// 不做快照时写入也会丢弃超出保留范围的版本，每个键的历史不会无限增长
+func TestVersionRetentionWithoutSnapshots(t *testing.T) {
+	kv := newTestServer()
+	kv.versionRetention = 5
+
+	for i := 1; i <= 100; i++ {
+		applyOp(kv, Op{Type: OpPut, Key: "a", Value: KVEntry{Name: strconv.Itoa(i)}, ClientID: 1, SeqNum: i})
+		kv.lastApplied = kv.applyingIndex
+	}
+	if n := len(kv.versions["a"]); n > kv.versionRetention+1 {
+		t.Fatalf("%d versions of a kept, want at most %d", n, kv.versionRetention+1)
+	}
+	if kv.versionHorizon != 95 {
+		t.Fatalf("versionHorizon = %d, want 95", kv.versionHorizon)
+	}
+
+	for asOf := 95; asOf <= 100; asOf++ {
+		if value, err := kv.getAsOfLocked("a", asOf); err != "" || value.Name != strconv.Itoa(asOf) {
+			t.Fatalf("a as of %d = %q, %q; want %d", asOf, value.Name, err, asOf)
+		}
+	}
+	if _, err := kv.getAsOfLocked("a", 94); err != ErrCompacted {
+		t.Fatalf("a as of 94 returned %q, want %s", err, ErrCompacted)
+	}
+}
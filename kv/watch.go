@@ -0,0 +1,133 @@
+package kv
+
+import (
+	"course/sim"
+	"strings"
+	"time"
+)
+
+const (
+	// 每个节点在内存中保留的变更事件数量
+	maxWatchHistory = 10000
+
+	// 单次 Watch 调用最长等待时间
+	maxWatchTimeout = 30 * time.Second
+)
+
+// 变更事件的类型
+const (
+	EventPut    = "put"
+	EventDelete = "delete"
+)
+
+// 一次键的变更，Index 为产生该变更的 Raft 日志位置
+type WatchEvent struct {
+	Index int
+	Type  string
+	Key   string
+	Value KVEntry // Type 为 EventDelete 时为空
+}
+
+// 记录一次变更并唤醒等待中的 Watch，调用方需持有 kv.mu
+func (kv *KVServer) recordEventLocked(typ, key string, value KVEntry) {
+	kv.events = append(kv.events, WatchEvent{
+		Index: kv.applyingIndex,
+		Type:  typ,
+		Key:   key,
+		Value: value,
+	})
+
+	// 超过上限时按日志位置整体丢弃最旧的事件，保证保留下来的每个位置的事件都是完整的
+	if len(kv.events) > maxWatchHistory {
+		kv.historyStart = kv.events[len(kv.events)-maxWatchHistory-1].Index + 1
+		drop := 0
+		for drop < len(kv.events) && kv.events[drop].Index < kv.historyStart {
+			drop++
+		}
+		kv.events = append([]WatchEvent(nil), kv.events[drop:]...)
+	}
+
+	close(kv.watchCh)
+	kv.watchCh = make(chan struct{})
+}
+
+// 丢弃全部历史事件，之后只能从 start 开始监听，在安装快照后调用
+func (kv *KVServer) resetHistoryLocked(start int) {
+	kv.events = nil
+	kv.historyStart = start
+	close(kv.watchCh)
+	kv.watchCh = make(chan struct{})
+}
+
+// Watch 在服务端最多挂起的时间，网络传输据此放宽调用的超时
+func (args WatchArgs) CallTimeout() time.Duration {
+	if args.Timeout <= 0 || args.Timeout > maxWatchTimeout {
+		return maxWatchTimeout + time.Second
+	}
+	return args.Timeout + time.Second
+}
+
+// 监听一个键或一个前缀上的变更
+// 返回 FromIndex 及之后的事件；暂时没有事件时最多等待 Timeout
+// FromIndex 早于本节点保留的历史时返回 ErrCompacted，客户端需要重新读取全量数据
+func (kv *KVServer) Watch(args *WatchArgs, reply *WatchReply) {
+	timeout := args.Timeout
+	if timeout <= 0 || timeout > maxWatchTimeout {
+		timeout = maxWatchTimeout
+	}
+	deadline := sim.After(timeout)
+
+	match := func(key string) bool {
+		if args.Key != "" {
+			return key == args.Key
+		}
+		return strings.HasPrefix(key, args.Prefix)
+	}
+
+	// FromIndex 为 0 表示只关心从现在开始的变更
+	kv.mu.Lock()
+	from := args.FromIndex
+	if from <= 0 {
+		from = kv.lastApplied + 1
+	}
+	kv.mu.Unlock()
+
+	for {
+		kv.mu.Lock()
+		if from < kv.historyStart {
+			reply.Err = ErrCompacted
+			reply.NextIndex = kv.historyStart
+			kv.mu.Unlock()
+			return
+		}
+
+		reply.Events = nil
+		for _, event := range kv.events {
+			if event.Index >= from && match(event.Key) {
+				reply.Events = append(reply.Events, event)
+			}
+		}
+		reply.NextIndex = kv.lastApplied + 1
+		if reply.NextIndex < from {
+			reply.NextIndex = from
+		}
+		wait := kv.watchCh
+		kv.mu.Unlock()
+
+		// 已经停止的节点不会再应用日志，让客户端换一个节点继续监听
+		if kv.killed() {
+			reply.Events = nil
+			reply.Err = ErrWrongLeader
+			return
+		}
+		if len(reply.Events) > 0 {
+			reply.Err = ""
+			return
+		}
+
+		if _, _, changed := sim.RecvUntil(wait, deadline); !changed {
+			reply.Err = ""
+			return
+		}
+	}
+}
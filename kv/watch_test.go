@@ -0,0 +1,66 @@
+package kv
+
+import (
+	"testing"
+	"time"
+)
+
+// 从上次返回的 NextIndex 继续监听只得到之后的事件，早于保留历史的位置返回 ErrCompacted
+func TestWatchResume(t *testing.T) {
+	kv := newTestServer()
+	apply := func(op Op) {
+		applyOp(kv, op)
+		kv.lastApplied = kv.applyingIndex
+	}
+	apply(Op{Type: OpPut, Key: "s/1", Value: KVEntry{Name: "a"}, ClientID: 1, SeqNum: 1})
+	apply(Op{Type: OpPut, Key: "t/1", Value: KVEntry{Name: "b"}, ClientID: 1, SeqNum: 2})
+	apply(Op{Type: OpPut, Key: "s/2", Value: KVEntry{Name: "c"}, ClientID: 1, SeqNum: 3})
+
+	var reply WatchReply
+	kv.Watch(&WatchArgs{Prefix: "s/", FromIndex: 1, Timeout: time.Millisecond}, &reply)
+	if reply.Err != "" || len(reply.Events) != 2 || reply.Events[0].Key != "s/1" || reply.Events[1].Key != "s/2" {
+		t.Fatalf("Watch s/ from 1 = %+v, want s/1 and s/2", reply)
+	}
+	if reply.NextIndex != 4 {
+		t.Fatalf("NextIndex = %d, want 4", reply.NextIndex)
+	}
+
+	apply(Op{Type: OpDelete, Key: "s/1", ClientID: 1, SeqNum: 4})
+	next := reply.NextIndex
+	reply = WatchReply{}
+	kv.Watch(&WatchArgs{Prefix: "s/", FromIndex: next, Timeout: time.Millisecond}, &reply)
+	if reply.Err != "" || len(reply.Events) != 1 || reply.Events[0].Type != EventDelete || reply.Events[0].Key != "s/1" {
+		t.Fatalf("Watch s/ from %d = %+v, want only the delete of s/1", next, reply)
+	}
+
+	// 没有新事件时等到超时，返回同一个位置
+	next = reply.NextIndex
+	reply = WatchReply{}
+	kv.Watch(&WatchArgs{Key: "s/2", FromIndex: next, Timeout: time.Millisecond}, &reply)
+	if reply.Err != "" || len(reply.Events) != 0 || reply.NextIndex != next {
+		t.Fatalf("idle Watch from %d = %+v", next, reply)
+	}
+
+	kv.mu.Lock()
+	kv.resetHistoryLocked(next)
+	kv.mu.Unlock()
+	reply = WatchReply{}
+	kv.Watch(&WatchArgs{Prefix: "s/", FromIndex: 1, Timeout: time.Millisecond}, &reply)
+	if reply.Err != ErrCompacted || reply.NextIndex != next {
+		t.Fatalf("Watch from a discarded index = %+v, want %s at %d", reply, ErrCompacted, next)
+	}
+}
+
+// 停止的节点返回 ErrWrongLeader，客户端换一个节点继续监听
+func TestWatchKilledNode(t *testing.T) {
+	kv := newTestServer()
+	applyOp(kv, Op{Type: OpPut, Key: "a", Value: KVEntry{Name: "1"}, ClientID: 1, SeqNum: 1})
+	kv.lastApplied = kv.applyingIndex
+	kv.dead = 1
+
+	var reply WatchReply
+	kv.Watch(&WatchArgs{Key: "a", FromIndex: 1, Timeout: time.Second}, &reply)
+	if reply.Err != ErrWrongLeader || len(reply.Events) != 0 {
+		t.Fatalf("Watch on a killed node = %+v, want %s", reply, ErrWrongLeader)
+	}
+}
@@ -52,8 +52,9 @@ package labrpc
 import (
 	"bytes"
 	"course/labgob"
+	"course/sim"
+	"fmt"
 	"log"
-	"math/rand"
 	"reflect"
 	"strings"
 	"sync"
@@ -67,6 +68,7 @@ type reqMsg struct {
 	argsType reflect.Type
 	args     []byte
 	replyCh  chan replyMsg
+	rand     *sim.Rand // the sending end's stream
 }
 
 type replyMsg struct {
@@ -78,6 +80,7 @@ type ClientEnd struct {
 	endname interface{}   // this end-point's name
 	ch      chan reqMsg   // copy of Network.endCh
 	done    chan struct{} // closed when Network is cleaned up
+	rand    *sim.Rand     // delays and drops for this end's RPCs
 }
 
 // send an RPC, wait for the reply.
@@ -87,8 +90,9 @@ func (e *ClientEnd) Call(svcMeth string, args interface{}, reply interface{}) bo
 	req := reqMsg{}
 	req.endname = e.endname
 	req.svcMeth = svcMeth
+	req.rand = e.rand
 	req.argsType = reflect.TypeOf(args)
-	req.replyCh = make(chan replyMsg)
+	req.replyCh = make(chan replyMsg, 1) // buffered: under a virtual clock both ends poll
 
 	qb := new(bytes.Buffer)
 	qe := labgob.NewEncoder(qb)
@@ -100,10 +104,7 @@ func (e *ClientEnd) Call(svcMeth string, args interface{}, reply interface{}) bo
 	//
 	// send the request.
 	//
-	select {
-	case e.ch <- req:
-		// the request has been sent.
-	case <-e.done:
+	if !sim.SendUntil(e.ch, req, e.done) {
 		// entire Network has been destroyed.
 		return false
 	}
@@ -111,7 +112,7 @@ func (e *ClientEnd) Call(svcMeth string, args interface{}, reply interface{}) bo
 	//
 	// wait for the reply.
 	//
-	rep := <-req.replyCh
+	rep, _ := sim.Recv(req.replyCh)
 	if rep.ok {
 		rb := bytes.NewBuffer(rep.reply)
 		rd := labgob.NewDecoder(rb)
@@ -133,6 +134,7 @@ type Network struct {
 	enabled        map[interface{}]bool        // by end name
 	servers        map[interface{}]*Server     // servers, by name
 	connections    map[interface{}]interface{} // endname -> servername
+	dropRates      map[interface{}]float64     // servername -> fraction of its requests and replies to drop
 	endCh          chan reqMsg
 	done           chan struct{} // closed when Network is cleaned up
 	count          int32         // total RPC count, for statistics
@@ -146,22 +148,22 @@ func MakeNetwork() *Network {
 	rn.enabled = map[interface{}]bool{}
 	rn.servers = map[interface{}]*Server{}
 	rn.connections = map[interface{}](interface{}){}
-	rn.endCh = make(chan reqMsg)
+	rn.dropRates = map[interface{}]float64{}
+	rn.endCh = make(chan reqMsg, 1)
 	rn.done = make(chan struct{})
 
 	// single goroutine to handle all ClientEnd.Call()s
-	go func() {
+	sim.Go(func() {
 		for {
-			select {
-			case xreq := <-rn.endCh:
-				atomic.AddInt32(&rn.count, 1)
-				atomic.AddInt64(&rn.bytes, int64(len(xreq.args)))
-				go rn.processReq(xreq)
-			case <-rn.done:
+			xreq, _, received := sim.RecvUntil(rn.endCh, rn.done)
+			if !received {
 				return
 			}
+			atomic.AddInt32(&rn.count, 1)
+			atomic.AddInt64(&rn.bytes, int64(len(xreq.args)))
+			sim.Go(func() { rn.processReq(xreq) })
 		}
-	}()
+	})
 
 	return rn
 }
@@ -191,8 +193,22 @@ func (rn *Network) LongDelays(yes bool) {
 	rn.longDelays = yes
 }
 
+// drop a fraction (0 to 1) of the requests sent to a server and
+// of its replies, independently of Reliable(). 0 turns it off.
+func (rn *Network) SetDropRate(servername interface{}, rate float64) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if rate <= 0 {
+		delete(rn.dropRates, servername)
+	} else {
+		rn.dropRates[servername] = rate
+	}
+}
+
 func (rn *Network) readEndnameInfo(endname interface{}) (enabled bool,
 	servername interface{}, server *Server, reliable bool, longreordering bool,
+	droprate float64,
 ) {
 	rn.mu.Lock()
 	defer rn.mu.Unlock()
@@ -201,6 +217,7 @@ func (rn *Network) readEndnameInfo(endname interface{}) (enabled bool,
 	servername = rn.connections[endname]
 	if servername != nil {
 		server = rn.servers[servername]
+		droprate = rn.dropRates[servername]
 	}
 	reliable = rn.reliable
 	longreordering = rn.longReordering
@@ -218,18 +235,18 @@ func (rn *Network) isServerDead(endname interface{}, servername interface{}, ser
 }
 
 func (rn *Network) processReq(req reqMsg) {
-	enabled, servername, server, reliable, longreordering := rn.readEndnameInfo(req.endname)
+	enabled, servername, server, reliable, longreordering, droprate := rn.readEndnameInfo(req.endname)
 
 	if enabled && servername != nil && server != nil {
 		if reliable == false {
 			// short delay
-			ms := (rand.Int() % 27)
-			time.Sleep(time.Duration(ms) * time.Millisecond)
+			ms := req.rand.Intn(27)
+			sim.Sleep(time.Duration(ms) * time.Millisecond)
 		}
 
-		if reliable == false && (rand.Int()%1000) < 100 {
+		if (reliable == false && req.rand.Intn(1000) < 100) || (droprate > 0 && req.rand.Float64() < droprate) {
 			// drop the request, return as if timeout
-			req.replyCh <- replyMsg{false, nil}
+			sim.Send(req.replyCh, replyMsg{false, nil})
 			return
 		}
 
@@ -237,11 +254,13 @@ func (rn *Network) processReq(req reqMsg) {
 		// in a separate thread so that we can periodically check
 		// if the server has been killed and the RPC should get a
 		// failure reply.
-		ech := make(chan replyMsg)
-		go func() {
+		// buffered, so that the handler can finish even if
+		// nobody waits for it any more.
+		ech := make(chan replyMsg, 1)
+		sim.Go(func() {
 			r := server.dispatch(req)
-			ech <- r
-		}()
+			sim.Send(ech, r)
+		})
 
 		// wait for handler to return,
 		// but stop waiting if DeleteServer() has been called,
@@ -250,16 +269,9 @@ func (rn *Network) processReq(req reqMsg) {
 		replyOK := false
 		serverDead := false
 		for replyOK == false && serverDead == false {
-			select {
-			case reply = <-ech:
-				replyOK = true
-			case <-time.After(100 * time.Millisecond):
+			reply, _, replyOK = sim.RecvUntil(ech, sim.After(100*time.Millisecond))
+			if !replyOK {
 				serverDead = rn.isServerDead(req.endname, servername, server)
-				if serverDead {
-					go func() {
-						<-ech // drain channel to let the goroutine created earlier terminate
-					}()
-				}
 			}
 		}
 
@@ -273,38 +285,41 @@ func (rn *Network) processReq(req reqMsg) {
 
 		if replyOK == false || serverDead == true {
 			// server was killed while we were waiting; return error.
-			req.replyCh <- replyMsg{false, nil}
-		} else if reliable == false && (rand.Int()%1000) < 100 {
+			sim.Send(req.replyCh, replyMsg{false, nil})
+		} else if (reliable == false && req.rand.Intn(1000) < 100) || (droprate > 0 && req.rand.Float64() < droprate) {
 			// drop the reply, return as if timeout
-			req.replyCh <- replyMsg{false, nil}
-		} else if longreordering == true && rand.Intn(900) < 600 {
+			sim.Send(req.replyCh, replyMsg{false, nil})
+		} else if longreordering == true && req.rand.Intn(900) < 600 {
 			// delay the response for a while
-			ms := 200 + rand.Intn(1+rand.Intn(2000))
+			ms := 200 + req.rand.Intn(1+req.rand.Intn(2000))
 			// Russ points out that this timer arrangement will decrease
 			// the number of goroutines, so that the race
 			// detector is less likely to get upset.
-			time.AfterFunc(time.Duration(ms)*time.Millisecond, func() {
+			sim.AfterFunc(time.Duration(ms)*time.Millisecond, func() {
 				atomic.AddInt64(&rn.bytes, int64(len(reply.reply)))
-				req.replyCh <- reply
+				sim.Send(req.replyCh, reply)
 			})
 		} else {
 			atomic.AddInt64(&rn.bytes, int64(len(reply.reply)))
-			req.replyCh <- reply
+			sim.Send(req.replyCh, reply)
 		}
 	} else {
 		// simulate no reply and eventual timeout.
 		ms := 0
-		if rn.longDelays {
+		rn.mu.Lock()
+		longDelays := rn.longDelays
+		rn.mu.Unlock()
+		if longDelays {
 			// let Raft tests check that leader doesn't send
 			// RPCs synchronously.
-			ms = (rand.Int() % 7000)
+			ms = req.rand.Intn(7000)
 		} else {
 			// many kv tests require the client to try each
 			// server in fairly rapid succession.
-			ms = (rand.Int() % 100)
+			ms = req.rand.Intn(100)
 		}
-		time.AfterFunc(time.Duration(ms)*time.Millisecond, func() {
-			req.replyCh <- replyMsg{false, nil}
+		sim.AfterFunc(time.Duration(ms)*time.Millisecond, func() {
+			sim.Send(req.replyCh, replyMsg{false, nil})
 		})
 	}
 
@@ -324,6 +339,7 @@ func (rn *Network) MakeEnd(endname interface{}) *ClientEnd {
 	e.endname = endname
 	e.ch = rn.endCh
 	e.done = rn.done
+	e.rand = sim.NewRand(fmt.Sprintf("labrpc-%v", endname))
 	rn.ends[endname] = e
 	rn.enabled[endname] = false
 	rn.connections[endname] = nil
@@ -0,0 +1,300 @@
+// Package linearizability 检查一段并发操作的历史是否可线性化
+//
+// 算法与 Porcupine 相同：按调用和返回的时间排序后回溯搜索一个合法的线性化顺序（Wing & Gong），
+// 并缓存已经搜索过的“已线性化的操作集合 + 模型状态”，避免重复搜索（Lowe）。
+// 模型可以把历史按键等划分成互不影响的部分分别检查，大大缩小搜索空间。
+//
+// 历史不可线性化时，报告最长的可线性化前缀之后卡住的那段时间窗口：
+// 窗口中的每个操作都无法作为下一个被线性化的操作
+package linearizability
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// 一次操作，Call 和 Return 为调用和返回的时间，单位任意但要一致
+// 结果未知的操作（例如超时的写入）Return 为 Pending，它可以在调用之后的任何时刻生效，也可以不生效
+type Operation struct {
+	ClientID int
+	Input    interface{}
+	Call     int64
+	Output   interface{}
+	Return   int64
+}
+
+// 结果未知的操作的返回时间
+const Pending = math.MaxInt64
+
+// 被检查的系统的顺序规约
+type Model struct {
+	// 把历史分成互不影响的部分分别检查，例如按键划分；为 nil 时整体检查
+	Partition func(history []Operation) [][]Operation
+	// 初始状态
+	Init func() interface{}
+	// 在状态 state 上执行输入为 input 的操作，返回结果是否可能为 output，以及执行后的状态
+	Step func(state, input, output interface{}) (bool, interface{})
+	// 比较两个状态，为 nil 时用 == 比较
+	Equal func(a, b interface{}) bool
+	// 描述一个操作，用于报告
+	Describe func(input, output interface{}) string
+}
+
+type Result string
+
+const (
+	Ok      Result = "ok"
+	Illegal Result = "illegal"
+	Unknown Result = "unknown" // 超时前没有检查完
+)
+
+// 第一个违反可线性化的窗口
+type Violation struct {
+	Partition  int         // 所在的划分，按 Model.Partition 返回的顺序
+	Linearized []Operation // 最长的可线性化前缀中最后几个操作，按线性化顺序
+	Stuck      []Operation // 在这之后无法线性化的操作，按调用时间排序
+	Start, End int64       // 窗口的起止时间：Stuck 中最早的调用时间和卡住时最早的返回时间
+}
+
+type Report struct {
+	Result     Result
+	Violation  *Violation // Result 为 Illegal 时不为 nil
+	Partitions int
+	Operations int
+	Elapsed    time.Duration
+}
+
+// 报告中保留的已线性化操作的数量
+const contextOps = 5
+
+// 检查 history 是否可线性化，超过 timeout 时返回 Unknown，timeout 为 0 表示不限时间
+// 有多个划分不可线性化时，报告窗口开始得最早的那个
+func Check(model Model, history []Operation, timeout time.Duration) Report {
+	start := time.Now()
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = start.Add(timeout)
+	}
+
+	partitions := [][]Operation{history}
+	if model.Partition != nil {
+		partitions = model.Partition(history)
+	}
+
+	report := Report{Result: Ok, Partitions: len(partitions), Operations: len(history)}
+	for i, ops := range partitions {
+		result, violation := checkPartition(model, ops, deadline)
+		switch result {
+		case Illegal:
+			violation.Partition = i
+			if report.Violation == nil || violation.Start < report.Violation.Start {
+				report.Violation = violation
+			}
+			report.Result = Illegal
+		case Unknown:
+			if report.Result == Ok {
+				report.Result = Unknown
+			}
+		}
+	}
+	report.Elapsed = time.Since(start)
+	return report
+}
+
+// 排序后的调用或返回事件，组成一个双向链表；调用事件的 match 指向对应的返回事件
+type entry struct {
+	id    int
+	time  int64
+	match *entry // 调用事件指向返回事件，返回事件为 nil
+	prev  *entry
+	next  *entry
+}
+
+func makeEntries(ops []Operation) *entry {
+	type event struct {
+		id     int
+		time   int64
+		isCall bool
+	}
+	events := make([]event, 0, 2*len(ops))
+	for i, op := range ops {
+		events = append(events, event{i, op.Call, true}, event{i, op.Return, false})
+	}
+	// 时间相同时调用排在返回之前，把这样的两个操作视为并发，不会误报
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].time != events[j].time {
+			return events[i].time < events[j].time
+		}
+		return events[i].isCall && !events[j].isCall
+	})
+
+	head := &entry{id: -1}
+	calls := make([]*entry, len(ops))
+	prev := head
+	for _, ev := range events {
+		e := &entry{id: ev.id, time: ev.time, prev: prev}
+		prev.next = e
+		prev = e
+		if ev.isCall {
+			calls[ev.id] = e
+		} else {
+			calls[ev.id].match = e
+		}
+	}
+	return head
+}
+
+// 从链表中摘下调用事件和它的返回事件
+func (e *entry) lift() {
+	e.prev.next = e.next
+	if e.next != nil {
+		e.next.prev = e.prev
+	}
+	m := e.match
+	m.prev.next = m.next
+	if m.next != nil {
+		m.next.prev = m.prev
+	}
+}
+
+// 把 lift 摘下的事件放回原处，必须按与 lift 相反的顺序调用
+func (e *entry) unlift() {
+	m := e.match
+	m.prev.next = m
+	if m.next != nil {
+		m.next.prev = m
+	}
+	e.prev.next = e
+	if e.next != nil {
+		e.next.prev = e
+	}
+}
+
+type bitset []uint64
+
+func newBitset(n int) bitset { return make(bitset, (n+63)/64) }
+
+func (b bitset) set(i int) bitset   { b[i/64] |= 1 << (uint(i) % 64); return b }
+func (b bitset) clear(i int) bitset { b[i/64] &^= 1 << (uint(i) % 64); return b }
+
+func (b bitset) clone() bitset {
+	c := make(bitset, len(b))
+	copy(c, b)
+	return c
+}
+
+func (b bitset) equals(c bitset) bool {
+	for i := range b {
+		if b[i] != c[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (b bitset) hash() uint64 {
+	h := uint64(14695981039346656037)
+	for _, w := range b {
+		h ^= w
+		h *= 1099511628211
+	}
+	return h
+}
+
+type cacheEntry struct {
+	linearized bitset
+	state      interface{}
+}
+
+type frame struct {
+	call  *entry
+	state interface{}
+}
+
+func checkPartition(model Model, ops []Operation, deadline time.Time) (Result, *Violation) {
+	equal := model.Equal
+	if equal == nil {
+		equal = func(a, b interface{}) bool { return a == b }
+	}
+
+	head := makeEntries(ops)
+	linearized := newBitset(len(ops))
+	cache := make(map[uint64][]cacheEntry)
+	seen := func(b bitset, state interface{}) bool {
+		for _, c := range cache[b.hash()] {
+			if c.linearized.equals(b) && equal(c.state, state) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var stack []frame
+	var best *Violation
+	bestLen := -1
+	state := model.Init()
+	e := head.next
+	for steps := 0; head.next != nil; steps++ {
+		if !deadline.IsZero() && steps%1000 == 0 && time.Now().After(deadline) {
+			return Unknown, nil
+		}
+
+		if e.match != nil {
+			// 尝试把这个操作作为下一个被线性化的操作
+			ok, next := model.Step(state, ops[e.id].Input, ops[e.id].Output)
+			if ok {
+				b := linearized.clone().set(e.id)
+				if !seen(b, next) {
+					h := b.hash()
+					cache[h] = append(cache[h], cacheEntry{b, next})
+					stack = append(stack, frame{e, state})
+					state = next
+					linearized.set(e.id)
+					e.lift()
+					e = head.next
+					continue
+				}
+			}
+			e = e.next
+			continue
+		}
+
+		// 遇到了一个返回事件，它的操作必须在这之前被线性化，但之前的操作都试过了
+		if len(stack) > bestLen {
+			bestLen = len(stack)
+			best = stuckWindow(ops, head, e, stack)
+		}
+		if len(stack) == 0 {
+			return Illegal, best
+		}
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		state = top.state
+		linearized.clear(top.call.id)
+		top.call.unlift()
+		e = top.call.next
+	}
+	return Ok, nil
+}
+
+// 记录卡住时的窗口：链表中 ret 之前的调用事件都是当时可以作为下一个线性化的候选
+func stuckWindow(ops []Operation, head, ret *entry, stack []frame) *Violation {
+	v := &Violation{Start: math.MaxInt64, End: ret.time}
+	for e := head.next; e != ret; e = e.next {
+		if e.match != nil {
+			v.Stuck = append(v.Stuck, ops[e.id])
+			if e.time < v.Start {
+				v.Start = e.time
+			}
+		}
+	}
+	from := len(stack) - contextOps
+	if from < 0 {
+		from = 0
+	}
+	for _, f := range stack[from:] {
+		v.Linearized = append(v.Linearized, ops[f.call.id])
+	}
+	return v
+}
@@ -0,0 +1,109 @@
+package linearizability
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// 一个整数寄存器，Input 为 nil 表示读
+var registerModel = Model{
+	Init: func() interface{} { return 0 },
+	Step: func(state, input, output interface{}) (bool, interface{}) {
+		if input != nil {
+			return true, input
+		}
+		return output == state, state
+	},
+	Describe: func(input, output interface{}) string {
+		if input != nil {
+			return fmt.Sprintf("put %v", input)
+		}
+		return fmt.Sprintf("get -> %v", output)
+	},
+}
+
+func put(client int, value int, call, ret int64) Operation {
+	return Operation{ClientID: client, Input: value, Call: call, Return: ret}
+}
+
+func get(client int, value int, call, ret int64) Operation {
+	return Operation{ClientID: client, Output: value, Call: call, Return: ret}
+}
+
+func TestConcurrentLinearizable(t *testing.T) {
+	// 读与两个写都重叠，可以排在它们之间
+	history := []Operation{
+		put(0, 1, 0, 10),
+		put(1, 2, 5, 15),
+		get(2, 1, 6, 12),
+		get(2, 2, 16, 20),
+	}
+	if r := Check(registerModel, history, time.Second); r.Result != Ok {
+		t.Fatalf("result = %s, want ok", r.Result)
+	}
+}
+
+func TestStaleRead(t *testing.T) {
+	// put 2 返回之后才开始的读不能读到旧值
+	history := []Operation{
+		put(0, 1, 0, 10),
+		put(1, 2, 11, 20),
+		get(2, 1, 21, 30),
+	}
+	r := Check(registerModel, history, time.Second)
+	if r.Result != Illegal {
+		t.Fatalf("result = %s, want illegal", r.Result)
+	}
+	v := r.Violation
+	if len(v.Stuck) != 1 || v.Stuck[0].ClientID != 2 {
+		t.Fatalf("stuck operations = %+v, want the read of client 2", v.Stuck)
+	}
+	if v.Start != 21 {
+		t.Fatalf("window starts at %d, want 21", v.Start)
+	}
+}
+
+func TestPendingPut(t *testing.T) {
+	// 结果未知的写入可以在调用之后的任何时候生效，也可以从未生效
+	history := []Operation{
+		put(0, 1, 0, 10),
+		put(1, 2, 11, Pending),
+		get(2, 1, 20, 30),
+		get(2, 2, 40, 50),
+	}
+	if r := Check(registerModel, history, time.Second); r.Result != Ok {
+		t.Fatalf("result = %s, want ok", r.Result)
+	}
+
+	// 但生效之后不能再读到之前的值
+	history = append(history, get(2, 1, 60, 70))
+	if r := Check(registerModel, history, time.Second); r.Result != Illegal {
+		t.Fatalf("result = %s, want illegal", r.Result)
+	}
+}
+
+func TestPartitions(t *testing.T) {
+	// 按客户端划分时，每个客户端只看到自己的写入
+	model := registerModel
+	model.Partition = func(history []Operation) [][]Operation {
+		byClient := make(map[int][]Operation)
+		for _, op := range history {
+			byClient[op.ClientID] = append(byClient[op.ClientID], op)
+		}
+		return [][]Operation{byClient[0], byClient[1]}
+	}
+	history := []Operation{
+		put(0, 1, 0, 10),
+		put(1, 2, 0, 10),
+		get(0, 1, 20, 30),
+		get(1, 1, 40, 50),
+	}
+	r := Check(model, history, time.Second)
+	if r.Result != Illegal || r.Partitions != 2 {
+		t.Fatalf("result = %s over %d partitions, want illegal over 2", r.Result, r.Partitions)
+	}
+	if r.Violation.Partition != 1 {
+		t.Fatalf("violation in partition %d, want 1", r.Violation.Partition)
+	}
+}
@@ -0,0 +1,133 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ANSI 颜色，只在输出到终端时使用
+const (
+	reset   = "\033[0m"
+	red     = "\033[31m"
+	yellow  = "\033[33m"
+	green   = "\033[32m"
+	blue    = "\033[34m"
+	cyan    = "\033[36m"
+	dimGray = "\033[90m"
+)
+
+// 输出到终端时使用的处理器，每条日志一行：时间、级别、主题、消息和字段，级别和主题带颜色
+type consoleHandler struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string // 通过 WithGroup 设置的字段前缀
+	attrs  []byte // 通过 WithAttrs 附加的字段，已经格式化
+	topic  string
+}
+
+func newConsoleHandler(w io.Writer) *consoleHandler {
+	return &consoleHandler{mu: new(sync.Mutex), w: w}
+}
+
+func (h *consoleHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func levelColor(l slog.Level) string {
+	switch {
+	case l >= slog.LevelError:
+		return red
+	case l >= slog.LevelWarn:
+		return yellow
+	case l >= slog.LevelInfo:
+		return green
+	}
+	return blue
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var buf bytes.Buffer
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	buf.WriteString(dimGray + t.Format("15:04:05.000") + reset + " ")
+	fmt.Fprintf(&buf, "%s%-5s%s ", levelColor(r.Level), r.Level.String(), reset)
+	if h.topic != "" {
+		fmt.Fprintf(&buf, "%s%-9s%s ", cyan, h.topic, reset)
+	}
+	buf.WriteString(r.Message)
+	buf.Write(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&buf, h.prefix, a)
+		return true
+	})
+	buf.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf.Bytes())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append([]byte(nil), h.attrs...)
+	for _, a := range attrs {
+		// 主题单独显示在级别后面
+		if a.Key == "topic" && h.prefix == "" {
+			h2.topic = a.Value.String()
+			continue
+		}
+		buf := bytes.NewBuffer(h2.attrs)
+		appendAttr(buf, h.prefix, a)
+		h2.attrs = buf.Bytes()
+	}
+	return &h2
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.prefix = h.prefix + name + "."
+	return &h2
+}
+
+func appendAttr(buf *bytes.Buffer, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		p := prefix
+		if a.Key != "" {
+			p += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(buf, p, ga)
+		}
+		return
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(dimGray + prefix + a.Key + "=" + reset)
+	s := a.Value.String()
+	if needsQuote(s) {
+		s = strconv.Quote(s)
+	}
+	buf.WriteString(s)
+}
+
+func needsQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, c := range s {
+		if c <= ' ' || c == '"' || c == '=' || c > '~' {
+			return true
+		}
+	}
+	return false
+}
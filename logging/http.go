@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// 返回查看和修改各主题级别的 HTTP 处理器
+//
+//	GET 返回 {"levels": {"raft": "info", ...}}
+//	PUT 请求体为 {"raft": "debug", "*": "warn"}，* 表示所有主题，返回修改后的级别
+//
+// 处理器本身不做认证，由调用方决定谁可以访问
+func LevelsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var request map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request) == 0 {
+				writeError(w, "Request body must be an object mapping topics to levels", http.StatusBadRequest)
+				return
+			}
+			// 全部检查通过后再修改，避免只修改了一部分
+			spec := ""
+			for topic, name := range request {
+				if topic != "*" && !Known(topic) {
+					writeError(w, fmt.Sprintf("Unknown topic %q", topic), http.StatusBadRequest)
+					return
+				}
+				if _, err := ParseLevel(name); err != nil {
+					writeError(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				spec += topic + "=" + name + ","
+			}
+			SetLevels(spec)
+			Logger(TopicMain).InfoContext(r.Context(), "Log levels changed", "levels", request)
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			writeError(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"levels": Levels()})
+	})
+}
+
+func writeError(w http.ResponseWriter, msg string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,303 @@
+// Package logging 基于 log/slog 输出结构化日志
+//
+// 每个子系统（raft、kv、client、gateway 等）使用自己的主题，各主题的级别可以在运行时单独调整。
+// 输出格式为 text 或 json；text 格式只在输出到终端时带颜色。
+//
+// 启动时读取以下环境变量，程序也可以通过 Setup 覆盖：
+//
+//	LOG_FORMAT  text（默认）或 json
+//	LOG_LEVEL   全局级别（如 debug），或按主题设置（如 raft=debug,client=warn）
+//	VERBOSE     兼容旧的 raft 调试开关：0、1 为 debug，2 为 warn，3 为 error
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 关闭一个主题的全部日志
+const LevelOff = slog.Level(12)
+
+// 内置的主题，其他主题在第一次使用时自动创建
+const (
+	TopicRaft        = "raft"
+	TopicKV          = "kv"
+	TopicClient      = "client"
+	TopicGateway     = "gateway"
+	TopicTransport   = "transport"
+	TopicShardKV     = "shardkv"
+	TopicShardCtrler = "shardctrler"
+	TopicMain        = "main"
+)
+
+var (
+	mu           sync.Mutex
+	levels       = make(map[string]*slog.LevelVar)
+	defaultLevel = slog.LevelInfo
+
+	// 当前的输出处理器，Setup 时替换
+	base atomic.Pointer[slog.Handler]
+)
+
+func init() {
+	for _, topic := range []string{TopicRaft, TopicKV, TopicClient, TopicGateway, TopicTransport, TopicShardKV, TopicShardCtrler, TopicMain} {
+		levelVar(topic)
+	}
+	if v := os.Getenv("VERBOSE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			levelVar(TopicRaft).Set(verboseLevel(n))
+		}
+	}
+	if err := Setup(os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL")); err != nil {
+		fmt.Fprintf(os.Stderr, "logging: %v\n", err)
+	}
+}
+
+// 旧的 VERBOSE 取值对应的级别
+func verboseLevel(n int) slog.Level {
+	switch {
+	case n <= 1:
+		return slog.LevelDebug
+	case n == 2:
+		return slog.LevelWarn
+	case n == 3:
+		return slog.LevelError
+	}
+	return LevelOff
+}
+
+// 设置输出格式和级别，format 为空时使用 text，spec 为空时不改变级别
+// 同时把标准库 log 的输出转到 main 主题
+func Setup(format, spec string) error {
+	if err := SetOutput(os.Stderr, format); err != nil {
+		return err
+	}
+	if spec != "" {
+		if err := SetLevels(spec); err != nil {
+			return err
+		}
+	}
+	slog.SetDefault(Logger(TopicMain))
+	return nil
+}
+
+// 把日志写到 w，format 为 text 或 json
+func SetOutput(w io.Writer, format string) error {
+	var h slog.Handler
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug} // 级别由各主题自己判断
+	switch format {
+	case "", "text":
+		if useColor(w) {
+			h = newConsoleHandler(w)
+		} else {
+			h = slog.NewTextHandler(w, opts)
+		}
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q, want text or json", format)
+	}
+	base.Store(&h)
+	return nil
+}
+
+// 输出到终端且没有设置 NO_COLOR 时使用颜色
+func useColor(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func levelVar(topic string) *slog.LevelVar {
+	mu.Lock()
+	defer mu.Unlock()
+	lv, ok := levels[topic]
+	if !ok {
+		lv = new(slog.LevelVar)
+		lv.Set(defaultLevel)
+		levels[topic] = lv
+	}
+	return lv
+}
+
+// 返回一个主题的日志记录器
+func Logger(topic string) *slog.Logger {
+	return slog.New(&topicHandler{topic: topic, level: levelVar(topic)})
+}
+
+// 判断一个主题是否会输出某个级别的日志，用于避免拼接不会输出的消息
+func Enabled(topic string, level slog.Level) bool {
+	return level >= levelVar(topic).Level()
+}
+
+// 解析级别名称：debug、info、warn、error 或 off
+func ParseLevel(s string) (slog.Level, error) {
+	if strings.EqualFold(s, "off") {
+		return LevelOff, nil
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q, want debug, info, warn, error or off", s)
+	}
+	return l, nil
+}
+
+func levelName(l slog.Level) string {
+	if l >= LevelOff {
+		return "off"
+	}
+	return strings.ToLower(l.String())
+}
+
+// 设置一个主题的级别，topic 为 * 时设置所有主题以及之后新建的主题
+func SetLevel(topic string, level slog.Level) {
+	if topic != "*" {
+		levelVar(topic).Set(level)
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	defaultLevel = level
+	for _, lv := range levels {
+		lv.Set(level)
+	}
+}
+
+// 按 "debug" 或 "raft=debug,client=warn" 的格式设置级别，不带主题的一项作用于所有主题
+func SetLevels(spec string) error {
+	type setting struct {
+		topic string
+		level slog.Level
+	}
+	var settings []setting
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		topic, name, found := strings.Cut(item, "=")
+		if !found {
+			topic, name = "*", item
+		}
+		level, err := ParseLevel(strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+		settings = append(settings, setting{strings.TrimSpace(topic), level})
+	}
+	// 全局设置先生效，按主题的设置覆盖它
+	sort.SliceStable(settings, func(i, j int) bool { return settings[i].topic == "*" && settings[j].topic != "*" })
+	for _, s := range settings {
+		SetLevel(s.topic, s.level)
+	}
+	return nil
+}
+
+// 返回所有主题当前的级别
+func Levels() map[string]string {
+	mu.Lock()
+	defer mu.Unlock()
+	result := make(map[string]string, len(levels))
+	for topic, lv := range levels {
+		result[topic] = levelName(lv.Level())
+	}
+	return result
+}
+
+// 判断主题是否已经存在
+func Known(topic string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	_, ok := levels[topic]
+	return ok
+}
+
+type requestIDKey struct{}
+
+// 返回带有请求 ID 的 context，用它记录的日志会带上 request_id 字段
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// 返回 context 中的请求 ID，没有时返回空字符串
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// 按主题的级别过滤日志，并加上 topic 和 request_id 字段后交给当前的输出处理器
+type topicHandler struct {
+	topic string
+	level *slog.LevelVar
+	// 通过 WithAttrs 和 WithGroup 附加的字段，输出处理器替换后需要重新附加
+	chain []func(slog.Handler) slog.Handler
+
+	cache atomic.Pointer[cachedHandler]
+}
+
+type cachedHandler struct {
+	base *slog.Handler
+	h    slog.Handler
+}
+
+func (h *topicHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *topicHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.handler().Handle(ctx, r)
+}
+
+// 返回附加了字段的输出处理器，输出处理器没有变化时复用上一次的结果
+func (h *topicHandler) handler() slog.Handler {
+	b := base.Load()
+	if c := h.cache.Load(); c != nil && c.base == b {
+		return c.h
+	}
+	out := (*b).WithAttrs([]slog.Attr{slog.String("topic", h.topic)})
+	for _, f := range h.chain {
+		out = f(out)
+	}
+	h.cache.Store(&cachedHandler{base: b, h: out})
+	return out
+}
+
+func (h *topicHandler) with(f func(slog.Handler) slog.Handler) *topicHandler {
+	chain := make([]func(slog.Handler) slog.Handler, len(h.chain), len(h.chain)+1)
+	copy(chain, h.chain)
+	return &topicHandler{topic: h.topic, level: h.level, chain: append(chain, f)}
+}
+
+func (h *topicHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *topicHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
@@ -1,365 +1,195 @@
 package main
 
 import (
+	"course/cluster"
+	"course/gateway"
 	"course/kv"
-	"course/labrpc"
+	"course/logging"
 	"course/raft"
-	"encoding/json"
+	"course/tracing"
+	"course/transport"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"math/rand"
-	"net/http"
+	"net"
 	"os"
 	"os/signal"
-	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
 
-var client *kv.KVClient
+// Raft 状态超过该大小（字节）时，KVServer 会做一次快照
+const maxRaftState = 1 << 20
 
-func main() {
-	// 创建一个网络
-	network := labrpc.MakeNetwork()
-
-	// 节点数量
-	nServers := 3
-
-	// 存储服务器的 ClientEnd
-	servers := make([]*labrpc.ClientEnd, nServers)
-
-	// 存储 KVServer 实例
-	kvServers := make([]*kv.KVServer, nServers)
-
-	// 创建所有 ClientEnd
-	for i := 0; i < nServers; i++ {
-		serverName := "server" + strconv.Itoa(i)
-		clientEnd := network.MakeEnd("ClientEnd" + serverName)
-		servers[i] = clientEnd
-	}
-
-	// 创建所有 KVServer 实例
-	for i := 0; i < nServers; i++ {
-		serverName := "server" + strconv.Itoa(i)
+// 做快照时保留最近多少条日志范围内的历史版本，更早的版本会被丢弃
+const versionRetention = 20000
 
-		// 创建服务器
-		server := labrpc.MakeServer()
+// 节点之间的 RPC 传输方式
+var (
+	transportMode = flag.String("transport", "labrpc", "RPC transport between nodes: labrpc (in-process network) or tcp")
+	rpcBasePort   = flag.Int("rpc-port", 9000, "with -transport=tcp, node i listens on 127.0.0.1:rpc-port+i")
+)
 
-		// 创建持久化实例
-		persister := raft.MakePersister()
+// 定期让随机的节点崩溃再重启，只支持 -transport=labrpc
+var faultInterval = flag.Duration("simulate-faults", 0, "with -transport=labrpc, crash a random node every interval and restart it after interval/2 (default: disabled)")
 
-		// 创建 KVServer 实例
-		kvs := kv.StartKVServer(servers, i, persister)
+// 启用 /admin/ 下手动注入故障的接口，只支持 -transport=labrpc，并且必须设置 -admin-token
+var enableFaultInjection = flag.Bool("enable-fault-injection", false, "with -transport=labrpc, serve the /admin/ fault injection endpoints; requires -admin-token")
 
-		// 将 KVServer 注册为服务
-		kvService := labrpc.MakeService(kvs)
-		server.AddService(kvService)
+// HTTP 网关的认证配置，管理员令牌也可以通过环境变量 KV_ADMIN_TOKEN 传入，避免出现在进程列表中
+var (
+	adminToken  = flag.String("admin-token", os.Getenv("KV_ADMIN_TOKEN"), "enables API token authentication; this token has the admin role")
+	corsOrigins = flag.String("cors-origins", "", "comma-separated origins allowed for CORS, * for any (default: none)")
+)
 
-		// 创建并注册 Raft 实例
-		raftService := labrpc.MakeService(kvs.GetRaft())
-		server.AddService(raftService)
+// 记录的校验规则文件，启动时写入集群
+var schemaFile = flag.String("schema", "", "JSON file with validation rules for student records, stored in the cluster at startup")
 
-		// 将服务器添加到网络
-		network.AddServer(serverName, server)
+// 日志格式和级别，级别可以是全局的（debug）或按主题设置（raft=debug,client=warn），运行时可以通过 /log/levels 修改
+var (
+	logFormat = flag.String("log-format", os.Getenv("LOG_FORMAT"), "log output format: text or json")
+	logLevel  = flag.String("log-level", os.Getenv("LOG_LEVEL"), "log level for all topics (debug) or per topic (raft=debug,client=warn)")
+)
 
-		// 连接网络
-		network.Connect("ClientEnd"+serverName, serverName)
-		network.Enable("ClientEnd"+serverName, true)
+// 请求各阶段的 span 以 OTLP/JSON 格式追加写入该文件，- 表示标准输出
+var traceFile = flag.String("trace-file", os.Getenv("TRACE_FILE"), "append request spans in OTLP/JSON to this file, - for stdout (default: disabled)")
 
-		// 保存 KVServer 实例
-		kvServers[i] = kvs
+func main() {
+	flag.Parse()
+	if err := logging.Setup(*logFormat, *logLevel); err != nil {
+		log.Fatal(err)
+	}
+	if err := tracing.Setup(*traceFile, "kvmain"); err != nil {
+		log.Fatalf("Failed to open trace file: %v", err)
 	}
 
-	// 创建客户端
-	clientEnds := make([]*labrpc.ClientEnd, nServers)
-	for i := 0; i < nServers; i++ {
-		serverName := "server" + strconv.Itoa(i)
-		clientEndName := "Client" + serverName
-
-		// 创建一个 ClientEnd
-		clientEnd := network.MakeEnd(clientEndName)
-		network.Connect(clientEndName, serverName)
-		network.Enable(clientEndName, true)
+	// 节点数量
+	nServers := 3
 
-		clientEnds[i] = clientEnd
+	var kvServers []*kv.KVServer
+	var clientEnds []transport.ClientEnd
+	var cleanup func()
+	opts := gatewayOptions()
+	if *enableFaultInjection && opts.AdminToken == "" {
+		log.Fatal("-enable-fault-injection requires -admin-token: the /admin/ endpoints can take the whole cluster down")
+	}
+	switch *transportMode {
+	case "labrpc":
+		c := cluster.New(nServers, cluster.Options{MaxRaftState: maxRaftState, VersionRetention: versionRetention})
+		clientEnds, cleanup = c.ClientEnds(), c.Shutdown
+		if *enableFaultInjection {
+			// 节点运行在同一进程中，网关可以通过 /admin/ 接口让节点崩溃、重启或分区
+			opts.Cluster = c
+		}
+		if *faultInterval > 0 {
+			go simulateFaults(c, *faultInterval)
+		}
+	case "tcp":
+		if *faultInterval > 0 {
+			log.Fatal("-simulate-faults requires -transport=labrpc")
+		}
+		if *enableFaultInjection {
+			log.Fatal("-enable-fault-injection requires -transport=labrpc")
+		}
+		kvServers, clientEnds, cleanup = startTCPCluster(nServers, *rpcBasePort)
+	default:
+		log.Fatalf("unknown transport %q", *transportMode)
 	}
 
 	// 创建 KVClient
-	client = kv.MakeKVClient(clientEnds)
+	client := kv.MakeKVClient(clientEnds)
+	if *schemaFile != "" {
+		if err := gateway.LoadSchemaFile(client, *schemaFile); err != nil {
+			log.Fatalf("Failed to load schema: %v", err)
+		}
+	}
 
 	// 启动 HTTP 服务
-	go startHTTPServer()
-	// 启动模拟故障的 Goroutine
-	// go simulateFaults(kvServers)
+	go func() {
+		log.Fatal(gateway.ListenAndServe(":8080", client, opts))
+	}()
 	// 捕获中断信号
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
 
-	log.Println("Server is running. Press Ctrl+C to stop.")
+	slog.Info("Server is running. Press Ctrl+C to stop.")
 
 	// 阻塞等待中断信号
 	<-signalChan
 
 	// 清理工作
-	log.Println("Shutting down servers...")
+	slog.Info("Shutting down servers")
 	for _, kvs := range kvServers {
 		kvs.Kill()
 	}
 
 	// 清理网络
-	network.Cleanup()
-
-	log.Println("Server stopped.")
-}
-
-func cors(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// 设置允许的来源，* 表示允许所有来源
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		// 设置允许的请求方法
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		// 设置允许的请求头
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		// 处理预检请求（OPTIONS）
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
-// 启动 HTTP 服务
-func startHTTPServer() {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/put", handlePut)
-	mux.HandleFunc("/get", handleGet)
-	mux.HandleFunc("/search", handleSearch)
-	mux.HandleFunc("/list_all", handleListAll)
+	cleanup()
+	tracing.Shutdown()
 
-	// 使用跨域中间件
-	log.Println("HTTP server is running on :8080")
-	log.Fatal(http.ListenAndServe(":8080", cors(mux)))
+	slog.Info("Server stopped")
 }
 
-// 处理 /put 请求
-func handlePut(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, `{"error": "Invalid request method"}`, http.StatusMethodNotAllowed)
-		return
-	}
-
-	var request struct {
-		Key   string     `json:"key"`
-		Value kv.KVEntry `json:"value"`
-	}
-
-	err := json.NewDecoder(r.Body).Decode(&request)
-	if err != nil {
-		http.Error(w, `{"error": "Failed to parse request body"}`, http.StatusBadRequest)
-		return
-	}
-
-	client.Put(request.Key, request.Value)
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": fmt.Sprintf("Put operation successful for key: %s", request.Key),
-	})
-}
-
-// 处理 /get 请求
-func handleGet(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, `{"error": "Invalid request method"}`, http.StatusMethodNotAllowed)
-		return
-	}
-
-	key := r.URL.Query().Get("key")
-	if key == "" {
-		http.Error(w, `{"error": "Key is required"}`, http.StatusBadRequest)
-		return
-	}
-
-	jsonValue := client.Get(key)
-	if jsonValue == "" {
-		http.Error(w, `{"error": "Key not found"}`, http.StatusNotFound)
-		return
-	}
-
-	// 将 JSON 字符串解析为结构化对象
-	var record map[string]interface{}
-	err := json.Unmarshal([]byte(jsonValue), &record)
-	if err != nil {
-		http.Error(w, `{"error": "Failed to parse record"}`, http.StatusInternalServerError)
-		return
+func gatewayOptions() gateway.Options {
+	opts := gateway.Options{AdminToken: *adminToken}
+	if *corsOrigins != "" {
+		opts.AllowedOrigins = strings.Split(*corsOrigins, ",")
 	}
-
-	// 添加 key 字段到记录中
-	record["id"] = key
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(record)
+	return opts
 }
 
-// 处理 /search 请求
-func handleSearch(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, `{"error": "Invalid request method"}`, http.StatusMethodNotAllowed)
-		return
-	}
-
-	// 获取查询条件
-	query := r.URL.Query()
-	name := query.Get("name")                  // 查询姓名
-	class := query.Get("class")                // 查询班级
-	major := query.Get("major")                // 查询专业
-	grand := query.Get("grand")                // 查询年级
-	courseCount := query.Get("course_count")   // 查询课程数
-	totalCredits := query.Get("total_credits") // 查询总学分
-
-	if name == "" && class == "" && major == "" && grand == "" && courseCount == "" && totalCredits == "" {
-		http.Error(w, `{"error": "At least one condition is required"}`, http.StatusBadRequest)
-		return
+// 各节点通过本机 TCP 端口通信，节点 i 监听 127.0.0.1:basePort+i
+func startTCPCluster(nServers int, basePort int) ([]*kv.KVServer, []transport.ClientEnd, func()) {
+	addrs := make([]string, nServers)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("127.0.0.1:%d", basePort+i)
 	}
 
-	// 从客户端获取所有键
-	keys := client.GetAllKeys()
-
-	// 结果列表
-	var results []map[string]interface{}
-
-	// 遍历每个键
-	for _, key := range keys {
-		jsonValue := client.Get(key)
-		if jsonValue == "" {
-			continue
-		}
-
-		// 解析 JSON 数据
-		var record map[string]interface{}
-		err := json.Unmarshal([]byte(jsonValue), &record)
+	kvServers := make([]*kv.KVServer, nServers)
+	rpcServers := make([]*transport.Server, nServers)
+	for i := 0; i < nServers; i++ {
+		listener, err := net.Listen("tcp", addrs[i])
 		if err != nil {
-			continue
-		}
-
-		// 检查条件
-		matches := true
-		if name != "" && record["name"] != name {
-			matches = false
-		}
-		if class != "" && record["class"] != class {
-			matches = false
-		}
-		if major != "" && record["major"] != major {
-			matches = false
-		}
-		if grand != "" && strconv.Itoa(int(record["grand"].(float64))) != grand {
-			matches = false
-		}
-		if courseCount != "" {
-			queryCourseCount, err := strconv.Atoi(courseCount)
-			if err != nil || int(record["course_count"].(float64)) != queryCourseCount {
-				matches = false
-			}
-		}
-		if totalCredits != "" {
-			queryCredits, err := strconv.ParseFloat(totalCredits, 64)
-			if err != nil || record["total_credits"] != queryCredits {
-				matches = false
-			}
+			log.Fatalf("Failed to listen on %s: %v", addrs[i], err)
 		}
 
-		// 如果条件匹配，加入结果
-		if matches {
-			record["id"] = key // 添加键作为 `id` 字段
-			results = append(results, record)
-		}
-	}
+		kvs := kv.StartKVServer(transport.NewTCPClientEnds(addrs), i, raft.MakePersister(), maxRaftState)
+		kvs.SetVersionRetention(versionRetention)
 
-	// 设置响应头为 JSON 格式
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(results)
-}
+		server := transport.NewServer()
+		server.AddService(kvs)
+		server.AddService(kvs.GetRaft())
+		go server.Serve(listener)
 
-// 处理 /list_all 请求
-func handleListAll(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, `{"error": "Invalid request method"}`, http.StatusMethodNotAllowed)
-		return
+		kvServers[i] = kvs
+		rpcServers[i] = server
 	}
 
-	// 从客户端获取所有键
-	keys := client.GetAllKeys()
-
-	// 存储所有学生信息的列表
-	var results []map[string]interface{}
-
-	// 遍历每个键
-	for _, key := range keys {
-		jsonValue := client.Get(key)
-		if jsonValue == "" {
-			continue
-		}
-
-		// 将 JSON 数据解析为结构化对象
-		var record map[string]interface{}
-		err := json.Unmarshal([]byte(jsonValue), &record)
-		if err != nil {
-			continue
+	cleanup := func() {
+		for _, server := range rpcServers {
+			server.Close()
 		}
-
-		// 添加键到结果中
-		record["id"] = key
-		results = append(results, record)
 	}
-
-	// 设置响应头为 JSON 格式
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(results)
+	return kvServers, transport.NewTCPClientEnds(addrs), cleanup
 }
 
-// 模拟故障
-func simulateFaults(kvServers []*kv.KVServer) {
-	rand.Seed(time.Now().UnixNano()) // 初始化随机种子
-
+// 每隔 interval 让一个随机的节点崩溃，interval/2 之后从它保留的持久化状态重启
+func simulateFaults(c *cluster.Cluster, interval time.Duration) {
 	for {
-		time.Sleep(10 * time.Second) // 每隔 10 秒模拟一次故障
-
-		// 随机选择一个服务器
-		serverIndex := rand.Intn(len(kvServers))
-		log.Printf("[Fault] Simulating failure on server %d", serverIndex)
-
-		// 模拟杀死服务器
-		kvServers[serverIndex].Kill()
-
-		// 启动一个 Goroutine 持续打印状态
-		done := make(chan bool)
-		go func(index int, done chan bool) {
-			for {
-				select {
-				case <-done:
-					return
-				default:
-					log.Printf("\033[31m💀💀💀💀[Fault] Server %d is down💀💀💀💀\033[0m", index)
-
-					time.Sleep(100 * time.Millisecond)
-				}
-			}
-		}(serverIndex, done)
+		time.Sleep(interval)
 
-		// 休眠 10 秒后恢复服务器
-		// time.Sleep(5 * time.Second)
-
-		log.Printf("🩺🩺🩺[Fault] Recovering server %d🩺🩺🩺", serverIndex)
-		persister := raft.MakePersister()
-		newServer := kv.StartKVServer(kvServers[serverIndex].GetPeers(), kvServers[serverIndex].GetMe(), persister)
-		kvServers[serverIndex] = newServer
-
-		// 通知打印 Goroutine 停止
-		done <- true
-		close(done)
+		i := rand.Intn(c.N())
+		if err := c.Crash(i); err != nil {
+			slog.Warn("Failed to simulate failure", "node", i, "err", err)
+			continue
+		}
 
-		log.Printf("👩‍⚕️👩‍⚕️👩‍⚕️[Fault] Server %d recovered👩‍⚕️👩‍⚕️👩‍⚕️", serverIndex)
+		time.Sleep(interval / 2)
+		if err := c.Restart(i); err != nil {
+			slog.Warn("Failed to restart node", "node", i, "err", err)
+		}
 	}
 }
@@ -0,0 +1,306 @@
+// Package metrics 实现计数器、仪表和直方图，并按 Prometheus 文本格式输出
+//
+// 所有指标默认注册在进程级的 Default 中，同一进程中的多个节点通过 node 标签区分
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 请求耗时（秒）的默认分桶，覆盖本机 RPC 到跨机房的延迟
+var DefBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// 一组同名指标的元数据和全部标签组合
+type family struct {
+	name       string
+	help       string
+	typ        string // counter、gauge 或 histogram
+	labelNames []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]metric // 标签值用 \xff 拼接后的字符串 -> 指标
+}
+
+type metric interface {
+	write(w io.Writer, name string, labels string)
+}
+
+// 指标的集合
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// 进程级的默认集合
+var Default = NewRegistry()
+
+// 注册一组指标，同名的指标只注册一次，再次注册时返回已有的那一组
+func (r *Registry) register(name, help, typ string, labelNames []string, buckets []float64) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.typ != typ || len(f.labelNames) != len(labelNames) {
+			panic(fmt.Sprintf("metrics: %s registered twice with different types or labels", name))
+		}
+		return f
+	}
+	f := &family{
+		name:       name,
+		help:       help,
+		typ:        typ,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     make(map[string]metric),
+	}
+	r.families[name] = f
+	return f
+}
+
+func (f *family) with(values []string, create func() metric) metric {
+	if len(values) != len(f.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labelNames), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m, ok := f.series[key]
+	if !ok {
+		m = create()
+		f.series[key] = m
+	}
+	return m
+}
+
+func (f *family) delete(values []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.series, strings.Join(values, "\xff"))
+}
+
+// 按 Prometheus 文本格式输出全部指标，指标和标签组合都按名字排序，输出是稳定的
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	for _, f := range families {
+		f.mu.Lock()
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		series := make([]metric, len(keys))
+		for i, key := range keys {
+			series[i] = f.series[key]
+		}
+		f.mu.Unlock()
+		if len(series) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+		for i, m := range series {
+			m.write(w, f.name, formatLabels(f.labelNames, strings.Split(keys[i], "\xff")))
+		}
+	}
+}
+
+// 返回输出 r 中全部指标的 HTTP 处理器
+func Handler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+// 拼接标签，extra 为额外的一个标签（直方图的 le）
+func joinLabels(labels, extra string) string {
+	switch {
+	case labels == "" && extra == "":
+		return ""
+	case labels == "":
+		return "{" + extra + "}"
+	case extra == "":
+		return "{" + labels + "}"
+	}
+	return "{" + labels + "," + extra + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// 原子地读写 float64
+type atomicFloat struct {
+	bits uint64
+}
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+func (f *atomicFloat) store(v float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(v))
+}
+
+func (f *atomicFloat) add(v float64) {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&f.bits, old, next) {
+			return
+		}
+	}
+}
+
+// 只增不减的计数器
+type Counter struct {
+	v atomicFloat
+}
+
+func (c *Counter) Inc()          { c.v.add(1) }
+func (c *Counter) Add(v float64) { c.v.add(v) }
+
+func (c *Counter) write(w io.Writer, name, labels string) {
+	fmt.Fprintf(w, "%s%s %s\n", name, joinLabels(labels, ""), formatFloat(c.v.load()))
+}
+
+// 可以任意设置的仪表
+type Gauge struct {
+	v atomicFloat
+}
+
+func (g *Gauge) Set(v float64) { g.v.store(v) }
+func (g *Gauge) Add(v float64) { g.v.add(v) }
+
+func (g *Gauge) write(w io.Writer, name, labels string) {
+	fmt.Fprintf(w, "%s%s %s\n", name, joinLabels(labels, ""), formatFloat(g.v.load()))
+}
+
+// 按分桶统计观测值的直方图
+type Histogram struct {
+	buckets []float64
+	counts  []uint64 // counts[i] 为落在 (buckets[i-1], buckets[i]] 中的次数，最后一个为 +Inf
+	sum     atomicFloat
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	atomic.AddUint64(&h.counts[i], 1)
+	h.sum.add(v)
+}
+
+// 记录从 start 到现在经过的秒数
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (h *Histogram) write(w io.Writer, name, labels string) {
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, joinLabels(labels, `le="`+formatFloat(bound)+`"`), cumulative)
+	}
+	cumulative += atomic.LoadUint64(&h.counts[len(h.buckets)])
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, joinLabels(labels, `le="+Inf"`), cumulative)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, joinLabels(labels, ""), formatFloat(h.sum.load()))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, joinLabels(labels, ""), cumulative)
+}
+
+// 带标签的一组计数器
+type CounterVec struct{ f *family }
+
+// 带标签的一组仪表
+type GaugeVec struct{ f *family }
+
+// 带标签的一组直方图
+type HistogramVec struct{ f *family }
+
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, "counter", labelNames, nil)}
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, "gauge", labelNames, nil)}
+}
+
+// buckets 为 nil 时使用 DefBuckets
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	return &HistogramVec{r.register(name, help, "histogram", labelNames, buckets)}
+}
+
+// 返回一组标签值对应的计数器，不存在时创建
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.f.with(values, func() metric { return &Counter{} }).(*Counter)
+}
+
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.f.with(values, func() metric { return &Gauge{} }).(*Gauge)
+}
+
+// 删除一组标签值，例如节点不再是领导者时删除它对各个副本的统计
+func (v *GaugeVec) Delete(values ...string) {
+	v.f.delete(values)
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.f.with(values, func() metric { return newHistogram(v.f.buckets) }).(*Histogram)
+}
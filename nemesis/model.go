@@ -0,0 +1,57 @@
+package nemesis
+
+import (
+	"course/linearizability"
+	"fmt"
+)
+
+// 客户端的操作，Value 为写入的值
+type kvInput struct {
+	Put   bool   `json:"put"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+// 读到的值，键不存在时为空字符串；写入的值都不为空
+type kvOutput struct {
+	Value string `json:"value"`
+}
+
+// 每个键是一个寄存器：读返回最近一次写入的值，键之间互不影响，按键分别检查
+var kvModel = linearizability.Model{
+	Partition: func(history []linearizability.Operation) [][]linearizability.Operation {
+		byKey := make(map[string][]linearizability.Operation)
+		var keys []string
+		for _, op := range history {
+			key := op.Input.(kvInput).Key
+			if _, ok := byKey[key]; !ok {
+				keys = append(keys, key)
+			}
+			byKey[key] = append(byKey[key], op)
+		}
+		partitions := make([][]linearizability.Operation, len(keys))
+		for i, key := range keys {
+			partitions[i] = byKey[key]
+		}
+		return partitions
+	},
+	Init: func() interface{} { return "" },
+	Step: func(state, input, output interface{}) (bool, interface{}) {
+		in := input.(kvInput)
+		if in.Put {
+			return true, in.Value
+		}
+		return output.(kvOutput).Value == state.(string), state
+	},
+	Describe: func(input, output interface{}) string {
+		in := input.(kvInput)
+		if in.Put {
+			return fmt.Sprintf("put %s = %s", in.Key, in.Value)
+		}
+		value := output.(kvOutput).Value
+		if value == "" {
+			value = "(none)"
+		}
+		return fmt.Sprintf("get %s -> %s", in.Key, value)
+	},
+}
@@ -0,0 +1,101 @@
+package nemesis
+
+import (
+	"course/linearizability"
+	"fmt"
+	"io"
+	"time"
+)
+
+// 一个步骤的执行结果
+type StepResult struct {
+	At          time.Duration // 实际执行的时间，相对开始
+	Action      string
+	Description string // 实际作用的节点或分组
+	Err         string // 不为空时该步骤没有生效，例如当时没有领导者
+}
+
+type Report struct {
+	Scenario Scenario
+	Steps    []StepResult
+	Elapsed  time.Duration // 场景中经过的时间，模拟运行时为虚拟时间
+
+	RealElapsed time.Duration
+
+	// 模拟运行时调度过程的摘要：每次切换的虚拟时间和 goroutine 编号的 FNV-1a 哈希，以及切换的次数
+	// 同一个种子的两次模拟运行两者都相同
+	Schedule uint64
+	Switches int64
+
+	Puts        int
+	Gets        int
+	UnknownPuts int // 重试用尽、不知道是否已经提交的写入
+	FailedGets  int // 重试用尽的读，不计入历史
+
+	History []linearizability.Operation // 按调用时间排序，时间为相对开始的纳秒数
+	Check   linearizability.Report
+}
+
+// 历史可线性化时返回 true；检查超时的结果为 false
+func (r *Report) OK() bool {
+	return r.Check.Result == linearizability.Ok
+}
+
+func (r *Report) Write(w io.Writer) {
+	s := r.Scenario
+	fmt.Fprintf(w, "scenario %s: %d nodes, %d clients, %d keys, %s, seed %d\n",
+		s.Name, s.Nodes, s.Clients, s.Keys, time.Duration(s.Duration), s.Seed)
+	if s.Simulate {
+		fmt.Fprintf(w, "simulated: %s of virtual time in %s, schedule %016x (%d switches)\n",
+			r.Elapsed.Round(time.Millisecond), r.RealElapsed.Round(time.Millisecond), r.Schedule, r.Switches)
+	}
+
+	fmt.Fprintf(w, "\nsteps:\n")
+	for _, step := range r.Steps {
+		fmt.Fprintf(w, "  %-9s %-11s %s", offset(int64(step.At)), step.Action, step.Description)
+		if step.Err != "" {
+			fmt.Fprintf(w, " (skipped: %s)", step.Err)
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "\noperations: %d puts (%d with unknown outcome), %d gets (%d more failed and were dropped)\n",
+		r.Puts, r.UnknownPuts, r.Gets, r.FailedGets)
+
+	c := r.Check
+	switch c.Result {
+	case linearizability.Ok:
+		fmt.Fprintf(w, "linearizable: yes (%d keys checked in %s)\n", c.Partitions, c.Elapsed.Round(time.Millisecond))
+	case linearizability.Unknown:
+		fmt.Fprintf(w, "linearizable: unknown (the check did not finish in %s)\n", c.Elapsed.Round(time.Second))
+	case linearizability.Illegal:
+		v := c.Violation
+		fmt.Fprintf(w, "linearizable: NO\n")
+		fmt.Fprintf(w, "\nfirst violating window: %s .. %s\n", offset(v.Start), offset(v.End))
+		fmt.Fprintf(w, "  last operations of the longest linearizable prefix:\n")
+		if len(v.Linearized) == 0 {
+			fmt.Fprintf(w, "    (none)\n")
+		}
+		for _, op := range v.Linearized {
+			writeOp(w, op)
+		}
+		fmt.Fprintf(w, "  none of these can be linearized next:\n")
+		for _, op := range v.Stuck {
+			writeOp(w, op)
+		}
+	}
+}
+
+func writeOp(w io.Writer, op linearizability.Operation) {
+	ret := "pending"
+	if op.Return != linearizability.Pending {
+		ret = offset(op.Return)
+	}
+	fmt.Fprintf(w, "    client %-2d %-28s [%s, %s]\n",
+		op.ClientID, kvModel.Describe(op.Input, op.Output), offset(op.Call), ret)
+}
+
+// 相对开始的时间，例如 +1.234s
+func offset(ns int64) string {
+	return fmt.Sprintf("+%.3fs", time.Duration(ns).Seconds())
+}
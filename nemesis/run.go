@@ -0,0 +1,369 @@
+package nemesis
+
+import (
+	"course/cluster"
+	"course/kv"
+	"course/linearizability"
+	"course/logging"
+	"course/sim"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var logger = logging.Logger(logging.TopicMain)
+
+// 检查历史的最长时间，超过时结果为 unknown
+const checkTimeout = time.Minute
+
+// Raft 状态超过该大小时做快照，让较长的场景也会经过快照和安装快照
+const maxRaftState = 64 * 1024
+
+// 运行场景：启动集群，让客户端并发读写 s.Duration，同时按时间执行各步骤，
+// 结束后停止集群并检查历史
+//
+// KVServer 会读写 kv.DataFile，调用方应先把它设为空或指向一个不存在的临时文件，
+// 否则集群启动时会读入之前的数据，检查会把这些值当作没有写入过的值报告出来
+//
+// 种子同时通过 sim.SetSeed 固定 Raft 的选举超时、labrpc 的延迟和丢包以及客户端编号。
+// s.Simulate 为 true 时整个场景在 sim.Virtual 中运行：goroutine 由它逐个调度，
+// 所有 goroutine 都在等待时时间直接跳到下一个计时器，几分钟的场景几秒就能跑完。
+// 两者都会修改 sim 包的全局状态，所以同一时间只能运行一个场景，结束后恢复原状。
+//
+// 模拟运行时同一个种子每次得到完全相同的执行过程和操作历史，Report.Schedule 可以用来确认这一点；
+// 发现违反时用同一种子重新运行即可复现。不模拟时 goroutine 的调度由 Go 运行时决定，不能保证复现
+func Run(s Scenario) (*Report, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	if s.Seed == 0 {
+		s.Seed = time.Now().UnixNano()
+	}
+
+	sim.SetSeed(s.Seed)
+	defer sim.SetSeed(0)
+	realStart := time.Now()
+
+	r := &runner{scenario: s, rng: rand.New(rand.NewSource(s.Seed))}
+	report := &Report{Scenario: s}
+	if s.Simulate {
+		start := time.Now()
+		virtual := sim.NewVirtual(start)
+		schedule := fnv.New64a()
+		virtual.SetTrace(func(now time.Time, proc uint64) {
+			var b [16]byte
+			binary.LittleEndian.PutUint64(b[:8], uint64(now.Sub(start)))
+			binary.LittleEndian.PutUint64(b[8:], proc)
+			schedule.Write(b[:])
+			report.Switches++
+		})
+		restore := sim.SetClock(virtual)
+		virtual.Run(r.run)
+		restore()
+		report.Schedule = schedule.Sum64()
+	} else {
+		r.run()
+	}
+
+	report.Steps = r.steps
+	report.Elapsed = r.elapsed
+	report.RealElapsed = time.Since(realStart)
+	for _, op := range r.history {
+		if op.Input.(kvInput).Put {
+			report.Puts++
+			if op.Return == linearizability.Pending {
+				report.UnknownPuts++
+			}
+		} else {
+			report.Gets++
+		}
+	}
+	report.FailedGets = r.failedGets
+	sort.Slice(r.history, func(i, j int) bool { return r.history[i].Call < r.history[j].Call })
+	report.History = r.history
+	report.Check = linearizability.Check(kvModel, r.history, checkTimeout)
+	return report, nil
+}
+
+type runner struct {
+	scenario Scenario
+	cluster  *cluster.Cluster
+	rng      *rand.Rand // 只由执行步骤的 goroutine 使用
+	start    time.Time
+	elapsed  time.Duration
+	steps    []StepResult
+
+	mu         sync.Mutex
+	history    []linearizability.Operation
+	failedGets int
+}
+
+// 启动集群和客户端，按时间执行各步骤，客户端结束后停止集群
+func (r *runner) run() {
+	s := r.scenario
+	r.cluster = cluster.New(s.Nodes, cluster.Options{MaxRaftState: maxRaftState})
+	r.start = sim.Now()
+	duration := time.Duration(s.Duration)
+
+	var wg sim.WaitGroup
+	for i := 0; i < s.Clients; i++ {
+		wg.Add(1)
+		sim.Go(func() {
+			defer wg.Done()
+			r.client(i, rand.New(rand.NewSource(s.Seed+int64(i)+1)), duration)
+		})
+	}
+
+	for _, step := range s.Steps {
+		if time.Duration(step.At) > duration {
+			break
+		}
+		sim.Sleep(time.Duration(step.At) - sim.Since(r.start))
+		r.steps = append(r.steps, r.apply(step))
+	}
+	wg.Wait()
+
+	r.elapsed = sim.Since(r.start)
+	// 在模拟运行结束前停止集群，节点的 goroutine 在调度停止后能够退出，检查时也不会再占用处理器
+	r.cluster.Shutdown()
+}
+
+// 相对开始的时间，单位为纳秒
+func (r *runner) now() int64 {
+	return int64(sim.Since(r.start))
+}
+
+// 一个客户端：在 duration 内依次随机读写，每次写入的值都不同
+func (r *runner) client(id int, rng *rand.Rand, duration time.Duration) {
+	ck := kv.MakeKVClient(r.cluster.ClientEnds())
+	for n := 0; sim.Since(r.start) < duration; n++ {
+		key := fmt.Sprintf("k%d", rng.Intn(r.scenario.Keys))
+		op := linearizability.Operation{ClientID: id}
+
+		if rng.Intn(2) == 0 {
+			value := fmt.Sprintf("c%d-%d", id, n)
+			op.Input = kvInput{Put: true, Key: key, Value: value}
+			op.Output = kvOutput{}
+			op.Call = r.now()
+			err := ck.PutWithTTL(key, kv.KVEntry{Name: value}, 0)
+			op.Return = r.now()
+			if err != nil {
+				// 写入可能已经提交，也可能没有，交给检查器考虑两种情况
+				op.Return = linearizability.Pending
+			}
+		} else {
+			op.Input = kvInput{Key: key}
+			op.Call = r.now()
+			value, err := ck.GetEntry(key)
+			op.Return = r.now()
+			if err != nil && err.Error() != kv.ErrNoKey {
+				// 读失败不影响状态，不记录
+				r.mu.Lock()
+				r.failedGets++
+				r.mu.Unlock()
+				continue
+			}
+			op.Output = kvOutput{Value: value.Name}
+		}
+
+		r.mu.Lock()
+		r.history = append(r.history, op)
+		r.mu.Unlock()
+	}
+}
+
+// 执行一个步骤，失败（例如没有领导者）时记录原因并继续
+func (r *runner) apply(step Step) StepResult {
+	result := StepResult{At: sim.Since(r.start), Action: step.Action}
+	desc, err := r.applyStep(step)
+	result.Description = desc
+	if err != nil {
+		result.Err = err.Error()
+		logger.Warn("Nemesis step failed", "action", step.Action, "err", err)
+	}
+	return result
+}
+
+func (r *runner) applyStep(step Step) (string, error) {
+	c := r.cluster
+	switch step.Action {
+	case ActionPartition:
+		groups := step.Groups
+		if len(groups) == 0 {
+			var err error
+			if groups, err = r.partitionGroups(step); err != nil {
+				return "", err
+			}
+		}
+		return fmt.Sprint(groups), c.Partition(groups)
+
+	case ActionHeal:
+		c.Heal()
+		return "", nil
+
+	case ActionCrash:
+		nodes, err := r.resolve(step.Node, true)
+		if err != nil {
+			return "", err
+		}
+		for _, i := range nodes {
+			if err := c.Crash(i); err != nil {
+				return fmt.Sprintf("node %v", nodes), err
+			}
+		}
+		return fmt.Sprintf("node %v", nodes), nil
+
+	case ActionRestart:
+		var nodes []int
+		if step.Node == "" || step.Node == TargetAll {
+			// 重启所有崩溃的节点
+			for _, n := range c.Nodes() {
+				if !n.Running {
+					nodes = append(nodes, n.ID)
+				}
+			}
+		} else {
+			var err error
+			if nodes, err = r.resolve(step.Node, false); err != nil {
+				return "", err
+			}
+		}
+		for _, i := range nodes {
+			if err := c.Restart(i); err != nil {
+				return fmt.Sprintf("node %v", nodes), err
+			}
+		}
+		return fmt.Sprintf("node %v", nodes), nil
+
+	case ActionClockSkew:
+		nodes, err := r.resolve(step.Node, false)
+		if err != nil {
+			return "", err
+		}
+		for _, i := range nodes {
+			c.SetClockSkew(i, time.Duration(step.Skew))
+		}
+		return fmt.Sprintf("node %v skew %s", nodes, time.Duration(step.Skew)), nil
+
+	case ActionDrop:
+		nodes, err := r.resolve(step.Node, false)
+		if err != nil {
+			return "", err
+		}
+		for _, i := range nodes {
+			c.SetDropRate(i, step.Percent/100)
+		}
+		return fmt.Sprintf("node %v %g%%", nodes, step.Percent), nil
+
+	case ActionNetwork:
+		s := c.Network()
+		if step.Reliable != nil {
+			s.Reliable = *step.Reliable
+		}
+		if step.LongReordering != nil {
+			s.LongReordering = *step.LongReordering
+		}
+		if step.LongDelays != nil {
+			s.LongDelays = *step.LongDelays
+		}
+		c.SetNetwork(s)
+		return fmt.Sprintf("reliable=%v long_reordering=%v long_delays=%v", s.Reliable, s.LongReordering, s.LongDelays), nil
+	}
+	return "", fmt.Errorf("unknown action %q", step.Action)
+}
+
+// 把 Target 换成节点编号，running 为 true 时 random 只从正在运行的节点中选
+func (r *runner) resolve(t Target, running bool) ([]int, error) {
+	c := r.cluster
+	switch t {
+	case TargetAll:
+		nodes := make([]int, c.N())
+		for i := range nodes {
+			nodes[i] = i
+		}
+		return nodes, nil
+	case TargetLeader:
+		leader, ok := c.Leader()
+		if !ok {
+			return nil, errors.New("no leader")
+		}
+		return []int{leader}, nil
+	case TargetFollower, TargetRandom:
+		leader, _ := c.Leader()
+		var candidates []int
+		for _, n := range c.Nodes() {
+			if (running || t == TargetFollower) && !n.Running {
+				continue
+			}
+			if t == TargetFollower && n.ID == leader {
+				continue
+			}
+			candidates = append(candidates, n.ID)
+		}
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("no %s node to choose from", t)
+		}
+		return []int{candidates[r.rng.Intn(len(candidates))]}, nil
+	}
+	n, _ := strconv.Atoi(string(t))
+	return []int{n}, nil
+}
+
+// 按 mode 分成少数派和多数派两组
+func (r *runner) partitionGroups(step Step) ([][]int, error) {
+	n := r.cluster.N()
+	if step.Mode == PartitionIsolate {
+		nodes, err := r.resolve(step.Node, false)
+		if err != nil {
+			return nil, err
+		}
+		return [][]int{nodes, others(n, nodes)}, nil
+	}
+
+	minoritySize := (n - 1) / 2
+	if minoritySize == 0 {
+		return nil, fmt.Errorf("cannot split %d nodes into a minority and a majority", n)
+	}
+	perm := r.rng.Perm(n)
+	var minority []int
+	switch step.Mode {
+	case PartitionRandom:
+		minority = perm[:minoritySize]
+	case PartitionLeaderMinority, PartitionLeaderMajority:
+		leader, ok := r.cluster.Leader()
+		if !ok {
+			return nil, errors.New("no leader")
+		}
+		if step.Mode == PartitionLeaderMinority {
+			minority = append(minority, leader)
+		}
+		for _, i := range perm {
+			if len(minority) < minoritySize && i != leader {
+				minority = append(minority, i)
+			}
+		}
+	}
+	sort.Ints(minority)
+	return [][]int{minority, others(n, minority)}, nil
+}
+
+// 不在 nodes 中的节点
+func others(n int, nodes []int) []int {
+	in := make(map[int]bool)
+	for _, i := range nodes {
+		in[i] = true
+	}
+	var rest []int
+	for i := 0; i < n; i++ {
+		if !in[i] {
+			rest = append(rest, i)
+		}
+	}
+	return rest
+}
@@ -0,0 +1,56 @@
+package nemesis
+
+import (
+	"course/kv"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 同一个种子的两次模拟运行得到相同的调度过程、步骤和操作历史
+func TestSimulateIsDeterministic(t *testing.T) {
+	defer func(file string) { kv.DataFile = file }(kv.DataFile)
+	kv.DataFile = ""
+
+	s := Scenario{
+		Name:     "determinism",
+		Nodes:    3,
+		Clients:  3,
+		Keys:     2,
+		Duration: Duration(5 * time.Second),
+		Seed:     7,
+		Simulate: true,
+		Steps: []Step{
+			{At: Duration(time.Second), Action: ActionPartition, Mode: PartitionLeaderMinority},
+			{At: Duration(2 * time.Second), Action: ActionHeal},
+			{At: Duration(3 * time.Second), Action: ActionCrash, Node: TargetLeader},
+			{At: Duration(4 * time.Second), Action: ActionRestart, Node: TargetAll},
+		},
+	}
+
+	first, err := Run(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.OK() {
+		t.Fatalf("history is not linearizable: %v", first.Check.Result)
+	}
+	if first.Puts == 0 || first.Switches == 0 {
+		t.Fatalf("nothing happened: %d puts, %d switches", first.Puts, first.Switches)
+	}
+
+	second, err := Run(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Schedule != second.Schedule || first.Switches != second.Switches {
+		t.Fatalf("schedule %016x (%d switches), then %016x (%d switches)",
+			first.Schedule, first.Switches, second.Schedule, second.Switches)
+	}
+	if !reflect.DeepEqual(first.Steps, second.Steps) {
+		t.Fatalf("steps differ:\n%v\n%v", first.Steps, second.Steps)
+	}
+	if !reflect.DeepEqual(first.History, second.History) {
+		t.Fatalf("histories differ: %d and %d operations", len(first.History), len(second.History))
+	}
+}
@@ -0,0 +1,217 @@
+// Package nemesis 按脚本在 labrpc 集群上注入故障，同时让多个客户端并发读写，
+// 最后用 linearizability 包检查记录下来的操作历史
+//
+// 场景是一个 JSON 文件，例如：
+//
+//	{
+//	  "name": "leader-partition",
+//	  "nodes": 5, "clients": 4, "keys": 3, "duration": "20s", "seed": 1,
+//	  "steps": [
+//	    {"at": "2s", "action": "partition", "mode": "leader-minority"},
+//	    {"at": "6s", "action": "heal"},
+//	    {"at": "8s", "action": "crash", "node": "leader"},
+//	    {"at": "10s", "action": "drop", "node": 2, "percent": 30},
+//	    {"at": "11s", "action": "clock-skew", "node": "follower", "skew": "-1m"},
+//	    {"at": "12s", "action": "restart", "node": "all"}
+//	  ]
+//	}
+package nemesis
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 故障动作
+const (
+	ActionPartition = "partition"  // 按 mode 或 groups 分区
+	ActionHeal      = "heal"       // 恢复所有连接
+	ActionCrash     = "crash"      // 让 node 崩溃
+	ActionRestart   = "restart"    // 从保留的状态重启 node，all 表示所有崩溃的节点
+	ActionClockSkew = "clock-skew" // 让 node 的系统时间偏差 skew
+	ActionDrop      = "drop"       // 丢弃发往 node 的请求和它的回复中 percent% 的消息
+	ActionNetwork   = "network"    // 修改整个网络的 reliable、long_reordering、long_delays
+)
+
+// 分区方式
+const (
+	PartitionLeaderMinority = "leader-minority" // 领导者和少数节点在一边
+	PartitionLeaderMajority = "leader-majority" // 领导者在多数派一边，少数派是其他节点
+	PartitionRandom         = "random"          // 随机分成少数派和多数派
+	PartitionIsolate        = "isolate"         // 只把 node 分出去
+)
+
+type Scenario struct {
+	Name     string   `json:"name"`
+	Nodes    int      `json:"nodes"`    // 节点数量，默认 3
+	Clients  int      `json:"clients"`  // 并发客户端数量，默认 4
+	Keys     int      `json:"keys"`     // 读写的键的数量，默认 3；键越少并发冲突越多
+	Duration Duration `json:"duration"` // 客户端读写的时长，默认 10s
+	Seed     int64    `json:"seed"`     // 选择节点和生成读写的随机种子，0 表示随机
+	Simulate bool     `json:"simulate"` // 在虚拟时间中运行，见 Run
+	Steps    []Step   `json:"steps"`
+}
+
+type Step struct {
+	At     Duration `json:"at"` // 相对开始的时间
+	Action string   `json:"action"`
+	Node   Target   `json:"node,omitempty"`
+
+	Mode   string   `json:"mode,omitempty"`   // partition 的分区方式
+	Groups [][]int  `json:"groups,omitempty"` // partition 直接给出各组节点，与 mode 二选一
+	Skew   Duration `json:"skew,omitempty"`   // clock-skew 的偏差，可以为负
+	// drop 丢弃消息的百分比，0 表示恢复
+	Percent float64 `json:"percent,omitempty"`
+
+	// network 要修改的设置，没有给出的保持不变
+	Reliable       *bool `json:"reliable,omitempty"`
+	LongReordering *bool `json:"long_reordering,omitempty"`
+	LongDelays     *bool `json:"long_delays,omitempty"`
+}
+
+// 以字符串表示的时长，例如 "1.5s"、"-1m"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"2s\": %s", data)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// 步骤作用的节点：节点编号，或者 leader、follower、random、all
+// 在 JSON 中可以写成数字或字符串
+type Target string
+
+const (
+	TargetLeader   Target = "leader"   // 当前的领导者
+	TargetFollower Target = "follower" // 随机一个正在运行的非领导者
+	TargetRandom   Target = "random"   // 随机一个节点
+	TargetAll      Target = "all"      // 所有节点
+)
+
+func (t *Target) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		*t = Target(strconv.Itoa(n))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("node must be a number or one of leader, follower, random, all: %s", data)
+	}
+	*t = Target(s)
+	return nil
+}
+
+// 读取场景文件并检查
+func Load(path string) (Scenario, error) {
+	var s Scenario
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("parse %s: %v", path, err)
+	}
+	if err := s.Validate(); err != nil {
+		return s, fmt.Errorf("%s: %v", path, err)
+	}
+	return s, nil
+}
+
+// 填充默认值，检查各步骤的参数，并把步骤按时间排序
+func (s *Scenario) Validate() error {
+	if s.Nodes == 0 {
+		s.Nodes = 3
+	}
+	if s.Clients == 0 {
+		s.Clients = 4
+	}
+	if s.Keys == 0 {
+		s.Keys = 3
+	}
+	if s.Duration == 0 {
+		s.Duration = Duration(10 * time.Second)
+	}
+	if s.Nodes < 1 || s.Clients < 1 || s.Keys < 1 || s.Duration < 0 {
+		return fmt.Errorf("nodes, clients, keys and duration must be positive")
+	}
+
+	sort.SliceStable(s.Steps, func(i, j int) bool { return s.Steps[i].At < s.Steps[j].At })
+	for i, step := range s.Steps {
+		if err := step.validate(s.Nodes); err != nil {
+			return fmt.Errorf("step %d (%s at %s): %v", i+1, step.Action, time.Duration(step.At), err)
+		}
+	}
+	return nil
+}
+
+func (step Step) validate(nodes int) error {
+	if step.At < 0 {
+		return fmt.Errorf("at must not be negative")
+	}
+	switch step.Action {
+	case ActionPartition:
+		if len(step.Groups) > 0 {
+			return nil
+		}
+		switch step.Mode {
+		case PartitionLeaderMinority, PartitionLeaderMajority, PartitionRandom:
+			return nil
+		case PartitionIsolate:
+			return step.Node.validate(nodes, false)
+		}
+		return fmt.Errorf("partition needs groups or a mode (leader-minority, leader-majority, random, isolate)")
+	case ActionHeal, ActionNetwork:
+		return nil
+	case ActionCrash:
+		return step.Node.validate(nodes, false)
+	case ActionRestart:
+		if step.Node == "" {
+			return nil
+		}
+		return step.Node.validate(nodes, true)
+	case ActionClockSkew:
+		return step.Node.validate(nodes, true)
+	case ActionDrop:
+		if step.Percent < 0 || step.Percent > 100 {
+			return fmt.Errorf("percent must be between 0 and 100")
+		}
+		return step.Node.validate(nodes, true)
+	}
+	return fmt.Errorf("unknown action")
+}
+
+func (t Target) validate(nodes int, allowAll bool) error {
+	switch t {
+	case "":
+		return fmt.Errorf("node is required")
+	case TargetLeader, TargetFollower, TargetRandom:
+		return nil
+	case TargetAll:
+		if allowAll {
+			return nil
+		}
+		return fmt.Errorf("node cannot be all for this action")
+	}
+	n, err := strconv.Atoi(string(t))
+	if err != nil || n < 0 || n >= nodes {
+		return fmt.Errorf("node %q does not exist", string(t))
+	}
+	return nil
+}
@@ -0,0 +1,753 @@
+package raft
+
+//
+// test harness for Raft: N peers on a labrpc network that can be
+// crashed, restarted from a copy of their persister, and partitioned.
+//
+// every committed command is collected from each peer's applyCh, so
+// the harness can check that peers agree on what is committed at each
+// index, and that each peer applies its log in order without gaps.
+//
+
+import (
+	"bytes"
+	"course/labgob"
+	"course/labrpc"
+	"course/transport"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// the service snapshots every snapshotInterval applied entries
+// when the harness is made with snapshots enabled.
+const snapshotInterval = 10
+
+// a leader must be elected well within this time after a failure.
+const electionTimeout = 1 * time.Second
+
+func randstring(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte('a' + rand.Intn(26))
+	}
+	return string(b)
+}
+
+type config struct {
+	mu          sync.Mutex
+	t           *testing.T
+	finished    bool
+	net         *labrpc.Network
+	n           int
+	rafts       []*Raft
+	applyErr    []string // from the apply channel readers
+	connected   []bool   // whether each server is on the net
+	saved       []*Persister
+	endnames    [][]string            // the port file names each sends to
+	logs        []map[int]interface{} // copy of each server's committed entries
+	lastApplied []int
+	snapshot    bool
+	start       time.Time   // time at which makeConfig() was called
+	leaders     map[int]int // term -> the one server seen as leader in it
+
+	// begin()/end() statistics
+	t0        time.Time // time at which begin() was called
+	rpcs0     int       // rpcTotal() at start of test
+	cmds0     int       // number of agreements
+	bytes0    int64
+	maxIndex  int
+	maxIndex0 int
+}
+
+func makeConfig(t *testing.T, n int, unreliable bool, snapshot bool) *config {
+	cfg := &config{}
+	cfg.t = t
+	cfg.net = labrpc.MakeNetwork()
+	cfg.n = n
+	cfg.applyErr = make([]string, n)
+	cfg.rafts = make([]*Raft, n)
+	cfg.connected = make([]bool, n)
+	cfg.saved = make([]*Persister, n)
+	cfg.endnames = make([][]string, n)
+	cfg.logs = make([]map[int]interface{}, n)
+	cfg.lastApplied = make([]int, n)
+	cfg.snapshot = snapshot
+	cfg.start = time.Now()
+	cfg.leaders = make(map[int]int)
+
+	cfg.setunreliable(unreliable)
+	cfg.net.LongDelays(true)
+
+	// create a full set of Rafts.
+	for i := 0; i < cfg.n; i++ {
+		cfg.logs[i] = map[int]interface{}{}
+		cfg.start1(i)
+	}
+
+	// connect everyone
+	for i := 0; i < cfg.n; i++ {
+		cfg.connect(i)
+	}
+
+	go cfg.monitor()
+	t.Cleanup(cfg.cleanup)
+	return cfg
+}
+
+// sample every server, connected or not, until the test ends and
+// check that no term ever has two leaders. checkOneLeader() only looks
+// when the test asks; this also catches short-lived split brains.
+func (cfg *config) monitor() {
+	for !cfg.checkFinished() {
+		for i := 0; i < cfg.n; i++ {
+			rf := cfg.raft(i)
+			if rf == nil {
+				continue
+			}
+			term, isLeader := rf.GetState()
+			if !isLeader {
+				continue
+			}
+			cfg.mu.Lock()
+			other, ok := cfg.leaders[term]
+			if !ok {
+				cfg.leaders[term] = i
+			}
+			cfg.mu.Unlock()
+			if ok && other != i {
+				cfg.fatalf("term %d has two leaders: %d and %d", term, other, i)
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// shut down a Raft server but save its persistent state.
+func (cfg *config) crash1(i int) {
+	cfg.disconnect(i)
+	cfg.net.DeleteServer(i) // disable client connections to the server.
+
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	// a fresh persister, in case old instance
+	// continues to update the Persister.
+	// but copy old persister's content so that we always
+	// pass Make() the last persisted state.
+	if cfg.saved[i] != nil {
+		cfg.saved[i] = cfg.saved[i].Copy()
+	}
+
+	rf := cfg.rafts[i]
+	if rf != nil {
+		cfg.mu.Unlock()
+		rf.Kill()
+		cfg.mu.Lock()
+		cfg.rafts[i] = nil
+	}
+
+	if cfg.saved[i] != nil {
+		raftlog := cfg.saved[i].ReadRaftState()
+		snapshot := cfg.saved[i].ReadSnapshot()
+		cfg.saved[i] = MakePersister()
+		cfg.saved[i].Save(raftlog, snapshot)
+	}
+}
+
+// check a newly applied command against what the other servers
+// have committed at the same index, and record it.
+func (cfg *config) checkLogs(i int, m ApplyMsg) (string, bool) {
+	errMsg := ""
+	v := m.Command
+	for j := 0; j < len(cfg.logs); j++ {
+		if old, oldok := cfg.logs[j][m.CommandIndex]; oldok && old != v {
+			// some server has already committed a different value for this entry!
+			errMsg = fmt.Sprintf("commit index=%v server=%v %v != server=%v %v",
+				m.CommandIndex, i, m.Command, j, old)
+		}
+	}
+	_, prevok := cfg.logs[i][m.CommandIndex-1]
+	cfg.logs[i][m.CommandIndex] = v
+	if m.CommandIndex > cfg.maxIndex {
+		cfg.maxIndex = m.CommandIndex
+	}
+	return errMsg, prevok
+}
+
+// applier reads message from apply ch and checks that they match the log
+// contents, and that they are applied in order.
+func (cfg *config) applier(i int, applyCh chan ApplyMsg) {
+	for m := range applyCh {
+		if m.CommandValid == false {
+			// ignore other types of ApplyMsg
+			continue
+		}
+		cfg.mu.Lock()
+		err, prevok := cfg.checkLogs(i, m)
+		cfg.mu.Unlock()
+		if m.CommandIndex > 1 && prevok == false {
+			err = fmt.Sprintf("server %v apply out of order %v", i, m.CommandIndex)
+		}
+		if err != "" {
+			cfg.fatalf("apply error: %v", err)
+			cfg.mu.Lock()
+			cfg.applyErr[i] = err
+			cfg.mu.Unlock()
+			// keep reading after error so that Raft doesn't block
+			// holding locks...
+		}
+	}
+}
+
+// returns "" or error string
+func (cfg *config) ingestSnap(i int, snapshot []byte, index int) string {
+	if snapshot == nil {
+		cfg.fatalf("nil snapshot")
+		return "nil snapshot"
+	}
+	r := bytes.NewBuffer(snapshot)
+	d := labgob.NewDecoder(r)
+	var lastIncludedIndex int
+	var xlog []interface{}
+	if d.Decode(&lastIncludedIndex) != nil ||
+		d.Decode(&xlog) != nil {
+		cfg.fatalf("snapshot decode error")
+		return "snapshot Decode() error"
+	}
+	if index != -1 && index != lastIncludedIndex {
+		err := fmt.Sprintf("server %v snapshot doesn't match m.SnapshotIndex", i)
+		return err
+	}
+	cfg.logs[i] = map[int]interface{}{}
+	for j := 0; j < len(xlog); j++ {
+		cfg.logs[i][j] = xlog[j]
+	}
+	cfg.lastApplied[i] = lastIncludedIndex
+	return ""
+}
+
+// periodically snapshot raft state
+func (cfg *config) applierSnap(i int, applyCh chan ApplyMsg) {
+	cfg.mu.Lock()
+	rf := cfg.rafts[i]
+	cfg.mu.Unlock()
+	if rf == nil {
+		return // ???
+	}
+
+	for m := range applyCh {
+		errMsg := ""
+		if m.SnapshotValid {
+			cfg.mu.Lock()
+			errMsg = cfg.ingestSnap(i, m.Snapshot, m.SnapshotIndex)
+			cfg.mu.Unlock()
+		} else if m.CommandValid {
+			cfg.mu.Lock()
+			expected := cfg.lastApplied[i] + 1
+			cfg.mu.Unlock()
+			if m.CommandIndex != expected {
+				errMsg = fmt.Sprintf("server %v apply out of order, expected index %v, got %v", i, expected, m.CommandIndex)
+			}
+
+			if errMsg == "" {
+				cfg.mu.Lock()
+				var prevok bool
+				errMsg, prevok = cfg.checkLogs(i, m)
+				cfg.mu.Unlock()
+				if m.CommandIndex > 1 && prevok == false {
+					errMsg = fmt.Sprintf("server %v apply out of order %v", i, m.CommandIndex)
+				}
+			}
+
+			cfg.mu.Lock()
+			cfg.lastApplied[i] = m.CommandIndex
+			cfg.mu.Unlock()
+
+			if (m.CommandIndex+1)%snapshotInterval == 0 {
+				w := new(bytes.Buffer)
+				e := labgob.NewEncoder(w)
+				e.Encode(m.CommandIndex)
+				var xlog []interface{}
+				cfg.mu.Lock()
+				for j := 0; j <= m.CommandIndex; j++ {
+					xlog = append(xlog, cfg.logs[i][j])
+				}
+				cfg.mu.Unlock()
+				e.Encode(xlog)
+				rf.Snapshot(m.CommandIndex, w.Bytes())
+			}
+		} else {
+			// Ignore other types of ApplyMsg.
+		}
+		if errMsg != "" {
+			cfg.fatalf("apply error: %v", errMsg)
+			cfg.mu.Lock()
+			cfg.applyErr[i] = errMsg
+			cfg.mu.Unlock()
+			// keep reading after error so that Raft doesn't block
+			// holding locks...
+		}
+	}
+}
+
+// start or re-start a Raft.
+// if one already exists, "kill" it first.
+// allocate new outgoing port file names, and a new
+// state persister, to isolate previous instance of
+// this server. since we cannot really kill it.
+func (cfg *config) start1(i int) {
+	cfg.crash1(i)
+
+	// a fresh set of outgoing ClientEnd names.
+	// so that old crashed instance's ClientEnds can't send.
+	cfg.endnames[i] = make([]string, cfg.n)
+	for j := 0; j < cfg.n; j++ {
+		cfg.endnames[i][j] = randstring(20)
+	}
+
+	// a fresh set of ClientEnds.
+	ends := make([]*labrpc.ClientEnd, cfg.n)
+	for j := 0; j < cfg.n; j++ {
+		ends[j] = cfg.net.MakeEnd(cfg.endnames[i][j])
+		cfg.net.Connect(cfg.endnames[i][j], j)
+	}
+
+	cfg.mu.Lock()
+
+	cfg.lastApplied[i] = 0
+
+	// a fresh persister, so old instance doesn't overwrite
+	// new instance's persisted state.
+	// but copy old persister's content so that we always
+	// pass Make() the last persisted state.
+	if cfg.saved[i] != nil {
+		cfg.saved[i] = cfg.saved[i].Copy()
+
+		snapshot := cfg.saved[i].ReadSnapshot()
+		if snapshot != nil && len(snapshot) > 0 {
+			// mimic KV server and process snapshot now.
+			// ideally Raft should send it up on applyCh...
+			err := cfg.ingestSnap(i, snapshot, -1)
+			if err != "" {
+				cfg.fatalf("%v", err)
+			}
+		}
+	} else {
+		cfg.saved[i] = MakePersister()
+	}
+
+	cfg.mu.Unlock()
+
+	applyCh := make(chan ApplyMsg)
+
+	rf := Make(transport.FromLabrpc(ends), i, cfg.saved[i], applyCh)
+
+	cfg.mu.Lock()
+	cfg.rafts[i] = rf
+	cfg.mu.Unlock()
+
+	if cfg.snapshot {
+		go cfg.applierSnap(i, applyCh)
+	} else {
+		go cfg.applier(i, applyCh)
+	}
+
+	svc := labrpc.MakeService(rf)
+	srv := labrpc.MakeServer()
+	srv.AddService(svc)
+	cfg.net.AddServer(i, srv)
+}
+
+func (cfg *config) checkTimeout() {
+	// enforce a two minute real-time limit on each test
+	if !cfg.t.Failed() && time.Since(cfg.start) > 120*time.Second {
+		cfg.fatalf("test took longer than 120 seconds")
+	}
+}
+
+func (cfg *config) checkFinished() bool {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return cfg.finished
+}
+
+func (cfg *config) cleanup() {
+	cfg.mu.Lock()
+	cfg.finished = true
+	cfg.mu.Unlock()
+	for i := 0; i < len(cfg.rafts); i++ {
+		cfg.mu.Lock()
+		rf := cfg.rafts[i]
+		cfg.mu.Unlock()
+		if rf != nil {
+			rf.Kill()
+		}
+	}
+	cfg.net.Cleanup()
+	cfg.checkTimeout()
+}
+
+// attach server i to the net.
+func (cfg *config) connect(i int) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.connected[i] = true
+
+	// outgoing ClientEnds
+	for j := 0; j < cfg.n; j++ {
+		if cfg.connected[j] {
+			endname := cfg.endnames[i][j]
+			cfg.net.Enable(endname, true)
+		}
+	}
+
+	// incoming ClientEnds
+	for j := 0; j < cfg.n; j++ {
+		if cfg.connected[j] {
+			endname := cfg.endnames[j][i]
+			cfg.net.Enable(endname, true)
+		}
+	}
+}
+
+// detach server i from the net.
+func (cfg *config) disconnect(i int) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.connected[i] = false
+
+	// outgoing ClientEnds
+	for j := 0; j < cfg.n; j++ {
+		if cfg.endnames[i] != nil {
+			endname := cfg.endnames[i][j]
+			cfg.net.Enable(endname, false)
+		}
+	}
+
+	// incoming ClientEnds
+	for j := 0; j < cfg.n; j++ {
+		if cfg.endnames[j] != nil {
+			endname := cfg.endnames[j][i]
+			cfg.net.Enable(endname, false)
+		}
+	}
+}
+
+// split the servers into groups that can only talk among themselves.
+// servers not listed in any group are disconnected.
+func (cfg *config) partition(groups ...[]int) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	group := make([]int, cfg.n)
+	for i := range group {
+		group[i] = -1
+	}
+	for g, servers := range groups {
+		for _, i := range servers {
+			group[i] = g
+		}
+	}
+	for i := 0; i < cfg.n; i++ {
+		cfg.connected[i] = group[i] >= 0
+		for j := 0; j < cfg.n; j++ {
+			if cfg.endnames[i] != nil {
+				cfg.net.Enable(cfg.endnames[i][j], group[i] >= 0 && group[i] == group[j])
+			}
+		}
+	}
+}
+
+func (cfg *config) rpcCount(server int) int {
+	return cfg.net.GetCount(server)
+}
+
+func (cfg *config) rpcTotal() int {
+	return cfg.net.GetTotalCount()
+}
+
+func (cfg *config) setunreliable(unrel bool) {
+	cfg.net.Reliable(!unrel)
+}
+
+func (cfg *config) bytesTotal() int64 {
+	return cfg.net.GetTotalBytes()
+}
+
+func (cfg *config) setlongreordering(longrel bool) {
+	cfg.net.LongReordering(longrel)
+}
+
+// fatalf fails the test from any goroutine: t.Fatalf may only be
+// called from the test goroutine, so appliers record the error and
+// the next check on the test goroutine stops the test.
+func (cfg *config) fatalf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	cfg.mu.Lock()
+	finished := cfg.finished
+	cfg.mu.Unlock()
+	if finished {
+		return
+	}
+	cfg.t.Error(msg)
+}
+
+// stop the test if an applier has reported an error.
+func (cfg *config) checkApplyErr() {
+	cfg.t.Helper()
+	if cfg.t.Failed() {
+		cfg.t.FailNow()
+	}
+}
+
+// check that one of the connected servers thinks
+// it is the leader, and that no other connected
+// server thinks otherwise.
+//
+// try a few times in case re-elections are needed.
+func (cfg *config) checkOneLeader() int {
+	cfg.t.Helper()
+	for iters := 0; iters < 10; iters++ {
+		ms := 450 + (rand.Int63() % 100)
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+
+		leaders := make(map[int][]int)
+		for i := 0; i < cfg.n; i++ {
+			if cfg.isConnected(i) {
+				if term, leader := cfg.raft(i).GetState(); leader {
+					leaders[term] = append(leaders[term], i)
+				}
+			}
+		}
+
+		lastTermWithLeader := -1
+		for term, leaders := range leaders {
+			if len(leaders) > 1 {
+				cfg.t.Fatalf("term %d has %d (>1) leaders", term, len(leaders))
+			}
+			if term > lastTermWithLeader {
+				lastTermWithLeader = term
+			}
+		}
+
+		if len(leaders) != 0 {
+			return leaders[lastTermWithLeader][0]
+		}
+	}
+	cfg.t.Fatalf("expected one leader, got none")
+	return -1
+}
+
+// check that everyone agrees on the term.
+func (cfg *config) checkTerms() int {
+	cfg.t.Helper()
+	term := -1
+	for i := 0; i < cfg.n; i++ {
+		if cfg.isConnected(i) {
+			xterm, _ := cfg.raft(i).GetState()
+			if term == -1 {
+				term = xterm
+			} else if term != xterm {
+				cfg.t.Fatalf("servers disagree on term")
+			}
+		}
+	}
+	return term
+}
+
+// check that none of the connected servers
+// thinks it is the leader.
+func (cfg *config) checkNoLeader() {
+	cfg.t.Helper()
+	for i := 0; i < cfg.n; i++ {
+		if cfg.isConnected(i) {
+			_, isLeader := cfg.raft(i).GetState()
+			if isLeader {
+				cfg.t.Fatalf("expected no leader among connected servers, but %v claims to be leader", i)
+			}
+		}
+	}
+}
+
+// how many servers think a log entry is committed?
+func (cfg *config) nCommitted(index int) (int, interface{}) {
+	cfg.t.Helper()
+	count := 0
+	var cmd interface{} = nil
+	for i := 0; i < len(cfg.rafts); i++ {
+		cfg.mu.Lock()
+		err := cfg.applyErr[i]
+		cmd1, ok := cfg.logs[i][index]
+		cfg.mu.Unlock()
+		if err != "" {
+			cfg.t.Fatal(err)
+		}
+
+		if ok {
+			if count > 0 && cmd != cmd1 {
+				cfg.t.Fatalf("committed values do not match: index %v, %v, %v", index, cmd, cmd1)
+			}
+			count += 1
+			cmd = cmd1
+		}
+	}
+	return count, cmd
+}
+
+// wait for at least n servers to commit.
+// but don't wait forever.
+func (cfg *config) wait(index int, n int, startTerm int) interface{} {
+	cfg.t.Helper()
+	to := 10 * time.Millisecond
+	for iters := 0; iters < 30; iters++ {
+		nd, _ := cfg.nCommitted(index)
+		if nd >= n {
+			break
+		}
+		time.Sleep(to)
+		if to < time.Second {
+			to *= 2
+		}
+		if startTerm > -1 {
+			for i := 0; i < cfg.n; i++ {
+				r := cfg.raft(i)
+				if r == nil {
+					continue
+				}
+				if t, _ := r.GetState(); t > startTerm {
+					// someone has moved on
+					// can no longer guarantee that we'll "win"
+					return -1
+				}
+			}
+		}
+	}
+	nd, cmd := cfg.nCommitted(index)
+	if nd < n {
+		cfg.t.Fatalf("only %d decided for index %d; wanted %d", nd, index, n)
+	}
+	return cmd
+}
+
+// do a complete agreement.
+// it might choose the wrong leader initially,
+// and have to re-submit after giving up.
+// entirely gives up after about 10 seconds.
+// indirectly checks that the servers agree on the
+// same value, since nCommitted() checks this,
+// as do the threads that read from applyCh.
+// returns index.
+// if retry==true, may submit the command multiple
+// times, in case a leader fails just after Start().
+// if retry==false, calls Start() only once, in order
+// to simplify the early Lab 3B tests.
+func (cfg *config) one(cmd interface{}, expectedServers int, retry bool) int {
+	cfg.t.Helper()
+	t0 := time.Now()
+	starts := 0
+	for time.Since(t0).Seconds() < 10 && cfg.checkFinished() == false {
+		// try all the servers, maybe one is the leader.
+		index := -1
+		for si := 0; si < cfg.n; si++ {
+			starts = (starts + 1) % cfg.n
+			var rf *Raft
+			cfg.mu.Lock()
+			if cfg.connected[starts] {
+				rf = cfg.rafts[starts]
+			}
+			cfg.mu.Unlock()
+			if rf != nil {
+				index1, _, ok := rf.Start(cmd)
+				if ok {
+					index = index1
+					break
+				}
+			}
+		}
+
+		if index != -1 {
+			// somebody claimed to be the leader and to have
+			// submitted our command; wait a while for agreement.
+			t1 := time.Now()
+			for time.Since(t1).Seconds() < 2 {
+				nd, cmd1 := cfg.nCommitted(index)
+				if nd > 0 && nd >= expectedServers {
+					// committed
+					if cmd1 == cmd {
+						// and it was the command we submitted.
+						return index
+					}
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+			if retry == false {
+				cfg.t.Fatalf("one(%v) failed to reach agreement", cmd)
+			}
+		} else {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	if cfg.checkFinished() == false {
+		cfg.t.Fatalf("one(%v) failed to reach agreement", cmd)
+	}
+	return -1
+}
+
+func (cfg *config) raft(i int) *Raft {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return cfg.rafts[i]
+}
+
+func (cfg *config) isConnected(i int) bool {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return cfg.connected[i]
+}
+
+// start a Test.
+// print the Test message.
+// e.g. cfg.begin("Test (3B): RPC counts aren't too high")
+func (cfg *config) begin(description string) {
+	cfg.t.Logf("%s ...", description)
+	cfg.t0 = time.Now()
+	cfg.rpcs0 = cfg.rpcTotal()
+	cfg.bytes0 = cfg.bytesTotal()
+	cfg.cmds0 = 0
+	cfg.mu.Lock()
+	cfg.maxIndex0 = cfg.maxIndex
+	cfg.mu.Unlock()
+}
+
+// end a Test -- the fact that we got here means there
+// was no failure.
+// print the Passed message,
+// and some performance numbers.
+func (cfg *config) end() {
+	cfg.checkTimeout()
+	if cfg.t.Failed() {
+		return
+	}
+	t := time.Since(cfg.t0).Seconds()       // real time
+	npeers := cfg.n                         // number of Raft peers
+	nrpc := cfg.rpcTotal() - cfg.rpcs0      // number of RPC sends
+	nbytes := cfg.bytesTotal() - cfg.bytes0 // number of bytes
+	cfg.mu.Lock()
+	ncmds := cfg.maxIndex - cfg.maxIndex0 // number of Raft agreements reported
+	cfg.mu.Unlock()
+	cfg.t.Logf("  ... Passed --  %4.1fs %d peers %4d rpcs %7d bytes %4d cmds", t, npeers, nrpc, nbytes, ncmds)
+}
+
+// maximum log size across all servers
+func (cfg *config) logSize() int {
+	logsize := 0
+	for i := 0; i < cfg.n; i++ {
+		n := cfg.saved[i].RaftStateSize()
+		if n > logsize {
+			logsize = n
+		}
+	}
+	return logsize
+}
@@ -0,0 +1,111 @@
+package raft
+
+import (
+	"course/metrics"
+	"strconv"
+	"time"
+)
+
+// Raft metrics live in metrics.Default and are labelled by node, so several
+// peers running in the same process (as in main.go) export separate series.
+var (
+	termGauge        = metrics.Default.NewGaugeVec("raft_term", "Current term of the node.", "node")
+	roleGauge        = metrics.Default.NewGaugeVec("raft_role", "1 for the role the node currently has, 0 for the others.", "node", "role")
+	commitIndexGauge = metrics.Default.NewGaugeVec("raft_commit_index", "Highest log index known to be committed.", "node")
+	appliedGauge     = metrics.Default.NewGaugeVec("raft_applied_index", "Highest log index delivered to the service.", "node")
+	lastIndexGauge   = metrics.Default.NewGaugeVec("raft_last_log_index", "Index of the last entry in the log.", "node")
+	logEntriesGauge  = metrics.Default.NewGaugeVec("raft_log_entries", "Log entries kept in memory after the last snapshot.", "node")
+	stateSizeGauge   = metrics.Default.NewGaugeVec("raft_state_size_bytes", "Size of the persisted Raft state (RaftStateSize).", "node")
+	snapSizeGauge    = metrics.Default.NewGaugeVec("raft_snapshot_size_bytes", "Size of the latest snapshot.", "node")
+	snapIndexGauge   = metrics.Default.NewGaugeVec("raft_snapshot_index", "Last log index included in the latest snapshot.", "node")
+	electionsCounter = metrics.Default.NewCounterVec("raft_elections_total", "Elections started by the node.", "node")
+	leaderCounter    = metrics.Default.NewCounterVec("raft_leader_elected_total", "Times the node became leader.", "node")
+	matchLagGauge    = metrics.Default.NewGaugeVec("raft_peer_match_lag", "Entries the leader has that the peer has not matched yet. Only exported by the leader.", "node", "peer")
+	rpcDuration      = metrics.Default.NewHistogramVec("raft_rpc_duration_seconds", "Latency of successful outgoing Raft RPCs.", nil, "node", "peer", "rpc")
+	rpcFailures      = metrics.Default.NewCounterVec("raft_rpc_failures_total", "Outgoing Raft RPCs that got no reply.", "node", "peer", "rpc")
+)
+
+var roles = []Role{Follower, Candidate, Leader}
+
+// per-node handles, resolved once so the hot paths don't look up labels
+type raftMetrics struct {
+	node       string
+	term       *metrics.Gauge
+	role       map[Role]*metrics.Gauge
+	commit     *metrics.Gauge
+	applied    *metrics.Gauge
+	lastIndex  *metrics.Gauge
+	logEntries *metrics.Gauge
+	stateSize  *metrics.Gauge
+	snapSize   *metrics.Gauge
+	snapIndex  *metrics.Gauge
+	elections  *metrics.Counter
+	leader     *metrics.Counter
+}
+
+func newRaftMetrics(me int) *raftMetrics {
+	node := strconv.Itoa(me)
+	m := &raftMetrics{
+		node:       node,
+		term:       termGauge.With(node),
+		role:       make(map[Role]*metrics.Gauge, len(roles)),
+		commit:     commitIndexGauge.With(node),
+		applied:    appliedGauge.With(node),
+		lastIndex:  lastIndexGauge.With(node),
+		logEntries: logEntriesGauge.With(node),
+		stateSize:  stateSizeGauge.With(node),
+		snapSize:   snapSizeGauge.With(node),
+		snapIndex:  snapIndexGauge.With(node),
+		elections:  electionsCounter.With(node),
+		leader:     leaderCounter.With(node),
+	}
+	for _, role := range roles {
+		m.role[role] = roleGauge.With(node, string(role))
+	}
+	return m
+}
+
+// refresh the gauges that mirror the node's state; called whenever that
+// state changes
+func (rf *Raft) updateMetricsLocked() {
+	m := rf.metrics
+	m.term.Set(float64(rf.currentTerm))
+	for role, g := range m.role {
+		if role == rf.role {
+			g.Set(1)
+		} else {
+			g.Set(0)
+		}
+	}
+	m.commit.Set(float64(rf.commitIndex))
+	m.applied.Set(float64(rf.lastApplied))
+	m.lastIndex.Set(float64(rf.log.size() - 1))
+	m.logEntries.Set(float64(rf.log.size() - 1 - rf.log.snapLastIdx))
+	m.stateSize.Set(float64(rf.persister.RaftStateSize()))
+	m.snapSize.Set(float64(len(rf.log.snapshot)))
+	m.snapIndex.Set(float64(rf.log.snapLastIdx))
+}
+
+// only the leader knows the peers' match index, so a node that steps down
+// drops its lag series instead of leaving stale values behind
+func (rf *Raft) updatePeerLagLocked() {
+	for peer := range rf.peers {
+		if peer == rf.me {
+			continue
+		}
+		if rf.role == Leader {
+			lag := rf.log.size() - 1 - rf.matchIndex[peer]
+			matchLagGauge.With(rf.metrics.node, strconv.Itoa(peer)).Set(float64(lag))
+		} else {
+			matchLagGauge.Delete(rf.metrics.node, strconv.Itoa(peer))
+		}
+	}
+}
+
+func (rf *Raft) observeRPC(peer int, rpc string, start time.Time, ok bool) {
+	if ok {
+		rpcDuration.With(rf.metrics.node, strconv.Itoa(peer), rpc).ObserveSince(start)
+	} else {
+		rpcFailures.With(rf.metrics.node, strconv.Itoa(peer), rpc).Inc()
+	}
+}
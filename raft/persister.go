@@ -9,14 +9,38 @@ package raft
 // test with the original before submitting.
 //
 
-import "sync"
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
 
 type Persister struct {
 	mu        sync.Mutex
 	raftstate []byte
 	snapshot  []byte
+
+	// set only for persisters created by MakeFilePersister.
+	dir          string
+	snapshotFile string // name of the snapshot file paired with the current state
+	snapshotSeq  int
+}
+
+// on-disk format of the state file. the snapshot is kept in a
+// separate file so that it is only rewritten when it changes;
+// the state file names the snapshot it belongs to, and since
+// the state file is replaced with an atomic rename, a crash
+// never pairs a Raft state with the wrong snapshot.
+type diskState struct {
+	RaftState    []byte
+	SnapshotFile string
 }
 
+const stateFileName = "raftstate"
+
 func MakePersister() *Persister {
 	return &Persister{}
 }
@@ -30,6 +54,8 @@ func clone(orig []byte) []byte {
 func (ps *Persister) Copy() *Persister {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
+	// the copy is always in memory; it is used by tests to
+	// restart a server from the state of a crashed one.
 	np := MakePersister()
 	np.raftstate = ps.raftstate
 	np.snapshot = ps.snapshot
@@ -53,10 +79,94 @@ func (ps *Persister) RaftStateSize() int {
 func (ps *Persister) Save(raftstate []byte, snapshot []byte) {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
+	if ps.dir != "" {
+		if err := ps.writeFilesLocked(raftstate, snapshot); err != nil {
+			// a node that cannot persist must not keep acknowledging
+			// RPCs as if its state were durable.
+			panic(fmt.Sprintf("persister: %v", err))
+		}
+	}
 	ps.raftstate = clone(raftstate)
 	ps.snapshot = clone(snapshot)
 }
 
+// create a persister that keeps its state in dir, loading whatever
+// a previous run saved there. the directory is created if needed.
+func MakeFilePersister(dir string) (*Persister, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	ps := &Persister{dir: dir}
+
+	data, err := os.ReadFile(filepath.Join(dir, stateFileName))
+	if os.IsNotExist(err) {
+		return ps, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var state diskState
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&state); err != nil {
+		return nil, fmt.Errorf("decode %s: %v", stateFileName, err)
+	}
+	ps.raftstate = state.RaftState
+	if state.SnapshotFile != "" {
+		ps.snapshot, err = os.ReadFile(filepath.Join(dir, state.SnapshotFile))
+		if err != nil {
+			return nil, err
+		}
+		ps.snapshotFile = state.SnapshotFile
+		fmt.Sscanf(state.SnapshotFile, "snapshot-%d", &ps.snapshotSeq)
+	}
+	return ps, nil
+}
+
+func (ps *Persister) writeFilesLocked(raftstate []byte, snapshot []byte) error {
+	snapshotFile := ps.snapshotFile
+	if len(snapshot) > 0 && (snapshotFile == "" || !bytes.Equal(snapshot, ps.snapshot)) {
+		ps.snapshotSeq++
+		snapshotFile = fmt.Sprintf("snapshot-%d", ps.snapshotSeq)
+		if err := writeFileAtomic(filepath.Join(ps.dir, snapshotFile), snapshot); err != nil {
+			return err
+		}
+	}
+
+	w := new(bytes.Buffer)
+	if err := gob.NewEncoder(w).Encode(diskState{RaftState: raftstate, SnapshotFile: snapshotFile}); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(ps.dir, stateFileName), w.Bytes()); err != nil {
+		return err
+	}
+
+	if snapshotFile != ps.snapshotFile && ps.snapshotFile != "" {
+		os.Remove(filepath.Join(ps.dir, ps.snapshotFile))
+	}
+	ps.snapshotFile = snapshotFile
+	return nil
+}
+
+// write data to a temporary file, sync it, and rename it over path.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
 func (ps *Persister) ReadSnapshot() []byte {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
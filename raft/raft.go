@@ -20,12 +20,14 @@ package raft
 import (
 	//	"bytes"
 
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	//	"course/labgob"
-	"course/labrpc"
+	"course/sim"
+	"course/transport"
 )
 
 const (
@@ -71,11 +73,11 @@ type ApplyMsg struct {
 
 // A Go object implementing a single Raft peer.
 type Raft struct {
-	mu        sync.Mutex          // Lock to protect shared access to this peer's state
-	peers     []*labrpc.ClientEnd // RPC end points of all peers
-	persister *Persister          // Object to hold this peer's persisted state
-	me        int                 // this peer's index into peers[]
-	dead      int32               // set by Kill()
+	mu        sync.Mutex            // Lock to protect shared access to this peer's state
+	peers     []transport.ClientEnd // RPC end points of all peers
+	persister *Persister            // Object to hold this peer's persisted state
+	me        int                   // this peer's index into peers[]
+	dead      int32                 // set by Kill()
 
 	// Your data here (PartA, PartB, PartC).
 	// Look at the paper's Figure 2 for a description of what
@@ -97,10 +99,13 @@ type Raft struct {
 	lastApplied int
 	applyCh     chan ApplyMsg
 	snapPending bool
-	applyCond   *sync.Cond
+	applyCond   *sim.Cond
 
 	electionStart   time.Time
 	electionTimeout time.Duration // random
+	rand            *sim.Rand     // per-peer stream, so a fixed seed gives the same timeouts
+
+	metrics *raftMetrics
 }
 
 func (rf *Raft) becomeFollowerLocked(term int) {
@@ -110,6 +115,7 @@ func (rf *Raft) becomeFollowerLocked(term int) {
 	}
 
 	LOG(rf.me, rf.currentTerm, DLog, "%s->Follower, For T%v->T%v", rf.role, rf.currentTerm, term)
+	wasLeader := rf.role == Leader
 	rf.role = Follower
 	shouldPersit := rf.currentTerm != term
 	if term > rf.currentTerm {
@@ -119,6 +125,10 @@ func (rf *Raft) becomeFollowerLocked(term int) {
 	if shouldPersit {
 		rf.persistLocked()
 	}
+	rf.updateMetricsLocked()
+	if wasLeader {
+		rf.updatePeerLagLocked()
+	}
 }
 
 func (rf *Raft) becomeCandidateLocked() {
@@ -132,6 +142,8 @@ func (rf *Raft) becomeCandidateLocked() {
 	rf.role = Candidate
 	rf.votedFor = rf.me
 	rf.persistLocked()
+	rf.metrics.elections.Inc()
+	rf.updateMetricsLocked()
 }
 
 func (rf *Raft) becomeLeaderLocked() {
@@ -146,6 +158,8 @@ func (rf *Raft) becomeLeaderLocked() {
 		rf.nextIndex[peer] = rf.log.size()
 		rf.matchIndex[peer] = 0
 	}
+	rf.metrics.leader.Inc()
+	rf.updateMetricsLocked()
 }
 
 // return currentTerm and whether this server
@@ -187,7 +201,7 @@ func (rf *Raft) Start(command interface{}) (int, int, bool) {
 		Command:      command,
 		Term:         rf.currentTerm,
 	})
-	LOG(rf.me, rf.currentTerm, DLeader, "Leader accept log [%d]T%d", rf.log.size()-1, rf.currentTerm)
+	LOG(rf.me, rf.currentTerm, DLog, "Leader accept log [%d]T%d", rf.log.size()-1, rf.currentTerm)
 	rf.persistLocked()
 
 	return rf.log.size() - 1, rf.currentTerm, true
@@ -225,12 +239,14 @@ func (rf *Raft) contextLostLocked(role Role, term int) bool {
 // tester or service expects Raft to send ApplyMsg messages.
 // Make() must return quickly, so it should start goroutines
 // for any long-running work.
-func Make(peers []*labrpc.ClientEnd, me int,
+func Make(peers []transport.ClientEnd, me int,
 	persister *Persister, applyCh chan ApplyMsg) *Raft {
 	rf := &Raft{}
 	rf.peers = peers
 	rf.persister = persister
 	rf.me = me
+	rf.metrics = newRaftMetrics(me)
+	rf.rand = sim.NewRand(fmt.Sprintf("raft-%d", me))
 
 	// Your initialization code here (PartA, PartB, PartC).
 	rf.role = Follower
@@ -246,17 +262,18 @@ func Make(peers []*labrpc.ClientEnd, me int,
 
 	// initialize the fields used for apply
 	rf.applyCh = applyCh
-	rf.applyCond = sync.NewCond(&rf.mu)
+	rf.applyCond = sim.NewCond(&rf.mu)
 	rf.commitIndex = 0
 	rf.lastApplied = 0
 	rf.snapPending = false
 
 	// initialize from state persisted before a crash
 	rf.readPersist(persister.ReadRaftState())
+	rf.updateMetricsLocked()
 
 	// start ticker goroutine to start elections
-	go rf.electionTicker()
-	go rf.applicationTicker()
+	sim.Go(rf.electionTicker)
+	sim.Go(rf.applicationTicker)
 
 	// log.Print("Raft has made!")
 	return rf
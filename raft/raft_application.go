@@ -1,11 +1,24 @@
 package raft
 
+import "course/sim"
+
 func (rf *Raft) applicationTicker() {
 	for !rf.killed() {
 		rf.mu.Lock()
 		rf.applyCond.Wait()
 		entries := make([]LogEntry, 0)
 		snapPendingApply := rf.snapPending
+		// copy what is sent while holding the lock: Snapshot() and
+		// InstallSnapshot() may replace it once we let go.
+		var snapMsg ApplyMsg
+		if snapPendingApply {
+			snapMsg = ApplyMsg{
+				SnapshotValid: true,
+				Snapshot:      rf.log.snapshot,
+				SnapshotIndex: rf.log.snapLastIdx,
+				SnapshotTerm:  rf.log.snapLastTerm,
+			}
+		}
 
 		if !snapPendingApply {
 			if rf.lastApplied < rf.log.snapLastIdx {
@@ -26,32 +39,33 @@ func (rf *Raft) applicationTicker() {
 
 		if !snapPendingApply {
 			for i, entry := range entries {
-				rf.applyCh <- ApplyMsg{
+				sim.Send(rf.applyCh, ApplyMsg{
 					CommandValid: entry.CommandValid,
 					Command:      entry.Command,
 					CommandIndex: rf.lastApplied + 1 + i, // must be cautious
-				}
+				})
 			}
 		} else {
-			rf.applyCh <- ApplyMsg{
-				SnapshotValid: true,
-				Snapshot:      rf.log.snapshot,
-				SnapshotIndex: rf.log.snapLastIdx,
-				SnapshotTerm:  rf.log.snapLastTerm,
-			}
+			sim.Send(rf.applyCh, snapMsg)
 		}
 
 		rf.mu.Lock()
 		if !snapPendingApply {
 			LOG(rf.me, rf.currentTerm, DApply, "Apply log for [%d, %d]", rf.lastApplied+1, rf.lastApplied+len(entries))
 			rf.lastApplied += len(entries)
+			rf.metrics.applied.Set(float64(rf.lastApplied))
 		} else {
-			LOG(rf.me, rf.currentTerm, DApply, "Apply snapshot for [0, %d]", 0, rf.log.snapLastIdx)
-			rf.lastApplied = rf.log.snapLastIdx
+			LOG(rf.me, rf.currentTerm, DApply, "Apply snapshot for [0, %d]", snapMsg.SnapshotIndex)
+			rf.lastApplied = snapMsg.SnapshotIndex
 			if rf.commitIndex < rf.lastApplied {
 				rf.commitIndex = rf.lastApplied
 			}
-			rf.snapPending = false
+			// a newer snapshot may have been installed meanwhile;
+			// leave it pending so that it is applied too.
+			if rf.log.snapLastIdx == snapMsg.SnapshotIndex {
+				rf.snapPending = false
+			}
+			rf.updateMetricsLocked()
 		}
 		rf.mu.Unlock()
 	}
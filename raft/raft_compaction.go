@@ -1,6 +1,9 @@
 package raft
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // the service says it has created a snapshot that has
 // all info up to and including index. this means the
@@ -81,7 +84,9 @@ func (rf *Raft) sendInstallSnapshot(server int, args *InstallSnapshotArgs, reply
 
 func (rf *Raft) installToPeer(peer, term int, args *InstallSnapshotArgs) {
 	reply := &InstallSnapshotReply{}
+	start := time.Now()
 	ok := rf.sendInstallSnapshot(peer, args, reply)
+	rf.observeRPC(peer, "InstallSnapshot", start, ok)
 
 	rf.mu.Lock()
 	defer rf.mu.Unlock()
@@ -2,18 +2,19 @@ package raft
 
 import (
 	"fmt"
-	"math/rand"
 	"time"
+
+	"course/sim"
 )
 
 func (rf *Raft) resetElectionTimerLocked() {
-	rf.electionStart = time.Now()
+	rf.electionStart = sim.Now()
 	randRange := int64(electionTimeoutMax - electionTimeoutMin)
-	rf.electionTimeout = electionTimeoutMin + time.Duration(rand.Int63()%randRange)
+	rf.electionTimeout = electionTimeoutMin + time.Duration(rf.rand.Int63()%randRange)
 }
 
 func (rf *Raft) isElectionTimeoutLocked() bool {
-	return time.Since(rf.electionStart) > rf.electionTimeout
+	return sim.Since(rf.electionStart) > rf.electionTimeout
 }
 
 // check whether my last log is more up to date than the candidate's last log
@@ -156,7 +157,7 @@ func (rf *Raft) startElection(term int) {
 			votes++
 			if votes > len(rf.peers)/2 {
 				rf.becomeLeaderLocked()
-				go rf.replicationTicker(term)
+				sim.Go(func() { rf.replicationTicker(term) })
 			}
 		}
 	}
@@ -164,7 +165,7 @@ func (rf *Raft) startElection(term int) {
 	rf.mu.Lock()
 	defer rf.mu.Unlock()
 	if rf.contextLostLocked(Candidate, term) {
-		LOG(rf.me, rf.currentTerm, DVote, "Lost Candidate[T%d] to %s[T%d], abort RequestVote", rf.role, term, rf.currentTerm)
+		LOG(rf.me, rf.currentTerm, DVote, "Lost Candidate[T%d] to %s[T%d], abort RequestVote", term, rf.role, rf.currentTerm)
 		return
 	}
 
@@ -183,7 +184,7 @@ func (rf *Raft) startElection(term int) {
 		}
 		LOG(rf.me, rf.currentTerm, DDebug, "-> S%d, AskVote, Args=%v", peer, args.String())
 
-		go askVoteFromPeer(peer, args)
+		sim.Go(func() { askVoteFromPeer(peer, args) })
 	}
 }
 
@@ -195,13 +196,14 @@ func (rf *Raft) electionTicker() {
 		rf.mu.Lock()
 		if rf.role != Leader && rf.isElectionTimeoutLocked() {
 			rf.becomeCandidateLocked()
-			go rf.startElection(rf.currentTerm)
+			term := rf.currentTerm
+			sim.Go(func() { rf.startElection(term) })
 		}
 		rf.mu.Unlock()
 
 		// pause for a random amount of time between 50 and 350
 		// milliseconds.
-		ms := 50 + (rand.Int63() % 300)
-		time.Sleep(time.Duration(ms) * time.Millisecond)
+		ms := 50 + (rf.rand.Int63() % 300)
+		sim.Sleep(time.Duration(ms) * time.Millisecond)
 	}
 }
@@ -0,0 +1,149 @@
+package raft
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestInitialElection(t *testing.T) {
+	servers := 3
+	cfg := makeConfig(t, servers, false, false)
+
+	cfg.begin("Test: initial election")
+
+	// is a leader elected?
+	cfg.checkOneLeader()
+
+	// sleep a bit to avoid racing with followers learning of the
+	// election, then check that all peers agree on the term.
+	time.Sleep(50 * time.Millisecond)
+	term1 := cfg.checkTerms()
+	if term1 < 1 {
+		t.Fatalf("term is %v, but should be at least 1", term1)
+	}
+
+	// does the leader+term stay the same if there is no network failure?
+	time.Sleep(2 * electionTimeout)
+	term2 := cfg.checkTerms()
+	if term1 != term2 {
+		t.Logf("warning: term changed even though there were no failures")
+	}
+
+	// there should still be a leader.
+	cfg.checkOneLeader()
+
+	cfg.end()
+}
+
+func TestReElection(t *testing.T) {
+	servers := 3
+	cfg := makeConfig(t, servers, false, false)
+
+	cfg.begin("Test: election after network failure")
+
+	leader1 := cfg.checkOneLeader()
+
+	// if the leader disconnects, a new one should be elected.
+	cfg.disconnect(leader1)
+	cfg.checkOneLeader()
+
+	// if the old leader rejoins, that shouldn't
+	// disturb the new leader. and the old leader
+	// should switch to follower.
+	cfg.connect(leader1)
+	leader2 := cfg.checkOneLeader()
+
+	// if there's no quorum, no new leader should
+	// be elected.
+	cfg.disconnect(leader2)
+	cfg.disconnect((leader2 + 1) % servers)
+	time.Sleep(2 * electionTimeout)
+
+	// check that the one connected server
+	// does not think it is the leader.
+	cfg.checkNoLeader()
+
+	// if a quorum arises, it should elect a leader.
+	cfg.connect((leader2 + 1) % servers)
+	cfg.checkOneLeader()
+
+	// re-join of last node shouldn't prevent leader from existing.
+	cfg.connect(leader2)
+	cfg.checkOneLeader()
+
+	cfg.end()
+}
+
+func TestManyElections(t *testing.T) {
+	servers := 7
+	cfg := makeConfig(t, servers, false, false)
+
+	cfg.begin("Test: multiple elections")
+
+	cfg.checkOneLeader()
+
+	iters := 10
+	for ii := 1; ii < iters; ii++ {
+		// disconnect three nodes
+		i1 := rand.Int() % servers
+		i2 := rand.Int() % servers
+		i3 := rand.Int() % servers
+		cfg.disconnect(i1)
+		cfg.disconnect(i2)
+		cfg.disconnect(i3)
+
+		// either the current leader should still be alive,
+		// or the remaining four should elect a new one.
+		cfg.checkOneLeader()
+
+		cfg.connect(i1)
+		cfg.connect(i2)
+		cfg.connect(i3)
+	}
+
+	cfg.checkOneLeader()
+
+	cfg.end()
+}
+
+// the leader of a minority partition must not stay the only leader:
+// the majority elects a new one in a higher term, and once healed the
+// old leader steps down.
+func TestPartitionedLeader(t *testing.T) {
+	servers := 5
+	cfg := makeConfig(t, servers, false, false)
+
+	cfg.begin("Test: leader in a minority partition")
+
+	leader1 := cfg.checkOneLeader()
+	term1, _ := cfg.raft(leader1).GetState()
+
+	minority := []int{leader1, (leader1 + 1) % servers}
+	majority := []int{(leader1 + 2) % servers, (leader1 + 3) % servers, (leader1 + 4) % servers}
+	cfg.partition(minority, majority)
+
+	// only the majority can elect, so the leader checkOneLeader finds
+	// in the newest term must come from it.
+	time.Sleep(2 * electionTimeout)
+	var leader2, term2 = -1, -1
+	for _, i := range majority {
+		if term, isLeader := cfg.raft(i).GetState(); isLeader {
+			leader2, term2 = i, term
+		}
+	}
+	if leader2 == -1 {
+		t.Fatalf("majority %v did not elect a leader", majority)
+	}
+	if term2 <= term1 {
+		t.Fatalf("new leader's term %d is not after the old leader's %d", term2, term1)
+	}
+
+	cfg.partition(append(minority, majority...))
+	cfg.checkOneLeader()
+	if term, isLeader := cfg.raft(leader1).GetState(); isLeader && term <= term2 {
+		t.Fatalf("old leader %d still leads in term %d after healing", leader1, term)
+	}
+
+	cfg.end()
+}
@@ -33,6 +33,7 @@ func (rf *Raft) persistLocked() {
 	rf.log.persist(e)
 	raftstate := w.Bytes()
 	rf.persister.Save(raftstate, rf.log.snapshot)
+	rf.updateMetricsLocked()
 	LOG(rf.me, rf.currentTerm, DPersist, "Persist: %v", rf.persistString())
 }
 
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"sort"
 	"time"
+
+	"course/sim"
 )
 
 type LogEntry struct {
@@ -99,6 +101,7 @@ func (rf *Raft) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply
 	if args.LeaderCommit > rf.commitIndex {
 		LOG(rf.me, rf.currentTerm, DApply, "Follower update the commit index %d->%d", rf.commitIndex, args.LeaderCommit)
 		rf.commitIndex = args.LeaderCommit
+		rf.metrics.commit.Set(float64(rf.commitIndex))
 		rf.applyCond.Signal()
 	}
 
@@ -122,7 +125,9 @@ func (rf *Raft) getMajorityIndexLocked() int {
 func (rf *Raft) startReplication(term int) bool {
 	replicateToPeer := func(peer int, args *AppendEntriesArgs) {
 		reply := &AppendEntriesReply{}
+		start := time.Now()
 		ok := rf.sendAppendEntries(peer, args, reply)
+		rf.observeRPC(peer, "AppendEntries", start, ok)
 
 		rf.mu.Lock()
 		defer rf.mu.Unlock()
@@ -184,6 +189,7 @@ func (rf *Raft) startReplication(term int) bool {
 		if majorityMatched > rf.commitIndex && rf.log.at(majorityMatched).Term == rf.currentTerm {
 			LOG(rf.me, rf.currentTerm, DApply, "Leader update the commit index %d->%d", rf.commitIndex, majorityMatched)
 			rf.commitIndex = majorityMatched
+			rf.metrics.commit.Set(float64(rf.commitIndex))
 			rf.applyCond.Signal()
 		}
 	}
@@ -196,6 +202,7 @@ func (rf *Raft) startReplication(term int) bool {
 		return false
 	}
 
+	rf.updatePeerLagLocked()
 	for peer := 0; peer < len(rf.peers); peer++ {
 		if peer == rf.me {
 			rf.matchIndex[peer] = rf.log.size() - 1
@@ -213,7 +220,7 @@ func (rf *Raft) startReplication(term int) bool {
 				Snapshot:          rf.log.snapshot,
 			}
 			LOG(rf.me, rf.currentTerm, DDebug, "-> S%d, SendSnap, Args=%v", peer, args.String())
-			go rf.installToPeer(peer, term, args)
+			sim.Go(func() { rf.installToPeer(peer, term, args) })
 			continue
 		}
 
@@ -227,7 +234,7 @@ func (rf *Raft) startReplication(term int) bool {
 			LeaderCommit: rf.commitIndex,
 		}
 		LOG(rf.me, rf.currentTerm, DDebug, "-> S%d, Append, Args=%v", peer, args.String())
-		go replicateToPeer(peer, args)
+		sim.Go(func() { replicateToPeer(peer, args) })
 	}
 
 	return true
@@ -241,6 +248,6 @@ func (rf *Raft) replicationTicker(term int) {
 			break
 		}
 
-		time.Sleep(replicateInterval)
+		sim.Sleep(replicateInterval)
 	}
 }
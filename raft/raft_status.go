@@ -0,0 +1,60 @@
+package raft
+
+// PeerStatus is the leader's replication progress for one follower.
+type PeerStatus struct {
+	ID         int
+	MatchIndex int
+	NextIndex  int
+}
+
+// Status is a consistent view of a peer's state, taken in one critical
+// section so that the indexes relate to each other as in Figure 2.
+type Status struct {
+	ID            int
+	Role          Role
+	Term          int
+	VotedFor      int
+	CommitIndex   int
+	LastApplied   int
+	SnapshotIndex int
+	SnapshotTerm  int
+	LastLogIndex  int
+	LogLength     int // entries kept in memory after the snapshot
+
+	// only filled in on the leader, one entry per other peer
+	Peers []PeerStatus
+}
+
+// Status returns the peer's current state for monitoring. It takes rf.mu
+// only for as long as it takes to copy the fields.
+func (rf *Raft) Status() Status {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	lastIndex := rf.log.size() - 1
+	s := Status{
+		ID:            rf.me,
+		Role:          rf.role,
+		Term:          rf.currentTerm,
+		VotedFor:      rf.votedFor,
+		CommitIndex:   rf.commitIndex,
+		LastApplied:   rf.lastApplied,
+		SnapshotIndex: rf.log.snapLastIdx,
+		SnapshotTerm:  rf.log.snapLastTerm,
+		LastLogIndex:  lastIndex,
+		LogLength:     lastIndex - rf.log.snapLastIdx,
+	}
+	if rf.role == Leader {
+		for peer := range rf.peers {
+			if peer == rf.me {
+				continue
+			}
+			s.Peers = append(s.Peers, PeerStatus{
+				ID:         peer,
+				MatchIndex: rf.matchIndex[peer],
+				NextIndex:  rf.nextIndex[peer],
+			})
+		}
+	}
+	return s
+}
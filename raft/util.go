@@ -1,22 +1,21 @@
 package raft
 
 import (
+	"context"
+	"course/logging"
 	"fmt"
-	"log"
-	"os"
-	"strconv"
-	"time"
+	"log/slog"
 )
 
 type logTopic string
 
 const (
-	DError logTopic = "ERRO" // level = 3
-	DWarn  logTopic = "WARN" // level = 2
-	DInfo  logTopic = "INFO" // level = 1
-	DDebug logTopic = "DBUG" // level = 0
+	DError logTopic = "ERRO"
+	DWarn  logTopic = "WARN"
+	DInfo  logTopic = "INFO"
+	DDebug logTopic = "DBUG"
 
-	// Custom topics with distinct colors
+	// Custom topics, logged at debug level except DLeader
 	DClient  logTopic = "CLNT"
 	DCommit  logTopic = "CMIT"
 	DDrop    logTopic = "DROP"
@@ -33,111 +32,31 @@ const (
 	DApply   logTopic = "APLY"
 )
 
-func getTopicLevel(topic logTopic) int {
+// Level of each topic: errors, warnings and leader changes are reported
+// by default, everything else is debug output.
+func getTopicLevel(topic logTopic) slog.Level {
 	switch topic {
 	case DError:
-		return 3
+		return slog.LevelError
 	case DWarn:
-		return 2
-	case DInfo:
-		return 1
-	case DDebug:
-		return 0
+		return slog.LevelWarn
+	case DInfo, DLeader:
+		return slog.LevelInfo
 	default:
-		return 1
+		return slog.LevelDebug
 	}
 }
 
-func getEnvLevel() int {
-	v := os.Getenv("VERBOSE")
-	level := getTopicLevel(DError) + 1
-	if v != "" {
-		var err error
-		level, err = strconv.Atoi(v)
-		if err != nil {
-			log.Fatalf("Invalid verbosity %v", v)
-		}
-	}
-	return level
-}
-
-var logStart time.Time
-var logLevel int
-
-// ANSI color codes for better visualization
-const (
-	reset     = "\033[0m"
-	red       = "\033[31m"
-	yellow    = "\033[33m"
-	green     = "\033[32m"
-	blue      = "\033[34m"
-	magenta   = "\033[35m"
-	cyan      = "\033[36m"
-	white     = "\033[37m"
-	lightRed  = "\033[91m"
-	lightCyan = "\033[96m"
-)
-
-func init() {
-	logLevel = getEnvLevel()
-	logStart = time.Now()
-
-	// Remove default log date and time
-	log.SetFlags(log.Flags() &^ (log.Ldate | log.Ltime))
-}
-
-// Color mapping for each topic
-func getColorForTopic(topic logTopic) string {
-	switch topic {
-	case DError:
-		return red
-	case DWarn:
-		return yellow
-	case DInfo:
-		return green
-	case DDebug:
-		return blue
-	case DClient:
-		return cyan
-	case DCommit:
-		return magenta
-	case DDrop:
-		return lightRed
-	case DLeader:
-		return lightCyan
-	case DLog:
-		return green
-	case DLog2:
-		return yellow
-	case DPersist:
-		return blue
-	case DSnap:
-		return magenta
-	case DTerm:
-		return cyan
-	case DTest:
-		return white
-	case DTimer:
-		return lightRed
-	case DTrace:
-		return lightCyan
-	case DVote:
-		return yellow
-	case DApply:
-		return lightCyan
-	default:
-		return reset
-	}
-}
+var logger = logging.Logger(logging.TopicRaft)
 
+// LOG writes one structured entry to the raft logging topic, with the node
+// id, term and topic as fields. The level of the topic can be changed at
+// runtime through the logging package; VERBOSE is still honoured.
 func LOG(peerId int, term int, topic logTopic, format string, a ...interface{}) {
-	topicLevel := getTopicLevel(topic)
-	if logLevel <= topicLevel {
-		timeElapsed := time.Since(logStart).Microseconds()
-		timeElapsed /= 100
-		color := getColorForTopic(topic)
-		prefix := fmt.Sprintf("%s%06d | T%04d | %-5s | S%d%s ", color, timeElapsed, term, string(topic), peerId, reset)
-		format = prefix + format
-		log.Printf(format, a...)
+	level := getTopicLevel(topic)
+	if !logger.Enabled(context.Background(), level) {
+		return
 	}
+	logger.Log(context.Background(), level, fmt.Sprintf(format, a...),
+		"node", peerId, "term", term, "event", string(topic))
 }
@@ -0,0 +1,174 @@
+#!/usr/bin/env bash
+# 在本机启动 3 个 kvnode 进程和 1 个 kvgateway，杀掉其中一个节点后检查集群仍能读写，
+# 再重启该节点，检查它从 data-dir 恢复并追上其余节点。
+#
+#   scripts/cluster_test.sh            # 使用默认端口 9100-9102 和 18080
+#   BASE_PORT=9200 HTTP_PORT=18081 scripts/cluster_test.sh
+#   TLS=1 scripts/cluster_test.sh      # 节点之间和网关到节点都使用双向 TLS，证书在测试时生成，
+#                                      # 并检查节点拒绝不受信任的客户端和冒充其他节点的 Raft 请求
+set -euo pipefail
+
+cd "$(dirname "$0")/.."
+
+BASE_PORT=${BASE_PORT:-9100}
+HTTP_PORT=${HTTP_PORT:-18080}
+WORK=$(mktemp -d)
+PEERS="127.0.0.1:${BASE_PORT},127.0.0.1:$((BASE_PORT + 1)),127.0.0.1:$((BASE_PORT + 2))"
+GATEWAY="http://127.0.0.1:${HTTP_PORT}"
+
+declare -a PIDS=()
+
+cleanup() {
+	for pid in "${PIDS[@]}"; do
+		kill "$pid" 2>/dev/null || true
+	done
+	wait 2>/dev/null || true
+	[ -n "${KEEP_WORK:-}" ] || rm -rf "${WORK:?}"
+}
+trap cleanup EXIT
+
+fail() {
+	echo "FAIL: $*" >&2
+	echo "--- logs in $WORK ---" >&2
+	tail -n 20 "$WORK"/*.log >&2 || true
+	trap - EXIT
+	for pid in "${PIDS[@]}"; do kill "$pid" 2>/dev/null || true; done
+	exit 1
+}
+
+go build -o "$WORK/kvnode" ./cmd/kvnode
+go build -o "$WORK/kvgateway" ./cmd/kvgateway
+go build -o "$WORK/kvcerts" ./cmd/kvcerts
+
+# 返回某个身份使用的 TLS 参数，未启用 TLS 时为空
+tls_flags() {
+	if [ -n "${TLS:-}" ]; then
+		echo "--tls-cert $WORK/certs/$1.crt --tls-key $WORK/certs/$1.key --tls-ca $WORK/certs/ca.crt"
+	fi
+}
+
+if [ -n "${TLS:-}" ]; then
+	"$WORK/kvcerts" --dir "$WORK/certs" --nodes 3 --clients gateway 2>/dev/null
+	# 另一个 CA 签发的证书，用来检查节点拒绝不受信任的客户端
+	"$WORK/kvcerts" --dir "$WORK/rogue" --nodes 0 --clients gateway 2>/dev/null
+fi
+
+start_node() {
+	local id=$1
+	# shellcheck disable=SC2046
+	"$WORK/kvnode" --id "$id" --peers "$PEERS" --data-dir "$WORK/node$id" $(tls_flags "node-$id") >>"$WORK/node$id.log" 2>&1 &
+	PIDS[$id]=$!
+}
+
+put() {
+	curl -sf -m 10 -X POST -H "Content-Type: application/json" \
+		-d "{\"key\": \"$1\", \"value\": {\"name\": \"$2\", \"grand\": 2024}}" "$GATEWAY/put" >/dev/null
+}
+
+get_name() {
+	curl -sf -m 10 "$GATEWAY/get?key=$1" | sed -n 's/.*"name":"\([^"]*\)".*/\1/p'
+}
+
+# 等待键的 name 字段变为期望值，选举期间的请求可能失败，需要重试
+expect_name() {
+	for _ in $(seq 1 25); do
+		if [ "$(get_name "$1" || true)" = "$2" ]; then
+			return 0
+		fi
+		sleep 0.2
+	done
+	return 1
+}
+
+# 写入并确认可以读到，选举期间网关的写入可能在重试用尽后放弃，此时重新写入
+write() {
+	for _ in $(seq 1 10); do
+		put "$1" "$2" || true
+		if expect_name "$1" "$2"; then
+			return 0
+		fi
+	done
+	return 1
+}
+
+# 等待网关可以完成一次写入
+wait_ready() {
+	for _ in $(seq 1 50); do
+		if put 20249999 ready 2>/dev/null; then
+			return 0
+		fi
+		sleep 0.2
+	done
+	fail "cluster did not become ready"
+}
+
+for id in 0 1 2; do
+	start_node "$id"
+done
+# shellcheck disable=SC2046
+"$WORK/kvgateway" --peers "$PEERS" --listen "127.0.0.1:${HTTP_PORT}" $(tls_flags gateway) >"$WORK/gateway.log" 2>&1 &
+PIDS[3]=$!
+wait_ready
+echo "cluster is up"
+
+write 20240001 before || fail "put before kill"
+curl -sf -m 10 "$GATEWAY/readyz" | grep -q '"reachable":3' || fail "readyz before kill"
+
+# 启用 TLS 时，没有证书或证书不是集群 CA 签发的网关都读不到数据
+if [ -n "${TLS:-}" ]; then
+	ROGUE_PORT=$((HTTP_PORT + 1))
+	"$WORK/kvgateway" --peers "$PEERS" --listen "127.0.0.1:${ROGUE_PORT}" >"$WORK/plain.log" 2>&1 &
+	PIDS[4]=$!
+	"$WORK/kvgateway" --peers "$PEERS" --listen "127.0.0.1:$((ROGUE_PORT + 1))" \
+		--tls-cert "$WORK/rogue/gateway.crt" --tls-key "$WORK/rogue/gateway.key" --tls-ca "$WORK/certs/ca.crt" >"$WORK/rogue.log" 2>&1 &
+	PIDS[5]=$!
+	sleep 1
+	for port in "$ROGUE_PORT" "$((ROGUE_PORT + 1))"; do
+		if curl -sf -m 10 "http://127.0.0.1:${port}/get?key=20240001" | grep -q before; then
+			fail "gateway on port $port without a trusted certificate could read data"
+		fi
+	done
+	kill "${PIDS[4]}" "${PIDS[5]}"
+	echo "untrusted clients are rejected"
+
+	# 节点证书只能以自己的编号发送 Raft 请求，node-1 的证书冒充节点 2 请求投票会被拒绝
+	forge_vote() {
+		go run scripts/forge_vote.go --peers "$PEERS" --target 0 --claim "$1" \
+			--tls-cert "$WORK/certs/node-1.crt" --tls-key "$WORK/certs/node-1.key" --tls-ca "$WORK/certs/ca.crt"
+	}
+	forge_vote 1 || fail "node 0 rejected a RequestVote matching the certificate"
+	if forge_vote 2 2>/dev/null; then
+		fail "node 0 accepted a RequestVote from node-1 claiming to be node 2"
+	fi
+	echo "Raft requests with a wrong identity are rejected"
+fi
+
+# 杀掉一个节点（不一定是领导者），剩下两个节点仍构成多数派
+kill -9 "${PIDS[0]}"
+wait "${PIDS[0]}" 2>/dev/null || true
+echo "killed node 0"
+
+write 20240002 after || fail "put after kill"
+expect_name 20240001 before || fail "lost write made before the kill"
+expect_name 20240002 after || fail "write after the kill not readable"
+echo "cluster keeps serving with 2 of 3 nodes"
+
+# 两个节点仍是多数派，集群就绪，状态中节点 0 不可达
+curl -sf -m 10 "$GATEWAY/readyz" >/dev/null || fail "not ready with 2 of 3 nodes"
+curl -sf -m 10 "$GATEWAY/cluster/status" | grep -q '"id":0,"reachable":false' || fail "killed node 0 reported reachable"
+echo "status reports node 0 down"
+
+# 重启节点 0，它应当从自己的 data-dir 恢复 Raft 状态，并从领导者补齐日志
+start_node 0
+sleep 2
+
+# 再杀掉另一个节点，此时多数派必须包含重启后的节点 0
+kill -9 "${PIDS[1]}"
+wait "${PIDS[1]}" 2>/dev/null || true
+echo "restarted node 0, killed node 1"
+
+write 20240003 restarted || fail "put after restarting node 0"
+expect_name 20240002 after || fail "node 0 did not catch up"
+expect_name 20240003 restarted || fail "write with restarted node not readable"
+
+echo "PASS"
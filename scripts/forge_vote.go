@@ -0,0 +1,40 @@
+//go:build ignore
+
+// forge_vote 用一个节点的证书向另一个节点发送 RequestVote，检查节点只接受证书身份与
+// CandidateId 一致的请求，由 cluster_test.sh 在启用 TLS 时调用
+//
+//	go run scripts/forge_vote.go --peers <addrs> --target 0 --claim 2 \
+//	    --tls-cert node-1.crt --tls-key node-1.key --tls-ca ca.crt
+//
+// 请求的任期为 0，被接受时也不会影响集群；请求被拒绝时退出码为 1
+package main
+
+import (
+	"course/raft"
+	"course/transport"
+	"flag"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	peers := flag.String("peers", "", "comma-separated addresses of all nodes, ordered by id")
+	target := flag.Int("target", 0, "node to send the RequestVote to")
+	claim := flag.Int("claim", 0, "CandidateId to put in the request")
+	var tlsFiles transport.TLSFiles
+	flag.StringVar(&tlsFiles.CertFile, "tls-cert", "", "certificate to present")
+	flag.StringVar(&tlsFiles.KeyFile, "tls-key", "", "private key of --tls-cert")
+	flag.StringVar(&tlsFiles.CAFile, "tls-ca", "", "CA that signs all node certificates")
+	flag.Parse()
+
+	config, err := tlsFiles.ClientConfig()
+	if err != nil {
+		log.Fatalf("Failed to load TLS client config: %v", err)
+	}
+	ends := transport.NewTLSClientEnds(strings.Split(*peers, ","), config)
+	args := &raft.RequestVoteArgs{Term: 0, CandidateId: *claim}
+	if !ends[*target].Call("Raft.RequestVote", args, &raft.RequestVoteReply{}) {
+		os.Exit(1)
+	}
+}
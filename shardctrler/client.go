@@ -0,0 +1,84 @@
+package shardctrler
+
+import (
+	"course/transport"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type Clerk struct {
+	mu       sync.Mutex
+	servers  []transport.ClientEnd
+	clientID int64
+	seqNum   int
+	leaderID int
+}
+
+func MakeClerk(servers []transport.ClientEnd) *Clerk {
+	return &Clerk{
+		servers:  servers,
+		clientID: rand.Int63(),
+	}
+}
+
+// 依次尝试各个控制器节点，直到领导者执行成功
+func (ck *Clerk) call(method string, args interface{}, newReply func() interface{}, errOf func(interface{}) Err) interface{} {
+	for {
+		for i := 0; i < len(ck.servers); i++ {
+			server := (ck.leaderID + i) % len(ck.servers)
+			reply := newReply()
+			ok := ck.servers[server].Call(method, args, reply)
+			if ok && errOf(reply) == OK {
+				ck.leaderID = server
+				return reply
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// 查询编号为 num 的配置，num 为 -1 时返回最新配置
+func (ck *Clerk) Query(num int) Config {
+	ck.mu.Lock()
+	defer ck.mu.Unlock()
+
+	args := &QueryArgs{Num: num}
+	reply := ck.call("ShardCtrler.Query", args,
+		func() interface{} { return &QueryReply{} },
+		func(r interface{}) Err { return r.(*QueryReply).Err })
+	return reply.(*QueryReply).Config
+}
+
+func (ck *Clerk) Join(servers map[int][]string) {
+	ck.mu.Lock()
+	defer ck.mu.Unlock()
+
+	ck.seqNum++
+	args := &JoinArgs{Servers: servers, ClientID: ck.clientID, SeqNum: ck.seqNum}
+	ck.call("ShardCtrler.Join", args,
+		func() interface{} { return &JoinReply{} },
+		func(r interface{}) Err { return r.(*JoinReply).Err })
+}
+
+func (ck *Clerk) Leave(gids []int) {
+	ck.mu.Lock()
+	defer ck.mu.Unlock()
+
+	ck.seqNum++
+	args := &LeaveArgs{GIDs: gids, ClientID: ck.clientID, SeqNum: ck.seqNum}
+	ck.call("ShardCtrler.Leave", args,
+		func() interface{} { return &LeaveReply{} },
+		func(r interface{}) Err { return r.(*LeaveReply).Err })
+}
+
+func (ck *Clerk) Move(shard int, gid int) {
+	ck.mu.Lock()
+	defer ck.mu.Unlock()
+
+	ck.seqNum++
+	args := &MoveArgs{Shard: shard, GID: gid, ClientID: ck.clientID, SeqNum: ck.seqNum}
+	ck.call("ShardCtrler.Move", args,
+		func() interface{} { return &MoveReply{} },
+		func(r interface{}) Err { return r.(*MoveReply).Err })
+}
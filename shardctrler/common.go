@@ -0,0 +1,99 @@
+package shardctrler
+
+// 分片控制器：保存分片到复制组的分配关系，本身也是一个 Raft 复制组
+//
+// 一份配置（Config）记录每个分片属于哪个复制组（gid），以及每个复制组的服务器名
+// 每次 Join、Leave、Move 都会生成一份编号加一的新配置
+//
+// Join(servers)  -- 加入新的复制组，gid -> 服务器名列表
+// Leave(gids)    -- 移除复制组，其分片分给剩余的复制组
+// Move(shard, gid) -- 将一个分片指定给某个复制组
+// Query(num)     -- 查询编号为 num 的配置，num 为 -1 或超过最新编号时返回最新配置
+
+// 分片数量
+const NShards = 10
+
+// 编号为 0 的初始配置中所有分片都属于无效的 gid 0
+type Config struct {
+	Num    int              // 配置编号
+	Shards [NShards]int     // 分片 -> gid
+	Groups map[int][]string // gid -> 服务器名
+}
+
+// 复制一份配置，避免调用方修改共享的 Groups
+func (cfg Config) Copy() Config {
+	c := Config{
+		Num:    cfg.Num,
+		Shards: cfg.Shards,
+		Groups: make(map[int][]string, len(cfg.Groups)),
+	}
+	for gid, servers := range cfg.Groups {
+		c.Groups[gid] = append([]string(nil), servers...)
+	}
+	return c
+}
+
+// 错误信息常量
+const (
+	OK             = "OK"
+	ErrWrongLeader = "ErrWrongLeader"
+	ErrTimeout     = "ErrTimeout"
+)
+
+type Err string
+
+// 操作类型常量
+const (
+	OpJoin  = "Join"
+	OpLeave = "Leave"
+	OpMove  = "Move"
+	OpQuery = "Query"
+)
+
+// Join 请求参数
+type JoinArgs struct {
+	Servers  map[int][]string // 新的 gid -> 服务器名
+	ClientID int64
+	SeqNum   int
+}
+
+// Join 回复参数
+type JoinReply struct {
+	Err Err
+}
+
+// Leave 请求参数
+type LeaveArgs struct {
+	GIDs     []int
+	ClientID int64
+	SeqNum   int
+}
+
+// Leave 回复参数
+type LeaveReply struct {
+	Err Err
+}
+
+// Move 请求参数
+type MoveArgs struct {
+	Shard    int
+	GID      int
+	ClientID int64
+	SeqNum   int
+}
+
+// Move 回复参数
+type MoveReply struct {
+	Err Err
+}
+
+// Query 请求参数
+type QueryArgs struct {
+	Num int // 期望的配置编号，-1 表示最新
+}
+
+// Query 回复参数
+type QueryReply struct {
+	Err    Err
+	Config Config
+}
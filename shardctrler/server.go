@@ -0,0 +1,255 @@
+package shardctrler
+
+import (
+	"course/labgob"
+	"course/logging"
+	"course/raft"
+	"course/transport"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var logger = logging.Logger(logging.TopicShardCtrler)
+
+// 操作结构体，Join/Leave/Move/Query 都作为日志提交，保证配置的读写都是线性一致的
+type Op struct {
+	Type     string
+	Servers  map[int][]string // Join
+	GIDs     []int            // Leave
+	Shard    int              // Move
+	GID      int              // Move
+	Num      int              // Query
+	ClientID int64
+	SeqNum   int
+}
+
+// 操作在状态机上的执行结果
+type opResult struct {
+	ClientID int64
+	SeqNum   int
+	Err      Err
+	Config   Config
+}
+
+type ShardCtrler struct {
+	mu      sync.Mutex
+	me      int
+	rf      *raft.Raft
+	applyCh chan raft.ApplyMsg
+	dead    int32
+
+	configs     []Config // 按编号排列的全部配置
+	notifyCh    map[int]chan opResult
+	clientSeq   map[int64]int // 每个客户端最后一次执行的写操作序号
+	lastApplied int
+}
+
+func StartServer(servers []transport.ClientEnd, me int, persister *raft.Persister) *ShardCtrler {
+	labgob.Register(Op{})
+
+	sc := &ShardCtrler{
+		me:        me,
+		applyCh:   make(chan raft.ApplyMsg),
+		configs:   []Config{{Groups: map[int][]string{}}},
+		notifyCh:  make(map[int]chan opResult),
+		clientSeq: make(map[int64]int),
+	}
+	sc.rf = raft.Make(servers, me, persister, sc.applyCh)
+	go sc.applyLoop()
+	return sc
+}
+
+func (sc *ShardCtrler) Raft() *raft.Raft {
+	return sc.rf
+}
+
+func (sc *ShardCtrler) Kill() {
+	atomic.StoreInt32(&sc.dead, 1)
+	sc.rf.Kill()
+}
+
+func (sc *ShardCtrler) killed() bool {
+	return atomic.LoadInt32(&sc.dead) == 1
+}
+
+func (sc *ShardCtrler) Join(args *JoinArgs, reply *JoinReply) {
+	reply.Err, _ = sc.startOp(Op{Type: OpJoin, Servers: args.Servers, ClientID: args.ClientID, SeqNum: args.SeqNum})
+}
+
+func (sc *ShardCtrler) Leave(args *LeaveArgs, reply *LeaveReply) {
+	reply.Err, _ = sc.startOp(Op{Type: OpLeave, GIDs: args.GIDs, ClientID: args.ClientID, SeqNum: args.SeqNum})
+}
+
+func (sc *ShardCtrler) Move(args *MoveArgs, reply *MoveReply) {
+	reply.Err, _ = sc.startOp(Op{Type: OpMove, Shard: args.Shard, GID: args.GID, ClientID: args.ClientID, SeqNum: args.SeqNum})
+}
+
+func (sc *ShardCtrler) Query(args *QueryArgs, reply *QueryReply) {
+	reply.Err, reply.Config = sc.startOp(Op{Type: OpQuery, Num: args.Num})
+}
+
+// 将操作提交给 Raft，并等待它在本节点被应用
+func (sc *ShardCtrler) startOp(op Op) (Err, Config) {
+	if sc.killed() {
+		return ErrWrongLeader, Config{}
+	}
+
+	sc.mu.Lock()
+	index, _, isLeader := sc.rf.Start(op)
+	if !isLeader {
+		sc.mu.Unlock()
+		return ErrWrongLeader, Config{}
+	}
+	ch := make(chan opResult, 1)
+	sc.notifyCh[index] = ch
+	sc.mu.Unlock()
+
+	var err Err
+	var config Config
+	select {
+	case applied := <-ch:
+		// 该位置上应用的是别的操作，说明领导者已经变更
+		if applied.ClientID != op.ClientID || applied.SeqNum != op.SeqNum {
+			err = ErrWrongLeader
+		} else {
+			err, config = applied.Err, applied.Config
+		}
+	case <-time.After(1 * time.Second):
+		err = ErrTimeout
+	}
+
+	sc.mu.Lock()
+	delete(sc.notifyCh, index)
+	sc.mu.Unlock()
+	return err, config
+}
+
+func (sc *ShardCtrler) applyLoop() {
+	for msg := range sc.applyCh {
+		if !msg.CommandValid {
+			continue
+		}
+
+		sc.mu.Lock()
+		if msg.CommandIndex <= sc.lastApplied {
+			sc.mu.Unlock()
+			continue
+		}
+		sc.lastApplied = msg.CommandIndex
+
+		if op, ok := msg.Command.(Op); ok {
+			result := sc.applyOpLocked(op)
+			if ch, ok := sc.notifyCh[msg.CommandIndex]; ok {
+				ch <- result
+				delete(sc.notifyCh, msg.CommandIndex)
+			}
+		}
+		sc.mu.Unlock()
+	}
+}
+
+// 将已提交的操作应用到状态机，调用方需持有 sc.mu
+func (sc *ShardCtrler) applyOpLocked(op Op) opResult {
+	result := opResult{ClientID: op.ClientID, SeqNum: op.SeqNum, Err: OK}
+
+	if op.Type == OpQuery {
+		if op.Num < 0 || op.Num >= len(sc.configs) {
+			result.Config = sc.configs[len(sc.configs)-1].Copy()
+		} else {
+			result.Config = sc.configs[op.Num].Copy()
+		}
+		return result
+	}
+
+	// 重复的写请求不再执行
+	if op.SeqNum <= sc.clientSeq[op.ClientID] {
+		return result
+	}
+	sc.clientSeq[op.ClientID] = op.SeqNum
+
+	config := sc.configs[len(sc.configs)-1].Copy()
+	config.Num++
+
+	switch op.Type {
+	case OpJoin:
+		for gid, servers := range op.Servers {
+			config.Groups[gid] = append([]string(nil), servers...)
+		}
+		rebalance(&config)
+	case OpLeave:
+		for _, gid := range op.GIDs {
+			delete(config.Groups, gid)
+			for shard := range config.Shards {
+				if config.Shards[shard] == gid {
+					config.Shards[shard] = 0
+				}
+			}
+		}
+		rebalance(&config)
+	case OpMove:
+		config.Shards[op.Shard] = op.GID
+	}
+
+	sc.configs = append(sc.configs, config)
+	logger.Info("Config changed", "node", sc.me, "op", op.Type, "config", config.Num, "shards", config.Shards)
+	return result
+}
+
+// 在复制组之间平均分配分片，尽量少移动分片
+// 所有副本对同样的输入必须得到同样的结果，因此 gid 都先排序再处理
+func rebalance(config *Config) {
+	if len(config.Groups) == 0 {
+		config.Shards = [NShards]int{}
+		return
+	}
+
+	gids := make([]int, 0, len(config.Groups))
+	for gid := range config.Groups {
+		gids = append(gids, gid)
+	}
+	sort.Ints(gids)
+
+	owned := make(map[int][]int, len(gids))
+	var free []int
+	for shard, gid := range config.Shards {
+		if _, ok := config.Groups[gid]; ok {
+			owned[gid] = append(owned[gid], shard)
+		} else {
+			free = append(free, shard)
+		}
+	}
+
+	// 分片较多的组排在前面，每组的目标数量为 NShards/len 或再多一个
+	sort.SliceStable(gids, func(i, j int) bool {
+		return len(owned[gids[i]]) > len(owned[gids[j]])
+	})
+	base, extra := NShards/len(gids), NShards%len(gids)
+	target := func(i int) int {
+		if i < extra {
+			return base + 1
+		}
+		return base
+	}
+
+	for i, gid := range gids {
+		if len(owned[gid]) > target(i) {
+			free = append(free, owned[gid][target(i):]...)
+			owned[gid] = owned[gid][:target(i)]
+		}
+	}
+	sort.Ints(free)
+	for i, gid := range gids {
+		for len(owned[gid]) < target(i) {
+			owned[gid] = append(owned[gid], free[0])
+			free = free[1:]
+		}
+	}
+
+	for gid, shards := range owned {
+		for _, shard := range shards {
+			config.Shards[shard] = gid
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package shardctrler
+
+// 分片控制器的测试：在 labrpc 上启动若干个控制器节点，通过 Clerk 执行 Join、Leave、Move、Query，
+// 检查每份配置中的分片分配是否均衡、分片是否尽量少移动，以及领导者失效后仍能继续服务
+
+import (
+	"course/labrpc"
+	"course/raft"
+	"course/transport"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type ctrlers struct {
+	mu      sync.Mutex
+	net     *labrpc.Network
+	n       int
+	servers []*ShardCtrler
+	ends    map[int][]string // 连到各节点和从各节点发出的 ClientEnd 名字，用于断开节点
+	nextEnd int
+}
+
+func ctrlerName(i int) string {
+	return fmt.Sprintf("ctrler-%d", i)
+}
+
+// 启动 n 个控制器节点，测试结束时停止
+func makeCtrlers(t *testing.T, n int) *ctrlers {
+	c := &ctrlers{
+		net:     labrpc.MakeNetwork(),
+		n:       n,
+		servers: make([]*ShardCtrler, n),
+		ends:    make(map[int][]string),
+	}
+	for i := 0; i < n; i++ {
+		ends := make([]transport.ClientEnd, n)
+		for j := 0; j < n; j++ {
+			ends[j] = c.makeEnd(i, j)
+		}
+		sc := StartServer(ends, i, raft.MakePersister())
+		server := labrpc.MakeServer()
+		server.AddService(labrpc.MakeService(sc))
+		server.AddService(labrpc.MakeService(sc.Raft()))
+		c.net.AddServer(ctrlerName(i), server)
+		c.servers[i] = sc
+	}
+	t.Cleanup(func() {
+		for _, sc := range c.servers {
+			sc.Kill()
+		}
+		c.net.Cleanup()
+	})
+	return c
+}
+
+// 新建一个从节点 i 连到节点 j 的 ClientEnd，客户端的 i 为 -1
+func (c *ctrlers) makeEnd(i, j int) transport.ClientEnd {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := fmt.Sprintf("end-%d", c.nextEnd)
+	c.nextEnd++
+	end := c.net.MakeEnd(name)
+	c.net.Connect(name, ctrlerName(j))
+	c.net.Enable(name, true)
+	c.ends[j] = append(c.ends[j], name)
+	if i >= 0 {
+		c.ends[i] = append(c.ends[i], name)
+	}
+	return end
+}
+
+func (c *ctrlers) clerk() *Clerk {
+	ends := make([]transport.ClientEnd, c.n)
+	for j := 0; j < c.n; j++ {
+		ends[j] = c.makeEnd(-1, j)
+	}
+	return MakeClerk(ends)
+}
+
+// 断开节点 i 收发的所有请求，把它单独隔离
+func (c *ctrlers) disconnect(i int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, name := range c.ends[i] {
+		c.net.Enable(name, false)
+	}
+}
+
+func (c *ctrlers) leader() (int, bool) {
+	for i, sc := range c.servers {
+		if _, isLeader := sc.Raft().GetState(); isLeader {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// 检查最新配置中的复制组正好是 gids，所有分片都分给了存在的复制组，且各组的分片数最多相差一个
+func check(t *testing.T, ck *Clerk, gids []int) Config {
+	t.Helper()
+	c := ck.Query(-1)
+	if len(c.Groups) != len(gids) {
+		t.Fatalf("config %d has groups %v, want %v", c.Num, c.Groups, gids)
+	}
+	for _, gid := range gids {
+		if _, ok := c.Groups[gid]; !ok {
+			t.Fatalf("config %d is missing group %d", c.Num, gid)
+		}
+	}
+
+	counts := make(map[int]int)
+	for shard, gid := range c.Shards {
+		if len(gids) == 0 {
+			if gid != 0 {
+				t.Fatalf("shard %d assigned to %d with no groups", shard, gid)
+			}
+			continue
+		}
+		if _, ok := c.Groups[gid]; !ok {
+			t.Fatalf("shard %d assigned to %d, which is not in config %d", shard, gid, c.Num)
+		}
+		counts[gid]++
+	}
+	min, max := NShards, 0
+	for _, gid := range gids {
+		if counts[gid] < min {
+			min = counts[gid]
+		}
+		if counts[gid] > max {
+			max = counts[gid]
+		}
+	}
+	if len(gids) > 0 && max > min+1 {
+		t.Fatalf("config %d is not balanced: %v", c.Num, c.Shards)
+	}
+	return c
+}
+
+func servers(gid int) []string {
+	return []string{fmt.Sprintf("server-%d-0", gid), fmt.Sprintf("server-%d-1", gid)}
+}
+
+func TestJoinLeave(t *testing.T) {
+	c := makeCtrlers(t, 3)
+	ck := c.clerk()
+
+	if cfg := check(t, ck, nil); cfg.Num != 0 {
+		t.Fatalf("initial config number is %d, want 0", cfg.Num)
+	}
+
+	ck.Join(map[int][]string{1: servers(1)})
+	check(t, ck, []int{1})
+	ck.Join(map[int][]string{2: servers(2)})
+	check(t, ck, []int{1, 2})
+	ck.Join(map[int][]string{3: servers(3), 4: servers(4)})
+	check(t, ck, []int{1, 2, 3, 4})
+
+	ck.Leave([]int{1})
+	check(t, ck, []int{2, 3, 4})
+	ck.Leave([]int{2, 3})
+	cfg := check(t, ck, []int{4})
+	if got := cfg.Groups[4]; len(got) != 2 || got[0] != servers(4)[0] {
+		t.Fatalf("group 4 has servers %v, want %v", got, servers(4))
+	}
+	ck.Leave([]int{4})
+	check(t, ck, nil)
+
+	// 历史配置保持不变，超过最新编号时返回最新配置
+	if cfg := ck.Query(1); cfg.Num != 1 || len(cfg.Groups) != 1 || cfg.Shards[0] != 1 {
+		t.Fatalf("config 1 = %+v, want every shard on group 1", cfg)
+	}
+	latest := ck.Query(-1)
+	if cfg := ck.Query(latest.Num + 10); cfg.Num != latest.Num {
+		t.Fatalf("query past the latest config returned %d, want %d", cfg.Num, latest.Num)
+	}
+}
+
+// 加入复制组时只把分片移到新的组，离开时只移动离开的组的分片
+func TestMinimalTransfers(t *testing.T) {
+	c := makeCtrlers(t, 3)
+	ck := c.clerk()
+
+	ck.Join(map[int][]string{1: servers(1), 2: servers(2), 3: servers(3)})
+	before := check(t, ck, []int{1, 2, 3})
+
+	ck.Join(map[int][]string{4: servers(4)})
+	after := check(t, ck, []int{1, 2, 3, 4})
+	for shard := range after.Shards {
+		if after.Shards[shard] != before.Shards[shard] && after.Shards[shard] != 4 {
+			t.Fatalf("join moved shard %d from %d to %d", shard, before.Shards[shard], after.Shards[shard])
+		}
+	}
+
+	before = after
+	ck.Leave([]int{2})
+	after = check(t, ck, []int{1, 3, 4})
+	for shard := range after.Shards {
+		if after.Shards[shard] != before.Shards[shard] && before.Shards[shard] != 2 {
+			t.Fatalf("leave moved shard %d from %d to %d", shard, before.Shards[shard], after.Shards[shard])
+		}
+	}
+}
+
+func TestMove(t *testing.T) {
+	c := makeCtrlers(t, 3)
+	ck := c.clerk()
+
+	ck.Join(map[int][]string{1: servers(1), 2: servers(2)})
+	check(t, ck, []int{1, 2})
+
+	for shard := 0; shard < NShards; shard++ {
+		gid := 1
+		if shard < NShards/2 {
+			gid = 2
+		}
+		ck.Move(shard, gid)
+	}
+	cfg := ck.Query(-1)
+	for shard, gid := range cfg.Shards {
+		want := 1
+		if shard < NShards/2 {
+			want = 2
+		}
+		if gid != want {
+			t.Fatalf("shard %d is on group %d after Move, want %d", shard, gid, want)
+		}
+	}
+
+	// 之后的 Join 重新平衡
+	ck.Join(map[int][]string{3: servers(3)})
+	check(t, ck, []int{1, 2, 3})
+}
+
+// 多个客户端同时加入和离开，每个客户端的请求只执行一次
+func TestConcurrentClerks(t *testing.T) {
+	c := makeCtrlers(t, 3)
+
+	const n = 8
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(gid int) {
+			defer wg.Done()
+			ck := c.clerk()
+			ck.Join(map[int][]string{gid: servers(gid)})
+			ck.Join(map[int][]string{gid + 100: servers(gid + 100)})
+			ck.Leave([]int{gid + 100})
+		}(i + 1)
+	}
+	wg.Wait()
+
+	ck := c.clerk()
+	gids := make([]int, n)
+	for i := range gids {
+		gids[i] = i + 1
+	}
+	cfg := check(t, ck, gids)
+	// 每个客户端产生 3 份配置
+	if cfg.Num != 3*n {
+		t.Fatalf("latest config is %d, want %d", cfg.Num, 3*n)
+	}
+}
+
+// 领导者被隔离后，其余节点选出新的领导者继续处理请求
+func TestLeaderFailure(t *testing.T) {
+	c := makeCtrlers(t, 3)
+	ck := c.clerk()
+	ck.Join(map[int][]string{1: servers(1)})
+
+	var leader int
+	for start := time.Now(); ; time.Sleep(50 * time.Millisecond) {
+		var ok bool
+		if leader, ok = c.leader(); ok {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("no leader after 5s")
+		}
+	}
+	c.disconnect(leader)
+
+	ck.Join(map[int][]string{2: servers(2)})
+	check(t, ck, []int{1, 2})
+	ck.Leave([]int{1})
+	check(t, ck, []int{2})
+}
@@ -0,0 +1,84 @@
+package shardkv
+
+import (
+	"course/kv"
+	"course/shardctrler"
+	"course/transport"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type Clerk struct {
+	mu        sync.Mutex
+	ctrler    *shardctrler.Clerk
+	config    shardctrler.Config
+	makeEnd   func(string) transport.ClientEnd
+	clientID  int64
+	seqNum    int
+	leaderIDs map[int]int // 每个复制组上次成功的服务器
+}
+
+// ctrlers 为分片控制器节点，makeEnd 将配置中的服务器名转换为可以调用的端点
+func MakeClerk(ctrlers []transport.ClientEnd, makeEnd func(string) transport.ClientEnd) *Clerk {
+	ck := &Clerk{
+		ctrler:    shardctrler.MakeClerk(ctrlers),
+		makeEnd:   makeEnd,
+		clientID:  rand.Int63(),
+		leaderIDs: make(map[int]int),
+	}
+	ck.config = ck.ctrler.Query(-1)
+	return ck
+}
+
+// 将请求发给键所属的复制组，直到执行成功
+// 收到 ErrWrongGroup 或整个复制组都不可用时重新查询配置
+func (ck *Clerk) call(key, method string, args interface{}, newReply func() interface{}, errOf func(interface{}) Err) interface{} {
+	shard := Key2Shard(key)
+	for {
+		gid := ck.config.Shards[shard]
+		if servers, ok := ck.config.Groups[gid]; ok {
+			for i := 0; i < len(servers); i++ {
+				server := (ck.leaderIDs[gid] + i) % len(servers)
+				reply := newReply()
+				if !ck.makeEnd(servers[server]).Call(method, args, reply) {
+					continue
+				}
+				err := errOf(reply)
+				if err == OK || err == ErrNoKey {
+					ck.leaderIDs[gid] = server
+					return reply
+				}
+				if err == ErrWrongGroup {
+					break
+				}
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+		ck.config = ck.ctrler.Query(-1)
+	}
+}
+
+// 读取键的值，键不存在时返回 false
+func (ck *Clerk) Get(key string) (kv.KVEntry, bool) {
+	ck.mu.Lock()
+	defer ck.mu.Unlock()
+
+	ck.seqNum++
+	args := &GetArgs{Key: key, ClientID: ck.clientID, SeqNum: ck.seqNum}
+	reply := ck.call(key, "ShardKV.Get", args,
+		func() interface{} { return &GetReply{} },
+		func(r interface{}) Err { return r.(*GetReply).Err }).(*GetReply)
+	return reply.Value, reply.Err == OK
+}
+
+func (ck *Clerk) Put(key string, value kv.KVEntry) {
+	ck.mu.Lock()
+	defer ck.mu.Unlock()
+
+	ck.seqNum++
+	args := &PutArgs{Key: key, Value: value, ClientID: ck.clientID, SeqNum: ck.seqNum}
+	ck.call(key, "ShardKV.Put", args,
+		func() interface{} { return &PutReply{} },
+		func(r interface{}) Err { return r.(*PutReply).Err })
+}
@@ -0,0 +1,86 @@
+package shardkv
+
+import (
+	"course/kv"
+	"course/shardctrler"
+	"hash/fnv"
+)
+
+// 分片 KV：键按哈希分到 shardctrler.NShards 个分片上，每个分片由一个复制组负责
+// 复制组定期从分片控制器拉取新配置，分片的归属变化时，新的复制组从原复制组拉取
+// 分片数据和去重状态，安装完成后再通知原复制组删除
+//
+// 客户端按键的哈希找到所属复制组，收到 ErrWrongGroup 时重新查询配置
+//
+// 限制：复制组运行的是本包中独立的 ShardKV 状态机，没有复用 kv.KVServer，只支持整条记录的 Get 和 Put。
+// KVServer 的 TTL、历史版本和 as_of 读取、Watch、二级索引和查询、系统键空间与审计日志都不能用于分片数据，
+// 网关和 kvnode 也仍然只连接单个复制组的 KVServer
+
+// 错误信息常量
+const (
+	OK             = "OK"
+	ErrNoKey       = "ErrNoKey"
+	ErrWrongGroup  = "ErrWrongGroup"
+	ErrWrongLeader = "ErrWrongLeader"
+	ErrTimeout     = "ErrTimeout"
+	ErrNotReady    = "ErrNotReady" // 对方还没有应用到请求中的配置
+)
+
+type Err string
+
+// 返回键所属的分片
+func Key2Shard(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % shardctrler.NShards)
+}
+
+// Get 请求参数
+type GetArgs struct {
+	Key      string
+	ClientID int64
+	SeqNum   int
+}
+
+// Get 回复参数
+type GetReply struct {
+	Err   Err
+	Value kv.KVEntry
+}
+
+// Put 请求参数
+type PutArgs struct {
+	Key      string
+	Value    kv.KVEntry
+	ClientID int64
+	SeqNum   int
+}
+
+// Put 回复参数
+type PutReply struct {
+	Err Err
+}
+
+// PullShard 请求参数，新的复制组在配置 ConfigNum 下向原复制组拉取分片
+type PullShardArgs struct {
+	ConfigNum int
+	Shard     int
+}
+
+// PullShard 回复参数，包括分片数据和该分片上的去重状态
+type PullShardReply struct {
+	Err       Err
+	Data      map[string]kv.KVEntry
+	ClientSeq map[int64]int
+}
+
+// DeleteShard 请求参数，新的复制组安装分片后通知原复制组删除
+type DeleteShardArgs struct {
+	ConfigNum int
+	Shard     int
+}
+
+// DeleteShard 回复参数
+type DeleteShardReply struct {
+	Err Err
+}
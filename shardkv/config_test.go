@@ -0,0 +1,208 @@
+package shardkv
+
+// 分片 KV 测试的环境：在同一个 labrpc 网络上启动分片控制器和若干复制组，
+// 支持复制组加入、离开，以及整个复制组崩溃后从持久化状态重启
+
+import (
+	"course/labrpc"
+	"course/raft"
+	"course/shardctrler"
+	"course/transport"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+const nctrlers = 3
+
+type group struct {
+	gid        int
+	servers    []*ShardKV
+	persisters []*raft.Persister
+	endnames   [][]string // 每个节点发出请求用的 ClientEnd 名字，重启时换成新的
+}
+
+type config struct {
+	t            *testing.T
+	mu           sync.Mutex
+	net          *labrpc.Network
+	n            int // 每个复制组的节点数
+	maxraftstate int
+	ctrlers      []*shardctrler.ShardCtrler
+	groups       []*group
+	mck          *shardctrler.Clerk
+	nextEnd      int
+}
+
+func ctrlerName(i int) string {
+	return fmt.Sprintf("ctrler-%d", i)
+}
+
+func serverName(gid, i int) string {
+	return fmt.Sprintf("server-%d-%d", gid, i)
+}
+
+// 启动分片控制器和 ngroups 个复制组，复制组的 gid 从 100 开始；复制组启动后还没有加入
+func makeConfig(t *testing.T, ngroups, n, maxraftstate int) *config {
+	cfg := &config{
+		t:            t,
+		net:          labrpc.MakeNetwork(),
+		n:            n,
+		maxraftstate: maxraftstate,
+		ctrlers:      make([]*shardctrler.ShardCtrler, nctrlers),
+	}
+
+	for i := 0; i < nctrlers; i++ {
+		ends := make([]transport.ClientEnd, nctrlers)
+		for j := 0; j < nctrlers; j++ {
+			ends[j] = cfg.makeEnd(ctrlerName(j))
+		}
+		sc := shardctrler.StartServer(ends, i, raft.MakePersister())
+		server := labrpc.MakeServer()
+		server.AddService(labrpc.MakeService(sc))
+		server.AddService(labrpc.MakeService(sc.Raft()))
+		cfg.net.AddServer(ctrlerName(i), server)
+		cfg.ctrlers[i] = sc
+	}
+	cfg.mck = shardctrler.MakeClerk(cfg.ctrlerEnds())
+
+	for gi := 0; gi < ngroups; gi++ {
+		g := &group{
+			gid:        100 + gi,
+			servers:    make([]*ShardKV, n),
+			persisters: make([]*raft.Persister, n),
+			endnames:   make([][]string, n),
+		}
+		for i := 0; i < n; i++ {
+			g.persisters[i] = raft.MakePersister()
+		}
+		cfg.groups = append(cfg.groups, g)
+		for i := 0; i < n; i++ {
+			cfg.startServer(gi, i)
+		}
+	}
+
+	t.Cleanup(cfg.cleanup)
+	return cfg
+}
+
+func (cfg *config) cleanup() {
+	for gi := range cfg.groups {
+		for i := 0; i < cfg.n; i++ {
+			cfg.shutdownServer(gi, i)
+		}
+	}
+	for _, sc := range cfg.ctrlers {
+		sc.Kill()
+	}
+	cfg.net.Cleanup()
+}
+
+// 新建一个连到 server 的 ClientEnd，同时返回它的名字
+func (cfg *config) newEnd(server string) (transport.ClientEnd, string) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	name := fmt.Sprintf("end-%d", cfg.nextEnd)
+	cfg.nextEnd++
+	end := cfg.net.MakeEnd(name)
+	cfg.net.Connect(name, server)
+	cfg.net.Enable(name, true)
+	return end, name
+}
+
+// 复制组和客户端使用的 makeEnd
+func (cfg *config) makeEnd(server string) transport.ClientEnd {
+	end, _ := cfg.newEnd(server)
+	return end
+}
+
+func (cfg *config) ctrlerEnds() []transport.ClientEnd {
+	ends := make([]transport.ClientEnd, nctrlers)
+	for i := range ends {
+		ends[i] = cfg.makeEnd(ctrlerName(i))
+	}
+	return ends
+}
+
+func (cfg *config) makeClient() *Clerk {
+	return MakeClerk(cfg.ctrlerEnds(), cfg.makeEnd)
+}
+
+// 用上次持久化的状态启动复制组 gi 的节点 i
+func (cfg *config) startServer(gi, i int) {
+	g := cfg.groups[gi]
+	ends := make([]transport.ClientEnd, cfg.n)
+	g.endnames[i] = make([]string, cfg.n)
+	for j := 0; j < cfg.n; j++ {
+		ends[j], g.endnames[i][j] = cfg.newEnd(serverName(g.gid, j))
+	}
+
+	g.persisters[i] = g.persisters[i].Copy()
+	skv := StartServer(ends, i, g.persisters[i], cfg.maxraftstate, g.gid, cfg.ctrlerEnds(), cfg.makeEnd)
+	server := labrpc.MakeServer()
+	server.AddService(labrpc.MakeService(skv))
+	server.AddService(labrpc.MakeService(skv.Raft()))
+	cfg.net.AddServer(serverName(g.gid, i), server)
+	g.servers[i] = skv
+}
+
+// 停止复制组 gi 的节点 i，断开它发出的 Raft 请求，持久化状态保留给下次启动
+func (cfg *config) shutdownServer(gi, i int) {
+	g := cfg.groups[gi]
+	if g.servers[i] == nil {
+		return
+	}
+	cfg.net.DeleteServer(serverName(g.gid, i))
+	for _, name := range g.endnames[i] {
+		cfg.net.Enable(name, false)
+	}
+	g.servers[i].Kill()
+	g.servers[i] = nil
+}
+
+func (cfg *config) shutdownGroup(gi int) {
+	for i := 0; i < cfg.n; i++ {
+		cfg.shutdownServer(gi, i)
+	}
+}
+
+func (cfg *config) startGroup(gi int) {
+	for i := 0; i < cfg.n; i++ {
+		cfg.startServer(gi, i)
+	}
+}
+
+func (cfg *config) gid(gi int) int {
+	return cfg.groups[gi].gid
+}
+
+func (cfg *config) join(gis ...int) {
+	groups := make(map[int][]string)
+	for _, gi := range gis {
+		names := make([]string, cfg.n)
+		for i := range names {
+			names[i] = serverName(cfg.gid(gi), i)
+		}
+		groups[cfg.gid(gi)] = names
+	}
+	cfg.mck.Join(groups)
+}
+
+func (cfg *config) leave(gis ...int) {
+	gids := make([]int, len(gis))
+	for i, gi := range gis {
+		gids[i] = cfg.gid(gi)
+	}
+	cfg.mck.Leave(gids)
+}
+
+// 检查每个节点的 Raft 状态没有超出快照阈值太多
+func (cfg *config) checkLogs() {
+	for _, g := range cfg.groups {
+		for i, p := range g.persisters {
+			if size := p.RaftStateSize(); size > 8*cfg.maxraftstate {
+				cfg.t.Fatalf("group %d server %d raft state is %d bytes, maxraftstate %d", g.gid, i, size, cfg.maxraftstate)
+			}
+		}
+	}
+}
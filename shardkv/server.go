@@ -0,0 +1,505 @@
+package shardkv
+
+import (
+	"bytes"
+	"course/kv"
+	"course/labgob"
+	"course/logging"
+	"course/raft"
+	"course/shardctrler"
+	"course/transport"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var logger = logging.Logger(logging.TopicShardKV)
+
+// 操作类型
+const (
+	OpGet         = "Get"
+	OpPut         = "Put"
+	OpConfig      = "Config"      // 切换到下一个配置
+	OpInsertShard = "InsertShard" // 安装从原复制组拉取到的分片
+	OpDeleteShard = "DeleteShard" // 原复制组删除已经迁移走的分片
+	OpGCDone      = "GCDone"      // 原复制组已删除分片，新复制组开始正常服务
+)
+
+// 分片的状态
+const (
+	Serving   = "Serving"   // 正常状态
+	Pulling   = "Pulling"   // 本组新分到该分片，正在从原复制组拉取数据
+	BePulling = "BePulling" // 该分片已分给别的组，等待对方拉取
+	GCing     = "GCing"     // 已经拉取并安装，等待原复制组删除；此时可以正常服务
+)
+
+// 后台任务的执行间隔
+const (
+	configPollInterval = 100 * time.Millisecond
+	migrationInterval  = 50 * time.Millisecond
+)
+
+// 一个分片的数据和去重状态，去重状态跟随分片一起迁移
+type Shard struct {
+	Status    string
+	Data      map[string]kv.KVEntry
+	ClientSeq map[int64]int
+}
+
+func newShard() *Shard {
+	return &Shard{
+		Status:    Serving,
+		Data:      make(map[string]kv.KVEntry),
+		ClientSeq: make(map[int64]int),
+	}
+}
+
+// 操作结构体，客户端请求和配置、迁移的状态变化都作为日志提交
+type Op struct {
+	Type      string
+	Key       string
+	Value     kv.KVEntry
+	ClientID  int64
+	SeqNum    int
+	Config    shardctrler.Config    // OpConfig
+	ConfigNum int                   // OpInsertShard/OpDeleteShard/OpGCDone
+	Shard     int                   // OpInsertShard/OpDeleteShard/OpGCDone
+	Data      map[string]kv.KVEntry // OpInsertShard
+	ClientSeq map[int64]int         // OpInsertShard
+}
+
+// 操作在状态机上的执行结果，前几个字段用来确认该位置应用的是否是等待的操作
+type opResult struct {
+	Type      string
+	ClientID  int64
+	SeqNum    int
+	ConfigNum int
+	Shard     int
+	Err       Err
+	Value     kv.KVEntry
+}
+
+type ShardKV struct {
+	mu           sync.Mutex
+	me           int
+	rf           *raft.Raft
+	applyCh      chan raft.ApplyMsg
+	dead         int32
+	maxraftstate int
+	persister    *raft.Persister
+
+	gid     int
+	ctrler  *shardctrler.Clerk
+	makeEnd func(string) transport.ClientEnd
+
+	shards      [shardctrler.NShards]*Shard
+	config      shardctrler.Config // 当前配置
+	lastConfig  shardctrler.Config // 上一个配置，迁移时用来找到分片的原复制组
+	notifyCh    map[int]chan opResult
+	lastApplied int
+}
+
+// 启动复制组 gid 中的一个节点
+// ctrlers 为分片控制器节点，makeEnd 将配置中的服务器名转换为可以调用的端点
+func StartServer(servers []transport.ClientEnd, me int, persister *raft.Persister, maxraftstate int,
+	gid int, ctrlers []transport.ClientEnd, makeEnd func(string) transport.ClientEnd) *ShardKV {
+	labgob.Register(Op{})
+
+	skv := &ShardKV{
+		me:           me,
+		applyCh:      make(chan raft.ApplyMsg),
+		maxraftstate: maxraftstate,
+		persister:    persister,
+		gid:          gid,
+		ctrler:       shardctrler.MakeClerk(ctrlers),
+		makeEnd:      makeEnd,
+		config:       shardctrler.Config{Groups: map[int][]string{}},
+		lastConfig:   shardctrler.Config{Groups: map[int][]string{}},
+		notifyCh:     make(map[int]chan opResult),
+	}
+	for i := range skv.shards {
+		skv.shards[i] = newShard()
+	}
+	skv.readSnapshotLocked(persister.ReadSnapshot())
+
+	skv.rf = raft.Make(servers, me, persister, skv.applyCh)
+	go skv.applyLoop()
+	go skv.daemon(skv.pollConfig, configPollInterval)
+	go skv.daemon(skv.pullShards, migrationInterval)
+	go skv.daemon(skv.gcShards, migrationInterval)
+	return skv
+}
+
+func (skv *ShardKV) Raft() *raft.Raft {
+	return skv.rf
+}
+
+func (skv *ShardKV) Kill() {
+	atomic.StoreInt32(&skv.dead, 1)
+	skv.rf.Kill()
+}
+
+func (skv *ShardKV) killed() bool {
+	return atomic.LoadInt32(&skv.dead) == 1
+}
+
+func (skv *ShardKV) Get(args *GetArgs, reply *GetReply) {
+	result := skv.startOp(Op{Type: OpGet, Key: args.Key, ClientID: args.ClientID, SeqNum: args.SeqNum})
+	reply.Err, reply.Value = result.Err, result.Value
+}
+
+func (skv *ShardKV) Put(args *PutArgs, reply *PutReply) {
+	result := skv.startOp(Op{Type: OpPut, Key: args.Key, Value: args.Value, ClientID: args.ClientID, SeqNum: args.SeqNum})
+	reply.Err = result.Err
+}
+
+// 新的复制组拉取分片，只有领导者回复，保证返回的是已提交的状态
+func (skv *ShardKV) PullShard(args *PullShardArgs, reply *PullShardReply) {
+	if _, isLeader := skv.rf.GetState(); !isLeader {
+		reply.Err = ErrWrongLeader
+		return
+	}
+
+	skv.mu.Lock()
+	defer skv.mu.Unlock()
+
+	if skv.config.Num < args.ConfigNum {
+		reply.Err = ErrNotReady
+		return
+	}
+
+	shard := skv.shards[args.Shard]
+	reply.Data = make(map[string]kv.KVEntry, len(shard.Data))
+	for k, v := range shard.Data {
+		reply.Data[k] = v
+	}
+	reply.ClientSeq = make(map[int64]int, len(shard.ClientSeq))
+	for k, v := range shard.ClientSeq {
+		reply.ClientSeq[k] = v
+	}
+	reply.Err = OK
+}
+
+// 新的复制组已经安装分片，原复制组删除本地的副本
+func (skv *ShardKV) DeleteShard(args *DeleteShardArgs, reply *DeleteShardReply) {
+	skv.mu.Lock()
+	done := skv.config.Num > args.ConfigNum
+	skv.mu.Unlock()
+	if done {
+		reply.Err = OK
+		return
+	}
+
+	result := skv.startOp(Op{Type: OpDeleteShard, ConfigNum: args.ConfigNum, Shard: args.Shard})
+	reply.Err = result.Err
+}
+
+// 将操作提交给 Raft，并等待它在本节点被应用
+func (skv *ShardKV) startOp(op Op) opResult {
+	if skv.killed() {
+		return opResult{Err: ErrWrongLeader}
+	}
+
+	skv.mu.Lock()
+	index, _, isLeader := skv.rf.Start(op)
+	if !isLeader {
+		skv.mu.Unlock()
+		return opResult{Err: ErrWrongLeader}
+	}
+	ch := make(chan opResult, 1)
+	skv.notifyCh[index] = ch
+	skv.mu.Unlock()
+
+	var result opResult
+	select {
+	case applied := <-ch:
+		// 该位置上应用的是别的操作，说明领导者已经变更
+		if applied.Type != op.Type || applied.ClientID != op.ClientID || applied.SeqNum != op.SeqNum ||
+			applied.ConfigNum != op.ConfigNum || applied.Shard != op.Shard {
+			result.Err = ErrWrongLeader
+		} else {
+			result = applied
+		}
+	case <-time.After(1 * time.Second):
+		result.Err = ErrTimeout
+	}
+
+	skv.mu.Lock()
+	delete(skv.notifyCh, index)
+	skv.mu.Unlock()
+	return result
+}
+
+func (skv *ShardKV) applyLoop() {
+	for msg := range skv.applyCh {
+		if msg.CommandValid {
+			skv.mu.Lock()
+			if msg.CommandIndex <= skv.lastApplied {
+				skv.mu.Unlock()
+				continue
+			}
+			skv.lastApplied = msg.CommandIndex
+
+			if op, ok := msg.Command.(Op); ok {
+				result := skv.applyOpLocked(op)
+				if ch, ok := skv.notifyCh[msg.CommandIndex]; ok {
+					ch <- result
+					delete(skv.notifyCh, msg.CommandIndex)
+				}
+			}
+
+			if skv.maxraftstate != -1 && skv.persister.RaftStateSize() >= skv.maxraftstate {
+				skv.rf.Snapshot(msg.CommandIndex, skv.encodeSnapshotLocked())
+			}
+			skv.mu.Unlock()
+		} else if msg.SnapshotValid {
+			skv.mu.Lock()
+			if msg.SnapshotIndex > skv.lastApplied {
+				skv.readSnapshotLocked(msg.Snapshot)
+				skv.lastApplied = msg.SnapshotIndex
+			}
+			skv.mu.Unlock()
+		}
+	}
+}
+
+// 当前配置下本组能否服务该分片，调用方需持有 skv.mu
+func (skv *ShardKV) canServeLocked(shard int) bool {
+	status := skv.shards[shard].Status
+	return skv.config.Shards[shard] == skv.gid && (status == Serving || status == GCing)
+}
+
+// 将已提交的操作应用到状态机，调用方需持有 skv.mu
+func (skv *ShardKV) applyOpLocked(op Op) opResult {
+	result := opResult{
+		Type:      op.Type,
+		ClientID:  op.ClientID,
+		SeqNum:    op.SeqNum,
+		ConfigNum: op.ConfigNum,
+		Shard:     op.Shard,
+		Err:       OK,
+	}
+
+	switch op.Type {
+	case OpGet, OpPut:
+		s := Key2Shard(op.Key)
+		if !skv.canServeLocked(s) {
+			result.Err = ErrWrongGroup
+			return result
+		}
+		shard := skv.shards[s]
+		if op.Type == OpGet {
+			value, exists := shard.Data[op.Key]
+			if !exists {
+				result.Err = ErrNoKey
+			}
+			result.Value = value
+			return result
+		}
+		// 重复的写请求不再执行
+		if op.SeqNum <= shard.ClientSeq[op.ClientID] {
+			return result
+		}
+		shard.Data[op.Key] = op.Value
+		shard.ClientSeq[op.ClientID] = op.SeqNum
+
+	case OpConfig:
+		if op.Config.Num != skv.config.Num+1 {
+			return result
+		}
+		for s := range skv.shards {
+			oldGID, newGID := skv.config.Shards[s], op.Config.Shards[s]
+			switch {
+			case newGID == skv.gid && oldGID != skv.gid:
+				if oldGID == 0 {
+					// 第一个配置里分到的分片没有数据需要迁移
+					skv.shards[s] = newShard()
+				} else {
+					skv.shards[s] = newShard()
+					skv.shards[s].Status = Pulling
+				}
+			case oldGID == skv.gid && newGID != skv.gid:
+				skv.shards[s].Status = BePulling
+			}
+		}
+		skv.lastConfig = skv.config
+		skv.config = op.Config
+		logger.Info("Config changed", "gid", skv.gid, "node", skv.me, "config", skv.config.Num, "shards", skv.config.Shards)
+
+	case OpInsertShard:
+		if op.ConfigNum != skv.config.Num || skv.shards[op.Shard].Status != Pulling {
+			return result
+		}
+		shard := skv.shards[op.Shard]
+		for k, v := range op.Data {
+			shard.Data[k] = v
+		}
+		for k, v := range op.ClientSeq {
+			shard.ClientSeq[k] = v
+		}
+		shard.Status = GCing
+
+	case OpDeleteShard:
+		if op.ConfigNum != skv.config.Num {
+			if op.ConfigNum > skv.config.Num {
+				result.Err = ErrNotReady
+			}
+			return result
+		}
+		if skv.shards[op.Shard].Status == BePulling {
+			skv.shards[op.Shard] = newShard()
+		}
+
+	case OpGCDone:
+		if op.ConfigNum == skv.config.Num && skv.shards[op.Shard].Status == GCing {
+			skv.shards[op.Shard].Status = Serving
+		}
+	}
+	return result
+}
+
+// 领导者上周期性执行的后台任务
+func (skv *ShardKV) daemon(task func(), interval time.Duration) {
+	for !skv.killed() {
+		if _, isLeader := skv.rf.GetState(); isLeader {
+			task()
+		}
+		time.Sleep(interval)
+	}
+}
+
+// 所有分片都迁移完成后，拉取并提交下一个配置；配置必须逐个应用，不能跳过
+func (skv *ShardKV) pollConfig() {
+	skv.mu.Lock()
+	for _, shard := range skv.shards {
+		if shard.Status != Serving {
+			skv.mu.Unlock()
+			return
+		}
+	}
+	next := skv.config.Num + 1
+	skv.mu.Unlock()
+
+	config := skv.ctrler.Query(next)
+	if config.Num == next {
+		skv.rf.Start(Op{Type: OpConfig, Config: config})
+	}
+}
+
+// 返回处于某个状态的分片，以及它们在上一个配置中所属复制组的服务器
+func (skv *ShardKV) shardsInStatus(status string) (int, map[int][]string) {
+	skv.mu.Lock()
+	defer skv.mu.Unlock()
+
+	shards := make(map[int][]string)
+	for s, shard := range skv.shards {
+		if shard.Status == status {
+			shards[s] = skv.lastConfig.Groups[skv.lastConfig.Shards[s]]
+		}
+	}
+	return skv.config.Num, shards
+}
+
+// 从原复制组拉取 Pulling 状态的分片
+func (skv *ShardKV) pullShards() {
+	configNum, shards := skv.shardsInStatus(Pulling)
+
+	var wg sync.WaitGroup
+	for s, servers := range shards {
+		wg.Add(1)
+		go func(s int, servers []string) {
+			defer wg.Done()
+			args := &PullShardArgs{ConfigNum: configNum, Shard: s}
+			for _, server := range servers {
+				reply := &PullShardReply{}
+				if skv.makeEnd(server).Call("ShardKV.PullShard", args, reply) && reply.Err == OK {
+					skv.rf.Start(Op{
+						Type:      OpInsertShard,
+						ConfigNum: configNum,
+						Shard:     s,
+						Data:      reply.Data,
+						ClientSeq: reply.ClientSeq,
+					})
+					return
+				}
+			}
+		}(s, servers)
+	}
+	wg.Wait()
+}
+
+// 通知原复制组删除 GCing 状态的分片，成功后分片回到正常状态
+func (skv *ShardKV) gcShards() {
+	configNum, shards := skv.shardsInStatus(GCing)
+
+	var wg sync.WaitGroup
+	for s, servers := range shards {
+		wg.Add(1)
+		go func(s int, servers []string) {
+			defer wg.Done()
+			args := &DeleteShardArgs{ConfigNum: configNum, Shard: s}
+			for _, server := range servers {
+				reply := &DeleteShardReply{}
+				if skv.makeEnd(server).Call("ShardKV.DeleteShard", args, reply) && reply.Err == OK {
+					skv.rf.Start(Op{Type: OpGCDone, ConfigNum: configNum, Shard: s})
+					return
+				}
+			}
+		}(s, servers)
+	}
+	wg.Wait()
+}
+
+// 将状态机编码为 Raft 快照，调用方需持有 skv.mu
+func (skv *ShardKV) encodeSnapshotLocked() []byte {
+	w := new(bytes.Buffer)
+	e := labgob.NewEncoder(w)
+	e.Encode(skv.shards)
+	e.Encode(skv.config)
+	e.Encode(skv.lastConfig)
+	e.Encode(skv.lastApplied)
+	return w.Bytes()
+}
+
+// 从 Raft 快照恢复状态机，调用方需持有 skv.mu
+func (skv *ShardKV) readSnapshotLocked(snapshot []byte) {
+	if len(snapshot) == 0 {
+		return
+	}
+
+	r := bytes.NewBuffer(snapshot)
+	d := labgob.NewDecoder(r)
+
+	var shards [shardctrler.NShards]*Shard
+	var config, lastConfig shardctrler.Config
+	var lastApplied int
+	if d.Decode(&shards) != nil || d.Decode(&config) != nil ||
+		d.Decode(&lastConfig) != nil || d.Decode(&lastApplied) != nil {
+		logger.Error("Failed to decode snapshot", "gid", skv.gid, "node", skv.me)
+		return
+	}
+
+	for i, shard := range shards {
+		// gob 不会编码空 map，解码后需要补上
+		if shard == nil {
+			shard = newShard()
+		}
+		if shard.Data == nil {
+			shard.Data = make(map[string]kv.KVEntry)
+		}
+		if shard.ClientSeq == nil {
+			shard.ClientSeq = make(map[int64]int)
+		}
+		shards[i] = shard
+	}
+	if config.Groups == nil {
+		config.Groups = map[int][]string{}
+	}
+	if lastConfig.Groups == nil {
+		lastConfig.Groups = map[int][]string{}
+	}
+	skv.shards = shards
+	skv.config = config
+	skv.lastConfig = lastConfig
+	skv.lastApplied = lastApplied
+}
@@ -0,0 +1,293 @@
+package shardkv
+
+// 分片 KV 的测试：复制组加入和离开时分片迁移，客户端在 ErrWrongGroup 后刷新配置，
+// 去重状态随分片迁移，以及快照和整组重启
+
+import (
+	"course/kv"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func check(t *testing.T, ck *Clerk, key, want string) {
+	t.Helper()
+	value, ok := ck.Get(key)
+	if !ok {
+		t.Fatalf("Get(%q) found no key, want %q", key, want)
+	}
+	if value.Name != want {
+		t.Fatalf("Get(%q) = %q, want %q", key, value.Name, want)
+	}
+}
+
+// 直接向复制组 gi 发送请求，直到某个节点给出 ErrWrongLeader 以外的结果
+func (cfg *config) callGroup(gi int, method string, args interface{}, newReply func() interface{}, errOf func(interface{}) Err) Err {
+	g := cfg.groups[gi]
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(50 * time.Millisecond) {
+		for i := 0; i < cfg.n; i++ {
+			reply := newReply()
+			if !cfg.makeEnd(serverName(g.gid, i)).Call(method, args, reply) {
+				continue
+			}
+			if err := errOf(reply); err != ErrWrongLeader && err != ErrTimeout {
+				return err
+			}
+		}
+	}
+	cfg.t.Fatalf("group %d did not answer %s", g.gid, method)
+	return ""
+}
+
+func (cfg *config) put(gi int, args *PutArgs) Err {
+	return cfg.callGroup(gi, "ShardKV.Put", args,
+		func() interface{} { return &PutReply{} },
+		func(r interface{}) Err { return r.(*PutReply).Err })
+}
+
+func (cfg *config) get(gi int, args *GetArgs) Err {
+	return cfg.callGroup(gi, "ShardKV.Get", args,
+		func() interface{} { return &GetReply{} },
+		func(r interface{}) Err { return r.(*GetReply).Err })
+}
+
+// 等待复制组 gi 的领导者应用到配置 num
+func (cfg *config) waitConfig(gi, num int) {
+	g := cfg.groups[gi]
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(50 * time.Millisecond) {
+		for _, skv := range g.servers {
+			if skv == nil {
+				continue
+			}
+			skv.mu.Lock()
+			applied := skv.config.Num
+			skv.mu.Unlock()
+			if _, isLeader := skv.Raft().GetState(); isLeader && applied >= num {
+				return
+			}
+		}
+	}
+	cfg.t.Fatalf("group %d did not reach config %d", g.gid, num)
+}
+
+func TestStaticShards(t *testing.T) {
+	cfg := makeConfig(t, 3, 3, -1)
+	ck := cfg.makeClient()
+
+	cfg.join(0, 1, 2)
+	for i := 0; i < 20; i++ {
+		ck.Put(strconv.Itoa(i), kv.KVEntry{Name: "v" + strconv.Itoa(i)})
+	}
+	for i := 0; i < 20; i++ {
+		check(t, ck, strconv.Itoa(i), "v"+strconv.Itoa(i))
+	}
+	if _, ok := ck.Get("missing"); ok {
+		t.Fatalf("Get of a missing key reported a value")
+	}
+}
+
+// 复制组加入和离开后数据跟着分片迁移，原复制组停止后仍能读到所有数据
+func TestJoinLeave(t *testing.T) {
+	cfg := makeConfig(t, 2, 3, -1)
+	ck := cfg.makeClient()
+
+	cfg.join(0)
+	values := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		key := strconv.Itoa(i)
+		values[key] = "a" + key
+		ck.Put(key, kv.KVEntry{Name: values[key]})
+	}
+
+	cfg.join(1)
+	for key, want := range values {
+		check(t, ck, key, want)
+		values[key] = "b" + key
+		ck.Put(key, kv.KVEntry{Name: values[key]})
+	}
+
+	cfg.leave(0)
+	for key, want := range values {
+		check(t, ck, key, want)
+		values[key] = "c" + key
+		ck.Put(key, kv.KVEntry{Name: values[key]})
+	}
+
+	// 所有分片都在复制组 1 上，复制组 0 不再参与
+	cfg.shutdownGroup(0)
+	for key, want := range values {
+		check(t, ck, key, want)
+	}
+}
+
+// 分片移走后原复制组回复 ErrWrongGroup，客户端据此刷新配置并找到新的复制组
+func TestWrongGroupRefresh(t *testing.T) {
+	cfg := makeConfig(t, 2, 3, -1)
+	ck := cfg.makeClient()
+
+	cfg.join(0)
+	ck.Put("k", kv.KVEntry{Name: "v1"})
+	stale := ck.config.Num
+
+	cfg.join(1)
+	cfg.mck.Move(Key2Shard("k"), cfg.gid(1))
+	latest := cfg.mck.Query(-1)
+	cfg.waitConfig(0, latest.Num)
+
+	if err := cfg.get(0, &GetArgs{Key: "k", ClientID: 1, SeqNum: 1}); err != ErrWrongGroup {
+		t.Fatalf("old group answered Get with %s, want %s", err, ErrWrongGroup)
+	}
+
+	// 客户端仍持有旧配置，第一次请求发给复制组 0
+	if ck.config.Num != stale {
+		t.Fatalf("client config changed to %d before any request", ck.config.Num)
+	}
+	check(t, ck, "k", "v1")
+	if ck.config.Num != latest.Num {
+		t.Fatalf("client config is %d after ErrWrongGroup, want %d", ck.config.Num, latest.Num)
+	}
+	ck.Put("k", kv.KVEntry{Name: "v2"})
+	check(t, cfg.makeClient(), "k", "v2")
+}
+
+// 去重状态随分片迁移：迁移前已经执行的写请求重发到新的复制组时不会再执行一次
+func TestMigrationKeepsDedup(t *testing.T) {
+	cfg := makeConfig(t, 2, 3, -1)
+	ck := cfg.makeClient()
+
+	cfg.join(0, 1)
+	shard := Key2Shard("k")
+	cfg.mck.Move(shard, cfg.gid(0))
+	cfg.waitConfig(0, cfg.mck.Query(-1).Num)
+
+	retried := &PutArgs{Key: "k", Value: kv.KVEntry{Name: "old"}, ClientID: 42, SeqNum: 1}
+	if err := cfg.put(0, retried); err != OK {
+		t.Fatalf("Put to group %d = %s", cfg.gid(0), err)
+	}
+	ck.Put("k", kv.KVEntry{Name: "new"})
+
+	// 两个复制组都切换到新配置后，客户端只能从复制组 1 读到数据，说明分片已经安装
+	cfg.mck.Move(shard, cfg.gid(1))
+	latest := cfg.mck.Query(-1).Num
+	cfg.waitConfig(0, latest)
+	cfg.waitConfig(1, latest)
+	check(t, ck, "k", "new")
+
+	// 重发的请求被新的复制组识别为重复，不覆盖之后的写入
+	if err := cfg.put(1, retried); err != OK {
+		t.Fatalf("retried Put to group %d = %s", cfg.gid(1), err)
+	}
+	check(t, ck, "k", "new")
+}
+
+// 快照限制 Raft 状态的大小，所有节点重启后从快照恢复数据
+func TestSnapshots(t *testing.T) {
+	cfg := makeConfig(t, 3, 3, 1000)
+	ck := cfg.makeClient()
+
+	cfg.join(0)
+	values := make(map[string]string)
+	for i := 0; i < 30; i++ {
+		key := strconv.Itoa(i)
+		values[key] = fmt.Sprintf("x%d-%s", i, key)
+		ck.Put(key, kv.KVEntry{Name: values[key]})
+	}
+
+	cfg.join(1, 2)
+	cfg.leave(0)
+	for round := 0; round < 3; round++ {
+		for key := range values {
+			values[key] += "y"
+			ck.Put(key, kv.KVEntry{Name: values[key]})
+		}
+	}
+	cfg.join(0)
+	cfg.leave(1)
+	for key, want := range values {
+		check(t, ck, key, want)
+	}
+	cfg.checkLogs()
+
+	for _, g := range cfg.groups {
+		snapshotted := false
+		for _, p := range g.persisters {
+			if p.SnapshotSize() > 0 {
+				snapshotted = true
+			}
+		}
+		if !snapshotted {
+			t.Fatalf("group %d never took a snapshot", g.gid)
+		}
+	}
+
+	for gi := range cfg.groups {
+		cfg.shutdownGroup(gi)
+	}
+	for gi := range cfg.groups {
+		cfg.startGroup(gi)
+	}
+	ck = cfg.makeClient()
+	for key, want := range values {
+		check(t, ck, key, want)
+	}
+}
+
+// 复制组加入和离开的同时，多个客户端持续写入自己的键
+func TestConcurrentReconfig(t *testing.T) {
+	cfg := makeConfig(t, 3, 3, -1)
+	cfg.join(0)
+
+	const nclients = 5
+	done := make(chan struct{})
+	last := make([]string, nclients)
+	var wg sync.WaitGroup
+	for c := 0; c < nclients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			ck := cfg.makeClient()
+			key := "client-" + strconv.Itoa(c)
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				value := strconv.Itoa(i)
+				ck.Put(key, kv.KVEntry{Name: value})
+				last[c] = value
+				if got, _ := ck.Get(key); got.Name != value {
+					t.Errorf("Get(%q) = %q, want %q", key, got.Name, value)
+					return
+				}
+			}
+		}(c)
+	}
+
+	cfg.join(1)
+	time.Sleep(300 * time.Millisecond)
+	cfg.join(2)
+	time.Sleep(300 * time.Millisecond)
+	cfg.leave(0)
+	time.Sleep(300 * time.Millisecond)
+	cfg.mck.Move(0, cfg.gid(1))
+	time.Sleep(300 * time.Millisecond)
+	cfg.join(0)
+	cfg.leave(1)
+	time.Sleep(300 * time.Millisecond)
+	close(done)
+	wg.Wait()
+
+	ck := cfg.makeClient()
+	for c := 0; c < nclients; c++ {
+		if last[c] == "" {
+			t.Fatalf("client %d made no progress", c)
+		}
+		check(t, ck, "client-"+strconv.Itoa(c), last[c])
+	}
+	if cfg.mck.Query(-1).Num < 6 {
+		t.Fatalf("only %d configs were created", cfg.mck.Query(-1).Num)
+	}
+}
@@ -0,0 +1,110 @@
+// Package sim 提供可以替换的时钟、调度和按种子生成的随机数，用于模拟运行
+//
+// Raft 的选举和复制计时器、labrpc 的延迟和丢包、KVServer 和 KVClient 的超时与重试都通过这里取时间和随机数，
+// 它们启动 goroutine 和在通道上阻塞也都通过这里（Go、Recv、Send 等，见 sync.go）。
+// 默认使用真实时间和随机的种子，与直接使用 time、math/rand 和 go 语句相同；
+// 模拟运行时用 SetClock 换成 Virtual 时钟、用 SetSeed 固定种子，见 virtual.go
+package sim
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 时钟，真实时钟直接调用 time 包
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func())
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) AfterFunc(d time.Duration, f func())    { time.AfterFunc(d, f) }
+
+// 真实时钟
+var Real Clock = realClock{}
+
+type clockHolder struct{ Clock }
+
+var clock atomic.Pointer[clockHolder]
+
+func init() {
+	clock.Store(&clockHolder{Real})
+	shared.Store(NewRand("shared"))
+}
+
+// 换成时钟 c，返回换回原来时钟的函数
+// 应在启动节点之前调用，已经在等待的计时器仍然使用原来的时钟
+func SetClock(c Clock) (restore func()) {
+	old := clock.Swap(&clockHolder{c})
+	return func() { clock.Store(old) }
+}
+
+func Now() time.Time                         { return clock.Load().Now() }
+func Since(t time.Time) time.Duration        { return Now().Sub(t) }
+func Sleep(d time.Duration)                  { clock.Load().Sleep(d) }
+func After(d time.Duration) <-chan time.Time { return clock.Load().After(d) }
+func AfterFunc(d time.Duration, f func())    { clock.Load().AfterFunc(d, f) }
+
+// 为 0 时每个随机数流使用随机的种子
+var seed atomic.Int64
+
+// 由 Int63 共享的随机数流，SetSeed 时重新创建
+var shared atomic.Pointer[Rand]
+
+// 固定所有随机数流的种子，0 表示恢复为随机的种子
+// 只影响之后由 NewRand 创建的随机数流和 Int63
+func SetSeed(s int64) {
+	seed.Store(s)
+	shared.Store(NewRand("shared"))
+}
+
+// 从共享的随机数流中取一个非负数，例如生成客户端编号
+// 固定种子并在 Virtual 时钟下运行时，按取用的顺序产生相同的序列
+func Int63() int64 {
+	return shared.Load().Int63()
+}
+
+// 可以被多个 goroutine 使用的随机数流
+type Rand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+// 创建名为 stream 的随机数流，固定种子时同名的流产生相同的序列
+// 每个节点、每个连接使用自己的流，这样一个流取随机数的顺序不受其他 goroutine 的影响
+func NewRand(stream string) *Rand {
+	h := fnv.New64a()
+	h.Write([]byte(stream))
+	s := seed.Load()
+	if s == 0 {
+		s = time.Now().UnixNano()
+	}
+	return &Rand{r: rand.New(rand.NewSource(s ^ int64(h.Sum64())))}
+}
+
+func (r *Rand) Int63() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Int63()
+}
+
+func (r *Rand) Intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Intn(n)
+}
+
+func (r *Rand) Float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Float64()
+}
@@ -0,0 +1,214 @@
+package sim
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// 启动 goroutine 和阻塞等待的操作。使用真实时钟时与 go 语句、通道收发、sync.Cond 和 sync.WaitGroup 相同；
+// 在 Virtual.Run 中时由 Virtual 调度：条件不满足时让出执行权，等其他 goroutine 取得进展后按顺序重试
+
+// 当前时钟是正在调度的 Virtual 时返回它
+func scheduler() *Virtual {
+	if v, ok := clock.Load().Clock.(*Virtual); ok && v.active.Load() {
+		return v
+	}
+	return nil
+}
+
+// 反复调用 try 直到它返回 true，try 不能阻塞
+// 不在调度中或调度在等待时停止时返回 false，调用方应改为直接阻塞
+func poll(try func() bool) bool {
+	v := scheduler()
+	if v == nil {
+		return false
+	}
+	for retry := false; !try(); retry = true {
+		if !v.poll(retry) {
+			return false
+		}
+	}
+	return true
+}
+
+// 启动一个 goroutine 运行 f
+func Go(f func()) {
+	if v := scheduler(); v != nil {
+		v.spawn(f)
+		return
+	}
+	go f()
+}
+
+// 从 ch 接收，与 v, ok := <-ch 相同
+func Recv[T any](ch <-chan T) (v T, ok bool) {
+	if poll(func() bool {
+		select {
+		case v, ok = <-ch:
+			return true
+		default:
+			return false
+		}
+	}) {
+		return v, ok
+	}
+	v, ok = <-ch
+	return v, ok
+}
+
+// 向 ch 发送 v，与 ch <- v 相同。在 Virtual 时钟下 ch 必须带缓冲
+func Send[T any](ch chan<- T, v T) {
+	checkBuffered(ch)
+	if poll(func() bool {
+		select {
+		case ch <- v:
+			return true
+		default:
+			return false
+		}
+	}) {
+		return
+	}
+	ch <- v
+}
+
+// 从 ch 接收，或者在 stop 可以接收（例如已经关闭或计时器到期）时放弃，received 为 false 表示因为 stop 返回
+// 两者都可以进行时，真实时钟下随机选择一个，Virtual 时钟下优先从 ch 接收
+func RecvUntil[T, S any](ch <-chan T, stop <-chan S) (v T, ok bool, received bool) {
+	if poll(func() bool {
+		select {
+		case v, ok = <-ch:
+			received = true
+			return true
+		default:
+		}
+		select {
+		case <-stop:
+			return true
+		default:
+			return false
+		}
+	}) {
+		return v, ok, received
+	}
+	select {
+	case v, ok = <-ch:
+		return v, ok, true
+	case <-stop:
+		return v, false, false
+	}
+}
+
+// 向 ch 发送 v，或者在 stop 可以接收时放弃，返回是否已经发送。在 Virtual 时钟下 ch 必须带缓冲
+func SendUntil[T, S any](ch chan<- T, v T, stop <-chan S) (sent bool) {
+	checkBuffered(ch)
+	if poll(func() bool {
+		select {
+		case ch <- v:
+			sent = true
+			return true
+		default:
+		}
+		select {
+		case <-stop:
+			return true
+		default:
+			return false
+		}
+	}) {
+		return sent
+	}
+	select {
+	case ch <- v:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+func checkBuffered[T any](ch chan<- T) {
+	if cap(ch) == 0 && scheduler() != nil {
+		panic(fmt.Sprintf("sim: send on unbuffered %T under a virtual clock", ch))
+	}
+}
+
+// 条件变量，用法与 sync.Cond 相同
+type Cond struct {
+	L       sync.Locker
+	c       *sync.Cond
+	waiters []*proc // 在 Virtual 时钟下等待的 proc，持有 Virtual.mu 时访问
+}
+
+func NewCond(l sync.Locker) *Cond {
+	return &Cond{L: l, c: sync.NewCond(l)}
+}
+
+func (c *Cond) Wait() {
+	v := scheduler()
+	if v == nil {
+		c.c.Wait()
+		return
+	}
+	c.L.Unlock()
+	v.mu.Lock()
+	if v.schedulingLocked() {
+		p := v.current
+		c.waiters = append(c.waiters, p)
+		v.progress = true
+		v.parkLocked(p)
+	} else {
+		v.mu.Unlock()
+	}
+	c.L.Lock()
+}
+
+func (c *Cond) Signal() {
+	c.wake(false)
+}
+
+func (c *Cond) Broadcast() {
+	c.wake(true)
+}
+
+func (c *Cond) wake(all bool) {
+	if v := scheduler(); v != nil {
+		v.mu.Lock()
+		for len(c.waiters) > 0 {
+			v.runq = append(v.runq, c.waiters[0])
+			c.waiters = c.waiters[1:]
+			if !all {
+				break
+			}
+		}
+		v.mu.Unlock()
+	}
+	// 调度停止前开始等待的 proc 已经被唤醒，这里只需要通知直接阻塞的 goroutine
+	if all {
+		c.c.Broadcast()
+	} else {
+		c.c.Signal()
+	}
+}
+
+// 等待一组 goroutine 结束，用法与 sync.WaitGroup 相同
+type WaitGroup struct {
+	wg sync.WaitGroup
+	n  atomic.Int64
+}
+
+func (wg *WaitGroup) Add(delta int) {
+	wg.n.Add(int64(delta))
+	wg.wg.Add(delta)
+}
+
+func (wg *WaitGroup) Done() {
+	wg.Add(-1)
+}
+
+func (wg *WaitGroup) Wait() {
+	if poll(func() bool { return wg.n.Load() == 0 }) {
+		return
+	}
+	wg.wg.Wait()
+}
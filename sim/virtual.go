@@ -0,0 +1,294 @@
+package sim
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 虚拟时钟：在 Run 中运行的 goroutine（称为 proc）由它逐个调度，同一时刻只有一个 proc 在运行。
+// proc 只在 Sleep、Recv、Send、Cond.Wait 等 sim 提供的阻塞操作处让出执行权，
+// 运行队列为空、所有 proc 都在等待时才把时间推进到最早的计时器，等待不花费真实时间。
+//
+// 调度完全由运行队列和计时器决定：运行队列先进先出，同一时刻到期的计时器按创建的顺序触发，
+// 等待通道的 proc 按开始等待的顺序重试，select 按分支的顺序检查而不是随机选择。
+// 因此只要 proc 之间只通过 sim 的阻塞操作和互斥锁交互、随机数都来自 NewRand，
+// 同样的种子每次都得到完全相同的执行过程。
+//
+// 使用约束：
+//   - Run 中的代码必须用 Go 启动 goroutine，用 Recv、Send、RecvUntil、SendUntil、Cond、WaitGroup 阻塞；
+//     直接阻塞在通道或 sync.Cond 上会让整个调度停住
+//   - 通过 Send 发送的通道必须带缓冲：收发双方都是轮询，无缓冲通道上永远无法配对
+//   - 持有互斥锁时不能让出执行权，否则其他 proc 加锁时会直接阻塞
+type Virtual struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers timerHeap
+	seq    uint64
+	steps  int64
+
+	active   atomic.Bool // Run 开始后为 true，Run 结束后为 false
+	stopped  bool
+	current  *proc              // 持有执行权的 proc
+	runq     []*proc            // 可以运行的 proc，先进先出
+	polling  []*proc            // 条件还不满足、等待其他 proc 取得进展后重试的 proc
+	progress bool               // 上次让 polling 重试之后是否有 proc 取得了进展
+	procs    map[*proc]struct{} // 还没有退出的 proc
+	nextID   uint64
+	trace    func(now time.Time, proc uint64)
+}
+
+// 一个由 Virtual 调度的 goroutine
+type proc struct {
+	id   uint64        // 按创建的顺序编号，root 为 1
+	wake chan struct{} // 轮到它运行时写入，容量为 1
+}
+
+type timer struct {
+	when time.Time
+	seq  uint64
+	fire func(now time.Time) // 持有 v.mu 时调用，不能阻塞
+}
+
+type timerHeap []*timer
+
+func (h timerHeap) Len() int { return len(h) }
+func (h timerHeap) Less(i, j int) bool {
+	if !h[i].when.Equal(h[j].when) {
+		return h[i].when.Before(h[j].when)
+	}
+	return h[i].seq < h[j].seq
+}
+func (h timerHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *timerHeap) Push(x interface{}) { *h = append(*h, x.(*timer)) }
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return t
+}
+
+// 创建从 start 开始的虚拟时钟，用 SetClock 换上之后调用 Run
+func NewVirtual(start time.Time) *Virtual {
+	return &Virtual{now: start, procs: make(map[*proc]struct{})}
+}
+
+// 每次调度切换时调用 f，参数为当前虚拟时间和切换到的 proc 的编号，用于比较两次运行的执行过程
+// 应在 Run 之前设置，f 不能调用 Virtual 的方法
+func (v *Virtual) SetTrace(f func(now time.Time, proc uint64)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.trace = f
+}
+
+// 把 root 作为第一个 proc 运行，root 返回后停止调度并返回。只能调用一次
+//
+// 停止后时间不再前进，所有计时器（包括已经在等待的）立即到期，还没有退出的 proc 被唤醒，
+// 之后像普通 goroutine 一样并发运行、直接阻塞，让它们能够退出
+func (v *Virtual) Run(root func()) {
+	done := make(chan struct{})
+	v.mu.Lock()
+	v.active.Store(true)
+	v.spawnLocked(func() {
+		defer close(done)
+		root()
+		v.stop()
+	})
+	v.switchToLocked(nil)
+	<-done
+}
+
+func (v *Virtual) Now() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.now
+}
+
+func (v *Virtual) Sleep(d time.Duration) {
+	v.mu.Lock()
+	if !v.schedulingLocked() {
+		v.mu.Unlock()
+		return
+	}
+	p := v.current
+	v.addLocked(d, func(time.Time) { v.runq = append(v.runq, p) })
+	v.progress = true
+	v.parkLocked(p)
+}
+
+func (v *Virtual) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.schedulingLocked() {
+		ch <- v.now
+		return ch
+	}
+	v.addLocked(d, func(now time.Time) { ch <- now })
+	return ch
+}
+
+func (v *Virtual) AfterFunc(d time.Duration, f func()) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.schedulingLocked() {
+		go f()
+		return
+	}
+	v.addLocked(d, func(time.Time) { v.spawnLocked(f) })
+}
+
+// 时间前进的次数
+func (v *Virtual) Steps() int64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.steps
+}
+
+// 是否在 Run 中调度，调用方需持有 v.mu
+func (v *Virtual) schedulingLocked() bool {
+	return v.current != nil && !v.stopped
+}
+
+func (v *Virtual) addLocked(d time.Duration, fire func(now time.Time)) {
+	if d < 0 {
+		d = 0
+	}
+	v.seq++
+	heap.Push(&v.timers, &timer{when: v.now.Add(d), seq: v.seq, fire: fire})
+}
+
+// 创建一个 proc 并放到运行队列末尾，停止后直接启动 goroutine
+func (v *Virtual) spawnLocked(f func()) {
+	if v.stopped {
+		go f()
+		return
+	}
+	v.nextID++
+	p := &proc{id: v.nextID, wake: make(chan struct{}, 1)}
+	v.procs[p] = struct{}{}
+	v.runq = append(v.runq, p)
+	go func() {
+		<-p.wake
+		f()
+		v.exit(p)
+	}()
+}
+
+func (v *Virtual) spawn(f func()) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.spawnLocked(f)
+}
+
+// 取下一个要运行的 proc：先取运行队列；运行队列为空时，如果有 proc 取得了进展，让所有等待条件的 proc 重试；
+// 否则把时间推进到最早的计时器。没有任何 proc 能够运行时返回 nil
+func (v *Virtual) nextLocked() *proc {
+	for {
+		if len(v.runq) > 0 {
+			p := v.runq[0]
+			v.runq[0] = nil
+			v.runq = v.runq[1:]
+			return p
+		}
+		if v.progress && len(v.polling) > 0 {
+			v.runq, v.polling = v.polling, nil
+			v.progress = false
+			continue
+		}
+		if len(v.timers) == 0 {
+			return nil
+		}
+		v.advanceLocked()
+	}
+}
+
+// 把时间推进到最早的计时器，并触发所有在这一时刻到期的计时器
+func (v *Virtual) advanceLocked() {
+	v.now = v.timers[0].when
+	v.steps++
+	for len(v.timers) > 0 && !v.timers[0].when.After(v.now) {
+		heap.Pop(&v.timers).(*timer).fire(v.now)
+	}
+	v.progress = true
+}
+
+// 把执行权交给下一个 proc，调用时持有 v.mu，返回时已释放
+func (v *Virtual) switchToLocked(from *proc) (next *proc) {
+	next = v.nextLocked()
+	if next == nil {
+		blocked := len(v.procs)
+		v.mu.Unlock()
+		panic(fmt.Sprintf("sim: deadlock, all %d goroutines are blocked and no timer is pending", blocked))
+	}
+	v.current = next
+	if v.trace != nil {
+		v.trace(v.now, next.id)
+	}
+	if next != from {
+		next.wake <- struct{}{}
+	}
+	v.mu.Unlock()
+	return next
+}
+
+// p 让出执行权，等到再次轮到它或者调度停止时返回。调用时持有 v.mu，返回时已释放
+func (v *Virtual) parkLocked(p *proc) {
+	if v.switchToLocked(p) != p {
+		<-p.wake
+	}
+}
+
+// 当前 proc 在条件不满足时等待其他 proc 取得进展，retry 为 true 表示刚刚重试过并且仍不满足，
+// 这次等待不算进展。返回 false 表示调度已经停止，调用方应改为直接阻塞
+func (v *Virtual) poll(retry bool) bool {
+	v.mu.Lock()
+	if !v.schedulingLocked() {
+		v.mu.Unlock()
+		return false
+	}
+	p := v.current
+	if !retry {
+		v.progress = true
+	}
+	v.polling = append(v.polling, p)
+	v.parkLocked(p)
+	return v.scheduling()
+}
+
+func (v *Virtual) scheduling() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.schedulingLocked()
+}
+
+func (v *Virtual) exit(p *proc) {
+	v.mu.Lock()
+	delete(v.procs, p)
+	if v.stopped {
+		v.mu.Unlock()
+		return
+	}
+	v.progress = true
+	v.switchToLocked(p)
+}
+
+// 停止调度：触发所有计时器，唤醒所有还在等待的 proc
+func (v *Virtual) stop() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.stopped = true
+	v.active.Store(false)
+	for len(v.timers) > 0 {
+		heap.Pop(&v.timers).(*timer).fire(v.now)
+	}
+	for p := range v.procs {
+		if p != v.current {
+			p.wake <- struct{}{}
+		}
+	}
+	v.procs = make(map[*proc]struct{})
+	v.runq, v.polling = nil, nil
+}
@@ -0,0 +1,107 @@
+package sim
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// 在新的 Virtual 时钟下运行 root，返回调度过程
+func runVirtual(t *testing.T, root func()) []string {
+	t.Helper()
+	v := NewVirtual(epoch)
+	var trace []string
+	v.SetTrace(func(now time.Time, proc uint64) {
+		trace = append(trace, fmt.Sprintf("%s p%d", now.Sub(epoch), proc))
+	})
+	defer SetClock(v)()
+	v.Run(root)
+	return trace
+}
+
+// 计算再久，只要没有 goroutine 等待，虚拟时间就不前进
+func TestTimeStandsStillWhileRunning(t *testing.T) {
+	var before, after time.Time
+	var woke time.Time
+	runVirtual(t, func() {
+		Go(func() {
+			Sleep(time.Millisecond)
+			woke = Now()
+		})
+		before = Now()
+		for start := time.Now(); time.Since(start) < 20*time.Millisecond; {
+		}
+		after = Now()
+		Sleep(time.Second)
+	})
+	if !after.Equal(before) {
+		t.Fatalf("virtual time moved by %s during a busy loop", after.Sub(before))
+	}
+	if got := woke.Sub(epoch); got != time.Millisecond {
+		t.Fatalf("sleeper woke at %s, want 1ms", got)
+	}
+}
+
+// 通道、条件变量和计时器一起使用时，同样的程序每次得到相同的调度过程和结果
+func TestSameSchedule(t *testing.T) {
+	program := func() []string {
+		var events []string
+		log := func(format string, args ...interface{}) {
+			events = append(events, fmt.Sprintf("%s ", Now().Sub(epoch))+fmt.Sprintf(format, args...))
+		}
+		trace := runVirtual(t, func() {
+			var mu sync.Mutex
+			cond := NewCond(&mu)
+			ready := 0
+			results := make(chan int, 1)
+			var wg WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				Go(func() {
+					defer wg.Done()
+					mu.Lock()
+					for ready == 0 {
+						cond.Wait()
+					}
+					mu.Unlock()
+					for j := 0; j < 3; j++ {
+						Sleep(time.Duration(i+1) * time.Millisecond)
+						Send(results, i*10+j)
+					}
+				})
+			}
+			Go(func() {
+				Sleep(5 * time.Millisecond)
+				mu.Lock()
+				ready = 1
+				cond.Broadcast()
+				mu.Unlock()
+			})
+			for n := 0; n < 12; n++ {
+				r, _, ok := RecvUntil(results, After(time.Second))
+				if !ok {
+					log("timeout")
+					return
+				}
+				log("got %d", r)
+			}
+			wg.Wait()
+			log("done")
+		})
+		return append(events, trace...)
+	}
+
+	first := program()
+	if first[0] != "6ms got 0" || first[12] != "17ms done" {
+		t.Fatalf("unexpected events %v", first[:13])
+	}
+	for i := 0; i < 5; i++ {
+		if again := program(); !reflect.DeepEqual(first, again) {
+			t.Fatalf("run %d differs:\n%v\nfirst:\n%v", i+2, again, first)
+		}
+	}
+}
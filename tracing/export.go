@@ -0,0 +1,268 @@
+package tracing
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 接收已经结束的 span，Export 不能阻塞调用方
+type Exporter interface {
+	Export(span SpanData)
+	// 写出缓冲中的全部 span 并停止
+	Shutdown() error
+}
+
+// 设置导出器并开始记录 span，e 为 nil 时停止记录
+// 之前的导出器会被关闭
+func SetExporter(e Exporter) {
+	var old *Exporter
+	if e == nil {
+		old = exporter.Swap(nil)
+	} else {
+		old = exporter.Swap(&e)
+	}
+	if old != nil {
+		(*old).Shutdown()
+	}
+}
+
+// 按 path 开始导出 span：空字符串表示不记录，- 表示标准输出，其他值为追加写入的文件
+// service 为 resource 中的 service.name，用于区分 kvnode、kvgateway 等进程
+func Setup(path, service string) error {
+	if path == "" {
+		return nil
+	}
+	var w io.WriteCloser = nopCloser{os.Stdout}
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	SetExporter(NewJSONExporter(w, service))
+	return nil
+}
+
+// 停止记录并写出缓冲中的 span，进程退出前调用
+func Shutdown() {
+	SetExporter(nil)
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// 以 OTLP/JSON 格式写出 span 的导出器
+//
+// 每行是一个 ExportTraceServiceRequest，与 OpenTelemetry Collector 的 file exporter 输出相同，
+// 可以用 Collector 的 otlpjsonfile receiver 读入后转发到 Jaeger 等后端，也可以直接用 jq 查看
+type JSONExporter struct {
+	w       io.WriteCloser
+	service string
+
+	mu      sync.RWMutex // 保护 closed，避免关闭后仍向 ch 发送
+	closed  bool
+	ch      chan SpanData
+	done    chan struct{}
+	dropped atomic.Int64 // 缓冲已满时丢弃的 span 数量
+}
+
+// 缓冲的 span 数量，每批写出的最大数量和最长间隔
+const (
+	exportQueueSize = 4096
+	exportBatchSize = 256
+	exportInterval  = time.Second
+)
+
+func NewJSONExporter(w io.WriteCloser, service string) *JSONExporter {
+	e := &JSONExporter{
+		w:       w,
+		service: service,
+		ch:      make(chan SpanData, exportQueueSize),
+		done:    make(chan struct{}),
+	}
+	go e.loop()
+	return e
+}
+
+func (e *JSONExporter) Export(span SpanData) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.ch <- span:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+func (e *JSONExporter) Shutdown() error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.ch)
+	}
+	e.mu.Unlock()
+	<-e.done
+	return e.w.Close()
+}
+
+func (e *JSONExporter) loop() {
+	defer close(e.done)
+	bw := bufio.NewWriter(e.w)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	var batch []SpanData
+	flush := func() {
+		if len(batch) > 0 {
+			e.write(bw, batch)
+			batch = batch[:0]
+		}
+		bw.Flush()
+	}
+	for {
+		select {
+		case span, ok := <-e.ch:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= exportBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (e *JSONExporter) write(w io.Writer, batch []SpanData) {
+	spans := make([]otlpSpan, len(batch))
+	for i, s := range batch {
+		spans[i] = toOTLP(s)
+	}
+	resource := []otlpAttr{{Key: "service.name", Value: otlpValue{StringValue: &e.service}}}
+	if n := e.dropped.Swap(0); n > 0 {
+		resource = append(resource, attr("tracing.dropped_spans", n))
+	}
+	request := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: resource},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "course"},
+			Spans: spans,
+		}},
+	}}}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return
+	}
+	w.Write(append(data, '\n'))
+}
+
+// OTLP/JSON 的结构，字段名和取值规则见 opentelemetry-proto 的 JSON 映射：
+// TraceID 和 SpanID 为十六进制字符串，64 位整数为十进制字符串
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              Kind       `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"` // 0 为未设置，2 为错误
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func attr(key string, value interface{}) otlpAttr {
+	var v otlpValue
+	switch x := value.(type) {
+	case string:
+		v.StringValue = &x
+	case bool:
+		v.BoolValue = &x
+	case int:
+		s := strconv.Itoa(x)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &x
+	default:
+		s := fmt.Sprint(x)
+		v.StringValue = &s
+	}
+	return otlpAttr{Key: key, Value: v}
+}
+
+func toOTLP(s SpanData) otlpSpan {
+	out := otlpSpan{
+		TraceID:           s.Context.TraceID.String(),
+		SpanID:            s.Context.SpanID.String(),
+		Name:              s.Name,
+		Kind:              s.Kind,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+	}
+	if s.Parent != (SpanID{}) {
+		out.ParentSpanID = s.Parent.String()
+	}
+	for _, a := range s.Attrs {
+		out.Attributes = append(out.Attributes, attr(a.Key, a.Value))
+	}
+	if s.Error != "" {
+		out.Status = otlpStatus{Code: 2, Message: s.Error}
+	}
+	return out
+}
@@ -0,0 +1,174 @@
+// Package tracing 记录一次请求在网关、KVServer 和 Raft 各阶段的耗时（span）
+//
+// span 的模型与 OpenTelemetry 相同：同一次请求的 span 共用一个 TraceID，通过父 span 的 SpanID 连接成树。
+// 跨进程时 SpanContext 随 RPC 参数传递，HTTP 请求可以用 W3C traceparent 请求头带入上游的 trace。
+//
+// 没有调用 Setup 时不记录任何 span，Start 返回 nil，nil 的 *Span 上所有方法都可以安全调用
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// 标识一个 span，随 RPC 参数和日志条目传递
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// 按 W3C Trace Context 的格式输出，用于 traceparent 请求头
+func (sc SpanContext) TraceParent() string {
+	return fmt.Sprintf("00-%s-%s-01", sc.TraceID, sc.SpanID)
+}
+
+// 解析 traceparent 请求头，格式不正确时返回 false
+func ParseTraceParent(s string) (SpanContext, bool) {
+	var sc SpanContext
+	if len(s) != 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' || s[:2] == "ff" {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(s[3:35])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(s[36:52])); err != nil {
+		return sc, false
+	}
+	return sc, sc.IsValid()
+}
+
+// span 的类型，取值与 OpenTelemetry 的 SpanKind 相同
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// 一个属性，Value 为 string、bool、int、int64 或 float64
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+// 已经结束的 span，交给导出器
+type SpanData struct {
+	Context SpanContext
+	Parent  SpanID // 为零值时表示根 span
+	Name    string
+	Kind    Kind
+	Start   time.Time
+	End     time.Time
+	Attrs   []Attr
+	Error   string // 不为空时表示该阶段失败
+}
+
+// 一个进行中的 span，同一个 span 只能由一个 goroutine 修改
+type Span struct {
+	data  SpanData
+	ended bool
+}
+
+var exporter atomic.Pointer[Exporter]
+
+// 是否正在记录 span
+func Enabled() bool {
+	return exporter.Load() != nil
+}
+
+// 开始一个 span，parent 无效时开始一个新的 trace
+// attrs 按 key、value 交替给出
+func Start(parent SpanContext, name string, kind Kind, attrs ...interface{}) *Span {
+	return StartAt(parent, name, kind, time.Now(), attrs...)
+}
+
+// 与 Start 相同，但开始时间由调用方给出，用于事后记录已经发生的阶段
+func StartAt(parent SpanContext, name string, kind Kind, start time.Time, attrs ...interface{}) *Span {
+	if !Enabled() {
+		return nil
+	}
+	s := &Span{data: SpanData{Name: name, Kind: kind, Start: start}}
+	if parent.IsValid() {
+		s.data.Context.TraceID = parent.TraceID
+		s.data.Parent = parent.SpanID
+	} else {
+		rand.Read(s.data.Context.TraceID[:])
+	}
+	rand.Read(s.data.Context.SpanID[:])
+	s.SetAttrs(attrs...)
+	return s
+}
+
+// 返回 span 的标识，用作子 span 的 parent；s 为 nil 时返回零值
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+// 设置属性，按 key、value 交替给出
+func (s *Span) SetAttrs(attrs ...interface{}) {
+	if s == nil {
+		return
+	}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		key, ok := attrs[i].(string)
+		if !ok {
+			continue
+		}
+		s.data.Attrs = append(s.data.Attrs, Attr{Key: key, Value: attrs[i+1]})
+	}
+}
+
+// 标记该阶段失败，msg 为空时不做任何事
+func (s *Span) SetError(msg string) {
+	if s == nil || msg == "" {
+		return
+	}
+	s.data.Error = msg
+}
+
+// 结束 span 并交给导出器，重复调用时只有第一次生效
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+func (s *Span) EndAt(end time.Time) {
+	if s == nil || s.ended {
+		return
+	}
+	s.ended = true
+	s.data.End = end
+	if e := exporter.Load(); e != nil {
+		(*e).Export(s.data)
+	}
+}
+
+type spanContextKey struct{}
+
+// 返回带有 span 标识的 context
+func ContextWith(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// 返回 context 中的 span 标识，没有时返回零值
+func FromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc
+}
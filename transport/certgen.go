@@ -0,0 +1,98 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 证书有效期，生成的证书只用于测试和本机部署
+const certValidity = 365 * 24 * time.Hour
+
+// 在 dir 中生成一个 CA（ca.crt、ca.key），并为每个身份生成由它签发的证书 <identity>.crt 和 <identity>.key
+// 证书同时可以用作服务端和客户端证书，身份写在 DNS SAN 中
+// 返回每个身份对应的证书文件
+func GenerateCertificates(dir string, identities []string) (map[string]TLSFiles, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kv cluster CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(certValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := writeCertificate(caFile, filepath.Join(dir, "ca.key"), caDER, caKey); err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]TLSFiles, len(identities))
+	for i, identity := range identities {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 2)),
+			Subject:      pkix.Name{CommonName: identity},
+			DNSNames:     []string{identity},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(certValidity),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			return nil, err
+		}
+
+		f := TLSFiles{
+			CertFile: filepath.Join(dir, identity+".crt"),
+			KeyFile:  filepath.Join(dir, identity+".key"),
+			CAFile:   caFile,
+		}
+		if err := writeCertificate(f.CertFile, f.KeyFile, der, key); err != nil {
+			return nil, err
+		}
+		files[identity] = f
+	}
+	return files, nil
+}
+
+func writeCertificate(certFile, keyFile string, der []byte, key *ecdsa.PrivateKey) error {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return os.WriteFile(keyFile, keyPEM, 0600)
+}
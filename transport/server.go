@@ -0,0 +1,253 @@
+package transport
+
+import (
+	"bytes"
+	"course/labgob"
+	"course/logging"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var logger = logging.Logger(logging.TopicTransport)
+
+// TLS 握手的最长时间，避免不完成握手的连接一直占用资源
+const handshakeTimeout = 5 * time.Second
+
+// 一个请求帧，Args 为 labgob 编码后的参数
+type request struct {
+	SvcMeth string
+	Args    []byte
+}
+
+// 一个回复帧，OK 为 false 表示服务端无法处理该请求
+type response struct {
+	OK    bool
+	Reply []byte
+}
+
+// 可以通过 RPC 调用的对象，方法签名与 labrpc 的要求相同：func (r *T) Method(args *A, reply *R)
+type service struct {
+	name    string
+	rcvr    reflect.Value
+	methods map[string]reflect.Method
+}
+
+func newService(rcvr interface{}) *service {
+	svc := &service{
+		rcvr:    reflect.ValueOf(rcvr),
+		methods: make(map[string]reflect.Method),
+	}
+	svc.name = reflect.Indirect(svc.rcvr).Type().Name()
+
+	typ := reflect.TypeOf(rcvr)
+	for m := 0; m < typ.NumMethod(); m++ {
+		method := typ.Method(m)
+		mtype := method.Type
+		if method.PkgPath == "" && mtype.NumIn() == 3 &&
+			mtype.In(2).Kind() == reflect.Ptr && mtype.NumOut() == 0 {
+			svc.methods[method.Name] = method
+		}
+	}
+	return svc
+}
+
+// 解码方法的参数，返回值可以直接传给 call
+func (svc *service) decode(method reflect.Method, args []byte) (reflect.Value, error) {
+	argsType := method.Type.In(1)
+	var argv reflect.Value
+	if argsType.Kind() == reflect.Ptr {
+		argv = reflect.New(argsType.Elem())
+	} else {
+		argv = reflect.New(argsType)
+	}
+	if err := labgob.NewDecoder(bytes.NewBuffer(args)).Decode(argv.Interface()); err != nil {
+		return reflect.Value{}, fmt.Errorf("decode args: %w", err)
+	}
+	if argsType.Kind() != reflect.Ptr {
+		argv = argv.Elem()
+	}
+	return argv, nil
+}
+
+// 调用方法并编码回复
+func (svc *service) call(method reflect.Method, argv reflect.Value) ([]byte, error) {
+	replyv := reflect.New(method.Type.In(2).Elem())
+	method.Func.Call([]reflect.Value{svc.rcvr, argv, replyv})
+
+	w := new(bytes.Buffer)
+	if err := labgob.NewEncoder(w).Encode(replyv.Interface()); err != nil {
+		return nil, fmt.Errorf("encode reply: %w", err)
+	}
+	return w.Bytes(), nil
+}
+
+// TCP 服务端，一个服务端可以注册多个服务，例如 Raft 和 KVServer 共用一个端口
+type Server struct {
+	mu       sync.Mutex
+	services map[string]*service
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+
+	// 不为空时，每个请求先经过它检查，peer 为对方 TLS 证书的身份（未启用 TLS 时为空），
+	// args 为解码后的参数，与方法声明的参数类型相同
+	authorize func(peer, svcMeth string, args interface{}) bool
+}
+
+func NewServer() *Server {
+	return &Server{
+		services: make(map[string]*service),
+		conns:    make(map[net.Conn]struct{}),
+	}
+}
+
+// 注册一个服务，服务名为接收者的类型名，例如 "Raft"
+func (s *Server) AddService(rcvr interface{}) {
+	svc := newService(rcvr)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.services[svc.name] = svc
+}
+
+// 设置请求的鉴权函数，返回 false 的请求不会被执行，调用方收到失败
+func (s *Server) SetAuthorizer(fn func(peer, svcMeth string, args interface{}) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authorize = fn
+}
+
+// 在 addr 上监听并处理请求，直到 Close 被调用
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// 在已有的 listener 上处理请求，直到 Close 被调用
+// listener 由 Listen 创建并带有 TLS 配置时，只接受出示了有效证书的连接
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// 停止监听并断开所有连接
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// 依次处理一个连接上的请求，客户端在收到回复之前不会在同一连接上发送下一个请求
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	peer, err := peerIdentity(conn)
+	if err != nil {
+		logger.Warn("TLS handshake failed", "remote", conn.RemoteAddr().String(), "err", err)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	dec := gob.NewDecoder(conn)
+	enc := gob.NewEncoder(conn)
+	for {
+		var req request
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		if err := enc.Encode(s.dispatch(peer, req)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) dispatch(peer string, req request) response {
+	dot := strings.LastIndex(req.SvcMeth, ".")
+	if dot < 0 {
+		logger.Warn("Malformed method", "method", req.SvcMeth)
+		return response{}
+	}
+
+	s.mu.Lock()
+	svc, ok := s.services[req.SvcMeth[:dot]]
+	authorize := s.authorize
+	s.mu.Unlock()
+	if !ok {
+		logger.Warn("Unknown service", "method", req.SvcMeth)
+		return response{}
+	}
+	method, ok := svc.methods[req.SvcMeth[dot+1:]]
+	if !ok {
+		logger.Warn("Unknown method", "method", req.SvcMeth)
+		return response{}
+	}
+
+	argv, err := svc.decode(method, req.Args)
+	if err != nil {
+		logger.Warn("Call failed", "method", req.SvcMeth, "err", err)
+		return response{}
+	}
+	if authorize != nil && !authorize(peer, req.SvcMeth, argv.Interface()) {
+		logger.Warn("Call not allowed", "peer", peer, "method", req.SvcMeth)
+		return response{}
+	}
+	reply, err := svc.call(method, argv)
+	if err != nil {
+		logger.Warn("Call failed", "method", req.SvcMeth, "err", err)
+		return response{}
+	}
+	return response{OK: true, Reply: reply}
+}
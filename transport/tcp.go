@@ -0,0 +1,168 @@
+package transport
+
+import (
+	"bytes"
+	"course/labgob"
+	"crypto/tls"
+	"encoding/gob"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultDialTimeout = 1 * time.Second
+	DefaultCallTimeout = 3 * time.Second
+
+	// 每个端点最多缓存的空闲连接数
+	maxIdleConns = 8
+)
+
+// 参数实现该接口时，Call 的超时时间取 CallTimeout() 和端点默认值中较大的一个
+// 用于 Watch 这样服务端会挂起等待的长轮询请求
+type callTimeouter interface {
+	CallTimeout() time.Duration
+}
+
+type clientConn struct {
+	conn net.Conn
+	enc  *gob.Encoder
+	dec  *gob.Decoder
+}
+
+// 通过 TCP 访问一个远端节点的 RPC 端点
+// 连接按需建立并在调用结束后放回连接池复用；一个连接同一时间只承载一个调用，
+// 并发调用会各自使用不同的连接。调用失败时丢弃该端点的全部空闲连接，下次调用重新连接
+type TCPClientEnd struct {
+	addr        string
+	DialTimeout time.Duration
+	CallTimeout time.Duration
+	tlsConfig   *tls.Config // 不为空时使用双向 TLS，见 NewTLSClientEnds
+
+	mu     sync.Mutex
+	idle   []*clientConn
+	closed bool
+}
+
+// 创建指向 addr 的端点，此时不会建立连接
+func NewTCPClientEnd(addr string) *TCPClientEnd {
+	return &TCPClientEnd{
+		addr:        addr,
+		DialTimeout: DefaultDialTimeout,
+		CallTimeout: DefaultCallTimeout,
+	}
+}
+
+// 为每个地址创建一个端点
+func NewTCPClientEnds(addrs []string) []ClientEnd {
+	ends := make([]ClientEnd, len(addrs))
+	for i, addr := range addrs {
+		ends[i] = NewTCPClientEnd(addr)
+	}
+	return ends
+}
+
+func (e *TCPClientEnd) Addr() string {
+	return e.addr
+}
+
+func (e *TCPClientEnd) Call(svcMeth string, args interface{}, reply interface{}) bool {
+	w := new(bytes.Buffer)
+	if err := labgob.NewEncoder(w).Encode(args); err != nil {
+		panic(err)
+	}
+
+	timeout := e.CallTimeout
+	if t, ok := args.(callTimeouter); ok && t.CallTimeout() > timeout {
+		timeout = t.CallTimeout()
+	}
+
+	c, err := e.get()
+	if err != nil {
+		return false
+	}
+
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	var resp response
+	if err := c.enc.Encode(request{SvcMeth: svcMeth, Args: w.Bytes()}); err != nil {
+		e.fail(c)
+		return false
+	}
+	if err := c.dec.Decode(&resp); err != nil {
+		e.fail(c)
+		return false
+	}
+	c.conn.SetDeadline(time.Time{})
+	e.put(c)
+
+	if !resp.OK {
+		return false
+	}
+	if err := labgob.NewDecoder(bytes.NewBuffer(resp.Reply)).Decode(reply); err != nil {
+		logger.Warn("Failed to decode reply", "method", svcMeth, "addr", e.addr, "err", err)
+		return false
+	}
+	return true
+}
+
+// 关闭所有空闲连接，之后的调用都会失败
+func (e *TCPClientEnd) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	for _, c := range e.idle {
+		c.conn.Close()
+	}
+	e.idle = nil
+}
+
+// 取出一个空闲连接，没有时新建一个
+func (e *TCPClientEnd) get() (*clientConn, error) {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil, net.ErrClosed
+	}
+	if n := len(e.idle); n > 0 {
+		c := e.idle[n-1]
+		e.idle = e.idle[:n-1]
+		e.mu.Unlock()
+		return c, nil
+	}
+	e.mu.Unlock()
+
+	var conn net.Conn
+	var err error
+	if e.tlsConfig != nil {
+		dialer := &net.Dialer{Timeout: e.DialTimeout}
+		conn, err = tls.DialWithDialer(dialer, "tcp", e.addr, e.tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", e.addr, e.DialTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &clientConn{conn: conn, enc: gob.NewEncoder(conn), dec: gob.NewDecoder(conn)}, nil
+}
+
+// 将连接放回连接池，池满时直接关闭
+func (e *TCPClientEnd) put(c *clientConn) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed || len(e.idle) >= maxIdleConns {
+		c.conn.Close()
+		return
+	}
+	e.idle = append(e.idle, c)
+}
+
+// 调用失败时关闭该连接；对方可能已经重启，其余空闲连接也一并丢弃
+func (e *TCPClientEnd) fail(c *clientConn) {
+	c.conn.Close()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, idle := range e.idle {
+		idle.conn.Close()
+	}
+	e.idle = nil
+}
@@ -0,0 +1,143 @@
+package transport
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type EchoArgs struct {
+	Text  string
+	Delay time.Duration
+}
+
+type EchoReply struct {
+	Text string
+}
+
+// 服务端会挂起等待的请求，调用的超时由参数决定
+type LongArgs struct {
+	Delay time.Duration
+}
+
+func (args LongArgs) CallTimeout() time.Duration {
+	return args.Delay + time.Second
+}
+
+// 测试用的服务，Calls 记录被执行的次数
+type Echo struct {
+	Calls atomic.Int32
+}
+
+func (e *Echo) Echo(args *EchoArgs, reply *EchoReply) {
+	e.Calls.Add(1)
+	time.Sleep(args.Delay)
+	reply.Text = args.Text
+}
+
+func (e *Echo) Long(args *LongArgs, reply *EchoReply) {
+	time.Sleep(args.Delay)
+	reply.Text = "done"
+}
+
+// 在本机随机端口上启动服务端，测试结束时关闭
+func startServer(t *testing.T, l net.Listener) (*Server, *Echo) {
+	t.Helper()
+	echo := &Echo{}
+	s := NewServer()
+	s.AddService(echo)
+	go s.Serve(l)
+	t.Cleanup(s.Close)
+	return s, echo
+}
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// 调用通过 TCP 送达服务端并返回回复，并发调用各自使用一个连接，结束后连接放回连接池
+func TestTCPCall(t *testing.T) {
+	l := listen(t)
+	_, echo := startServer(t, l)
+	end := NewTCPClientEnd(l.Addr().String())
+	defer end.Close()
+
+	var reply EchoReply
+	if !end.Call("Echo.Echo", &EchoArgs{Text: "hello"}, &reply) || reply.Text != "hello" {
+		t.Fatalf("Call = %q, want hello", reply.Text)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply EchoReply
+			if !end.Call("Echo.Echo", &EchoArgs{Text: "x", Delay: 50 * time.Millisecond}, &reply) {
+				t.Errorf("concurrent Call failed")
+			}
+		}()
+	}
+	wg.Wait()
+	if n := echo.Calls.Load(); n != 5 {
+		t.Errorf("service ran %d times, want 5", n)
+	}
+	end.mu.Lock()
+	idle := len(end.idle)
+	end.mu.Unlock()
+	if idle == 0 || idle > 4 {
+		t.Errorf("%d idle connections after four concurrent calls", idle)
+	}
+
+	for _, method := range []string{"Echo.Nope", "Nope.Echo", "Echo"} {
+		if end.Call(method, &EchoArgs{}, &reply) {
+			t.Errorf("Call(%q) succeeded", method)
+		}
+	}
+}
+
+// 服务端没有在超时前回复时调用失败；服务端重启后端点重新建立连接
+func TestTCPCallTimeoutAndReconnect(t *testing.T) {
+	l := listen(t)
+	addr := l.Addr().String()
+	s, _ := startServer(t, l)
+	end := NewTCPClientEnd(addr)
+	end.CallTimeout = 100 * time.Millisecond
+	defer end.Close()
+
+	var reply EchoReply
+	if end.Call("Echo.Echo", &EchoArgs{Delay: time.Second}, &reply) {
+		t.Fatalf("Call that outlived CallTimeout succeeded")
+	}
+	if !end.Call("Echo.Echo", &EchoArgs{Text: "ok"}, &reply) || reply.Text != "ok" {
+		t.Fatalf("Call after a timeout failed")
+	}
+	if !end.Call("Echo.Long", LongArgs{Delay: 300 * time.Millisecond}, &reply) || reply.Text != "done" {
+		t.Fatalf("Call with a longer CallTimeout in its args failed")
+	}
+
+	s.Close()
+	if end.Call("Echo.Echo", &EchoArgs{}, &reply) {
+		t.Fatalf("Call to a closed server succeeded")
+	}
+	l, err := Listen(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	startServer(t, l)
+	if !end.Call("Echo.Echo", &EchoArgs{Text: "again"}, &reply) || reply.Text != "again" {
+		t.Fatalf("Call after the server restarted failed")
+	}
+
+	end.Close()
+	if end.Call("Echo.Echo", &EchoArgs{}, &reply) {
+		t.Fatalf("Call on a closed end succeeded")
+	}
+}
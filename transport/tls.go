@@ -0,0 +1,132 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// 节点 i 的证书身份，证书的 DNS SAN 中必须包含该名字
+// 连接节点 i 时要求对方出示这个身份，防止地址被别的进程冒用
+func NodeIdentity(id int) string {
+	return fmt.Sprintf("node-%d", id)
+}
+
+// 双向 TLS 使用的证书文件，三个路径都为空时不启用 TLS
+type TLSFiles struct {
+	CertFile string // 本进程的证书
+	KeyFile  string // 本进程证书的私钥
+	CAFile   string // 签发集群内所有证书的 CA
+}
+
+func (f TLSFiles) Enabled() bool {
+	return f.CertFile != "" || f.KeyFile != "" || f.CAFile != ""
+}
+
+// 返回本进程证书的身份
+func (f TLSFiles) Identity() (string, error) {
+	cert, _, err := f.load()
+	if err != nil {
+		return "", err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return "", err
+	}
+	return certIdentity(leaf), nil
+}
+
+func (f TLSFiles) load() (tls.Certificate, *x509.CertPool, error) {
+	if f.CertFile == "" || f.KeyFile == "" || f.CAFile == "" {
+		return tls.Certificate{}, nil, fmt.Errorf("TLS needs a certificate, a key and a CA file")
+	}
+	cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	caPEM, err := os.ReadFile(f.CAFile)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return tls.Certificate{}, nil, fmt.Errorf("no certificates found in %s", f.CAFile)
+	}
+	return cert, pool, nil
+}
+
+// 服务端配置：要求客户端出示由 CA 签发的证书
+func (f TLSFiles) ServerConfig() (*tls.Config, error) {
+	cert, pool, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// 客户端配置：出示本进程的证书，并只信任 CA 签发的服务端证书
+// 具体连接哪个节点时再设置 ServerName，见 NewTLSClientEnds
+func (f TLSFiles) ClientConfig() (*tls.Config, error) {
+	cert, pool, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// 在 addr 上监听，config 不为空时只接受 TLS 连接
+func Listen(addr string, config *tls.Config) (net.Listener, error) {
+	if config != nil {
+		return tls.Listen("tcp", addr, config)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// 为每个节点地址创建一个使用 TLS 的端点，第 i 个端点要求对方的身份为 NodeIdentity(i)
+func NewTLSClientEnds(addrs []string, config *tls.Config) []ClientEnd {
+	ends := make([]ClientEnd, len(addrs))
+	for i, addr := range addrs {
+		c := config.Clone()
+		c.ServerName = NodeIdentity(i)
+		end := NewTCPClientEnd(addr)
+		end.tlsConfig = c
+		ends[i] = end
+	}
+	return ends
+}
+
+// 证书代表的身份：第一个 DNS SAN，没有时使用 CommonName
+func certIdentity(cert *x509.Certificate) string {
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return cert.Subject.CommonName
+}
+
+// 返回 TLS 连接对端证书的身份，握手失败或不是 TLS 连接时返回空字符串
+func peerIdentity(conn net.Conn) (string, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", nil
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return "", err
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", fmt.Errorf("no client certificate")
+	}
+	return strings.TrimSpace(certIdentity(certs[0])), nil
+}
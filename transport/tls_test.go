@@ -0,0 +1,96 @@
+package transport
+
+import (
+	"crypto/tls"
+	"sync"
+	"testing"
+)
+
+// 生成 CA 和两个节点的证书，返回节点 0 的 TLS 服务端和每个身份的证书文件
+func startTLSServer(t *testing.T) (*Server, string, map[string]TLSFiles) {
+	t.Helper()
+	files, err := GenerateCertificates(t.TempDir(), []string{NodeIdentity(0), NodeIdentity(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := files[NodeIdentity(0)].ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Listen("127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := startServer(t, l)
+	return s, l.Addr().String(), files
+}
+
+func clientConfig(t *testing.T, f TLSFiles) *tls.Config {
+	t.Helper()
+	config, err := f.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+// 双向 TLS 连接上的调用可以送达，鉴权函数收到对方证书的身份和解码后的参数
+func TestTLSCallCarriesIdentity(t *testing.T) {
+	s, addr, files := startTLSServer(t)
+	if id, err := files[NodeIdentity(1)].Identity(); err != nil || id != NodeIdentity(1) {
+		t.Fatalf("Identity() = %q, %v", id, err)
+	}
+
+	var mu sync.Mutex
+	var peers []string
+	s.SetAuthorizer(func(peer, svcMeth string, args interface{}) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		peers = append(peers, peer)
+		return args.(*EchoArgs).Text != "forbidden"
+	})
+
+	end := NewTLSClientEnds([]string{addr}, clientConfig(t, files[NodeIdentity(1)]))[0]
+	defer end.(*TCPClientEnd).Close()
+	var reply EchoReply
+	if !end.Call("Echo.Echo", &EchoArgs{Text: "hello"}, &reply) || reply.Text != "hello" {
+		t.Fatalf("Call over TLS = %q, want hello", reply.Text)
+	}
+	if end.Call("Echo.Echo", &EchoArgs{Text: "forbidden"}, &reply) {
+		t.Fatalf("Call rejected by the authorizer succeeded")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(peers) != 2 || peers[0] != NodeIdentity(1) || peers[1] != NodeIdentity(1) {
+		t.Fatalf("authorizer saw peers %v, want %s twice", peers, NodeIdentity(1))
+	}
+}
+
+// 不出示证书的客户端、不使用 TLS 的客户端，以及身份与地址不符的服务端都连接失败
+func TestTLSRejectsUnverifiedPeers(t *testing.T) {
+	_, addr, files := startTLSServer(t)
+	var reply EchoReply
+
+	noCert := clientConfig(t, files[NodeIdentity(1)])
+	noCert.Certificates = nil
+	noCert.ServerName = NodeIdentity(0)
+	end := NewTCPClientEnd(addr)
+	end.tlsConfig = noCert
+	if end.Call("Echo.Echo", &EchoArgs{}, &reply) {
+		t.Errorf("Call without a client certificate succeeded")
+	}
+
+	if NewTCPClientEnd(addr).Call("Echo.Echo", &EchoArgs{}, &reply) {
+		t.Errorf("Call without TLS succeeded")
+	}
+
+	// 地址上的服务端是节点 0，客户端以为连接的是节点 1
+	wrong := NewTLSClientEnds([]string{addr, addr}, clientConfig(t, files[NodeIdentity(1)]))[1]
+	if wrong.Call("Echo.Echo", &EchoArgs{}, &reply) {
+		t.Errorf("Call to a server with another node's identity succeeded")
+	}
+
+	if _, err := (TLSFiles{CertFile: files[NodeIdentity(0)].CertFile}).ServerConfig(); err == nil {
+		t.Errorf("ServerConfig without a key and a CA succeeded")
+	}
+}
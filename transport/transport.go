@@ -0,0 +1,23 @@
+package transport
+
+import "course/labrpc"
+
+// 节点之间以及客户端到节点的 RPC 端点
+// raft、kv 等包只依赖这个接口，测试中使用 labrpc 的内存网络，部署时使用 TCP
+//
+// Call 的语义与 labrpc.ClientEnd.Call 相同：返回 true 表示服务端执行了请求并且 reply 有效，
+// 返回 false 表示请求或回复丢失、服务端不可用或超时，调用方需要自行重试
+type ClientEnd interface {
+	Call(svcMeth string, args interface{}, reply interface{}) bool
+}
+
+var _ ClientEnd = (*labrpc.ClientEnd)(nil)
+
+// 将 labrpc 的端点转换为 ClientEnd 切片
+func FromLabrpc(ends []*labrpc.ClientEnd) []ClientEnd {
+	result := make([]ClientEnd, len(ends))
+	for i, end := range ends {
+		result[i] = end
+	}
+	return result
+}